@@ -4,6 +4,7 @@ package main
 import (
 	"context"
 	_ "embed"
+	"flag"
 	"fmt"
 	"log/slog"
 	"os"
@@ -18,11 +19,17 @@ import (
 	"github.com/illmade-knight/go-dataflow/pkg/messagepipeline"
 	"github.com/tinywideclouds/go-microservice-base/pkg/middleware"
 
+	"github.com/tinywideclouds/go-notification-service/internal/feedback"
+	"github.com/tinywideclouds/go-notification-service/internal/platform/apns"
 	"github.com/tinywideclouds/go-notification-service/internal/platform/fcm"
 	"github.com/tinywideclouds/go-notification-service/internal/platform/web"
+	"github.com/tinywideclouds/go-notification-service/internal/prune"
+	"github.com/tinywideclouds/go-notification-service/internal/scheduler"
+	"github.com/tinywideclouds/go-notification-service/internal/templates"
 
 	"github.com/tinywideclouds/go-notification-service/internal/storage/cache"
 	fsStore "github.com/tinywideclouds/go-notification-service/internal/storage/firestore"
+	"github.com/tinywideclouds/go-notification-service/internal/storage/memory"
 	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 
 	"github.com/tinywideclouds/go-notification-service/notificationservice"
@@ -55,6 +62,9 @@ func main() {
 	})).With("service", "go-notifications-service")
 	slog.SetDefault(logger)
 
+	pruneOnce := flag.Bool("prune-once", false, "run the stale device pruning job once and exit")
+	flag.Parse()
+
 	ctx := context.Background()
 
 	// --- Config Loading ---
@@ -85,10 +95,20 @@ func main() {
 	}
 	defer fsClient.Close()
 
-	// --- Token Store (Decorated) ---
-	var tokenStore dispatch.TokenStore = fsStore.NewFirestoreStore(fsClient)
+	// --- Token Store (Decorated) & Delivery Ledger ---
+	firestoreStore := fsStore.NewFirestoreStore(fsClient)
+	var tokenStore dispatch.TokenStore = firestoreStore
+	var cacheInvalidator prune.CacheInvalidator // nil unless Redis is enabled
 	logger.Info("TokenStore initialized", "type", "firestore")
 
+	// The ledger only needs to outlive the Pub/Sub retry window.
+	// Without Redis it is per-instance (best effort).
+	var ledger dispatch.DeliveryLedger = memory.NewDeliveryLedger(24 * time.Hour)
+	var dedupStore dispatch.DedupStore = memory.NewDedupStore()
+	// Bursts must outlive the window plus the scheduler's flush delay.
+	coalesceRetention := cfg.Coalesce.Window + time.Hour
+	var coalesceStore dispatch.CoalesceStore = memory.NewCoalesceStore(coalesceRetention)
+
 	if cfg.Redis.Enabled {
 		logger.Info("Initializing Redis Cache layer...", "addr", cfg.Redis.Addr)
 		redisClient, err := cache.NewRedisClient(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB)
@@ -97,8 +117,35 @@ func main() {
 			os.Exit(1)
 		}
 		defer redisClient.Close()
-		tokenStore = cache.NewCachedTokenStore(tokenStore, redisClient, 24*time.Hour)
+		cachedStore := cache.NewCachedTokenStore(tokenStore, redisClient, 24*time.Hour)
+		tokenStore = cachedStore
+		cacheInvalidator = cachedStore
 		logger.Info("TokenStore upgraded", "type", "redis_cached_firestore")
+		ledger = cache.NewDeliveryLedger(redisClient, 24*time.Hour)
+		logger.Info("DeliveryLedger upgraded", "type", "redis")
+		dedupStore = cache.NewDedupStore(redisClient)
+		logger.Info("DedupStore upgraded", "type", "redis")
+		coalesceStore = cache.NewCoalesceStore(redisClient, coalesceRetention)
+	}
+
+	// --- Stale Device Pruning ---
+	pruneMode, _ := prune.ParseMode(cfg.Prune.Mode) // validated by config
+	pruner := prune.NewJob(prune.Config{
+		MaxAge:    cfg.Prune.MaxAge,
+		Mode:      pruneMode,
+		BatchSize: cfg.Prune.BatchSize,
+	}, firestoreStore, cacheInvalidator, logger)
+
+	if *pruneOnce {
+		report, err := pruner.RunOnce(ctx)
+		if err != nil {
+			logger.Error("Stale device pruning failed", "err", err)
+			os.Exit(1)
+		}
+		logger.Info("Stale device pruning finished",
+			"scanned", report.Scanned, "deleted", report.Deleted, "quarantined", report.Quarantined,
+			"failed", report.Failed, "users", report.Users)
+		return
 	}
 
 	// --- Auth ---
@@ -122,7 +169,11 @@ func main() {
 		logger.Error("Failed to create FCM messaging client", "err", err)
 		os.Exit(1)
 	}
-	fcmDispatcher := fcm.NewDispatcher(fcmMessaging, logger)
+	fcmDispatcher := fcm.NewDispatcher(fcmMessaging, logger, fcm.WithRetryPolicy(dispatch.RetryPolicy{
+		MaxAttempts: cfg.FCM.RetryMaxAttempts,
+		BaseDelay:   cfg.FCM.RetryBaseDelay,
+		MaxDelay:    cfg.FCM.RetryMaxDelay,
+	}), fcm.WithConcurrency(cfg.FCM.Concurrency))
 
 	// B. Web (VAPID) - ✅ Using Config Logic
 	// Fail fast if keys are missing but web support is expected?
@@ -133,19 +184,123 @@ func main() {
 		logger.Info("Web Dispatcher enabled", "public_key", cfg.Vapid.PublicKey)
 	}
 	// We pass the keys from config
-	webDispatcher := web.NewDispatcher(cfg.Vapid, logger)
+	webDispatcher := web.NewDispatcher(cfg.Vapid, logger,
+		web.WithConcurrency(cfg.WebPush.Concurrency),
+		web.WithTimeout(cfg.WebPush.Timeout),
+		web.WithMaxRetryAfter(cfg.WebPush.MaxRetryAfter))
+
+	// C. Native iOS (APNs) - optional, token-based (P8) or certificate auth
+	var apnsDispatcher dispatch.Dispatcher
+	if cfg.APNs.Enabled() {
+		apnsCfg := apns.Config{
+			KeyID:        cfg.APNs.KeyID,
+			TeamID:       cfg.APNs.TeamID,
+			BundleID:     cfg.APNs.BundleID,
+			P8KeyContent: cfg.APNs.P8Key,
+		}
+		if cfg.APNs.Auth == "certificate" {
+			apnsCfg = apns.Config{
+				BundleID:            cfg.APNs.BundleID,
+				CertificateContent:  cfg.APNs.Certificate,
+				CertificatePassword: cfg.APNs.CertificatePassword,
+			}
+		}
+		d, err := apns.NewDispatcher(apnsCfg, logger, apns.WithRetryPolicy(dispatch.RetryPolicy{
+			MaxAttempts: cfg.APNs.RetryMaxAttempts,
+			BaseDelay:   cfg.APNs.RetryBaseDelay,
+			MaxDelay:    cfg.APNs.RetryMaxDelay,
+		}), apns.WithConcurrency(cfg.APNs.Concurrency))
+		if err != nil {
+			logger.Error("Failed to initialize APNs Dispatcher", "err", err)
+			os.Exit(1)
+		}
+		apnsDispatcher = d
+		logger.Info("APNs Dispatcher enabled", "bundle_id", cfg.APNs.BundleID, "auth", cfg.APNs.Auth)
+	} else {
+		logger.Warn("APNs credentials missing in configuration. Native iOS delivery is disabled.")
+	}
+
+	serviceOpts := []notificationservice.Option{
+		notificationservice.WithDeliveryLedger(ledger),
+		notificationservice.WithDeduplication(dedupStore, cfg.DedupWindow),
+		notificationservice.WithPreferences(fsStore.NewPreferencesStore(fsClient)),
+		notificationservice.WithDeferredDelivery(
+			fsStore.NewScheduleStore(fsClient),
+			scheduler.Config{Lease: cfg.Scheduler.Lease, BatchSize: cfg.Scheduler.BatchSize},
+			cfg.Scheduler.PollInterval,
+		),
+	}
+	if cfg.Coalesce.Window > 0 {
+		if !cfg.Redis.Enabled {
+			logger.Warn("Coalescing without Redis is per instance; bursts are only merged reliably with a single instance")
+		}
+		serviceOpts = append(serviceOpts, notificationservice.WithCoalescing(coalesceStore, cfg.Coalesce.Window))
+		logger.Info("Burst coalescing enabled", "window", cfg.Coalesce.Window)
+	}
+	// Digests are always on: users can opt categories in even when none are configured
+	digestCategories := make(map[string]dispatch.DigestPeriod, len(cfg.Digest.Categories))
+	for category, period := range cfg.Digest.Categories {
+		digestCategories[category] = dispatch.DigestPeriod(period)
+	}
+	serviceOpts = append(serviceOpts, notificationservice.WithDigests(fsStore.NewDigestStore(fsClient), digestCategories, cfg.Digest.DailyAt))
+	logger.Info("Digests enabled", "categories", cfg.Digest.Categories, "daily_at", cfg.Digest.DailyAt)
+
+	// --- Templates (optional) ---
+	if cfg.Templates.Path != "" {
+		registry, err := templates.Load(cfg.Templates.Path, cfg.Templates.DefaultLocale)
+		if err != nil {
+			logger.Error("Failed to load notification templates", "path", cfg.Templates.Path, "err", err)
+			os.Exit(1)
+		}
+		serviceOpts = append(serviceOpts, notificationservice.WithTemplates(registry))
+		logger.Info("Notification templates loaded", "count", registry.Len(), "default_locale", cfg.Templates.DefaultLocale)
+	}
+
+	if cfg.Prune.Enabled {
+		serviceOpts = append(serviceOpts, notificationservice.WithPruner(pruner, cfg.Prune.Interval))
+	}
+
+	// --- Feedback Loop (optional) ---
+	if cfg.FeedbackTopicID != "" {
+		simplePublisher, err := messagepipeline.NewGoogleSimplePublisher(
+			messagepipeline.NewGoogleSimplePublisherDefaults(cfg.FeedbackTopicID), psClient, logger,
+		)
+		if err != nil {
+			logger.Error("Failed to create feedback publisher", "err", err)
+			os.Exit(1)
+		}
+		feedbackPublisher := feedback.NewPubsubPublisher(simplePublisher)
+		defer feedbackPublisher.Stop(context.Background())
+		serviceOpts = append(serviceOpts, notificationservice.WithFeedback(feedbackPublisher))
+		logger.Info("Feedback publishing enabled", "topic_id", cfg.FeedbackTopicID)
+	} else {
+		logger.Warn("feedback_topic_id not set. Delivery feedback events are disabled.")
+	}
 
 	// --- Consumer & Service ---
-	consumer, _ := newIngestionConsumer(ctx, cfg, psClient, logger)
+	consumer, _ := newIngestionConsumer(ctx, cfg, cfg.TopicID, cfg.PubsubConsumerConfig.SubscriptionID, psClient, logger)
+
+	// Priority lane (optional): its own subscription and workers
+	if cfg.PriorityLane.Enabled() {
+		priorityConsumer, err := newIngestionConsumer(ctx, cfg, cfg.PriorityLane.TopicID, cfg.PriorityLane.SubscriptionID, psClient, logger)
+		if err != nil {
+			logger.Error("Priority lane consumer failed", "err", err)
+			os.Exit(1)
+		}
+		serviceOpts = append(serviceOpts, notificationservice.WithPriorityLane(priorityConsumer, cfg.PriorityLane.NumWorkers))
+		logger.Info("Priority lane enabled", "topic_id", cfg.PriorityLane.TopicID, "workers", cfg.PriorityLane.NumWorkers)
+	}
 
 	service, err := notificationservice.New(
 		cfg,
 		consumer,
 		fcmDispatcher,
+		apnsDispatcher,
 		webDispatcher,
 		tokenStore,
 		authMiddleware,
 		logger,
+		serviceOpts...,
 	)
 	if err != nil {
 		logger.Error("Service creation failed", "err", err)
@@ -160,9 +315,9 @@ func main() {
 }
 
 // ... (Helpers remain unchanged) ...
-func newIngestionConsumer(ctx context.Context, cfg *config.Config, psClient *pubsub.Client, logger *slog.Logger) (messagepipeline.MessageConsumer, error) {
-	sub := convertPubsub(cfg.ProjectID, cfg.PubsubConsumerConfig.SubscriptionID, "subscriptions")
-	topicID := convertPubsub(cfg.ProjectID, cfg.TopicID, "topics")
+func newIngestionConsumer(ctx context.Context, cfg *config.Config, topic, subscription string, psClient *pubsub.Client, logger *slog.Logger) (messagepipeline.MessageConsumer, error) {
+	sub := convertPubsub(cfg.ProjectID, subscription, "subscriptions")
+	topicID := convertPubsub(cfg.ProjectID, topic, "topics")
 	dlt := convertPubsub(cfg.ProjectID, cfg.SubscriptionDLQTopicID, "topics")
 
 	subConfig := &pubsubpb.Subscription{
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/tinywideclouds/go-microservice-base/pkg/middleware"
+	"github.com/tinywideclouds/go-microservice-base/pkg/response"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+// --- DEVICE MANAGEMENT: "Which devices get my notifications?" ---
+
+// DeviceView is the public shape of a registered device. The token is masked:
+// it is a delivery credential and the client never needs it back.
+type DeviceView struct {
+	ID           string    `json:"id"`
+	Platform     string    `json:"platform"`
+	Label        string    `json:"label,omitempty"`
+	OS           string    `json:"os,omitempty"`
+	AppVersion   string    `json:"appVersion,omitempty"`
+	Locale       string    `json:"locale,omitempty"`
+	Timezone     string    `json:"timezone,omitempty"`
+	Environment  string    `json:"environment,omitempty"` // APNs only
+	Token        string    `json:"token"`
+	RegisteredAt time.Time `json:"registeredAt"`
+	LastSeenAt   time.Time `json:"lastSeenAt"`
+	Quarantined  bool      `json:"quarantined,omitempty"` // Stale: re-register to receive again
+}
+
+type ListDevicesResponse struct {
+	Devices []DeviceView `json:"devices"`
+}
+
+func (api *TokenAPI) ListDevices(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := middleware.GetUserHandleFromContext(ctx)
+	if !ok {
+		response.WriteJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	userURN, _ := urn.Parse(userID)
+
+	devices, err := api.Store.ListDevices(ctx, userURN)
+	if err != nil {
+		api.Logger.Error("failed to list devices", "err", err)
+		response.WriteJSONError(w, http.StatusInternalServerError, "storage failed")
+		return
+	}
+
+	views := make([]DeviceView, 0, len(devices))
+	for _, d := range devices {
+		views = append(views, DeviceView{
+			ID:           d.ID,
+			Platform:     d.Platform,
+			Label:        d.Metadata.Name,
+			OS:           d.Metadata.OS,
+			AppVersion:   d.Metadata.AppVersion,
+			Locale:       d.Metadata.Locale,
+			Timezone:     d.Metadata.Timezone,
+			Environment:  d.Metadata.APNsEnvironment,
+			Token:        maskAddress(d.Address),
+			RegisteredAt: d.RegisteredAt,
+			LastSeenAt:   d.UpdatedAt,
+			Quarantined:  d.Quarantined,
+		})
+	}
+
+	response.WriteJSON(w, http.StatusOK, ListDevicesResponse{Devices: views})
+}
+
+func (api *TokenAPI) DeleteDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := middleware.GetUserHandleFromContext(ctx)
+	if !ok {
+		response.WriteJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	userURN, _ := urn.Parse(userID)
+
+	deviceID := r.PathValue("id")
+	if deviceID == "" {
+		response.WriteJSONError(w, http.StatusBadRequest, "missing device id")
+		return
+	}
+
+	// Devices are scoped to the caller, so another user's ID is simply "not found"
+	if err := api.Store.DeleteDevice(ctx, userURN, deviceID); err != nil {
+		if errors.Is(err, dispatch.ErrDeviceNotFound) {
+			response.WriteJSONError(w, http.StatusNotFound, "device not found")
+			return
+		}
+		api.Logger.Error("failed to delete device", "err", err)
+		response.WriteJSONError(w, http.StatusInternalServerError, "storage failed")
+		return
+	}
+	api.Logger.Info("DeleteDevice: Device revoked", "user", userURN, "device_id", deviceID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maskAddress keeps just enough of a token (or endpoint host) to tell devices apart.
+func maskAddress(address string) string {
+	const visible = 6
+	prefix := ""
+	if u, err := url.Parse(address); err == nil && u.Host != "" {
+		// Web Push endpoint: the host says which browser vendor it is
+		prefix = u.Scheme + "://" + u.Host + "/"
+		address = u.Path
+	}
+	if len(address) <= visible {
+		return prefix + "…"
+	}
+	return prefix + "…" + address[len(address)-visible:]
+}
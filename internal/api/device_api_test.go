@@ -0,0 +1,103 @@
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tinywideclouds/go-notification-service/internal/api"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+func TestListDevices(t *testing.T) {
+	apiHandler, mockStore := setupAPI(t)
+	targetURN, _ := urn.Parse("urn:test:user:123")
+	registered := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	mockStore.On("ListDevices", mock.Anything, targetURN).Return([]dispatch.Device{
+		{
+			ID:           "dev-fcm",
+			Platform:     dispatch.PlatformFCM,
+			Address:      "fcm-token-secret-abcdef",
+			Metadata:     dispatch.DeviceMetadata{OS: "android", Name: "Pixel 8"},
+			RegisteredAt: registered,
+			UpdatedAt:    registered.Add(time.Hour),
+		},
+		{
+			ID:       "dev-web",
+			Platform: dispatch.PlatformWeb,
+			Address:  "https://fcm.googleapis.com/fcm/send/secret-endpoint-xyz789",
+		},
+	}, nil)
+
+	req := withUser(httptest.NewRequest("GET", "/api/v1/devices", nil), targetURN.String())
+	w := httptest.NewRecorder()
+
+	apiHandler.ListDevices(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp api.ListDevicesResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Devices, 2)
+
+	fcmDevice := resp.Devices[0]
+	assert.Equal(t, "dev-fcm", fcmDevice.ID)
+	assert.Equal(t, "Pixel 8", fcmDevice.Label)
+	assert.Equal(t, registered, fcmDevice.RegisteredAt)
+	assert.Equal(t, registered.Add(time.Hour), fcmDevice.LastSeenAt)
+
+	// Tokens must never leave the service in full
+	assert.Equal(t, "…abcdef", fcmDevice.Token)
+	assert.Equal(t, "https://fcm.googleapis.com/…xyz789", resp.Devices[1].Token)
+	assert.NotContains(t, w.Body.String(), "secret")
+}
+
+func TestDeleteDevice(t *testing.T) {
+	targetURN, _ := urn.Parse("urn:test:user:123")
+
+	newRequest := func(id string) *http.Request {
+		req := httptest.NewRequest("DELETE", "/api/v1/devices/"+id, nil)
+		req.SetPathValue("id", id)
+		return withUser(req, targetURN.String())
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		apiHandler, mockStore := setupAPI(t)
+		mockStore.On("DeleteDevice", mock.Anything, targetURN, "dev-1").Return(nil)
+
+		w := httptest.NewRecorder()
+		apiHandler.DeleteDevice(w, newRequest("dev-1"))
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockStore.AssertExpectations(t)
+	})
+
+	t.Run("Unknown Device Is 404", func(t *testing.T) {
+		apiHandler, mockStore := setupAPI(t)
+		mockStore.On("DeleteDevice", mock.Anything, targetURN, "someone-elses").Return(dispatch.ErrDeviceNotFound)
+
+		w := httptest.NewRecorder()
+		apiHandler.DeleteDevice(w, newRequest("someone-elses"))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Storage Failure Is 500", func(t *testing.T) {
+		apiHandler, mockStore := setupAPI(t)
+		mockStore.On("DeleteDevice", mock.Anything, targetURN, "dev-1").Return(errors.New("firestore down"))
+
+		w := httptest.NewRecorder()
+		apiHandler.DeleteDevice(w, newRequest("dev-1"))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tinywideclouds/go-microservice-base/pkg/middleware"
+	"github.com/tinywideclouds/go-microservice-base/pkg/response"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+// maxDisabledCategories bounds the opt-out list a client may store.
+const maxDisabledCategories = 100
+
+// --- PREFERENCES: "What do I want to be notified about?" ---
+
+type PreferencesAPI struct {
+	Store  dispatch.PreferencesStore
+	Logger *slog.Logger
+}
+
+func NewPreferencesAPI(store dispatch.PreferencesStore, logger *slog.Logger) *PreferencesAPI {
+	return &PreferencesAPI{
+		Store:  store,
+		Logger: logger,
+	}
+}
+
+type UpdatePreferencesRequest struct {
+	Muted              bool                 `json:"muted"`
+	DisabledCategories []string             `json:"disabledCategories"`
+	QuietHours         *dispatch.QuietHours `json:"quietHours,omitempty"` // Omit (or null) to turn off
+	// Digests maps a category to "hourly", "daily" or "immediate" (overriding the service default)
+	Digests map[string]dispatch.DigestPeriod `json:"digests,omitempty"`
+}
+
+func (api *PreferencesAPI) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := middleware.GetUserHandleFromContext(ctx)
+	if !ok {
+		response.WriteJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	userURN, _ := urn.Parse(userID)
+
+	prefs, err := api.Store.Get(ctx, userURN)
+	if err != nil {
+		api.Logger.Error("failed to read preferences", "err", err)
+		response.WriteJSONError(w, http.StatusInternalServerError, "storage failed")
+		return
+	}
+	if prefs.DisabledCategories == nil {
+		prefs.DisabledCategories = []string{} // Clients get [] rather than null
+	}
+
+	response.WriteJSON(w, http.StatusOK, prefs)
+}
+
+// PutPreferences replaces the caller's preferences and returns the stored result.
+func (api *PreferencesAPI) PutPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := middleware.GetUserHandleFromContext(ctx)
+	if !ok {
+		response.WriteJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	userURN, _ := urn.Parse(userID)
+
+	var req UpdatePreferencesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.WriteJSONError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	categories, err := normalizeCategories(req.DisabledCategories)
+	if err != nil {
+		response.WriteJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.QuietHours != nil {
+		if err := req.QuietHours.Validate(); err != nil {
+			response.WriteJSONError(w, http.StatusBadRequest, "invalid quietHours: "+err.Error())
+			return
+		}
+	}
+
+	digests, err := normalizeDigests(req.Digests)
+	if err != nil {
+		response.WriteJSONError(w, http.StatusBadRequest, "invalid digests: "+err.Error())
+		return
+	}
+
+	prefs := &dispatch.Preferences{
+		Muted:              req.Muted,
+		DisabledCategories: categories,
+		QuietHours:         req.QuietHours,
+		Digests:            digests,
+		UpdatedAt:          time.Now().UTC(),
+	}
+	if err := api.Store.Put(ctx, userURN, prefs); err != nil {
+		api.Logger.Error("failed to save preferences", "err", err)
+		response.WriteJSONError(w, http.StatusInternalServerError, "storage failed")
+		return
+	}
+	api.Logger.Info("PutPreferences: Preferences saved", "user", userURN, "muted", prefs.Muted, "disabled", len(categories))
+
+	response.WriteJSON(w, http.StatusOK, prefs)
+}
+
+// normalizeCategories trims, lower-cases and de-duplicates the opt-out list so it
+// matches the (lower-case) categories senders put on requests.
+func normalizeCategories(raw []string) ([]string, error) {
+	categories := make([]string, 0, len(raw))
+	for _, c := range raw {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c == "" || slices.Contains(categories, c) {
+			continue
+		}
+		categories = append(categories, c)
+	}
+	if len(categories) > maxDisabledCategories {
+		return nil, fmt.Errorf("too many categories (max %d)", maxDisabledCategories)
+	}
+	return categories, nil
+}
+
+// normalizeDigests lower-cases the categories and checks every period is known.
+func normalizeDigests(raw map[string]dispatch.DigestPeriod) (map[string]dispatch.DigestPeriod, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	if len(raw) > maxDisabledCategories {
+		return nil, fmt.Errorf("too many categories (max %d)", maxDisabledCategories)
+	}
+	digests := make(map[string]dispatch.DigestPeriod, len(raw))
+	for c, period := range raw {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+		if !period.Valid() {
+			return nil, fmt.Errorf("unknown period %q for %q", period, c)
+		}
+		digests[c] = period
+	}
+	return digests, nil
+}
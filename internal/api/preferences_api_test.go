@@ -0,0 +1,116 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tinywideclouds/go-notification-service/internal/api"
+	"github.com/tinywideclouds/go-notification-service/internal/storage/memory"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+func TestPreferencesAPI(t *testing.T) {
+	targetURN, _ := urn.Parse("urn:test:user:123")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	put := func(apiHandler *api.PreferencesAPI, body string) *httptest.ResponseRecorder {
+		req := withUser(httptest.NewRequest("PUT", "/api/v1/preferences", bytes.NewBufferString(body)), targetURN.String())
+		w := httptest.NewRecorder()
+		apiHandler.PutPreferences(w, req)
+		return w
+	}
+	get := func(apiHandler *api.PreferencesAPI) dispatch.Preferences {
+		req := withUser(httptest.NewRequest("GET", "/api/v1/preferences", nil), targetURN.String())
+		w := httptest.NewRecorder()
+		apiHandler.GetPreferences(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var prefs dispatch.Preferences
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &prefs))
+		return prefs
+	}
+
+	t.Run("Defaults Before Anything Is Saved", func(t *testing.T) {
+		apiHandler := api.NewPreferencesAPI(memory.NewPreferencesStore(), logger)
+
+		req := withUser(httptest.NewRequest("GET", "/api/v1/preferences", nil), targetURN.String())
+		w := httptest.NewRecorder()
+		apiHandler.GetPreferences(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"disabledCategories":[]`)
+		assert.Contains(t, w.Body.String(), `"muted":false`)
+	})
+
+	t.Run("Put Normalises Categories And Round-Trips", func(t *testing.T) {
+		apiHandler := api.NewPreferencesAPI(memory.NewPreferencesStore(), logger)
+
+		w := put(apiHandler, `{"muted": false, "disabledCategories": [" Marketing ", "marketing", "", "digest"]}`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		prefs := get(apiHandler)
+		assert.Equal(t, []string{"marketing", "digest"}, prefs.DisabledCategories)
+		assert.False(t, prefs.UpdatedAt.IsZero())
+	})
+
+	t.Run("Invalid Bodies Are Rejected", func(t *testing.T) {
+		apiHandler := api.NewPreferencesAPI(memory.NewPreferencesStore(), logger)
+
+		assert.Equal(t, http.StatusBadRequest, put(apiHandler, `{bad json`).Code)
+
+		tooMany := make([]string, 101)
+		for i := range tooMany {
+			tooMany[i] = strings.Repeat("c", i+1)
+		}
+		body, _ := json.Marshal(map[string]any{"disabledCategories": tooMany})
+		assert.Equal(t, http.StatusBadRequest, put(apiHandler, string(body)).Code)
+	})
+
+	t.Run("Quiet Hours Round-Trip And Validation", func(t *testing.T) {
+		apiHandler := api.NewPreferencesAPI(memory.NewPreferencesStore(), logger)
+
+		w := put(apiHandler, `{"quietHours": {"start": "22:00", "end": "07:00", "timezone": "Europe/London", "mode": "defer"}}`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		prefs := get(apiHandler)
+		require.NotNil(t, prefs.QuietHours)
+		assert.Equal(t, dispatch.QuietDefer, prefs.QuietHours.Mode)
+		assert.Equal(t, "Europe/London", prefs.QuietHours.Timezone)
+
+		assert.Equal(t, http.StatusBadRequest, put(apiHandler, `{"quietHours": {"start": "22:00", "end": "07:00", "mode": "snooze"}}`).Code)
+		assert.Equal(t, http.StatusBadRequest, put(apiHandler, `{"quietHours": {"start": "10pm", "end": "07:00", "mode": "drop"}}`).Code)
+
+		// Omitting quietHours turns them off
+		require.Equal(t, http.StatusOK, put(apiHandler, `{"muted": false}`).Code)
+		assert.Nil(t, get(apiHandler).QuietHours)
+	})
+
+	t.Run("Digests Round-Trip And Validation", func(t *testing.T) {
+		apiHandler := api.NewPreferencesAPI(memory.NewPreferencesStore(), logger)
+
+		w := put(apiHandler, `{"digests": {" Likes ": "daily", "follows": "immediate"}}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, map[string]dispatch.DigestPeriod{"likes": dispatch.DigestDaily, "follows": dispatch.DigestImmediate}, get(apiHandler).Digests)
+
+		assert.Equal(t, http.StatusBadRequest, put(apiHandler, `{"digests": {"likes": "weekly"}}`).Code)
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		apiHandler := api.NewPreferencesAPI(memory.NewPreferencesStore(), logger)
+
+		w := httptest.NewRecorder()
+		apiHandler.GetPreferences(w, httptest.NewRequest("GET", "/api/v1/preferences", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
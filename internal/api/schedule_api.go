@@ -0,0 +1,58 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/tinywideclouds/go-microservice-base/pkg/middleware"
+	"github.com/tinywideclouds/go-microservice-base/pkg/response"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+// --- SCHEDULING: "Never mind that reminder" ---
+
+type ScheduleAPI struct {
+	Store  dispatch.ScheduleStore
+	Logger *slog.Logger
+}
+
+func NewScheduleAPI(store dispatch.ScheduleStore, logger *slog.Logger) *ScheduleAPI {
+	return &ScheduleAPI{
+		Store:  store,
+		Logger: logger,
+	}
+}
+
+// CancelScheduled removes a pending notification by its ID (the request's requestId).
+// Only notifications addressed to the caller can be cancelled.
+func (api *ScheduleAPI) CancelScheduled(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := middleware.GetUserHandleFromContext(ctx)
+	if !ok {
+		response.WriteJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	userURN, _ := urn.Parse(userID)
+
+	id := r.PathValue("id")
+	if id == "" {
+		response.WriteJSONError(w, http.StatusBadRequest, "missing notification id")
+		return
+	}
+
+	if err := api.Store.Cancel(ctx, userURN, id); err != nil {
+		if errors.Is(err, dispatch.ErrScheduleNotFound) {
+			// Unknown, someone else's, or already delivered
+			response.WriteJSONError(w, http.StatusNotFound, "scheduled notification not found")
+			return
+		}
+		api.Logger.Error("failed to cancel scheduled notification", "err", err)
+		response.WriteJSONError(w, http.StatusInternalServerError, "storage failed")
+		return
+	}
+	api.Logger.Info("CancelScheduled: Notification cancelled", "user", userURN, "notification_id", id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,59 @@
+package api_test
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tinywideclouds/go-notification-service/internal/api"
+	"github.com/tinywideclouds/go-notification-service/internal/storage/memory"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+func TestCancelScheduled(t *testing.T) {
+	ownerURN, _ := urn.Parse("urn:test:user:owner")
+	otherURN, _ := urn.Parse("urn:test:user:other")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	setup := func(t *testing.T) (*api.ScheduleAPI, *memory.ScheduleStore) {
+		store := memory.NewScheduleStore()
+		n := dispatch.ScheduledNotification{ID: "reminder-1", DeliverAt: time.Now().Add(time.Hour)}
+		n.Request.RecipientID = ownerURN
+		require.NoError(t, store.Schedule(t.Context(), n))
+		return api.NewScheduleAPI(store, logger), store
+	}
+	cancel := func(apiHandler *api.ScheduleAPI, caller urn.URN, id string) int {
+		req := httptest.NewRequest("DELETE", "/api/v1/scheduled/"+id, nil)
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		apiHandler.CancelScheduled(w, withUser(req, caller.String()))
+		return w.Code
+	}
+
+	t.Run("Owner Can Cancel", func(t *testing.T) {
+		apiHandler, store := setup(t)
+
+		assert.Equal(t, http.StatusNoContent, cancel(apiHandler, ownerURN, "reminder-1"))
+
+		due, _ := store.Claim(t.Context(), time.Now().Add(2*time.Hour), time.Minute, 10)
+		assert.Empty(t, due, "cancelled notifications are never released")
+	})
+
+	t.Run("Someone Else's Notification Is 404", func(t *testing.T) {
+		apiHandler, _ := setup(t)
+		assert.Equal(t, http.StatusNotFound, cancel(apiHandler, otherURN, "reminder-1"))
+	})
+
+	t.Run("Unknown ID Is 404", func(t *testing.T) {
+		apiHandler, _ := setup(t)
+		assert.Equal(t, http.StatusNotFound, cancel(apiHandler, ownerURN, "never-scheduled"))
+	})
+}
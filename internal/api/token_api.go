@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 
 	"log/slog"
@@ -28,7 +29,8 @@ func NewTokenAPI(store dispatch.TokenStore, logger *slog.Logger) *TokenAPI {
 // --- DOOR A: Mobile (FCM) ---
 
 type RegisterFCMRequest struct {
-	Token string `json:"token"`
+	Token  string                   `json:"token"`
+	Device *dispatch.DeviceMetadata `json:"device,omitempty"` // Optional
 }
 
 func (api *TokenAPI) RegisterFCM(w http.ResponseWriter, r *http.Request) {
@@ -51,8 +53,13 @@ func (api *TokenAPI) RegisterFCM(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	meta, ok := deviceMetadata(w, req.Device)
+	if !ok {
+		return
+	}
+
 	// Direct call to FCM storage logic
-	if err := api.Store.RegisterFCM(ctx, userURN, req.Token); err != nil {
+	if err := api.Store.RegisterFCM(ctx, userURN, req.Token, meta); err != nil {
 		api.Logger.Error("failed to register fcm", "err", err)
 		response.WriteJSONError(w, http.StatusInternalServerError, "storage failed")
 		return
@@ -61,8 +68,65 @@ func (api *TokenAPI) RegisterFCM(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// --- DOOR C: Native iOS (APNs) ---
+
+type RegisterAPNsRequest struct {
+	Token string `json:"token"`
+	// Environment is the APNs gateway the token was issued for: "production"
+	// (the default) or "development" for sandbox builds.
+	Environment string                   `json:"environment,omitempty"`
+	Device      *dispatch.DeviceMetadata `json:"device,omitempty"` // Optional
+}
+
+func (api *TokenAPI) RegisterAPNs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := middleware.GetUserHandleFromContext(ctx)
+	if !ok {
+		response.WriteJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	userURN, _ := urn.Parse(userID)
+
+	var req RegisterAPNsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.WriteJSONError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if req.Token == "" {
+		response.WriteJSONError(w, http.StatusBadRequest, "missing token")
+		return
+	}
+
+	meta, ok := deviceMetadata(w, req.Device)
+	if !ok {
+		return
+	}
+	if req.Environment != "" {
+		meta.APNsEnvironment = req.Environment
+		if err := meta.Validate(); err != nil {
+			response.WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// Direct call to APNs storage logic
+	if err := api.Store.RegisterAPNs(ctx, userURN, req.Token, meta); err != nil {
+		api.Logger.Error("failed to register apns", "err", err)
+		response.WriteJSONError(w, http.StatusInternalServerError, "storage failed")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // --- DOOR B: Web (VAPID) ---
 
+// RegisterWebExtras holds the fields sent next to the PushSubscription JSON.
+type RegisterWebExtras struct {
+	Device *dispatch.DeviceMetadata `json:"device,omitempty"` // Optional
+}
+
 func (api *TokenAPI) RegisterWeb(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userID, ok := middleware.GetUserHandleFromContext(ctx)
@@ -72,13 +136,26 @@ func (api *TokenAPI) RegisterWeb(w http.ResponseWriter, r *http.Request) {
 	}
 	userURN, _ := urn.Parse(userID)
 
+	// The body is the browser's PushSubscription JSON, optionally with a "device" object
+	// alongside it. The subscription decoder ignores unknown keys, so we read it twice.
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		response.WriteJSONError(w, http.StatusBadRequest, "invalid subscription json")
+		return
+	}
+
 	// We decode directly into the Domain Object we defined in the previous step
 	var sub notification.WebPushSubscription
-	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+	if err := json.Unmarshal(body, &sub); err != nil {
 		api.Logger.Error("RegisterWeb: JSON Decode failed", "err", err)
 		response.WriteJSONError(w, http.StatusBadRequest, "invalid subscription json")
 		return
 	}
+	var extras RegisterWebExtras
+	if err := json.Unmarshal(body, &extras); err != nil {
+		response.WriteJSONError(w, http.StatusBadRequest, "invalid device json")
+		return
+	}
 
 	// Validate the Web Object (The "Big JSON" keys must exist)
 	if sub.Endpoint == "" || len(sub.Keys.P256dh) == 0 || len(sub.Keys.Auth) == 0 {
@@ -87,8 +164,13 @@ func (api *TokenAPI) RegisterWeb(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	meta, ok := deviceMetadata(w, extras.Device)
+	if !ok {
+		return
+	}
+
 	// Direct call to Web storage logic
-	if err := api.Store.RegisterWeb(ctx, userURN, sub); err != nil {
+	if err := api.Store.RegisterWeb(ctx, userURN, sub, meta); err != nil {
 		api.Logger.Error("failed to register web", "err", err)
 		response.WriteJSONError(w, http.StatusInternalServerError, "storage failed")
 		return
@@ -123,6 +205,31 @@ func (api *TokenAPI) UnregisterFCM(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// --- UNREGISTER DOOR C: Native iOS (APNs) ---
+
+func (api *TokenAPI) UnregisterAPNs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := middleware.GetUserHandleFromContext(ctx)
+	if !ok {
+		response.WriteJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	userURN, _ := urn.Parse(userID)
+
+	var req RegisterAPNsRequest // Same shape as registration: just the "token"
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.WriteJSONError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if err := api.Store.UnregisterAPNs(ctx, userURN, req.Token); err != nil {
+		// Log but don't fail hard; idempotency is preferred for unregister
+		api.Logger.Warn("failed to unregister apns", "err", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // --- UNREGISTER DOOR B: Web (VAPID) ---
 
 type UnregisterWebRequest struct {
@@ -163,3 +270,18 @@ func (api *TokenAPI) UnregisterWeb(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// --- Helpers ---
+
+// deviceMetadata validates the optional registration metadata.
+// It writes a 400 and returns false when the metadata is unusable.
+func deviceMetadata(w http.ResponseWriter, device *dispatch.DeviceMetadata) (dispatch.DeviceMetadata, bool) {
+	if device == nil {
+		return dispatch.DeviceMetadata{}, true
+	}
+	if err := device.Validate(); err != nil {
+		response.WriteJSONError(w, http.StatusBadRequest, err.Error())
+		return dispatch.DeviceMetadata{}, false
+	}
+	return *device, true
+}
@@ -16,6 +16,7 @@ import (
 
 	// Ensure this import path matches your directory structure
 	"github.com/tinywideclouds/go-notification-service/internal/api"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 
 	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
 	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
@@ -26,25 +27,40 @@ type MockTokenStore struct {
 	mock.Mock
 }
 
-func (m *MockTokenStore) RegisterFCM(ctx context.Context, u urn.URN, token string) error {
-	args := m.Called(ctx, u, token)
+func (m *MockTokenStore) RegisterFCM(ctx context.Context, u urn.URN, token string, meta dispatch.DeviceMetadata) error {
+	args := m.Called(ctx, u, token, meta)
+	return args.Error(0)
+}
+func (m *MockTokenStore) RegisterAPNs(ctx context.Context, u urn.URN, token string, meta dispatch.DeviceMetadata) error {
+	args := m.Called(ctx, u, token, meta)
 	return args.Error(0)
 }
-func (m *MockTokenStore) RegisterWeb(ctx context.Context, u urn.URN, sub notification.WebPushSubscription) error {
-	args := m.Called(ctx, u, sub)
+func (m *MockTokenStore) RegisterWeb(ctx context.Context, u urn.URN, sub notification.WebPushSubscription, meta dispatch.DeviceMetadata) error {
+	args := m.Called(ctx, u, sub, meta)
 	return args.Error(0)
 }
 func (m *MockTokenStore) UnregisterFCM(ctx context.Context, u urn.URN, token string) error {
 	args := m.Called(ctx, u, token)
 	return args.Error(0)
 }
+func (m *MockTokenStore) UnregisterAPNs(ctx context.Context, u urn.URN, token string) error {
+	args := m.Called(ctx, u, token)
+	return args.Error(0)
+}
 func (m *MockTokenStore) UnregisterWeb(ctx context.Context, u urn.URN, endpoint string) error {
 	args := m.Called(ctx, u, endpoint)
 	return args.Error(0)
 }
-func (m *MockTokenStore) Fetch(ctx context.Context, u urn.URN) (*notification.NotificationRequest, error) {
+func (m *MockTokenStore) ListDevices(ctx context.Context, u urn.URN) ([]dispatch.Device, error) {
 	args := m.Called(ctx, u)
-	return args.Get(0).(*notification.NotificationRequest), args.Error(1)
+	return args.Get(0).([]dispatch.Device), args.Error(1)
+}
+func (m *MockTokenStore) DeleteDevice(ctx context.Context, u urn.URN, deviceID string) error {
+	return m.Called(ctx, u, deviceID).Error(0)
+}
+func (m *MockTokenStore) Fetch(ctx context.Context, u urn.URN) (*dispatch.RecipientDevices, error) {
+	args := m.Called(ctx, u)
+	return args.Get(0).(*dispatch.RecipientDevices), args.Error(1)
 }
 
 // --- Setup ---
@@ -56,9 +72,9 @@ func setupAPI(t *testing.T) (*api.TokenAPI, *MockTokenStore) {
 
 // Helper to inject UserID into context (simulating Auth Middleware)
 func withUser(req *http.Request, userID string) *http.Request {
-	// FIX: Use the exported helper from middleware package
-	// Trying to use 'middleware.UserIDKey' directly is illegal as the key is private.
-	ctx := middleware.ContextWithUserID(req.Context(), userID)
+	// The handlers resolve the caller via the Handle (lookup URN), so we inject
+	// the URN as both the user ID and the handle.
+	ctx := middleware.ContextWithUser(req.Context(), userID, userID, "")
 	return req.WithContext(ctx)
 }
 
@@ -77,7 +93,7 @@ func TestRegisterFCM(t *testing.T) {
 		w := httptest.NewRecorder()
 
 		// Expectation: Store receives the string directly
-		mockStore.On("RegisterFCM", mock.Anything, targetURN, "fcm-token-abc").Return(nil)
+		mockStore.On("RegisterFCM", mock.Anything, targetURN, "fcm-token-abc", dispatch.DeviceMetadata{}).Return(nil)
 
 		apiHandler.RegisterFCM(w, req)
 
@@ -85,6 +101,36 @@ func TestRegisterFCM(t *testing.T) {
 		mockStore.AssertExpectations(t)
 	})
 
+	t.Run("Success With Device Metadata", func(t *testing.T) {
+		body := `{"token": "fcm-token-ios", "device": {"os": "ios", "appVersion": "2.4.1", "locale": "en-GB", "timezone": "Europe/London", "name": "Work iPhone"}}`
+		req := withUser(httptest.NewRequest("POST", "/register/fcm", bytes.NewReader([]byte(body))), targetURN.String())
+		w := httptest.NewRecorder()
+
+		expected := dispatch.DeviceMetadata{
+			OS:         "ios",
+			AppVersion: "2.4.1",
+			Locale:     "en-GB",
+			Timezone:   "Europe/London",
+			Name:       "Work iPhone",
+		}
+		mockStore.On("RegisterFCM", mock.Anything, targetURN, "fcm-token-ios", expected).Return(nil)
+
+		apiHandler.RegisterFCM(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockStore.AssertExpectations(t)
+	})
+
+	t.Run("Rejects Unknown Timezone", func(t *testing.T) {
+		body := `{"token": "fcm-token-abc", "device": {"timezone": "Mars/Olympus_Mons"}}`
+		req := withUser(httptest.NewRequest("POST", "/register/fcm", bytes.NewReader([]byte(body))), targetURN.String())
+		w := httptest.NewRecorder()
+
+		apiHandler.RegisterFCM(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
 	t.Run("Rejects Empty Token", func(t *testing.T) {
 		payload := map[string]string{"token": ""} // Empty
 		body, _ := json.Marshal(payload)
@@ -97,6 +143,71 @@ func TestRegisterFCM(t *testing.T) {
 	})
 }
 
+func TestRegisterAPNs(t *testing.T) {
+	apiHandler, mockStore := setupAPI(t)
+	targetURN, _ := urn.Parse("urn:test:user:123")
+
+	t.Run("Success", func(t *testing.T) {
+		payload := map[string]string{"token": "apns-device-token"}
+		body, _ := json.Marshal(payload)
+		req := withUser(httptest.NewRequest("POST", "/register/apns", bytes.NewReader(body)), targetURN.String())
+		w := httptest.NewRecorder()
+
+		// Expectation: Store receives the string on the APNs path, not FCM
+		mockStore.On("RegisterAPNs", mock.Anything, targetURN, "apns-device-token", dispatch.DeviceMetadata{}).Return(nil)
+
+		apiHandler.RegisterAPNs(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockStore.AssertExpectations(t)
+		mockStore.AssertNotCalled(t, "RegisterFCM", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Success With Environment", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]string{"token": "apns-dev-token", "environment": "development"})
+		req := withUser(httptest.NewRequest("POST", "/register/apns", bytes.NewReader(body)), targetURN.String())
+		w := httptest.NewRecorder()
+
+		expected := dispatch.DeviceMetadata{APNsEnvironment: dispatch.APNsDevelopment}
+		mockStore.On("RegisterAPNs", mock.Anything, targetURN, "apns-dev-token", expected).Return(nil)
+
+		apiHandler.RegisterAPNs(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockStore.AssertExpectations(t)
+	})
+
+	t.Run("Rejects Unknown Environment", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]string{"token": "apns-device-token", "environment": "staging"})
+		req := withUser(httptest.NewRequest("POST", "/register/apns", bytes.NewReader(body)), targetURN.String())
+		w := httptest.NewRecorder()
+
+		apiHandler.RegisterAPNs(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Rejects Empty Token", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]string{"token": ""})
+		req := withUser(httptest.NewRequest("POST", "/register/apns", bytes.NewReader(body)), targetURN.String())
+		w := httptest.NewRecorder()
+
+		apiHandler.RegisterAPNs(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Rejects Unauthenticated", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]string{"token": "apns-device-token"})
+		req := httptest.NewRequest("POST", "/register/apns", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+
+		apiHandler.RegisterAPNs(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
 func TestRegisterWeb(t *testing.T) {
 	apiHandler, mockStore := setupAPI(t)
 	targetURN, _ := urn.Parse("urn:test:user:123")
@@ -118,7 +229,27 @@ func TestRegisterWeb(t *testing.T) {
 		w := httptest.NewRecorder()
 
 		// Expectation: Store receives the full struct
-		mockStore.On("RegisterWeb", mock.Anything, targetURN, validSub).Return(nil)
+		mockStore.On("RegisterWeb", mock.Anything, targetURN, validSub, dispatch.DeviceMetadata{}).Return(nil)
+
+		apiHandler.RegisterWeb(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockStore.AssertExpectations(t)
+	})
+
+	t.Run("Success With Device Metadata", func(t *testing.T) {
+		// The browser's subscription JSON plus a sibling "device" object
+		subJSON, _ := json.Marshal(validSub)
+		var body map[string]any
+		_ = json.Unmarshal(subJSON, &body)
+		body["device"] = map[string]string{"os": "web", "locale": "de-DE", "timezone": "Europe/Berlin", "name": "Firefox on Linux"}
+		raw, _ := json.Marshal(body)
+
+		req := withUser(httptest.NewRequest("POST", "/register/web", bytes.NewReader(raw)), targetURN.String())
+		w := httptest.NewRecorder()
+
+		expected := dispatch.DeviceMetadata{OS: "web", Locale: "de-DE", Timezone: "Europe/Berlin", Name: "Firefox on Linux"}
+		mockStore.On("RegisterWeb", mock.Anything, targetURN, validSub, expected).Return(nil)
 
 		apiHandler.RegisterWeb(w, req)
 
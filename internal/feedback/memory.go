@@ -0,0 +1,42 @@
+// --- File: internal/feedback/memory.go ---
+package feedback
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+// MemoryPublisher keeps published events in memory. Intended for tests and local runs.
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []dispatch.FeedbackEvent
+}
+
+// NewMemoryPublisher creates an empty in-memory publisher.
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+// Publish implements dispatch.FeedbackPublisher.
+func (p *MemoryPublisher) Publish(_ context.Context, event dispatch.FeedbackEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+	return nil
+}
+
+// Events returns a copy of everything published so far, optionally filtered by type.
+func (p *MemoryPublisher) Events(types ...dispatch.FeedbackEventType) []dispatch.FeedbackEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []dispatch.FeedbackEvent
+	for _, e := range p.events {
+		if len(types) == 0 || slices.Contains(types, e.Type) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
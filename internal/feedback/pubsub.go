@@ -0,0 +1,47 @@
+// --- File: internal/feedback/pubsub.go ---
+
+// Package feedback publishes per-device delivery facts (see dispatch.FeedbackEvent).
+package feedback
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/illmade-knight/go-dataflow/pkg/messagepipeline"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+// Attribute keys set on every Pub/Sub message, so subscribers can filter
+// (e.g. attributes.eventType = "TokenInvalidated") without decoding the payload.
+const (
+	AttrEventType = "eventType"
+	AttrPlatform  = "platform"
+)
+
+// PubsubPublisher publishes feedback events as JSON to a Pub/Sub topic.
+type PubsubPublisher struct {
+	publisher messagepipeline.SimplePublisher
+}
+
+// NewPubsubPublisher wraps a (non-blocking) simple publisher bound to the feedback topic.
+func NewPubsubPublisher(publisher messagepipeline.SimplePublisher) *PubsubPublisher {
+	return &PubsubPublisher{publisher: publisher}
+}
+
+// Publish implements dispatch.FeedbackPublisher.
+func (p *PubsubPublisher) Publish(ctx context.Context, event dispatch.FeedbackEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal feedback event: %w", err)
+	}
+	return p.publisher.Publish(ctx, payload, map[string]string{
+		AttrEventType: string(event.Type),
+		AttrPlatform:  event.Platform,
+	})
+}
+
+// Stop flushes pending events.
+func (p *PubsubPublisher) Stop(ctx context.Context) error {
+	return p.publisher.Stop(ctx)
+}
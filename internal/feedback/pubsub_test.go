@@ -0,0 +1,63 @@
+package feedback_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/internal/feedback"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+type mockSimplePublisher struct {
+	mock.Mock
+}
+
+func (m *mockSimplePublisher) Publish(ctx context.Context, payload []byte, attributes map[string]string) error {
+	return m.Called(ctx, payload, attributes).Error(0)
+}
+
+func (m *mockSimplePublisher) Stop(ctx context.Context) error {
+	return m.Called(ctx).Error(0)
+}
+
+func TestPubsubPublisher_Publish(t *testing.T) {
+	ctx := context.Background()
+	user, _ := urn.Parse("urn:sm:user:feedback")
+
+	simple := new(mockSimplePublisher)
+	publisher := feedback.NewPubsubPublisher(simple)
+
+	event := dispatch.FeedbackEvent{
+		Type:           dispatch.EventTokenInvalidated,
+		NotificationID: "req-1",
+		RecipientID:    user,
+		Platform:       dispatch.PlatformAPNs,
+		Address:        "apns-dead",
+		Outcome:        dispatch.OutcomeInvalid,
+		Reason:         "Unregistered",
+	}
+
+	var captured []byte
+	simple.On("Publish", ctx, mock.Anything, map[string]string{
+		feedback.AttrEventType: "TokenInvalidated",
+		feedback.AttrPlatform:  "apns",
+	}).Run(func(args mock.Arguments) {
+		captured = args.Get(1).([]byte)
+	}).Return(nil)
+
+	require.NoError(t, publisher.Publish(ctx, event))
+	simple.AssertExpectations(t)
+
+	// The payload is the JSON event, readable by any subscriber
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(captured, &decoded))
+	assert.Equal(t, "TokenInvalidated", decoded["type"])
+	assert.Equal(t, "urn:sm:user:feedback", decoded["recipientId"])
+	assert.Equal(t, "apns", decoded["platform"])
+	assert.Equal(t, "Unregistered", decoded["reason"])
+}
@@ -0,0 +1,103 @@
+package pipeline
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
+)
+
+// localizer renders a templated request once per device locale. Requests
+// without a template (or a processor without templates) keep their message.
+type localizer struct {
+	renderer dispatch.TemplateRenderer
+	request  *dispatch.Request
+	msg      dispatch.Message
+	logger   *slog.Logger
+	rendered map[string]dispatch.Message
+}
+
+func newLocalizer(renderer dispatch.TemplateRenderer, request *dispatch.Request, msg dispatch.Message, logger *slog.Logger) *localizer {
+	return &localizer{
+		renderer: renderer,
+		request:  request,
+		msg:      msg,
+		logger:   logger,
+		rendered: make(map[string]dispatch.Message),
+	}
+}
+
+// message returns the message for a device locale. A failed rendering falls
+// back to the request's own content. Data-only messages show nothing to render.
+func (l *localizer) message(locale string) dispatch.Message {
+	if l.renderer == nil || l.request.Template == "" || l.msg.DataOnly {
+		return l.msg
+	}
+	if msg, ok := l.rendered[locale]; ok {
+		return msg
+	}
+	msg := l.msg
+	content, err := l.renderer.Render(l.request.Template, locale, l.request.TemplateVars)
+	switch {
+	case err == nil:
+		if content.Sound == "" {
+			content.Sound = msg.Content.Sound
+		}
+		msg.Content = content
+	case l.request.Template == dispatch.DigestTemplate && errors.Is(err, dispatch.ErrTemplateNotFound):
+		// The digest template is optional: without it the English summary is sent
+	default:
+		l.logger.Warn("Failed to render template; sending the request content", "template", l.request.Template, "locale", locale, "err", err)
+	}
+	l.rendered[locale] = msg
+	return msg
+}
+
+// localized is a batch of one path's devices that get the same rendering.
+type localized[T any] struct {
+	msg     dispatch.Message
+	targets []T
+}
+
+// localize splits a path's targets into batches by the message rendered for
+// each device's locale. Locales that render the same text share a batch, so an
+// untemplated request stays a single batch.
+func localize[T any](l *localizer, targets []T, locale func(T) string) []localized[T] {
+	var batches []localized[T]
+	index := make(map[notification.NotificationContent]int)
+	for _, target := range targets {
+		msg := l.message(locale(target))
+		i, ok := index[msg.Content]
+		if !ok {
+			i = len(batches)
+			index[msg.Content] = i
+			batches = append(batches, localized[T]{msg: msg})
+		}
+		batches[i].targets = append(batches[i].targets, target)
+	}
+	return batches
+}
+
+// splitByAPNsEnvironment splits batches further by each token's APNs
+// environment, so every batch goes to a single gateway. Batches keep their
+// order, and sub-batches the order environments are first seen in.
+func splitByAPNsEnvironment(batches []localized[string], environment func(token string) string) []localized[string] {
+	var split []localized[string]
+	for _, batch := range batches {
+		index := make(map[string]int)
+		for _, token := range batch.targets {
+			env := environment(token)
+			i, ok := index[env]
+			if !ok {
+				i = len(split)
+				index[env] = i
+				msg := batch.msg
+				msg.APNsEnvironment = env
+				split = append(split, localized[string]{msg: msg})
+			}
+			split[i].targets = append(split[i].targets, token)
+		}
+	}
+	return split
+}
@@ -2,83 +2,591 @@ package pipeline
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"time"
 
+	"github.com/VictoriaMetrics/metrics"
 	"github.com/illmade-knight/go-dataflow/pkg/messagepipeline"
 	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
 )
 
+// expiredNotifications counts notifications ACKed and dropped because their TTL ran out.
+// It is exported on the base server's /metrics endpoint.
+var expiredNotifications = metrics.NewCounter("notifications_expired_total")
+
+// ProcessorOption configures the optional stages of the processor.
+type ProcessorOption func(*processorOptions)
+
+type processorOptions struct {
+	ledger      dispatch.DeliveryLedger
+	dedup       dispatch.DedupStore
+	dedupWindow time.Duration
+	feedback    dispatch.FeedbackPublisher
+	preferences dispatch.PreferencesStore
+	schedule    dispatch.ScheduleStore
+
+	coalesce       dispatch.CoalesceStore
+	coalesceWindow time.Duration
+
+	digests          dispatch.DigestStore
+	digestCategories map[string]dispatch.DigestPeriod
+	digestDailyAt    string
+
+	templates dispatch.TemplateRenderer
+}
+
+// WithDeliveryLedger enables per-device delivery tracking. When set, a redelivered
+// message only re-targets the devices that have not yet been successfully served.
+func WithDeliveryLedger(ledger dispatch.DeliveryLedger) ProcessorOption {
+	return func(o *processorOptions) {
+		o.ledger = ledger
+	}
+}
+
+// WithDeduplication drops requests whose RequestID was already claimed by a
+// different Pub/Sub message within the window. Duplicates are ACKed without
+// touching the token store or any dispatcher.
+func WithDeduplication(store dispatch.DedupStore, window time.Duration) ProcessorOption {
+	return func(o *processorOptions) {
+		o.dedup = store
+		o.dedupWindow = window
+	}
+}
+
+// WithFeedback publishes a per-device event (TokenInvalidated, NotificationDelivered,
+// NotificationFailed) for every dispatch result. Publish failures are logged only.
+func WithFeedback(publisher dispatch.FeedbackPublisher) ProcessorOption {
+	return func(o *processorOptions) {
+		o.feedback = publisher
+	}
+}
+
+// WithPreferences applies the recipient's notification preferences. Muted users and
+// opted-out categories are ACKed without a dispatch. A failed lookup fails open.
+func WithPreferences(store dispatch.PreferencesStore) ProcessorOption {
+	return func(o *processorOptions) {
+		o.preferences = store
+	}
+}
+
+// WithScheduleStore enables deferred delivery: requests with a future DeliverAt,
+// and notifications that arrive during a user's "defer" quiet hours, are stored
+// and replayed when due. Without it, both are delivered immediately ("defer"
+// quiet hours fall back to silent delivery).
+func WithScheduleStore(store dispatch.ScheduleStore) ProcessorOption {
+	return func(o *processorOptions) {
+		o.schedule = store
+	}
+}
+
+// WithCoalescing holds requests that share a recipient and CollapseKey for the
+// window after the first one, then delivers the burst as one summary notification
+// (latest content plus the count). The flush is parked in the schedule store, so
+// it needs WithScheduleStore. Urgent requests are never held.
+func WithCoalescing(store dispatch.CoalesceStore, window time.Duration) ProcessorOption {
+	return func(o *processorOptions) {
+		o.coalesce = store
+		o.coalesceWindow = window
+	}
+}
+
+// WithDigests rolls non-urgent requests in digest categories up into one summary
+// per recipient and period. categories holds the defaults, which a user's
+// preferences override; daily digests go out at dailyAt ("HH:MM") in the
+// recipient's timezone. Delivery is parked in the schedule store, so it needs
+// WithScheduleStore.
+func WithDigests(store dispatch.DigestStore, categories map[string]dispatch.DigestPeriod, dailyAt string) ProcessorOption {
+	return func(o *processorOptions) {
+		o.digests = store
+		o.digestCategories = categories
+		o.digestDailyAt = dailyAt
+	}
+}
+
+// WithTemplates renders requests that name a Template, once per device locale,
+// so each device gets its own language. Rendering failures fall back to the
+// request's Content.
+func WithTemplates(renderer dispatch.TemplateRenderer) ProcessorOption {
+	return func(o *processorOptions) {
+		o.templates = renderer
+	}
+}
+
 // NewProcessor creates the logic that handles the "Fan-Out".
 // We inject specific dispatchers because the interfaces are now different (Strings vs Objects).
+// apnsDispatcher may be nil when native iOS delivery is not configured; APNs tokens are then skipped.
+//
+// Every delivery path is attempted even if an earlier one fails. Failures are joined
+// into a single (retryable) error so the message is Nacked once all paths have run.
 func NewProcessor(
 	fcmDispatcher dispatch.Dispatcher, // Handles []string (Mobile)
+	apnsDispatcher dispatch.Dispatcher, // Handles []string (Native iOS)
 	webDispatcher dispatch.WebDispatcher, // Handles []WebPushSubscription (Web)
 	tokenStore dispatch.TokenStore,
 	logger *slog.Logger,
-) messagepipeline.StreamProcessor[notification.NotificationRequest] {
+	opts ...ProcessorOption,
+) messagepipeline.StreamProcessor[dispatch.Request] {
+	var options processorOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
 
-	return func(ctx context.Context, original messagepipeline.Message, request *notification.NotificationRequest) error {
+	return func(ctx context.Context, original messagepipeline.Message, request *dispatch.Request) error {
 		procLogger := logger.With(
 			"recipient_id", request.RecipientID.String(),
+			"request_id", request.RequestID,
 			"pubsub_msg_id", original.ID,
 		)
 
-		// 1. Fetch & Fan-Out (The Lookup)
-		// We re-fetch the request data from the store to populate the token buckets.
+		// The RequestID identifies "this notification" in the ledger. It defaults
+		// to the Pub/Sub message ID, which is stable across redeliveries.
+		notificationID := request.RequestID
+		if notificationID == "" {
+			notificationID = original.ID
+		}
+
+		// Scheduler replays say why they were parked; fresh Pub/Sub deliveries don't
+		replayReason := original.Attributes[dispatch.ReplayReasonAttribute]
+
+		// Producer command: cancel a scheduled notification instead of sending one
+		if request.Cancel {
+			return cancelScheduled(ctx, procLogger, options, request.RequestID, original.ID)
+		}
+
+		// 0. Idempotency: drop duplicates before any lookup or dispatch
+		if options.dedup != nil {
+			holder, err := options.dedup.Claim(ctx, notificationID, original.ID, options.dedupWindow)
+			if err != nil {
+				// Fail open: the ledger still protects devices from redelivery duplicates.
+				procLogger.Warn("Failed to check idempotency key; processing anyway", "err", err)
+			} else if holder != original.ID {
+				procLogger.Info("Duplicate request dropped", "first_pubsub_msg_id", holder)
+				return nil
+			}
+		}
+
+		// Expiry: a late notification ("your ride is here") is worse than none.
+		// Checked before any stage parks, holds or looks up anything for it. Digest
+		// and burst flushes only stand in for their group, which is checked below.
+		standIn := replayReason == dispatch.ScheduleReasonDigest || replayReason == dispatch.ScheduleReasonCoalesce
+		if !standIn && request.IsExpired(time.Now()) {
+			expiredNotifications.Inc()
+			procLogger.Info("Notification expired; dropping", "expires_at", request.ExpiresAt)
+			return nil
+		}
+
+		// 1. Scheduling: a request for later is parked until it is due (replays already are)
+		if replayReason == "" && request.IsScheduledAfter(time.Now()) {
+			if options.schedule == nil {
+				procLogger.Warn("Scheduled delivery is not configured; delivering now", "deliver_at", request.DeliverAt)
+			} else {
+				err := options.schedule.Schedule(ctx, dispatch.ScheduledNotification{
+					ID:        notificationID,
+					MessageID: original.ID,
+					Request:   *request,
+					DeliverAt: request.DeliverAt,
+					Reason:    dispatch.ScheduleReasonDeliverAt,
+				})
+				if err != nil {
+					procLogger.Error("Failed to schedule notification", "err", err)
+					return err // Retryable
+				}
+				procLogger.Info("Notification scheduled", "deliver_at", request.DeliverAt)
+				return nil
+			}
+		}
+
+		// 2. Preferences: the user may have muted us or opted out of this category.
+		// Data-only pushes show nothing, so preferences (and quiet hours) don't apply.
+		var prefs *dispatch.Preferences
+		if options.preferences != nil && !request.DataOnly {
+			category := request.ResolvedCategory()
+			var err error
+			prefs, err = options.preferences.Get(ctx, request.RecipientID)
+			if err != nil {
+				// Fail open: a missed opt-out is better than a lost notification.
+				procLogger.Warn("Failed to read notification preferences; delivering anyway", "err", err)
+			} else if !prefs.Allows(category) {
+				procLogger.Info("Notification suppressed by user preferences", "muted", prefs.Muted, "category", category)
+				return nil
+			}
+		}
+
+		// 3. Digests: low-value categories are rolled up into one notification per period
+		if options.digests != nil {
+			category := request.ResolvedCategory()
+			period := prefs.DigestPeriod(category, options.digestCategories)
+			switch {
+			case replayReason == dispatch.ScheduleReasonDigest:
+				// The period is over: deliver the summary in place of the opening request
+				digest, err := options.digests.Get(ctx, request.RecipientID, request.DeliverAt)
+				if err != nil {
+					procLogger.Error("Failed to read digest", "err", err)
+					return err // Retryable: the delivery is claimed again
+				}
+				if digest == nil || len(digest.Entries) == 0 {
+					procLogger.Info("Digest already delivered; acknowledging.")
+					return nil
+				}
+				summary := digest.Summary()
+				summary.RequestID = request.RequestID
+				request = &summary
+				procLogger.Info("Delivering digest", "count", len(digest.Entries))
+
+			case replayReason == "" && period != "" && !request.IsUrgent() && !request.DataOnly:
+				if options.schedule == nil {
+					procLogger.Warn("Digests need scheduled delivery; delivering now", "category", category)
+					break
+				}
+				devices, err := tokenStore.Fetch(ctx, request.RecipientID)
+				if err != nil {
+					procLogger.Error("Failed to fetch device tokens", "err", err)
+					return err
+				}
+				var timezone string
+				if prefs != nil && prefs.QuietHours != nil {
+					timezone = prefs.QuietHours.Timezone
+				}
+				deliverAt, err := period.End(time.Now(), options.digestDailyAt, recipientLocation(timezone, devices))
+				if err != nil {
+					procLogger.Warn("Invalid digest period; delivering now", "period", period, "err", err)
+					break
+				}
+				first, err := options.digests.Add(ctx, request.RecipientID, deliverAt, dispatch.DigestEntry{
+					MessageID:    original.ID,
+					Category:     category,
+					Content:      request.Content,
+					Template:     request.Template,
+					TemplateVars: request.TemplateVars,
+					ReceivedAt:   time.Now(),
+				})
+				if err != nil {
+					// Fail open: an extra push is better than a lost one.
+					procLogger.Warn("Failed to add notification to digest; delivering now", "err", err)
+					break
+				}
+				// Every entry (re)schedules the delivery, which is keyed by DigestID. Leaving
+				// it to the first would orphan the digest if that schedule failed and the
+				// redelivery landed in the next period.
+				// The replay carries the period end so it can find the digest again.
+				scheduled := *request
+				scheduled.RequestID = dispatch.DigestID(request.RecipientID, deliverAt)
+				scheduled.DeliverAt = deliverAt
+				err = options.schedule.Schedule(ctx, dispatch.ScheduledNotification{
+					ID:        scheduled.RequestID,
+					MessageID: original.ID,
+					Request:   scheduled,
+					DeliverAt: deliverAt,
+					Reason:    dispatch.ScheduleReasonDigest,
+				})
+				if err != nil {
+					procLogger.Error("Failed to schedule digest", "err", err)
+					return err // Retryable
+				}
+				if first {
+					procLogger.Info("Digest opened", "period", period, "deliver_at", deliverAt)
+				} else {
+					procLogger.Info("Notification added to digest", "period", period, "deliver_at", deliverAt)
+				}
+				return nil
+			}
+		}
+
+		// 4. Coalescing: a burst for one conversation becomes a single notification
+		if options.coalesce != nil {
+			group := dispatch.CoalesceGroup(request.RecipientID, request.CollapseKey)
+			switch {
+			case replayReason == dispatch.ScheduleReasonCoalesce:
+				// The window is over: deliver the burst in place of its opening request
+				burst, err := options.coalesce.Take(ctx, group, original.ID)
+				if err != nil {
+					procLogger.Error("Failed to take coalesced burst", "err", err)
+					return err // Retryable: the flush is claimed again
+				}
+				if burst == nil {
+					procLogger.Warn("Coalesced burst not found; delivering the opening request")
+					break
+				}
+				summary := burst.Summary()
+				summary.RequestID = request.RequestID
+				request = &summary
+				procLogger.Info("Flushing coalesced burst", "count", burst.Count())
+
+			case replayReason == "" && request.CollapseKey != "" && !request.IsUrgent():
+				if options.schedule == nil {
+					procLogger.Warn("Coalescing needs scheduled delivery; delivering now")
+					break
+				}
+				opener, err := options.coalesce.Hold(ctx, group, original.ID, *request)
+				if err != nil {
+					// Fail open: an extra push is better than a lost one.
+					procLogger.Warn("Failed to hold request for coalescing; delivering now", "err", err)
+					break
+				}
+				if opener == original.ID {
+					flushAt := time.Now().Add(options.coalesceWindow)
+					err := options.schedule.Schedule(ctx, dispatch.ScheduledNotification{
+						ID:        notificationID,
+						MessageID: original.ID,
+						Request:   *request,
+						DeliverAt: flushAt,
+						Reason:    dispatch.ScheduleReasonCoalesce,
+					})
+					if err != nil {
+						procLogger.Error("Failed to schedule coalesced flush", "err", err)
+						return err // Retryable: the redelivery is held again and reschedules
+					}
+					procLogger.Info("Coalescing burst opened", "flush_at", flushAt)
+				} else {
+					procLogger.Info("Request held in coalescing burst", "opener_pubsub_msg_id", opener)
+				}
+				return nil
+			}
+		}
+
+		// 5. Fetch & Fan-Out (The Lookup)
 		// The incoming 'request' has the Content, but the Store has the Tokens.
-		enrichedReq, err := tokenStore.Fetch(ctx, request.RecipientID)
+		devices, err := tokenStore.Fetch(ctx, request.RecipientID)
 		if err != nil {
 			procLogger.Error("Failed to fetch device tokens", "err", err)
 			return err
 		}
 
-		// 2. Path A: FCM (Mobile)
-		if len(enrichedReq.FCMTokens) > 0 {
-			receipt, invalidTokens, err := fcmDispatcher.Dispatch(ctx, enrichedReq.FCMTokens, request.Content, request.DataPayload)
+		if devices.IsEmpty() {
+			procLogger.Info("No devices registered for user; dropping notification.")
+			return nil
+		}
 
-			// Self-Healing (Strings)
-			if len(invalidTokens) > 0 {
-				procLogger.Info("Cleaning up invalid FCM tokens", "count", len(invalidTokens))
-				for _, t := range invalidTokens {
-					if err := tokenStore.UnregisterFCM(ctx, request.RecipientID, t); err != nil {
-						procLogger.Warn("Failed to delete FCM token", "token", t, "err", err)
+		msg := request.Message()
+
+		// 6. Quiet hours: non-urgent notifications are dropped, hushed or deferred
+		if prefs != nil && prefs.QuietHours != nil && !request.IsUrgent() {
+			quiet := prefs.QuietHours
+			if active, until := quiet.Window(time.Now(), recipientLocation(quiet.Timezone, devices)); active {
+				switch {
+				case quiet.Mode == dispatch.QuietDrop:
+					procLogger.Info("Notification dropped during quiet hours", "until", until)
+					return nil
+				case quiet.Mode == dispatch.QuietDefer && options.schedule != nil:
+					err := options.schedule.Schedule(ctx, dispatch.ScheduledNotification{
+						ID:        notificationID,
+						MessageID: original.ID,
+						Request:   *request,
+						DeliverAt: until,
+						Reason:    dispatch.ScheduleReasonQuietHours,
+					})
+					if err != nil {
+						procLogger.Error("Failed to defer notification", "err", err)
+						return err // Retryable: better late than lost
+					}
+					procLogger.Info("Notification deferred until quiet hours end", "deliver_at", until)
+					return nil
+				default:
+					if quiet.Mode == dispatch.QuietDefer {
+						procLogger.Warn("Deferred delivery is not configured; delivering silently")
 					}
+					msg.Silent = true
 				}
 			}
+		}
 
+		// 7. Ledger: skip devices a previous attempt already served
+		if options.ledger != nil {
+			history, err := options.ledger.Outcomes(ctx, notificationID)
 			if err != nil {
-				procLogger.Error("FCM Dispatch failed", "err", err)
-				return err // Retryable
+				// Fail open: a duplicate is better than a lost notification.
+				procLogger.Warn("Failed to read delivery ledger; targeting all devices", "err", err)
+			} else if len(history) > 0 {
+				devices = devices.Pending(history)
+				if devices.IsEmpty() {
+					procLogger.Info("All devices already served by a previous attempt; acknowledging.")
+					return nil
+				}
+				procLogger.Info("Retrying notification for pending devices only", "already_served", len(history))
 			}
-			procLogger.Info("FCM Dispatched", "receipt", receipt)
 		}
 
-		// 3. Path B: Web (VAPID)
-		if len(enrichedReq.WebSubscriptions) > 0 {
-			receipt, invalidSubs, err := webDispatcher.Dispatch(ctx, enrichedReq.WebSubscriptions, request.Content, request.DataPayload)
+		// Expiry again: a request may expire while parked (or held in a burst),
+		// so replays are checked just before dispatch too
+		if request.IsExpired(time.Now()) {
+			expiredNotifications.Inc()
+			procLogger.Info("Notification expired before dispatch; dropping", "expires_at", request.ExpiresAt)
+			return nil
+		}
 
-			// Self-Healing (Objects - clean up by Endpoint)
-			if len(invalidSubs) > 0 {
-				procLogger.Info("Cleaning up invalid Web subscriptions", "count", len(invalidSubs))
-				for _, sub := range invalidSubs {
-					if err := tokenStore.UnregisterWeb(ctx, request.RecipientID, sub.Endpoint); err != nil {
-						procLogger.Warn("Failed to delete Web subscription", "endpoint", sub.Endpoint, "err", err)
+		outcomes := make(map[string]dispatch.DeliveryOutcome)
+		var errs []error
+		// Templated requests are rendered per device locale; each path may split into batches
+		localizer := newLocalizer(options.templates, request, msg, procLogger)
+		localeOf := func(platform string) func(address string) string {
+			return func(address string) string { return devices.MetadataFor(platform, address).Locale }
+		}
+
+		// 8. Path A: FCM (Mobile)
+		for _, batch := range localize(localizer, devices.FCMTokens, localeOf(dispatch.PlatformFCM)) {
+			result, err := fcmDispatcher.Dispatch(ctx, batch.targets, batch.msg)
+
+			// Self-Healing (Strings)
+			cleanup(ctx, procLogger, "FCM", result, func(t string) error {
+				return tokenStore.UnregisterFCM(ctx, request.RecipientID, t)
+			})
+			recordOutcomes(outcomes, result)
+			publishFeedback(ctx, procLogger, options.feedback, notificationID, request, result)
+
+			if err != nil {
+				procLogger.Error("FCM Dispatch failed", "err", err, "result", result)
+				errs = append(errs, fmt.Errorf("fcm: %w", err)) // Retryable
+			} else {
+				procLogger.Info("FCM Dispatched", "result", result)
+			}
+		}
+
+		// 9. Path C: APNs (Native iOS)
+		if len(devices.APNsTokens) > 0 {
+			if apnsDispatcher == nil {
+				procLogger.Warn("APNs devices registered but APNs is not configured; skipping", "count", len(devices.APNsTokens))
+			} else {
+				// Tokens only work on the gateway (production or development) that issued them
+				environment := func(token string) string {
+					return devices.MetadataFor(dispatch.PlatformAPNs, token).APNsEnvironment
+				}
+				batches := localize(localizer, devices.APNsTokens, localeOf(dispatch.PlatformAPNs))
+				for _, batch := range splitByAPNsEnvironment(batches, environment) {
+					result, err := apnsDispatcher.Dispatch(ctx, batch.targets, batch.msg)
+
+					// Self-Healing (Strings)
+					cleanup(ctx, procLogger, "APNs", result, func(t string) error {
+						return tokenStore.UnregisterAPNs(ctx, request.RecipientID, t)
+					})
+					recordOutcomes(outcomes, result)
+					publishFeedback(ctx, procLogger, options.feedback, notificationID, request, result)
+
+					if err != nil {
+						procLogger.Error("APNs Dispatch failed", "err", err, "result", result)
+						errs = append(errs, fmt.Errorf("apns: %w", err)) // Retryable
+					} else {
+						procLogger.Info("APNs Dispatched", "result", result)
 					}
 				}
 			}
+		}
+
+		// 10. Path B: Web (VAPID)
+		webLocale := func(sub notification.WebPushSubscription) string {
+			return devices.MetadataFor(dispatch.PlatformWeb, sub.Endpoint).Locale
+		}
+		for _, batch := range localize(localizer, devices.WebSubscriptions, webLocale) {
+			result, err := webDispatcher.Dispatch(ctx, batch.targets, batch.msg)
+
+			// Self-Healing (Objects - clean up by Endpoint)
+			cleanup(ctx, procLogger, "Web", result, func(endpoint string) error {
+				return tokenStore.UnregisterWeb(ctx, request.RecipientID, endpoint)
+			})
+			recordOutcomes(outcomes, result)
+			publishFeedback(ctx, procLogger, options.feedback, notificationID, request, result)
 
 			if err != nil {
-				procLogger.Error("Web Dispatch failed", "err", err)
-				return err // Retryable
+				procLogger.Error("Web Dispatch failed", "err", err, "result", result)
+				errs = append(errs, fmt.Errorf("web: %w", err)) // Retryable
+			} else {
+				procLogger.Info("Web Dispatched", "result", result)
 			}
-			procLogger.Info("Web Dispatched", "receipt", receipt)
 		}
 
-		if len(enrichedReq.FCMTokens) == 0 && len(enrichedReq.WebSubscriptions) == 0 {
-			procLogger.Info("No devices registered for user; dropping notification.")
+		// 11. Ledger: remember who was served so a retry skips them
+		if options.ledger != nil && len(outcomes) > 0 {
+			if err := options.ledger.Record(ctx, notificationID, outcomes); err != nil {
+				procLogger.Warn("Failed to record delivery outcomes", "err", err)
+			}
+		}
+
+		return errors.Join(errs...)
+	}
+}
+
+// cancelScheduled removes the pending notification with id. When the cancel
+// overtakes the request it cancels (Pub/Sub does not order them), the claim on
+// the request's idempotency key makes the request a duplicate when it arrives.
+func cancelScheduled(ctx context.Context, logger *slog.Logger, options processorOptions, id, messageID string) error {
+	if options.schedule == nil {
+		logger.Warn("Scheduled delivery is not configured; nothing to cancel")
+		return nil
+	}
+	if options.dedup != nil {
+		if _, err := options.dedup.Claim(ctx, id, "cancel:"+messageID, options.dedupWindow); err != nil {
+			logger.Warn("Failed to claim idempotency key for cancelled request", "err", err)
 		}
+	}
 
+	err := options.schedule.CancelByID(ctx, id)
+	switch {
+	case errors.Is(err, dispatch.ErrScheduleNotFound):
+		logger.Info("No scheduled notification to cancel; already delivered, cancelled or not yet received")
 		return nil
+	case err != nil:
+		logger.Error("Failed to cancel scheduled notification", "err", err)
+		return err // Retryable
+	}
+	logger.Info("Scheduled notification cancelled by producer")
+	return nil
+}
+
+// recipientLocation picks the zone quiet hours and digests are evaluated in: the
+// user's explicit choice, else the zone of their devices, else UTC.
+func recipientLocation(timezone string, devices *dispatch.RecipientDevices) *time.Location {
+	if timezone != "" {
+		if loc, err := time.LoadLocation(timezone); err == nil {
+			return loc
+		}
+	}
+	if loc := devices.Location(); loc != nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// cleanup unregisters the devices a dispatcher reported as fatally invalid.
+func cleanup(ctx context.Context, logger *slog.Logger, path string, result *dispatch.DispatchResult, unregister func(address string) error) {
+	if result == nil {
+		return
+	}
+	invalid := result.Invalid()
+	if len(invalid) == 0 {
+		return
+	}
+	logger.Info("Cleaning up invalid "+path+" devices", "count", len(invalid))
+	for _, address := range invalid {
+		if err := unregister(address); err != nil {
+			logger.Warn("Failed to delete "+path+" device", "address", address, "err", err)
+		}
+	}
+}
+
+// publishFeedback emits one event per device in the result (best effort).
+func publishFeedback(ctx context.Context, logger *slog.Logger, publisher dispatch.FeedbackPublisher, notificationID string, request *dispatch.Request, result *dispatch.DispatchResult) {
+	if publisher == nil {
+		return
+	}
+	for _, event := range dispatch.FeedbackEvents(notificationID, request.RecipientID, result, time.Now()) {
+		if err := publisher.Publish(ctx, event); err != nil {
+			logger.Warn("Failed to publish feedback event", "type", event.Type, "platform", event.Platform, "err", err)
+		}
+	}
+}
+
+// recordOutcomes copies a path's final per-device outcomes into the ledger map.
+// Retryable devices are left out so a redelivery targets them again. Results
+// returned alongside an error still count: the devices they served are done.
+func recordOutcomes(outcomes map[string]dispatch.DeliveryOutcome, result *dispatch.DispatchResult) {
+	if result == nil {
+		return
+	}
+	for _, d := range result.Devices {
+		if d.Outcome.IsFinal() {
+			outcomes[dispatch.DeviceKey(result.Platform, d.Address)] = d.Outcome
+		}
 	}
 }
@@ -2,14 +2,22 @@ package pipeline_test
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log/slog"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/illmade-knight/go-dataflow/pkg/messagepipeline"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/internal/feedback"
 	"github.com/tinywideclouds/go-notification-service/internal/pipeline"
+	"github.com/tinywideclouds/go-notification-service/internal/storage/memory"
+	"github.com/tinywideclouds/go-notification-service/internal/templates"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
 	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
 )
@@ -20,14 +28,14 @@ func newTestLogger() *slog.Logger {
 
 // --- Typed Mocks ---
 
-// Mock for FCM (String-based)
+// Mock for FCM and APNs (String-based)
 type mockFCMDispatcher struct {
 	mock.Mock
 }
 
-func (m *mockFCMDispatcher) Dispatch(ctx context.Context, tokens []string, content notification.NotificationContent, data map[string]string) (string, []string, error) {
-	args := m.Called(ctx, tokens, content, data)
-	return args.String(0), args.Get(1).([]string), args.Error(2)
+func (m *mockFCMDispatcher) Dispatch(ctx context.Context, tokens []string, msg dispatch.Message) (*dispatch.DispatchResult, error) {
+	args := m.Called(ctx, tokens, msg)
+	return args.Get(0).(*dispatch.DispatchResult), args.Error(1)
 }
 
 // Mock for Web (Object-based)
@@ -35,9 +43,18 @@ type mockWebDispatcher struct {
 	mock.Mock
 }
 
-func (m *mockWebDispatcher) Dispatch(ctx context.Context, subs []notification.WebPushSubscription, content notification.NotificationContent, data map[string]string) (string, []notification.WebPushSubscription, error) {
-	args := m.Called(ctx, subs, content, data)
-	return args.String(0), args.Get(1).([]notification.WebPushSubscription), args.Error(2)
+func (m *mockWebDispatcher) Dispatch(ctx context.Context, subs []notification.WebPushSubscription, msg dispatch.Message) (*dispatch.DispatchResult, error) {
+	args := m.Called(ctx, subs, msg)
+	return args.Get(0).(*dispatch.DispatchResult), args.Error(1)
+}
+
+// result builds a DispatchResult with every address in the same outcome.
+func result(platform string, outcome dispatch.DeliveryOutcome, addresses ...string) *dispatch.DispatchResult {
+	r := dispatch.NewDispatchResult(platform)
+	for _, a := range addresses {
+		r.Add(dispatch.DeviceResult{Address: a, Outcome: outcome})
+	}
+	return r
 }
 
 type mockTokenStore struct {
@@ -45,23 +62,38 @@ type mockTokenStore struct {
 }
 
 // Implement only what Processor uses
-func (m *mockTokenStore) Fetch(ctx context.Context, user urn.URN) (*notification.NotificationRequest, error) {
+func (m *mockTokenStore) ListDevices(ctx context.Context, u urn.URN) ([]dispatch.Device, error) {
+	args := m.Called(ctx, u)
+	return args.Get(0).([]dispatch.Device), args.Error(1)
+}
+func (m *mockTokenStore) DeleteDevice(ctx context.Context, u urn.URN, deviceID string) error {
+	return m.Called(ctx, u, deviceID).Error(0)
+}
+func (m *mockTokenStore) Fetch(ctx context.Context, user urn.URN) (*dispatch.RecipientDevices, error) {
 	args := m.Called(ctx, user)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).(*notification.NotificationRequest), args.Error(1)
+	return args.Get(0).(*dispatch.RecipientDevices), args.Error(1)
 }
 func (m *mockTokenStore) UnregisterFCM(ctx context.Context, user urn.URN, token string) error {
 	return m.Called(ctx, user, token).Error(0)
 }
+func (m *mockTokenStore) UnregisterAPNs(ctx context.Context, user urn.URN, token string) error {
+	return m.Called(ctx, user, token).Error(0)
+}
 func (m *mockTokenStore) UnregisterWeb(ctx context.Context, user urn.URN, endpoint string) error {
 	return m.Called(ctx, user, endpoint).Error(0)
 }
 
 // Satisfy strict interface (stubs for unused methods)
-func (m *mockTokenStore) RegisterFCM(_ context.Context, _ urn.URN, _ string) error { return nil }
-func (m *mockTokenStore) RegisterWeb(_ context.Context, _ urn.URN, _ notification.WebPushSubscription) error {
+func (m *mockTokenStore) RegisterFCM(_ context.Context, _ urn.URN, _ string, _ dispatch.DeviceMetadata) error {
+	return nil
+}
+func (m *mockTokenStore) RegisterAPNs(_ context.Context, _ urn.URN, _ string, _ dispatch.DeviceMetadata) error {
+	return nil
+}
+func (m *mockTokenStore) RegisterWeb(_ context.Context, _ urn.URN, _ notification.WebPushSubscription, _ dispatch.DeviceMetadata) error {
 	return nil
 }
 
@@ -71,21 +103,25 @@ func TestProcessor_Routing(t *testing.T) {
 	testURN, _ := urn.Parse("urn:sm:user:test-processor")
 
 	// Input Message (Content only, no tokens)
-	inboundReq := &notification.NotificationRequest{
-		RecipientID: testURN,
-		Content:     notification.NotificationContent{Title: "Hello"},
+	inboundReq := &dispatch.Request{
+		NotificationRequest: notification.NotificationRequest{
+			RecipientID: testURN,
+			Content:     notification.NotificationContent{Title: "Hello"},
+		},
 	}
 
 	t.Run("Routes Mixed Traffic Correctly", func(t *testing.T) {
 		fcmMock := new(mockFCMDispatcher)
+		apnsMock := new(mockFCMDispatcher)
 		webMock := new(mockWebDispatcher)
 		storeMock := new(mockTokenStore)
 
 		// 1. Setup Store Response (The Fan-Out)
-		// Return 1 FCM token and 1 Web Subscription
-		populatedReq := &notification.NotificationRequest{
+		// Return 1 FCM token, 1 APNs token and 1 Web Subscription
+		populatedReq := &dispatch.RecipientDevices{
 			RecipientID: testURN,
 			FCMTokens:   []string{"fcm-123"},
+			APNsTokens:  []string{"apns-456"},
 			WebSubscriptions: []notification.WebPushSubscription{
 				{Endpoint: "https://web.push/abc"},
 			},
@@ -93,22 +129,104 @@ func TestProcessor_Routing(t *testing.T) {
 		storeMock.On("Fetch", mock.Anything, testURN).Return(populatedReq, nil)
 
 		// 2. Setup Dispatch Expectations
-		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-123"}, inboundReq.Content, mock.Anything).
-			Return("ok", []string{}, nil)
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-123"}, inboundReq.Message()).
+			Return(result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-123"), nil)
 
-		webMock.On("Dispatch", mock.Anything, populatedReq.WebSubscriptions, inboundReq.Content, mock.Anything).
-			Return("ok", []notification.WebPushSubscription{}, nil)
+		apnsMock.On("Dispatch", mock.Anything, []string{"apns-456"}, inboundReq.Message()).
+			Return(result(dispatch.PlatformAPNs, dispatch.OutcomeDelivered, "apns-456"), nil)
+
+		webMock.On("Dispatch", mock.Anything, populatedReq.WebSubscriptions, inboundReq.Message()).
+			Return(result(dispatch.PlatformWeb, dispatch.OutcomeDelivered, "https://web.push/abc"), nil)
 
 		// 3. Execute
-		processor := pipeline.NewProcessor(fcmMock, webMock, storeMock, logger)
+		processor := pipeline.NewProcessor(fcmMock, apnsMock, webMock, storeMock, logger)
 		err := processor(ctx, messagepipeline.Message{}, inboundReq)
 
 		// 4. Verify
 		require.NoError(t, err)
 		fcmMock.AssertExpectations(t)
+		apnsMock.AssertExpectations(t)
 		webMock.AssertExpectations(t)
 	})
 
+	t.Run("Self-Healing APNs Cleanup", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher) // Not used
+		apnsMock := new(mockFCMDispatcher)
+		webMock := new(mockWebDispatcher) // Not used
+		storeMock := new(mockTokenStore)
+
+		// 1. Store returns 1 APNs token
+		populatedReq := &dispatch.RecipientDevices{
+			APNsTokens: []string{"apns-dead"},
+		}
+		storeMock.On("Fetch", mock.Anything, testURN).Return(populatedReq, nil)
+
+		// 2. Dispatcher reports it as INVALID (Unregistered)
+		apnsMock.On("Dispatch", mock.Anything, []string{"apns-dead"}, mock.Anything).
+			Return(result(dispatch.PlatformAPNs, dispatch.OutcomeInvalid, "apns-dead"), nil)
+
+		// 3. Processor MUST call UnregisterAPNs (not UnregisterFCM)
+		storeMock.On("UnregisterAPNs", mock.Anything, testURN, "apns-dead").Return(nil)
+
+		processor := pipeline.NewProcessor(fcmMock, apnsMock, webMock, storeMock, logger)
+		err := processor(ctx, messagepipeline.Message{}, inboundReq)
+
+		require.NoError(t, err)
+		storeMock.AssertExpectations(t)
+		fcmMock.AssertNotCalled(t, "Dispatch", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("APNs Not Configured Skips Tokens", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		webMock := new(mockWebDispatcher)
+		storeMock := new(mockTokenStore)
+
+		populatedReq := &dispatch.RecipientDevices{
+			APNsTokens: []string{"apns-orphan"},
+		}
+		storeMock.On("Fetch", mock.Anything, testURN).Return(populatedReq, nil)
+
+		// nil APNs dispatcher: tokens are skipped, message is still ACKed
+		processor := pipeline.NewProcessor(fcmMock, nil, webMock, storeMock, logger)
+		err := processor(ctx, messagepipeline.Message{}, inboundReq)
+
+		require.NoError(t, err)
+		storeMock.AssertNotCalled(t, "UnregisterAPNs", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("APNs Tokens Are Split By Environment", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		apnsMock := new(mockFCMDispatcher)
+		webMock := new(mockWebDispatcher)
+		storeMock := new(mockTokenStore)
+
+		populatedReq := &dispatch.RecipientDevices{
+			APNsTokens: []string{"apns-prod", "apns-dev", "apns-unknown"},
+		}
+		populatedReq.SetMetadata(dispatch.PlatformAPNs, "apns-prod", dispatch.DeviceMetadata{APNsEnvironment: dispatch.APNsProduction})
+		populatedReq.SetMetadata(dispatch.PlatformAPNs, "apns-dev", dispatch.DeviceMetadata{APNsEnvironment: dispatch.APNsDevelopment})
+		storeMock.On("Fetch", mock.Anything, testURN).Return(populatedReq, nil)
+
+		development := inboundReq.Message()
+		development.APNsEnvironment = dispatch.APNsDevelopment
+		production := inboundReq.Message()
+		production.APNsEnvironment = dispatch.APNsProduction
+
+		apnsMock.On("Dispatch", mock.Anything, []string{"apns-prod"}, production).
+			Return(result(dispatch.PlatformAPNs, dispatch.OutcomeDelivered, "apns-prod"), nil)
+		apnsMock.On("Dispatch", mock.Anything, []string{"apns-dev"}, development).
+			Return(result(dispatch.PlatformAPNs, dispatch.OutcomeDelivered, "apns-dev"), nil)
+		// Tokens registered without an environment go to the default (production) gateway
+		apnsMock.On("Dispatch", mock.Anything, []string{"apns-unknown"}, inboundReq.Message()).
+			Return(result(dispatch.PlatformAPNs, dispatch.OutcomeDelivered, "apns-unknown"), nil)
+
+		processor := pipeline.NewProcessor(fcmMock, apnsMock, webMock, storeMock, logger)
+		err := processor(ctx, messagepipeline.Message{}, inboundReq)
+
+		require.NoError(t, err)
+		apnsMock.AssertExpectations(t)
+	})
+
 	t.Run("Self-Healing Web Cleanup", func(t *testing.T) {
 		fcmMock := new(mockFCMDispatcher) // Not used
 		webMock := new(mockWebDispatcher)
@@ -116,23 +234,961 @@ func TestProcessor_Routing(t *testing.T) {
 
 		// 1. Store returns 1 Web Sub
 		badSub := notification.WebPushSubscription{Endpoint: "https://dead.endpoint"}
-		populatedReq := &notification.NotificationRequest{
+		populatedReq := &dispatch.RecipientDevices{
 			WebSubscriptions: []notification.WebPushSubscription{badSub},
 		}
 		storeMock.On("Fetch", mock.Anything, testURN).Return(populatedReq, nil)
 
 		// 2. Dispatcher reports it as INVALID (410/404)
-		webMock.On("Dispatch", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
-			Return("failed", []notification.WebPushSubscription{badSub}, nil)
+		webMock.On("Dispatch", mock.Anything, mock.Anything, mock.Anything).
+			Return(result(dispatch.PlatformWeb, dispatch.OutcomeInvalid, badSub.Endpoint), nil)
 
 		// 3. Processor MUST call UnregisterWeb
 		storeMock.On("UnregisterWeb", mock.Anything, testURN, "https://dead.endpoint").Return(nil)
 
 		// Execute
-		processor := pipeline.NewProcessor(fcmMock, webMock, storeMock, logger)
+		processor := pipeline.NewProcessor(fcmMock, nil, webMock, storeMock, logger)
 		err := processor(ctx, messagepipeline.Message{}, inboundReq)
 
 		require.NoError(t, err)
 		storeMock.AssertExpectations(t)
 	})
 }
+
+func TestProcessor_DeliveryLedger(t *testing.T) {
+	ctx := context.Background()
+	logger := newTestLogger()
+	testURN, _ := urn.Parse("urn:sm:user:test-ledger")
+
+	inboundReq := &dispatch.Request{
+		NotificationRequest: notification.NotificationRequest{
+			RecipientID: testURN,
+			Content:     notification.NotificationContent{Title: "Hello"},
+		},
+	}
+	webSub := notification.WebPushSubscription{Endpoint: "https://web.push/ok"}
+	populatedReq := &dispatch.RecipientDevices{
+		RecipientID:      testURN,
+		FCMTokens:        []string{"fcm-flaky"},
+		WebSubscriptions: []notification.WebPushSubscription{webSub},
+	}
+	msg := messagepipeline.Message{MessageData: messagepipeline.MessageData{ID: "pubsub-msg-1"}}
+
+	t.Run("Redelivery only re-targets unserved devices", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		webMock := new(mockWebDispatcher)
+		storeMock := new(mockTokenStore)
+		ledger := memory.NewDeliveryLedger(time.Hour)
+
+		storeMock.On("Fetch", mock.Anything, testURN).Return(populatedReq, nil)
+
+		// Attempt 1: FCM fails (retryable), Web succeeds
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-flaky"}, mock.Anything).
+			Return(result(dispatch.PlatformFCM, dispatch.OutcomeRetryable, "fcm-flaky"), errors.New("fcm unavailable")).Once()
+		webMock.On("Dispatch", mock.Anything, []notification.WebPushSubscription{webSub}, mock.Anything).
+			Return(result(dispatch.PlatformWeb, dispatch.OutcomeDelivered, webSub.Endpoint), nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, webMock, storeMock, logger, pipeline.WithDeliveryLedger(ledger))
+
+		err := processor(ctx, msg, inboundReq)
+		require.Error(t, err, "a failed path must Nack the message")
+
+		// Attempt 2 (Pub/Sub redelivery, same message ID): FCM recovers
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-flaky"}, mock.Anything).
+			Return(result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-flaky"), nil).Once()
+
+		err = processor(ctx, msg, inboundReq)
+		require.NoError(t, err)
+
+		// Web must have been notified exactly once across both attempts
+		webMock.AssertNumberOfCalls(t, "Dispatch", 1)
+		fcmMock.AssertNumberOfCalls(t, "Dispatch", 2)
+
+		// Attempt 3 (duplicate delivery after success): nothing is re-sent
+		err = processor(ctx, msg, inboundReq)
+		require.NoError(t, err)
+		webMock.AssertNumberOfCalls(t, "Dispatch", 1)
+		fcmMock.AssertNumberOfCalls(t, "Dispatch", 2)
+	})
+
+	t.Run("Invalid devices are final", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		webMock := new(mockWebDispatcher)
+		storeMock := new(mockTokenStore)
+		ledger := memory.NewDeliveryLedger(time.Hour)
+
+		storeMock.On("Fetch", mock.Anything, testURN).Return(populatedReq, nil)
+		storeMock.On("UnregisterFCM", mock.Anything, testURN, "fcm-flaky").Return(nil)
+
+		// FCM reports the token dead; Web fails transiently
+		fcmMock.On("Dispatch", mock.Anything, mock.Anything, mock.Anything).
+			Return(result(dispatch.PlatformFCM, dispatch.OutcomeInvalid, "fcm-flaky"), nil)
+		webMock.On("Dispatch", mock.Anything, mock.Anything, mock.Anything).
+			Return(result(dispatch.PlatformWeb, dispatch.OutcomeRetryable, webSub.Endpoint), errors.New("web timeout"))
+
+		processor := pipeline.NewProcessor(fcmMock, nil, webMock, storeMock, logger, pipeline.WithDeliveryLedger(ledger))
+
+		require.Error(t, processor(ctx, msg, inboundReq))
+		require.Error(t, processor(ctx, msg, inboundReq))
+
+		// The dead FCM token is not retried; the Web device is
+		fcmMock.AssertNumberOfCalls(t, "Dispatch", 1)
+		webMock.AssertNumberOfCalls(t, "Dispatch", 2)
+	})
+
+	t.Run("Partial result alongside an error is recorded", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		webMock := new(mockWebDispatcher)
+		storeMock := new(mockTokenStore)
+		ledger := memory.NewDeliveryLedger(time.Hour)
+
+		twoTokens := &dispatch.RecipientDevices{
+			RecipientID: testURN,
+			FCMTokens:   []string{"fcm-ok", "fcm-busy"},
+		}
+		storeMock.On("Fetch", mock.Anything, testURN).Return(twoTokens, nil)
+
+		// Attempt 1: one token served, one throttled
+		partial := result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-ok")
+		partial.Add(dispatch.DeviceResult{Address: "fcm-busy", Outcome: dispatch.OutcomeRetryable})
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-ok", "fcm-busy"}, mock.Anything).
+			Return(partial, errors.New("batch had 1 retryable errors")).Once()
+
+		// Attempt 2: only the throttled token is re-targeted
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-busy"}, mock.Anything).
+			Return(result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-busy"), nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, webMock, storeMock, logger, pipeline.WithDeliveryLedger(ledger))
+
+		require.Error(t, processor(ctx, msg, inboundReq))
+		require.NoError(t, processor(ctx, msg, inboundReq))
+		fcmMock.AssertExpectations(t)
+	})
+}
+
+func TestProcessor_Deduplication(t *testing.T) {
+	ctx := context.Background()
+	logger := newTestLogger()
+	testURN, _ := urn.Parse("urn:sm:user:test-dedup")
+
+	inboundReq := &dispatch.Request{
+		NotificationRequest: notification.NotificationRequest{
+			RecipientID: testURN,
+			Content:     notification.NotificationContent{Title: "Hello"},
+		},
+		RequestID: "producer-key-1",
+	}
+	populatedReq := &dispatch.RecipientDevices{
+		RecipientID: testURN,
+		FCMTokens:   []string{"fcm-123"},
+	}
+	first := messagepipeline.Message{MessageData: messagepipeline.MessageData{ID: "pubsub-msg-1"}}
+	second := messagepipeline.Message{MessageData: messagepipeline.MessageData{ID: "pubsub-msg-2"}}
+
+	t.Run("Duplicate publish is dropped before Fetch", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		webMock := new(mockWebDispatcher)
+		storeMock := new(mockTokenStore)
+
+		storeMock.On("Fetch", mock.Anything, testURN).Return(populatedReq, nil).Once()
+		fcmMock.On("Dispatch", mock.Anything, mock.Anything, mock.Anything).
+			Return(result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-123"), nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, webMock, storeMock, logger,
+			pipeline.WithDeduplication(memory.NewDedupStore(), time.Minute))
+
+		// The producer publishes the same request twice (two Pub/Sub messages)
+		require.NoError(t, processor(ctx, first, inboundReq))
+		require.NoError(t, processor(ctx, second, inboundReq), "duplicates are ACKed")
+
+		storeMock.AssertNumberOfCalls(t, "Fetch", 1)
+		fcmMock.AssertNumberOfCalls(t, "Dispatch", 1)
+	})
+
+	t.Run("Redelivery of the claiming message is not a duplicate", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		webMock := new(mockWebDispatcher)
+		storeMock := new(mockTokenStore)
+
+		storeMock.On("Fetch", mock.Anything, testURN).Return(populatedReq, nil)
+		fcmMock.On("Dispatch", mock.Anything, mock.Anything, mock.Anything).
+			Return(result(dispatch.PlatformFCM, dispatch.OutcomeRetryable, "fcm-123"), errors.New("fcm unavailable")).Once()
+		fcmMock.On("Dispatch", mock.Anything, mock.Anything, mock.Anything).
+			Return(result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-123"), nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, webMock, storeMock, logger,
+			pipeline.WithDeduplication(memory.NewDedupStore(), time.Minute))
+
+		require.Error(t, processor(ctx, first, inboundReq))
+		require.NoError(t, processor(ctx, first, inboundReq))
+
+		fcmMock.AssertNumberOfCalls(t, "Dispatch", 2)
+	})
+}
+
+func TestProcessor_Feedback(t *testing.T) {
+	ctx := context.Background()
+	logger := newTestLogger()
+	testURN, _ := urn.Parse("urn:sm:user:test-feedback")
+
+	inboundReq := &dispatch.Request{
+		NotificationRequest: notification.NotificationRequest{
+			RecipientID: testURN,
+			Content:     notification.NotificationContent{Title: "Hello"},
+		},
+		RequestID: "req-1",
+	}
+
+	fcmMock := new(mockFCMDispatcher)
+	webMock := new(mockWebDispatcher)
+	storeMock := new(mockTokenStore)
+	publisher := feedback.NewMemoryPublisher()
+
+	webSub := notification.WebPushSubscription{Endpoint: "https://gone.endpoint"}
+	storeMock.On("Fetch", mock.Anything, testURN).Return(&dispatch.RecipientDevices{
+		FCMTokens:        []string{"fcm-ok", "fcm-busy"},
+		WebSubscriptions: []notification.WebPushSubscription{webSub},
+	}, nil)
+	storeMock.On("UnregisterWeb", mock.Anything, testURN, webSub.Endpoint).Return(nil)
+
+	fcmResult := result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-ok")
+	fcmResult.Add(dispatch.DeviceResult{Address: "fcm-busy", Outcome: dispatch.OutcomeRetryable, Reason: "Unavailable"})
+	fcmMock.On("Dispatch", mock.Anything, mock.Anything, mock.Anything).
+		Return(fcmResult, errors.New("batch had 1 retryable errors"))
+	goneResult := dispatch.NewDispatchResult(dispatch.PlatformWeb)
+	goneResult.Add(dispatch.DeviceResult{Address: webSub.Endpoint, Outcome: dispatch.OutcomeInvalid, StatusCode: 410, Reason: "Gone"})
+	webMock.On("Dispatch", mock.Anything, mock.Anything, mock.Anything).Return(goneResult, nil)
+
+	processor := pipeline.NewProcessor(fcmMock, nil, webMock, storeMock, logger, pipeline.WithFeedback(publisher))
+	require.Error(t, processor(ctx, messagepipeline.Message{}, inboundReq))
+
+	delivered := publisher.Events(dispatch.EventNotificationDelivered)
+	require.Len(t, delivered, 1)
+	require.Equal(t, "fcm-ok", delivered[0].Address)
+	require.Equal(t, "req-1", delivered[0].NotificationID)
+
+	failed := publisher.Events(dispatch.EventNotificationFailed)
+	require.Len(t, failed, 1)
+	require.Equal(t, dispatch.OutcomeRetryable, failed[0].Outcome)
+	require.Equal(t, "Unavailable", failed[0].Reason)
+
+	invalidated := publisher.Events(dispatch.EventTokenInvalidated)
+	require.Len(t, invalidated, 1)
+	require.Equal(t, testURN, invalidated[0].RecipientID)
+	require.Equal(t, dispatch.PlatformWeb, invalidated[0].Platform)
+	require.Equal(t, "Gone", invalidated[0].Reason)
+}
+
+func TestProcessor_Preferences(t *testing.T) {
+	ctx := context.Background()
+	logger := newTestLogger()
+	testURN, _ := urn.Parse("urn:sm:user:test-prefs")
+
+	newRequest := func(category string, data map[string]string) *dispatch.Request {
+		return &dispatch.Request{
+			NotificationRequest: notification.NotificationRequest{
+				RecipientID: testURN,
+				Content:     notification.NotificationContent{Title: "Hello"},
+				DataPayload: data,
+			},
+			Category: category,
+		}
+	}
+	devices := &dispatch.RecipientDevices{RecipientID: testURN, FCMTokens: []string{"fcm-123"}}
+
+	testCases := []struct {
+		name         string
+		prefs        dispatch.Preferences
+		request      *dispatch.Request
+		wantDispatch bool
+	}{
+		{
+			name:         "Muted user gets nothing",
+			prefs:        dispatch.Preferences{Muted: true},
+			request:      newRequest("chat", nil),
+			wantDispatch: false,
+		},
+		{
+			name:         "Opted-out category is suppressed",
+			prefs:        dispatch.Preferences{DisabledCategories: []string{"marketing"}},
+			request:      newRequest("Marketing", nil),
+			wantDispatch: false,
+		},
+		{
+			name:         "Category from the data payload is honoured",
+			prefs:        dispatch.Preferences{DisabledCategories: []string{"marketing"}},
+			request:      newRequest("", map[string]string{dispatch.CategoryDataKey: "marketing"}),
+			wantDispatch: false,
+		},
+		{
+			name:         "Other categories are delivered",
+			prefs:        dispatch.Preferences{DisabledCategories: []string{"marketing"}},
+			request:      newRequest("chat", nil),
+			wantDispatch: true,
+		},
+		{
+			name:         "Uncategorised requests are delivered",
+			prefs:        dispatch.Preferences{DisabledCategories: []string{"marketing"}},
+			request:      newRequest("", nil),
+			wantDispatch: true,
+		},
+		{
+			name:  "Data-only syncs reach muted users",
+			prefs: dispatch.Preferences{Muted: true},
+			request: func() *dispatch.Request {
+				r := newRequest("chat", map[string]string{"sync": "inbox"})
+				r.DataOnly = true
+				return r
+			}(),
+			wantDispatch: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fcmMock := new(mockFCMDispatcher)
+			webMock := new(mockWebDispatcher)
+			storeMock := new(mockTokenStore)
+			prefsStore := memory.NewPreferencesStore()
+			require.NoError(t, prefsStore.Put(ctx, testURN, &tc.prefs))
+
+			storeMock.On("Fetch", mock.Anything, testURN).Return(devices, nil)
+			fcmMock.On("Dispatch", mock.Anything, mock.Anything, mock.Anything).
+				Return(result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-123"), nil)
+
+			processor := pipeline.NewProcessor(fcmMock, nil, webMock, storeMock, logger, pipeline.WithPreferences(prefsStore))
+			require.NoError(t, processor(ctx, messagepipeline.Message{}, tc.request), "suppressed requests are ACKed")
+
+			if tc.wantDispatch {
+				fcmMock.AssertNumberOfCalls(t, "Dispatch", 1)
+			} else {
+				storeMock.AssertNotCalled(t, "Fetch", mock.Anything, mock.Anything)
+				fcmMock.AssertNotCalled(t, "Dispatch", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestProcessor_QuietHours(t *testing.T) {
+	ctx := context.Background()
+	logger := newTestLogger()
+	testURN, _ := urn.Parse("urn:sm:user:test-quiet")
+	original := messagepipeline.Message{MessageData: messagepipeline.MessageData{ID: "pubsub-msg-1"}}
+
+	// A window around "now" in UTC, so the test is quiet whenever it runs
+	now := time.Now().UTC()
+	quietNow := func(mode dispatch.QuietMode) *dispatch.Preferences {
+		return &dispatch.Preferences{QuietHours: &dispatch.QuietHours{
+			Start:    now.Add(-time.Hour).Format("15:04"),
+			End:      now.Add(time.Hour).Format("15:04"),
+			Timezone: "UTC",
+			Mode:     mode,
+		}}
+	}
+	newRequest := func(priority dispatch.Priority) *dispatch.Request {
+		return &dispatch.Request{
+			NotificationRequest: notification.NotificationRequest{
+				RecipientID: testURN,
+				Content:     notification.NotificationContent{Title: "Hello", Sound: "default"},
+			},
+			RequestID: "req-quiet",
+			Priority:  priority,
+		}
+	}
+	devices := &dispatch.RecipientDevices{RecipientID: testURN, FCMTokens: []string{"fcm-123"}}
+	delivered := result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-123")
+	isSilent := func(silent bool) interface{} {
+		return mock.MatchedBy(func(msg dispatch.Message) bool { return msg.Silent == silent })
+	}
+
+	setup := func(t *testing.T, prefs *dispatch.Preferences) (*mockFCMDispatcher, *mockTokenStore, *memory.PreferencesStore) {
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore)
+		prefsStore := memory.NewPreferencesStore()
+		require.NoError(t, prefsStore.Put(ctx, testURN, prefs))
+		storeMock.On("Fetch", mock.Anything, testURN).Return(devices, nil)
+		return fcmMock, storeMock, prefsStore
+	}
+
+	t.Run("Drop mode discards non-urgent notifications", func(t *testing.T) {
+		fcmMock, storeMock, prefsStore := setup(t, quietNow(dispatch.QuietDrop))
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger, pipeline.WithPreferences(prefsStore))
+		require.NoError(t, processor(ctx, original, newRequest(dispatch.PriorityNormal)))
+
+		fcmMock.AssertNotCalled(t, "Dispatch", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Silent mode delivers without alerting", func(t *testing.T) {
+		fcmMock, storeMock, prefsStore := setup(t, quietNow(dispatch.QuietSilent))
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-123"}, isSilent(true)).Return(delivered, nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger, pipeline.WithPreferences(prefsStore))
+		require.NoError(t, processor(ctx, original, newRequest(dispatch.PriorityLow)))
+
+		fcmMock.AssertExpectations(t)
+	})
+
+	t.Run("Urgent notifications bypass quiet hours", func(t *testing.T) {
+		fcmMock, storeMock, prefsStore := setup(t, quietNow(dispatch.QuietDrop))
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-123"}, isSilent(false)).Return(delivered, nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger, pipeline.WithPreferences(prefsStore))
+		require.NoError(t, processor(ctx, original, newRequest(dispatch.PriorityUrgent)))
+
+		fcmMock.AssertExpectations(t)
+	})
+
+	t.Run("Data-only pushes ignore quiet hours", func(t *testing.T) {
+		fcmMock, storeMock, prefsStore := setup(t, quietNow(dispatch.QuietDrop))
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-123"}, mock.MatchedBy(func(msg dispatch.Message) bool {
+			return msg.DataOnly && !msg.Silent
+		})).Return(delivered, nil).Once()
+
+		request := newRequest(dispatch.PriorityNormal)
+		request.DataOnly = true
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger, pipeline.WithPreferences(prefsStore))
+		require.NoError(t, processor(ctx, original, request))
+
+		fcmMock.AssertExpectations(t)
+	})
+
+	t.Run("Defer mode without a schedule store falls back to silent", func(t *testing.T) {
+		fcmMock, storeMock, prefsStore := setup(t, quietNow(dispatch.QuietDefer))
+		fcmMock.On("Dispatch", mock.Anything, mock.Anything, isSilent(true)).Return(delivered, nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger, pipeline.WithPreferences(prefsStore))
+		require.NoError(t, processor(ctx, original, newRequest(dispatch.PriorityNormal)))
+
+		fcmMock.AssertExpectations(t)
+	})
+
+	t.Run("Defer mode schedules the request and the replay is not a duplicate", func(t *testing.T) {
+		fcmMock, storeMock, prefsStore := setup(t, quietNow(dispatch.QuietDefer))
+		schedule := memory.NewScheduleStore()
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-123"}, isSilent(false)).Return(delivered, nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger,
+			pipeline.WithPreferences(prefsStore),
+			pipeline.WithScheduleStore(schedule),
+			pipeline.WithDeduplication(memory.NewDedupStore(), time.Hour))
+
+		// 1. During quiet hours: ACKed and parked, nothing sent
+		require.NoError(t, processor(ctx, original, newRequest(dispatch.PriorityNormal)))
+		fcmMock.AssertNotCalled(t, "Dispatch", mock.Anything, mock.Anything, mock.Anything)
+
+		parked, err := schedule.Claim(ctx, now.Add(2*time.Hour), time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, parked, 1)
+		assert.Equal(t, "req-quiet", parked[0].ID)
+		assert.Equal(t, dispatch.ScheduleReasonQuietHours, parked[0].Reason)
+		assert.True(t, parked[0].DeliverAt.After(now), "delivered when the window ends")
+
+		// 2. Window over: the scheduler replays it under the original message ID
+		require.NoError(t, prefsStore.Put(ctx, testURN, &dispatch.Preferences{}))
+		replay := messagepipeline.Message{MessageData: messagepipeline.MessageData{ID: parked[0].MessageID}}
+		require.NoError(t, processor(ctx, replay, &parked[0].Request))
+
+		fcmMock.AssertExpectations(t)
+	})
+}
+
+func TestProcessor_DeliverAt(t *testing.T) {
+	ctx := context.Background()
+	logger := newTestLogger()
+	testURN, _ := urn.Parse("urn:sm:user:test-schedule")
+	original := messagepipeline.Message{MessageData: messagepipeline.MessageData{ID: "pubsub-msg-1"}}
+
+	newRequest := func(deliverAt time.Time) *dispatch.Request {
+		return &dispatch.Request{
+			NotificationRequest: notification.NotificationRequest{
+				RecipientID: testURN,
+				Content:     notification.NotificationContent{Title: "Reminder"},
+			},
+			RequestID: "reminder-1",
+			DeliverAt: deliverAt,
+		}
+	}
+	devices := &dispatch.RecipientDevices{RecipientID: testURN, FCMTokens: []string{"fcm-123"}}
+	delivered := result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-123")
+
+	t.Run("Future requests are parked, then delivered when released", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore)
+		schedule := memory.NewScheduleStore()
+		storeMock.On("Fetch", mock.Anything, testURN).Return(devices, nil)
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-123"}, mock.Anything).Return(delivered, nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger,
+			pipeline.WithScheduleStore(schedule),
+			pipeline.WithDeduplication(memory.NewDedupStore(), time.Hour))
+
+		deliverAt := time.Now().Add(time.Hour).Truncate(time.Second)
+		require.NoError(t, processor(ctx, original, newRequest(deliverAt)))
+		storeMock.AssertNotCalled(t, "Fetch", mock.Anything, mock.Anything)
+
+		due, err := schedule.Claim(ctx, deliverAt, time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		assert.Equal(t, "reminder-1", due[0].ID)
+		assert.Equal(t, dispatch.ScheduleReasonDeliverAt, due[0].Reason)
+		assert.True(t, deliverAt.Equal(due[0].DeliverAt))
+
+		// Released by the scheduler (the due time has passed by then)
+		released := due[0].Request
+		released.DeliverAt = time.Now().Add(-time.Second)
+		replay := messagepipeline.Message{MessageData: messagepipeline.MessageData{ID: due[0].MessageID}}
+		require.NoError(t, processor(ctx, replay, &released))
+
+		fcmMock.AssertExpectations(t)
+	})
+
+	t.Run("Producers cancel by requestId alone", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore)
+		schedule := memory.NewScheduleStore()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger,
+			pipeline.WithScheduleStore(schedule),
+			pipeline.WithDeduplication(memory.NewDedupStore(), time.Hour))
+
+		deliverAt := time.Now().Add(time.Hour)
+		require.NoError(t, processor(ctx, original, newRequest(deliverAt)))
+
+		cancel := &dispatch.Request{RequestID: "reminder-1", Cancel: true}
+		cancelMsg := messagepipeline.Message{MessageData: messagepipeline.MessageData{ID: "pubsub-cancel-1"}}
+		require.NoError(t, processor(ctx, cancelMsg, cancel))
+		// Redelivered: nothing left to cancel, still ACKed
+		require.NoError(t, processor(ctx, cancelMsg, cancel))
+
+		due, err := schedule.Claim(ctx, deliverAt, time.Minute, 10)
+		require.NoError(t, err)
+		assert.Empty(t, due)
+		fcmMock.AssertNotCalled(t, "Dispatch", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("A cancel that overtakes its request still cancels it", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore)
+		schedule := memory.NewScheduleStore()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger,
+			pipeline.WithScheduleStore(schedule),
+			pipeline.WithDeduplication(memory.NewDedupStore(), time.Hour))
+
+		cancelMsg := messagepipeline.Message{MessageData: messagepipeline.MessageData{ID: "pubsub-cancel-1"}}
+		require.NoError(t, processor(ctx, cancelMsg, &dispatch.Request{RequestID: "reminder-1", Cancel: true}))
+		deliverAt := time.Now().Add(time.Hour)
+		require.NoError(t, processor(ctx, original, newRequest(deliverAt)))
+
+		due, err := schedule.Claim(ctx, deliverAt, time.Minute, 10)
+		require.NoError(t, err)
+		assert.Empty(t, due)
+	})
+
+	t.Run("Past deliverAt is delivered immediately", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore)
+		schedule := memory.NewScheduleStore()
+		storeMock.On("Fetch", mock.Anything, testURN).Return(devices, nil)
+		fcmMock.On("Dispatch", mock.Anything, mock.Anything, mock.Anything).Return(delivered, nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger, pipeline.WithScheduleStore(schedule))
+		require.NoError(t, processor(ctx, original, newRequest(time.Now().Add(-time.Minute))))
+
+		fcmMock.AssertExpectations(t)
+	})
+
+	t.Run("Without a schedule store the request is delivered now", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore)
+		storeMock.On("Fetch", mock.Anything, testURN).Return(devices, nil)
+		fcmMock.On("Dispatch", mock.Anything, mock.Anything, mock.Anything).Return(delivered, nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger)
+		require.NoError(t, processor(ctx, original, newRequest(time.Now().Add(time.Hour))))
+
+		fcmMock.AssertExpectations(t)
+	})
+}
+
+func TestProcessor_Expiry(t *testing.T) {
+	ctx := context.Background()
+	logger := newTestLogger()
+	testURN, _ := urn.Parse("urn:sm:user:test-expiry")
+	original := messagepipeline.Message{MessageData: messagepipeline.MessageData{ID: "pubsub-msg-1"}}
+	devices := &dispatch.RecipientDevices{RecipientID: testURN, FCMTokens: []string{"fcm-123"}}
+
+	newRequest := func(expiresAt time.Time) *dispatch.Request {
+		return &dispatch.Request{
+			NotificationRequest: notification.NotificationRequest{
+				RecipientID: testURN,
+				Content:     notification.NotificationContent{Title: "Your ride is here"},
+			},
+			ExpiresAt: expiresAt,
+		}
+	}
+
+	t.Run("Expired notifications are ACKed without a dispatch", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore)
+		storeMock.On("Fetch", mock.Anything, testURN).Return(devices, nil)
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger)
+		err := processor(ctx, original, newRequest(time.Now().Add(-time.Second)))
+
+		require.NoError(t, err)
+		fcmMock.AssertNotCalled(t, "Dispatch", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Expired notifications are never parked", func(t *testing.T) {
+		now := time.Now().UTC()
+		quiet := &dispatch.Preferences{QuietHours: &dispatch.QuietHours{
+			Start:    now.Add(-time.Hour).Format("15:04"),
+			End:      now.Add(time.Hour).Format("15:04"),
+			Timezone: "UTC",
+			Mode:     dispatch.QuietDefer,
+		}}
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore) // No Fetch expected
+		schedule := memory.NewScheduleStore()
+		digests := memory.NewDigestStore()
+		coalesce := memory.NewCoalesceStore(time.Hour)
+		prefsStore := memory.NewPreferencesStore()
+		require.NoError(t, prefsStore.Put(ctx, testURN, quiet))
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger,
+			pipeline.WithPreferences(prefsStore),
+			pipeline.WithScheduleStore(schedule),
+			pipeline.WithCoalescing(coalesce, 30*time.Second),
+			pipeline.WithDigests(digests, map[string]dispatch.DigestPeriod{"likes": dispatch.DigestHourly}, "18:00"))
+
+		scheduled := newRequest(now.Add(-time.Second))
+		scheduled.DeliverAt = now.Add(time.Hour)
+		digested := newRequest(now.Add(-time.Second))
+		digested.Category = "likes"
+		coalesced := newRequest(now.Add(-time.Second))
+		coalesced.CollapseKey = "group-chat-1"
+		quieted := newRequest(now.Add(-time.Second))
+
+		periodEnd, err := dispatch.DigestHourly.End(now, "18:00", time.UTC)
+		require.NoError(t, err)
+		for _, request := range []*dispatch.Request{scheduled, digested, coalesced, quieted} {
+			require.NoError(t, processor(ctx, original, request))
+		}
+
+		due, err := schedule.Claim(ctx, now.Add(48*time.Hour), time.Minute, 10)
+		require.NoError(t, err)
+		assert.Empty(t, due, "nothing scheduled, deferred or opened as a digest")
+		digest, err := digests.Get(ctx, testURN, periodEnd)
+		require.NoError(t, err)
+		assert.Nil(t, digest)
+		burst, err := coalesce.Take(ctx, dispatch.CoalesceGroup(testURN, "group-chat-1"), original.ID)
+		require.NoError(t, err)
+		assert.Nil(t, burst)
+		storeMock.AssertNotCalled(t, "Fetch", mock.Anything, mock.Anything)
+		fcmMock.AssertNotCalled(t, "Dispatch", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Live notifications pass their deadline to the dispatcher", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore)
+		storeMock.On("Fetch", mock.Anything, testURN).Return(devices, nil)
+		expiresAt := time.Now().Add(time.Minute)
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-123"}, mock.MatchedBy(func(msg dispatch.Message) bool {
+			return msg.ExpiresAt.Equal(expiresAt)
+		})).Return(result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-123"), nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger)
+		require.NoError(t, processor(ctx, original, newRequest(expiresAt)))
+
+		fcmMock.AssertExpectations(t)
+	})
+}
+
+func TestProcessor_Coalescing(t *testing.T) {
+	ctx := context.Background()
+	logger := newTestLogger()
+	testURN, _ := urn.Parse("urn:sm:user:test-coalesce")
+	devices := &dispatch.RecipientDevices{RecipientID: testURN, FCMTokens: []string{"fcm-123"}}
+	delivered := result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-123")
+
+	newRequest := func(body string) *dispatch.Request {
+		return &dispatch.Request{
+			NotificationRequest: notification.NotificationRequest{
+				RecipientID: testURN,
+				Content:     notification.NotificationContent{Title: "Alice", Body: body},
+			},
+			CollapseKey: "group-chat-1",
+		}
+	}
+	message := func(id string) messagepipeline.Message {
+		return messagepipeline.Message{MessageData: messagepipeline.MessageData{ID: id}}
+	}
+
+	t.Run("A burst is held and flushed as one summary", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore)
+		schedule := memory.NewScheduleStore()
+		storeMock.On("Fetch", mock.Anything, testURN).Return(devices, nil)
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-123"}, mock.MatchedBy(func(msg dispatch.Message) bool {
+			return msg.Content.Body == "third (+2 more)" && msg.Data[dispatch.CoalescedCountDataKey] == "3"
+		})).Return(delivered, nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger,
+			pipeline.WithScheduleStore(schedule),
+			pipeline.WithCoalescing(memory.NewCoalesceStore(time.Hour), 30*time.Second))
+
+		for i, body := range []string{"first", "second", "third"} {
+			id := "pubsub-msg-" + strconv.Itoa(i)
+			request := newRequest(body)
+			request.RequestID = id // As the transformer would
+			require.NoError(t, processor(ctx, message(id), request))
+		}
+		storeMock.AssertNotCalled(t, "Fetch", mock.Anything, mock.Anything)
+
+		due, err := schedule.Claim(ctx, time.Now().Add(time.Minute), time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, due, 1, "one flush per burst")
+		assert.Equal(t, dispatch.ScheduleReasonCoalesce, due[0].Reason)
+
+		// The scheduler replays the flush with its reason attached
+		flush := message(due[0].MessageID)
+		flush.Attributes = map[string]string{dispatch.ReplayReasonAttribute: due[0].Reason}
+		request := due[0].Request
+		require.NoError(t, processor(ctx, flush, &request))
+
+		fcmMock.AssertExpectations(t)
+	})
+
+	t.Run("Urgent and uncollapsed requests are not held", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore)
+		storeMock.On("Fetch", mock.Anything, testURN).Return(devices, nil)
+		fcmMock.On("Dispatch", mock.Anything, mock.Anything, mock.Anything).Return(delivered, nil).Twice()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger,
+			pipeline.WithScheduleStore(memory.NewScheduleStore()),
+			pipeline.WithCoalescing(memory.NewCoalesceStore(time.Hour), 30*time.Second))
+
+		urgent := newRequest("ride is here")
+		urgent.Priority = dispatch.PriorityUrgent
+		require.NoError(t, processor(ctx, message("pubsub-msg-1"), urgent))
+
+		plain := newRequest("hello")
+		plain.CollapseKey = ""
+		require.NoError(t, processor(ctx, message("pubsub-msg-2"), plain))
+
+		fcmMock.AssertExpectations(t)
+	})
+}
+
+func TestProcessor_Digests(t *testing.T) {
+	ctx := context.Background()
+	logger := newTestLogger()
+	testURN, _ := urn.Parse("urn:sm:user:test-digest")
+	devices := &dispatch.RecipientDevices{RecipientID: testURN, FCMTokens: []string{"fcm-123"}}
+	delivered := result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-123")
+	defaults := map[string]dispatch.DigestPeriod{"likes": dispatch.DigestHourly, "follows": dispatch.DigestHourly}
+
+	newRequest := func(category, title string) *dispatch.Request {
+		return &dispatch.Request{
+			NotificationRequest: notification.NotificationRequest{
+				RecipientID: testURN,
+				Content:     notification.NotificationContent{Title: title},
+			},
+			Category: category,
+		}
+	}
+	message := func(id string) messagepipeline.Message {
+		return messagepipeline.Message{MessageData: messagepipeline.MessageData{ID: id}}
+	}
+
+	t.Run("Digest categories are accumulated and delivered as one summary", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore)
+		schedule := memory.NewScheduleStore()
+		digests := memory.NewDigestStore()
+		storeMock.On("Fetch", mock.Anything, testURN).Return(devices, nil)
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-123"}, mock.MatchedBy(func(msg dispatch.Message) bool {
+			return msg.Content.Title == "3 new notifications" && msg.Content.Body == "likes (2), follows (1)"
+		})).Return(delivered, nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger,
+			pipeline.WithScheduleStore(schedule),
+			pipeline.WithDigests(digests, defaults, "18:00"))
+
+		require.NoError(t, processor(ctx, message("pubsub-msg-1"), newRequest("likes", "Alice liked your post")))
+		require.NoError(t, processor(ctx, message("pubsub-msg-2"), newRequest("follows", "Bob followed you")))
+		require.NoError(t, processor(ctx, message("pubsub-msg-3"), newRequest("likes", "Carol liked your post")))
+		fcmMock.AssertNotCalled(t, "Dispatch", mock.Anything, mock.Anything, mock.Anything)
+
+		due, err := schedule.Claim(ctx, time.Now().Add(2*time.Hour), time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, due, 1, "one delivery per digest")
+		assert.Equal(t, dispatch.ScheduleReasonDigest, due[0].Reason)
+
+		// The scheduler replays the delivery with its reason attached
+		replay := message(due[0].MessageID)
+		replay.Attributes = map[string]string{dispatch.ReplayReasonAttribute: due[0].Reason}
+		request := due[0].Request
+		require.NoError(t, processor(ctx, replay, &request))
+
+		// Once the digest is gone, a repeated replay delivers nothing
+		require.NoError(t, digests.Delete(ctx, testURN, request.DeliverAt))
+		request = due[0].Request
+		require.NoError(t, processor(ctx, replay, &request))
+
+		fcmMock.AssertExpectations(t)
+	})
+
+	t.Run("Every entry schedules its digest", func(t *testing.T) {
+		storeMock := new(mockTokenStore)
+		schedule := memory.NewScheduleStore()
+		digests := memory.NewDigestStore()
+		storeMock.On("Fetch", mock.Anything, testURN).Return(devices, nil)
+
+		// An earlier entry whose schedule was lost (it failed, and the redelivery
+		// went to the next period)
+		periodEnd, err := dispatch.DigestHourly.End(time.Now(), "18:00", time.UTC)
+		require.NoError(t, err)
+		_, err = digests.Add(ctx, testURN, periodEnd, dispatch.DigestEntry{MessageID: "pubsub-msg-0", Category: "likes"})
+		require.NoError(t, err)
+
+		processor := pipeline.NewProcessor(new(mockFCMDispatcher), nil, new(mockWebDispatcher), storeMock, logger,
+			pipeline.WithScheduleStore(schedule),
+			pipeline.WithDigests(digests, defaults, "18:00"))
+		require.NoError(t, processor(ctx, message("pubsub-msg-1"), newRequest("likes", "Alice liked your post")))
+
+		due, err := schedule.Claim(ctx, time.Now().Add(2*time.Hour), time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, due, 1, "the digest is delivered after all")
+		assert.Equal(t, dispatch.DigestID(testURN, periodEnd), due[0].ID)
+	})
+
+	t.Run("Digest summaries are rendered in each device's language", func(t *testing.T) {
+		registry, err := templates.NewRegistry("en", templates.File{
+			dispatch.DigestTemplate: {
+				"en": {Title: "{{.digestCount}} new notifications", Body: "{{.digestCategories}}"},
+				"fr": {Title: "{{.digestCount}} nouvelles notifications", Body: "{{.digestCategories}}"},
+			},
+		})
+		require.NoError(t, err)
+		localized := &dispatch.RecipientDevices{RecipientID: testURN, FCMTokens: []string{"fcm-fr", "fcm-en"}}
+		localized.SetMetadata(dispatch.PlatformFCM, "fcm-fr", dispatch.DeviceMetadata{Locale: "fr-FR"})
+
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore)
+		schedule := memory.NewScheduleStore()
+		storeMock.On("Fetch", mock.Anything, testURN).Return(localized, nil)
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-fr"}, mock.MatchedBy(func(msg dispatch.Message) bool {
+			return msg.Content.Title == "2 nouvelles notifications" && msg.Content.Body == "likes (1), follows (1)"
+		})).Return(result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-fr"), nil).Once()
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-en"}, mock.MatchedBy(func(msg dispatch.Message) bool {
+			return msg.Content.Title == "2 new notifications"
+		})).Return(result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-en"), nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger,
+			pipeline.WithScheduleStore(schedule),
+			pipeline.WithDigests(memory.NewDigestStore(), defaults, "18:00"),
+			pipeline.WithTemplates(registry))
+
+		require.NoError(t, processor(ctx, message("pubsub-msg-1"), newRequest("likes", "Alice liked your post")))
+		require.NoError(t, processor(ctx, message("pubsub-msg-2"), newRequest("follows", "Bob followed you")))
+
+		due, err := schedule.Claim(ctx, time.Now().Add(2*time.Hour), time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		replay := message(due[0].MessageID)
+		replay.Attributes = map[string]string{dispatch.ReplayReasonAttribute: due[0].Reason}
+		request := due[0].Request
+		require.NoError(t, processor(ctx, replay, &request))
+
+		fcmMock.AssertExpectations(t)
+	})
+
+	t.Run("Urgent requests and opted-out users are pushed immediately", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore)
+		prefs := memory.NewPreferencesStore()
+		require.NoError(t, prefs.Put(ctx, testURN, &dispatch.Preferences{
+			Digests: map[string]dispatch.DigestPeriod{"follows": dispatch.DigestImmediate},
+		}))
+		storeMock.On("Fetch", mock.Anything, testURN).Return(devices, nil)
+		fcmMock.On("Dispatch", mock.Anything, mock.Anything, mock.Anything).Return(delivered, nil).Twice()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger,
+			pipeline.WithPreferences(prefs),
+			pipeline.WithScheduleStore(memory.NewScheduleStore()),
+			pipeline.WithDigests(memory.NewDigestStore(), defaults, "18:00"))
+
+		urgent := newRequest("likes", "Alice liked your post")
+		urgent.Priority = dispatch.PriorityUrgent
+		require.NoError(t, processor(ctx, message("pubsub-msg-1"), urgent))
+		require.NoError(t, processor(ctx, message("pubsub-msg-2"), newRequest("follows", "Bob followed you")))
+
+		fcmMock.AssertExpectations(t)
+	})
+}
+
+func TestProcessor_Templates(t *testing.T) {
+	ctx := context.Background()
+	logger := newTestLogger()
+	testURN, _ := urn.Parse("urn:sm:user:test-templates")
+	original := messagepipeline.Message{MessageData: messagepipeline.MessageData{ID: "pubsub-msg-1"}}
+
+	registry, err := templates.NewRegistry("en", templates.File{
+		"new_message": {
+			"en": {Title: "New message from {{.sender}}"},
+			"fr": {Title: "Nouveau message de {{.sender}}"},
+		},
+	})
+	require.NoError(t, err)
+
+	devices := &dispatch.RecipientDevices{RecipientID: testURN, FCMTokens: []string{"fcm-fr", "fcm-en", "fcm-unknown"}}
+	devices.SetMetadata(dispatch.PlatformFCM, "fcm-fr", dispatch.DeviceMetadata{Locale: "fr-FR"})
+	devices.SetMetadata(dispatch.PlatformFCM, "fcm-en", dispatch.DeviceMetadata{Locale: "en-GB"})
+
+	newRequest := func(template string) *dispatch.Request {
+		return &dispatch.Request{
+			NotificationRequest: notification.NotificationRequest{
+				RecipientID: testURN,
+				Content:     notification.NotificationContent{Title: "Fallback title"},
+			},
+			Template:     template,
+			TemplateVars: map[string]string{"sender": "Alice"},
+		}
+	}
+	title := func(want string) any {
+		return mock.MatchedBy(func(msg dispatch.Message) bool { return msg.Content.Title == want })
+	}
+
+	t.Run("Each device gets its own language", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore)
+		storeMock.On("Fetch", mock.Anything, testURN).Return(devices, nil)
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-fr"}, title("Nouveau message de Alice")).
+			Return(result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-fr"), nil).Once()
+		// Devices without a locale get the default, batched with the English one
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-en", "fcm-unknown"}, title("New message from Alice")).
+			Return(result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-en", "fcm-unknown"), nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger, pipeline.WithTemplates(registry))
+		require.NoError(t, processor(ctx, original, newRequest("new_message")))
+
+		fcmMock.AssertExpectations(t)
+	})
+
+	t.Run("Unknown templates fall back to the request content", func(t *testing.T) {
+		fcmMock := new(mockFCMDispatcher)
+		storeMock := new(mockTokenStore)
+		storeMock.On("Fetch", mock.Anything, testURN).Return(devices, nil)
+		fcmMock.On("Dispatch", mock.Anything, []string{"fcm-fr", "fcm-en", "fcm-unknown"}, title("Fallback title")).
+			Return(result(dispatch.PlatformFCM, dispatch.OutcomeDelivered, "fcm-fr", "fcm-en", "fcm-unknown"), nil).Once()
+
+		processor := pipeline.NewProcessor(fcmMock, nil, new(mockWebDispatcher), storeMock, logger, pipeline.WithTemplates(registry))
+		require.NoError(t, processor(ctx, original, newRequest("no_such_template")))
+
+		fcmMock.AssertExpectations(t)
+	})
+}
@@ -6,20 +6,21 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/illmade-knight/go-dataflow/pkg/messagepipeline"
-	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 )
 
 // NotificationRequestTransformer is a dataflow Transformer that safely unmarshals
-// and validates a raw message payload into a structured notification.NotificationRequest.
+// and validates a raw message payload into a structured dispatch.Request.
 //
-// It uses standard encoding/json, relying on the native struct's UnmarshalJSON
-// implementation to handle Protobuf deserialization and validation (e.g. URN parsing) internally.
+// It uses standard encoding/json; the embedded platform NotificationRequest handles
+// validation of its own fields (e.g. URN parsing) internally.
 func NotificationRequestTransformer(
 	_ context.Context,
 	msg *messagepipeline.Message,
-) (*notification.NotificationRequest, bool, error) {
+) (*dispatch.Request, bool, error) {
 
 	var msgData messagepipeline.MessageData
 	if err := json.Unmarshal(msg.Payload, &msgData); err != nil {
@@ -28,23 +29,59 @@ func NotificationRequestTransformer(
 		return nil, true, fmt.Errorf("failed to unmarshal notification request from message %s: %w", msg.ID, err)
 	}
 
-	var nativeReq notification.NotificationRequest
+	var nativeReq dispatch.Request
 
 	// This single call performs:
 	// 1. JSON Parsing
-	// 2. Protobuf Unmarshalling (internal)
-	// 3. Native Type Conversion (internal)
-	// 4. Validation (e.g. URN parsing) (internal)
+	// 2. Native Type Conversion (internal)
+	// 3. Validation (e.g. URN parsing) (internal)
 	if err := json.Unmarshal(msgData.Payload, &nativeReq); err != nil {
 		// If any step fails (malformed JSON, invalid URN, etc.), we return an error
 		// and set skip=true so the StreamingService can handle the Nack/DLQ logic.
 		return nil, true, fmt.Errorf("failed to unmarshal notification request from message %s: %w", msg.ID, err)
 	}
 
+	if nativeReq.Cancel {
+		// A cancel command names its target; it has no recipient or content of its own
+		if nativeReq.RequestID == "" {
+			return nil, true, fmt.Errorf("cancel command in message %s is missing requestId", msg.ID)
+		}
+		return &nativeReq, false, nil
+	}
+
 	if nativeReq.RecipientID.IsZero() {
 		return nil, true, fmt.Errorf("notification request in message %s is missing RecipientID", msg.ID)
 	}
 
+	// Idempotency: producers that don't supply a key are deduplicated on the
+	// Pub/Sub message ID, which is stable across redeliveries.
+	if nativeReq.RequestID == "" {
+		nativeReq.RequestID = msg.ID
+	}
+
+	// Expiry: the TTL runs from the original publish, not from this (re)delivery.
+	published := msg.PublishTime
+	if published.IsZero() {
+		published = msgData.PublishTime
+	}
+	if published.IsZero() {
+		published = time.Now()
+	}
+	nativeReq.ResolveExpiry(published)
+
 	// On success, we pass the structured request to the next stage.
 	return &nativeReq, false, nil
 }
+
+// PriorityLaneTransformer is the transformer for a dedicated priority lane.
+// Requests that don't state a priority take the lane's, so producers can opt
+// in just by publishing to the lane's topic.
+func PriorityLaneTransformer(priority dispatch.Priority) messagepipeline.MessageTransformer[dispatch.Request] {
+	return func(ctx context.Context, msg *messagepipeline.Message) (*dispatch.Request, bool, error) {
+		request, skip, err := NotificationRequestTransformer(ctx, msg)
+		if request != nil && request.Priority == "" {
+			request.Priority = priority
+		}
+		return request, skip, err
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tinywideclouds/go-notification-service/internal/pipeline"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
 	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
 )
@@ -31,23 +32,45 @@ func TestNotificationRequestTransformer(t *testing.T) {
 	validPayload, err := json.Marshal(validReq)
 	require.NoError(t, err)
 
+	// Producers publish a messagepipeline.MessageData envelope; the request is its Payload.
+	envelope := func(id string, payload []byte) []byte {
+		b, err := json.Marshal(messagepipeline.MessageData{ID: id, Payload: payload})
+		require.NoError(t, err)
+		return b
+	}
+
+	keyedPayload, err := json.Marshal(&dispatch.Request{NotificationRequest: *validReq, RequestID: "producer-key"})
+	require.NoError(t, err)
+
 	// Create a payload that looks like JSON but has an invalid URN string.
 	// Since we can't easily force json.Marshal to produce an invalid URN from a typed struct,
 	// we construct this JSON manually to test the validation logic inside UnmarshalJSON.
-	invalidURNPayload := []byte(`{"recipientId": "not-a-valid-urn"}`)
+	// (Single-segment IDs are auto-upgraded as legacy user IDs, so use a malformed multi-part URN.)
+	invalidURNPayload := []byte(`{"recipientId": "urn:not-a-valid"}`)
 
 	testCases := []struct {
 		name                  string
 		inputMessage          *messagepipeline.Message
 		expectError           bool
 		expectedErrorContains string
+		expectedRequestID     string
 	}{
 		{
 			name: "Happy Path - Valid JSON",
 			inputMessage: &messagepipeline.Message{
-				MessageData: messagepipeline.MessageData{ID: "msg-1", Payload: validPayload},
+				MessageData: messagepipeline.MessageData{ID: "msg-1", Payload: envelope("upstream-1", validPayload)},
 			},
 			expectError: false,
+			// No producer key: falls back to the Pub/Sub message ID
+			expectedRequestID: "msg-1",
+		},
+		{
+			name: "Happy Path - Producer Idempotency Key",
+			inputMessage: &messagepipeline.Message{
+				MessageData: messagepipeline.MessageData{ID: "msg-4", Payload: envelope("upstream-4", keyedPayload)},
+			},
+			expectError:       false,
+			expectedRequestID: "producer-key",
 		},
 		{
 			name: "Failure - Malformed JSON",
@@ -60,7 +83,7 @@ func TestNotificationRequestTransformer(t *testing.T) {
 		{
 			name: "Failure - Invalid URN (Validation)",
 			inputMessage: &messagepipeline.Message{
-				MessageData: messagepipeline.MessageData{ID: "msg-3", Payload: invalidURNPayload},
+				MessageData: messagepipeline.MessageData{ID: "msg-3", Payload: envelope("upstream-3", invalidURNPayload)},
 			},
 			expectError: true,
 			// The error message comes from urn.Parse inside the notification package
@@ -83,7 +106,103 @@ func TestNotificationRequestTransformer(t *testing.T) {
 				assert.NotNil(t, result)
 				// Basic check to ensure it parsed correctly
 				assert.Equal(t, validReq.RecipientID, result.RecipientID)
+				assert.Equal(t, tc.expectedRequestID, result.RequestID)
 			}
 		})
 	}
 }
+
+func TestNotificationRequestTransformer_Cancel(t *testing.T) {
+	ctx := context.Background()
+	message := func(payload string) *messagepipeline.Message {
+		data, err := json.Marshal(messagepipeline.MessageData{ID: "upstream-1", Payload: []byte(payload)})
+		require.NoError(t, err)
+		return &messagepipeline.Message{MessageData: messagepipeline.MessageData{ID: "msg-1", Payload: data}}
+	}
+
+	t.Run("A cancel command needs no recipient", func(t *testing.T) {
+		request, skip, err := pipeline.NotificationRequestTransformer(ctx, message(`{"cancel": true, "requestId": "reminder-1"}`))
+
+		require.NoError(t, err)
+		assert.False(t, skip)
+		assert.True(t, request.Cancel)
+		assert.Equal(t, "reminder-1", request.RequestID)
+	})
+
+	t.Run("A cancel command must name its target", func(t *testing.T) {
+		_, skip, err := pipeline.NotificationRequestTransformer(ctx, message(`{"cancel": true}`))
+
+		require.Error(t, err)
+		assert.True(t, skip)
+	})
+}
+
+func TestNotificationRequestTransformer_Expiry(t *testing.T) {
+	ctx := context.Background()
+	recipient, _ := urn.Parse("urn:contacts:user:user-123")
+	published := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	transform := func(req dispatch.Request) *dispatch.Request {
+		t.Helper()
+		req.RecipientID = recipient
+		payload, err := json.Marshal(&req)
+		require.NoError(t, err)
+		data, err := json.Marshal(messagepipeline.MessageData{ID: "upstream-1", Payload: payload})
+		require.NoError(t, err)
+
+		// A redelivery hours later still carries the original publish time
+		result, skip, err := pipeline.NotificationRequestTransformer(ctx, &messagepipeline.Message{
+			MessageData: messagepipeline.MessageData{ID: "msg-1", Payload: data, PublishTime: published},
+		})
+		require.NoError(t, err)
+		require.False(t, skip)
+		return result
+	}
+
+	t.Run("TTL runs from the publish time", func(t *testing.T) {
+		result := transform(dispatch.Request{TTLSeconds: 120})
+		assert.Equal(t, published.Add(2*time.Minute), result.ExpiresAt)
+		assert.True(t, result.IsExpired(time.Now()))
+	})
+
+	t.Run("TTL of a scheduled request runs from deliverAt", func(t *testing.T) {
+		deliverAt := published.Add(time.Hour)
+		result := transform(dispatch.Request{TTLSeconds: 120, DeliverAt: deliverAt})
+		assert.Equal(t, deliverAt.Add(2*time.Minute), result.ExpiresAt)
+	})
+
+	t.Run("An explicit expiresAt wins", func(t *testing.T) {
+		expiresAt := published.Add(time.Minute)
+		result := transform(dispatch.Request{TTLSeconds: 3600, ExpiresAt: expiresAt})
+		assert.Equal(t, expiresAt, result.ExpiresAt)
+	})
+
+	t.Run("No TTL means no expiry", func(t *testing.T) {
+		result := transform(dispatch.Request{})
+		assert.True(t, result.ExpiresAt.IsZero())
+		assert.False(t, result.IsExpired(time.Now()))
+	})
+}
+
+func TestPriorityLaneTransformer(t *testing.T) {
+	ctx := context.Background()
+	recipient, _ := urn.Parse("urn:contacts:user:user-123")
+	transformer := pipeline.PriorityLaneTransformer(dispatch.PriorityHigh)
+
+	transform := func(req dispatch.Request) *dispatch.Request {
+		t.Helper()
+		req.RecipientID = recipient
+		payload, err := json.Marshal(&req)
+		require.NoError(t, err)
+		data, err := json.Marshal(messagepipeline.MessageData{ID: "upstream-1", Payload: payload})
+		require.NoError(t, err)
+
+		result, skip, err := transformer(ctx, &messagepipeline.Message{MessageData: messagepipeline.MessageData{ID: "msg-1", Payload: data}})
+		require.NoError(t, err)
+		require.False(t, skip)
+		return result
+	}
+
+	assert.Equal(t, dispatch.PriorityHigh, transform(dispatch.Request{}).Priority)
+	assert.Equal(t, dispatch.PriorityUrgent, transform(dispatch.Request{Priority: dispatch.PriorityUrgent}).Priority)
+}
@@ -4,132 +4,404 @@ package apns
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
+	"time"
 
+	"github.com/VictoriaMetrics/metrics"
 	"github.com/sideshow/apns2"
 	"github.com/sideshow/apns2/payload"
 	"github.com/sideshow/apns2/token"
-	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 )
 
 // APNSClient defines the subset of the apns2.Client methods we use.
 // This allows mocking for unit tests.
 type APNSClient interface {
-	Push(n *apns2.Notification) (*apns2.Response, error)
+	PushWithContext(ctx apns2.Context, n *apns2.Notification) (*apns2.Response, error)
 }
 
+// defaultConcurrency is how many pushes are in flight at once. They are
+// multiplexed as streams over the client's HTTP/2 connection.
+const defaultConcurrency = 16
+
+// configurationErrors counts pushes APNs refused because of our credentials or
+// topic. Every one is a notification lost until the configuration is fixed.
+var configurationErrors = metrics.NewCounter("apns_configuration_errors_total")
+
 type Dispatcher struct {
-	client APNSClient
-	topic  string // The App Bundle ID (e.g. com.tinywide.messenger)
-	logger *slog.Logger
+	client APNSClient // Production gateway, unless clientEnvironment says otherwise
+	// clientEnvironment is the gateway client talks to; "" means production.
+	// Only a development certificate points client at the sandbox.
+	clientEnvironment string
+	// sandbox is the development gateway, for tokens from development builds.
+	// When nil, only tokens of client's environment (or none) can be pushed.
+	sandbox     APNSClient
+	topic       string // The App Bundle ID (e.g. com.tinywide.messenger)
+	concurrency int
+	retry       dispatch.RetryPolicy
+	logger      *slog.Logger
+}
+
+// Option configures a Dispatcher.
+type Option func(*Dispatcher)
+
+// WithConcurrency bounds how many pushes are in flight at once.
+// Values below 1 are ignored.
+func WithConcurrency(n int) Option {
+	return func(d *Dispatcher) {
+		if n > 0 {
+			d.concurrency = n
+		}
+	}
+}
+
+// WithRetryPolicy replaces dispatch.DefaultRetryPolicy for pushes APNs reports
+// as retryable (TooManyRequests, InternalServerError, ServiceUnavailable, ...)
+// and for transport failures.
+func WithRetryPolicy(policy dispatch.RetryPolicy) Option {
+	return func(d *Dispatcher) {
+		d.retry = policy
+	}
 }
 
-// Config holds the credentials required to sign APNs tokens.
+// Config holds the APNs credentials. Exactly one of P8KeyContent (token auth)
+// or CertificateContent (certificate auth) must be set.
 type Config struct {
 	KeyID    string
 	TeamID   string
 	BundleID string
 	// P8KeyContent is the raw string content of the .p8 file
 	P8KeyContent string
+	// CertificateContent is a push certificate: PEM (certificate and private
+	// key), or a base64-encoded .p12 file. KeyID and TeamID are not used.
+	CertificateContent string
+	// CertificatePassword decrypts the certificate's private key, if protected.
+	CertificatePassword string
 }
 
 // NewDispatcher creates a configured APNS dispatcher.
-// It parses the P8 key immediately to fail fast on startup if credentials are bad.
-func NewDispatcher(cfg Config, logger *slog.Logger) (*Dispatcher, error) {
-	authKey, err := token.AuthKeyFromBytes([]byte(cfg.P8KeyContent))
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse APNs P8 key: %w", err)
+// It parses the P8 key or certificate immediately to fail fast on startup if
+// credentials are bad, and refuses a certificate that has expired.
+func NewDispatcher(cfg Config, logger *slog.Logger, opts ...Option) (*Dispatcher, error) {
+	logger = logger.With("component", "APNSDispatcher")
+	d := &Dispatcher{
+		topic:       cfg.BundleID,
+		concurrency: defaultConcurrency,
+		retry:       dispatch.DefaultRetryPolicy(),
+		logger:      logger,
 	}
 
-	tokenSource := &token.Token{
-		AuthKey: authKey,
-		KeyID:   cfg.KeyID,
-		TeamID:  cfg.TeamID,
-	}
+	// A token only works on the gateway it was issued by, so hold a client for
+	// each the credentials allow.
+	switch {
+	case cfg.P8KeyContent != "" && cfg.CertificateContent != "":
+		return nil, errors.New("configure either an APNs P8 key or a certificate, not both")
+	case cfg.P8KeyContent != "":
+		authKey, err := token.AuthKeyFromBytes([]byte(cfg.P8KeyContent))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse APNs P8 key: %w", err)
+		}
 
-	// Use Production client by default.
-	// In dev, the sandbox environment is usually determined by the device token itself
-	// or separate certs, but for Token-based auth, Production endpoint is generally preferred
-	// as it can route to sandbox if needed, though apns2.NewTokenClient defaults to Production.
-	client := apns2.NewTokenClient(tokenSource)
+		tokenSource := &token.Token{
+			AuthKey: authKey,
+			KeyID:   cfg.KeyID,
+			TeamID:  cfg.TeamID,
+		}
+		// Both sign with the same provider token.
+		d.client = withRetryAfterHeader(apns2.NewTokenClient(tokenSource).Production())
+		d.sandbox = withRetryAfterHeader(apns2.NewTokenClient(tokenSource).Development())
+	case cfg.CertificateContent != "":
+		cert, err := loadCertificate(cfg.CertificateContent, cfg.CertificatePassword)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkCertificate(cert.Leaf, cfg.BundleID, time.Now(), logger); err != nil {
+			return nil, err
+		}
+		switch production, development := certificateEnvironments(cert.Leaf); {
+		case production && development:
+			d.client = withRetryAfterHeader(apns2.NewClient(cert).Production())
+			d.sandbox = withRetryAfterHeader(apns2.NewClient(cert).Development())
+		case production:
+			d.client = withRetryAfterHeader(apns2.NewClient(cert).Production())
+		default:
+			// A development certificate can only reach the sandbox
+			d.client = withRetryAfterHeader(apns2.NewClient(cert).Development())
+			d.clientEnvironment = dispatch.APNsDevelopment
+		}
+		logger.Info("APNs certificate loaded", "subject", cert.Leaf.Subject.CommonName, "expires_at", cert.Leaf.NotAfter)
+	default:
+		return nil, errors.New("no APNs credentials: set a P8 key or a certificate")
+	}
 
-	return &Dispatcher{
-		client: client,
-		topic:  cfg.BundleID,
-		logger: logger.With("component", "APNSDispatcher"),
-	}, nil
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d, nil
 }
 
 // Dispatch sends the notification to a batch of APNs tokens.
 // Note: APNs HTTP/2 API is unary (one request per token). There is no "Multicast" endpoint.
-// We iterate sequentially. For massive scale, this loop would be parallelized, but
-// given this runs inside a scaled Pipeline Worker, serial processing per-user is acceptable.
+// Pushes run concurrently, up to the concurrency limit, as streams on one HTTP/2
+// connection; the result lists the devices in the order of tokens.
+// msg.APNsEnvironment picks the gateway; all tokens in a batch share it.
+// Retryable failures are pushed again with backoff under the retry policy (never
+// sooner than APNs' Retry-After), and those still failing are returned as an error so the message is redelivered.
 func (d *Dispatcher) Dispatch(
 	ctx context.Context,
 	tokens []string,
-	content notification.NotificationContent,
-	data map[string]string,
-) (string, []string, error) {
+	msg dispatch.Message,
+) (*dispatch.DispatchResult, error) {
+	result := dispatch.NewDispatchResult(dispatch.PlatformAPNs)
 	if len(tokens) == 0 {
-		return "skipped: no tokens", nil, nil
+		return result, nil
 	}
-
-	var invalidTokens []string
-	successCount := 0
-	failureCount := 0
+	start := time.Now()
 
 	// 1. Build Payload
 	// We use the builder pattern to construct the correct JSON structure
-	builder := payload.NewPayload().
-		AlertTitle(content.Title).
-		AlertBody(content.Body).
-		Sound(content.Sound)
+	builder := payload.NewPayload()
+	pushType := apns2.PushTypeAlert
+	if msg.DataOnly {
+		// Background push: wakes the app to handle the custom data, shows nothing
+		builder.ContentAvailable()
+		pushType = apns2.PushTypeBackground
+	} else {
+		builder.AlertTitle(msg.Content.Title).AlertBody(msg.Content.Body)
+		if msg.Silent {
+			// Passive: lands in the notification center without sound or lighting the screen
+			builder.InterruptionLevel(payload.InterruptionLevelPassive)
+		} else {
+			builder.Sound(msg.Content.Sound)
+		}
+
+		if msg.CollapseKey != "" {
+			// Group by conversation in the notification center
+			builder.ThreadID(msg.CollapseKey)
+		}
 
-	// Add custom data fields
-	for k, v := range data {
+		// Rich content: the app's notification service extension fetches the image
+		// (from the "image" key) and its registered category supplies the buttons
+		rich := msg.Rich
+		if rich.Subtitle != "" {
+			builder.AlertSubtitle(rich.Subtitle)
+		}
+		if rich.Badge != nil {
+			builder.Badge(*rich.Badge)
+		}
+		if rich.ActionCategory != "" {
+			builder.Category(rich.ActionCategory)
+		}
+		if rich.ImageURL != "" {
+			builder.MutableContent()
+		}
+	}
+
+	// Add custom data fields (including the link, action links and image)
+	for k, v := range msg.PayloadData() {
 		builder.Custom(k, v)
 	}
 
-	for _, deviceToken := range tokens {
-		notification := &apns2.Notification{
-			DeviceToken: deviceToken,
-			Topic:       d.topic,
-			Payload:     builder,
-			// Expiration, Priority, etc. can be set here
+	// apns-priority: 5 lets iOS wait for a power-friendly moment; 10 is immediate.
+	// Background pushes must use 5.
+	priority := apns2.PriorityHigh
+	if msg.Priority.IsLow() || msg.DataOnly {
+		priority = apns2.PriorityLow
+	}
+
+	primary, fallback := d.route(msg.APNsEnvironment)
+	if primary == nil {
+		// The other gateway would answer BadDeviceToken, but the tokens are
+		// fine: it is our credentials that cannot reach their environment
+		configurationErrors.Add(len(tokens))
+		d.logger.Error("No APNs client for the tokens' environment; configure credentials that cover it",
+			"environment", msg.APNsEnvironment, "count", len(tokens))
+		for _, deviceToken := range tokens {
+			result.Add(dispatch.DeviceResult{
+				Address: deviceToken,
+				Outcome: dispatch.OutcomeRejected,
+				Reason:  fmt.Sprintf("no APNs client for the %s environment", msg.APNsEnvironment),
+			})
 		}
+		result.Latency = time.Since(start)
+		return result, nil
+	}
 
-		// 2. Send (Synchronous HTTP/2)
-		res, err := d.client.Push(notification)
+	devices := make([]dispatch.DeviceResult, len(tokens))
+	retryAfters := make([]time.Duration, len(tokens)) // Retry-After per token, from its last push
+	pending := make([]int, len(tokens))               // Indexes into tokens still to be pushed
+	for i := range tokens {
+		pending[i] = i
+	}
 
-		if err != nil {
-			// Network/Transport Failure
-			d.logger.Error("APNs transport failed", "token", deviceToken, "err", err)
-			failureCount++
-			continue
+	var err error
+	for attempt := 1; ; attempt++ {
+		d.pushAll(ctx, primary, fallback, tokens, pending, devices, retryAfters, func(deviceToken string) *apns2.Notification {
+			return &apns2.Notification{
+				DeviceToken: deviceToken,
+				Topic:       d.topic,
+				Payload:     builder,
+				// Zero Expiration lets APNs pick its default; otherwise it stops retrying at the deadline
+				Expiration: msg.ExpiresAt,
+				Priority:   priority,
+				PushType:   pushType,
+				// A newer notification with the same ID replaces the displayed one
+				CollapseID: msg.APNsCollapseID(),
+			}
+		})
+
+		var retry []int
+		var retryAfter time.Duration
+		for _, idx := range pending {
+			if devices[idx].Outcome == dispatch.OutcomeRetryable {
+				retry = append(retry, idx)
+				retryAfter = max(retryAfter, retryAfters[idx])
+			}
+		}
+		if len(retry) == 0 {
+			break
 		}
 
-		// 3. Handle Response Codes
-		if res.Sent() {
-			successCount++
-		} else {
-			failureCount++
-			// Map APNs error reasons to our "Invalid" concept
-			// See: https://developer.apple.com/documentation/usernotifications/setting_up_a_remote_notification_server/handling_notification_responses_from_apns
-			switch res.Reason {
-			case apns2.ReasonBadDeviceToken, apns2.ReasonUnregistered, apns2.ReasonDeviceTokenNotForTopic:
-				// Token is dead. Add to cleanup list.
-				invalidTokens = append(invalidTokens, deviceToken)
-			default:
-				// Other logic errors (TopicDisallowed, PayloadEmpty) are logged but not returned as "Invalid Token"
-				// because the token might be fine, but our configuration is wrong.
-				d.logger.Warn("APNs rejected notification", "reason", res.Reason, "status", res.StatusCode)
+		delay, ok := d.retry.Delay(attempt, retryAfter)
+		if ok {
+			ok = dispatch.Wait(ctx, delay)
+		}
+		if !ok {
+			err = fmt.Errorf("apns had %d retryable errors after %d attempts", len(retry), attempt)
+			break
+		}
+		d.logger.Info("Retrying APNs tokens", "count", len(retry), "attempt", attempt+1, "delay", delay)
+		pending = retry
+	}
+
+	for _, device := range devices {
+		result.Add(device)
+	}
+	result.Latency = time.Since(start)
+	return result, err
+}
+
+// route returns the client for tokens of the given environment, and the other
+// gateway (or nil) to try when it answers BadDeviceToken. primary is nil when
+// the credentials reach no gateway of that environment. Tokens registered
+// without an environment go to client first.
+func (d *Dispatcher) route(environment string) (primary, fallback APNSClient) {
+	clientEnvironment := d.clientEnvironment
+	if clientEnvironment == "" {
+		clientEnvironment = dispatch.APNsProduction
+	}
+	switch {
+	case environment == "" || environment == clientEnvironment:
+		return d.client, d.sandbox
+	case environment == dispatch.APNsDevelopment && d.sandbox != nil:
+		return d.sandbox, d.client
+	default:
+		return nil, nil
+	}
+}
+
+// pushAll pushes to tokens[i] for every i in indexes, with at most
+// d.concurrency pushes in flight, and stores each outcome in devices[i] and
+// any Retry-After in retryAfters[i].
+func (d *Dispatcher) pushAll(ctx context.Context, primary, fallback APNSClient, tokens []string, indexes []int, devices []dispatch.DeviceResult, retryAfters []time.Duration, notificationFor func(deviceToken string) *apns2.Notification) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(d.concurrency, 1), len(indexes)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				devices[idx], retryAfters[idx] = d.push(ctx, primary, fallback, notificationFor(tokens[idx]))
 			}
+		}()
+	}
+	for _, idx := range indexes {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// push sends one notification through primary and classifies the response.
+// A BadDeviceToken is tried again through fallback, the other gateway: a token
+// registered without (or with the wrong) environment is not dead, just misrouted.
+// The returned duration is the response's Retry-After, zero when there is none.
+func (d *Dispatcher) push(ctx context.Context, primary, fallback APNSClient, notification *apns2.Notification) (dispatch.DeviceResult, time.Duration) {
+	// 2. Send (HTTP/2 stream)
+	pushStart := time.Now()
+	ctx, retryAfter := withRetryAfter(ctx)
+	res, err := primary.PushWithContext(ctx, notification)
+	if err == nil && res.Reason == apns2.ReasonBadDeviceToken && fallback != nil {
+		// The other gateway's answer stands: only a BadDeviceToken (or
+		// Unregistered) from both makes the token invalid
+		res, err = fallback.PushWithContext(ctx, notification)
+		if err == nil && res.Sent() {
+			d.logger.Warn("APNs token belongs to the other environment; the client should register its environment",
+				"token", notification.DeviceToken)
 		}
 	}
+	device := dispatch.DeviceResult{
+		Address: notification.DeviceToken,
+		Latency: time.Since(pushStart),
+	}
+
+	if err != nil {
+		// Network/Transport Failure
+		d.logger.Error("APNs transport failed", "token", notification.DeviceToken, "err", err)
+		device.Outcome = dispatch.OutcomeRetryable
+		device.Reason = err.Error()
+		return device, 0
+	}
 
-	// If everything failed and it wasn't due to invalid tokens, we might want to signal a retry.
-	// For now, we return the receipt.
-	receipt := fmt.Sprintf("success:%d invalid:%d total_fail:%d", successCount, len(invalidTokens), failureCount)
-	return receipt, invalidTokens, nil
+	// 3. Handle Response Codes
+	device.ProviderMessageID = res.ApnsID
+	device.StatusCode = res.StatusCode
+	device.Reason = res.Reason
+	device.Outcome = classifyResponse(res)
+	switch {
+	case isConfigurationError(res):
+		// Every push will fail the same way until the credentials or topic are fixed
+		configurationErrors.Inc()
+		d.logger.Error("APNs refused our credentials or topic; check the APNs configuration",
+			"reason", res.Reason, "status", res.StatusCode, "topic", notification.Topic)
+	case device.Outcome == dispatch.OutcomeRejected:
+		// Logic errors (PayloadTooLarge, BadCollapseId) are not "Invalid Token":
+		// the token might be fine, but this notification is wrong.
+		d.logger.Warn("APNs rejected notification", "reason", res.Reason, "status", res.StatusCode)
+	}
+	return device, dispatch.ParseRetryAfter(*retryAfter, time.Now())
+}
+
+// classifyResponse maps an APNs response onto our outcome model.
+// See: https://developer.apple.com/documentation/usernotifications/setting_up_a_remote_notification_server/handling_notification_responses_from_apns
+func classifyResponse(res *apns2.Response) dispatch.DeliveryOutcome {
+	if res.Sent() {
+		return dispatch.OutcomeDelivered
+	}
+	switch res.Reason {
+	case apns2.ReasonBadDeviceToken, apns2.ReasonUnregistered, apns2.ReasonDeviceTokenNotForTopic:
+		// Token is dead. Add to cleanup list.
+		return dispatch.OutcomeInvalid
+	case apns2.ReasonTooManyRequests, apns2.ReasonInternalServerError, apns2.ReasonServiceUnavailable,
+		apns2.ReasonShutdown, apns2.ReasonIdleTimeout:
+		return dispatch.OutcomeRetryable
+	default:
+		return dispatch.OutcomeRejected
+	}
+}
+
+// isConfigurationError reports whether APNs refused the push because of our
+// provider token, certificate or topic rather than the device or the payload.
+// These are rejected (retrying can't help) but must not go unnoticed.
+func isConfigurationError(res *apns2.Response) bool {
+	switch res.Reason {
+	case apns2.ReasonBadCertificate, apns2.ReasonBadCertificateEnvironment, apns2.ReasonForbidden,
+		apns2.ReasonInvalidProviderToken, apns2.ReasonMissingProviderToken, apns2.ReasonExpiredProviderToken,
+		apns2.ReasonTopicDisallowed, apns2.ReasonBadTopic, apns2.ReasonMissingTopic:
+		return true
+	}
+	return false
 }
@@ -3,16 +3,19 @@ package apns
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"log/slog"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/sideshow/apns2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
 )
 
@@ -21,8 +24,8 @@ type MockAPNSClient struct {
 	mock.Mock
 }
 
-func (m *MockAPNSClient) Push(n *apns2.Notification) (*apns2.Response, error) {
-	args := m.Called(n)
+func (m *MockAPNSClient) PushWithContext(ctx apns2.Context, n *apns2.Notification) (*apns2.Response, error) {
+	args := m.Called(ctx, n)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -32,8 +35,10 @@ func (m *MockAPNSClient) Push(n *apns2.Notification) (*apns2.Response, error) {
 func TestDispatch_Internal(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	ctx := context.Background()
-	content := notification.NotificationContent{Title: "Hello iOS"}
-	data := map[string]string{"msg_id": "123"}
+	msg := dispatch.Message{
+		Content: notification.NotificationContent{Title: "Hello iOS"},
+		Data:    map[string]string{"msg_id": "123"},
+	}
 
 	t.Run("Happy Path - Success", func(t *testing.T) {
 		mockClient := new(MockAPNSClient)
@@ -46,18 +51,19 @@ func TestDispatch_Internal(t *testing.T) {
 		tokens := []string{"token-1"}
 
 		// Arrange: Return 200 OK
-		mockResponse := &apns2.Response{StatusCode: http.StatusOK}
-		mockClient.On("Push", mock.MatchedBy(func(n *apns2.Notification) bool {
+		mockResponse := &apns2.Response{StatusCode: http.StatusOK, ApnsID: "apns-id-1"}
+		mockClient.On("PushWithContext", mock.Anything, mock.MatchedBy(func(n *apns2.Notification) bool {
 			return n.DeviceToken == "token-1" && n.Topic == "com.test.app"
 		})).Return(mockResponse, nil)
 
 		// Act
-		receipt, invalid, err := dispatcher.Dispatch(ctx, tokens, content, data)
+		result, err := dispatcher.Dispatch(ctx, tokens, msg)
 
 		// Assert
 		require.NoError(t, err)
-		assert.Empty(t, invalid)
-		assert.Contains(t, receipt, "success:1")
+		assert.Empty(t, result.Invalid())
+		assert.Equal(t, 1, result.Count(dispatch.OutcomeDelivered))
+		assert.Equal(t, "apns-id-1", result.Devices[0].ProviderMessageID)
 		mockClient.AssertExpectations(t)
 	})
 
@@ -76,15 +82,15 @@ func TestDispatch_Internal(t *testing.T) {
 			StatusCode: http.StatusBadRequest,
 			Reason:     apns2.ReasonBadDeviceToken,
 		}
-		mockClient.On("Push", mock.Anything).Return(mockResponse, nil)
+		mockClient.On("PushWithContext", mock.Anything, mock.Anything).Return(mockResponse, nil)
 
 		// Act
-		_, invalid, err := dispatcher.Dispatch(ctx, tokens, content, data)
+		result, err := dispatcher.Dispatch(ctx, tokens, msg)
 
 		// Assert
 		require.NoError(t, err)
-		assert.Len(t, invalid, 1)
-		assert.Equal(t, "bad-token", invalid[0])
+		assert.Equal(t, []string{"bad-token"}, result.Invalid())
+		assert.Equal(t, apns2.ReasonBadDeviceToken, result.Devices[0].Reason)
 	})
 
 	t.Run("Transport Failure - Retryable", func(t *testing.T) {
@@ -98,16 +104,331 @@ func TestDispatch_Internal(t *testing.T) {
 		tokens := []string{"token-1"}
 
 		// Arrange: Return Error (Network down)
-		mockClient.On("Push", mock.Anything).Return(nil, errors.New("connection refused"))
+		mockClient.On("PushWithContext", mock.Anything, mock.Anything).Return(nil, errors.New("connection refused"))
 
 		// Act
-		receipt, invalid, err := dispatcher.Dispatch(ctx, tokens, content, data)
+		result, err := dispatcher.Dispatch(ctx, tokens, msg)
+
+		// Assert: the failure is returned so the message is redelivered
+		require.Error(t, err)
+		assert.Empty(t, result.Invalid())
+		assert.Equal(t, 1, result.Count(dispatch.OutcomeRetryable))
+	})
+
+	t.Run("Retryable Reasons Are Retried With Backoff", func(t *testing.T) {
+		mockClient := new(MockAPNSClient)
+		dispatcher := &Dispatcher{
+			client:      mockClient,
+			topic:       "com.test.app",
+			concurrency: 4,
+			retry:       dispatch.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+			logger:      logger,
+		}
+
+		mockClient.On("PushWithContext", mock.Anything, forToken("token-1")).Return(&apns2.Response{StatusCode: http.StatusOK}, nil).Once()
+		mockClient.On("PushWithContext", mock.Anything, forToken("token-2")).Return(&apns2.Response{
+			StatusCode: http.StatusServiceUnavailable, Reason: apns2.ReasonServiceUnavailable,
+		}, nil).Once()
+		mockClient.On("PushWithContext", mock.Anything, forToken("token-2")).Return(&apns2.Response{StatusCode: http.StatusOK}, nil).Once()
+		mockClient.On("PushWithContext", mock.Anything, forToken("token-3")).Return(&apns2.Response{
+			StatusCode: http.StatusTooManyRequests, Reason: apns2.ReasonTooManyRequests,
+		}, nil).Times(3)
+
+		result, err := dispatcher.Dispatch(ctx, []string{"token-1", "token-2", "token-3"}, msg)
+
+		require.Error(t, err, "token-3 is still failing after every attempt")
+		assert.Equal(t, []string{"token-1", "token-2"}, result.Addresses(dispatch.OutcomeDelivered))
+		assert.Equal(t, []string{"token-3"}, result.Addresses(dispatch.OutcomeRetryable))
+		assert.Equal(t, "token-3", result.Devices[2].Address, "results keep the token order")
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("Configuration Errors Are Rejected, Not Retried", func(t *testing.T) {
+		mockClient := new(MockAPNSClient)
+		dispatcher := &Dispatcher{
+			client: mockClient,
+			topic:  "com.wrong.app",
+			retry:  dispatch.DefaultRetryPolicy(),
+			logger: logger,
+		}
+		mockClient.On("PushWithContext", mock.Anything, mock.Anything).Return(&apns2.Response{
+			StatusCode: http.StatusBadRequest, Reason: apns2.ReasonTopicDisallowed,
+		}, nil).Twice()
+		before := configurationErrors.Get()
+
+		result, err := dispatcher.Dispatch(ctx, []string{"token-1", "token-2"}, msg)
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, result.Count(dispatch.OutcomeRejected))
+		assert.Equal(t, before+2, configurationErrors.Get())
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("Rejected - Payload Problem Is Not Retried Or Deleted", func(t *testing.T) {
+		mockClient := new(MockAPNSClient)
+		dispatcher := &Dispatcher{
+			client: mockClient,
+			topic:  "com.test.app",
+			logger: logger,
+		}
+
+		// Arrange: Return 413 PayloadTooLarge
+		mockResponse := &apns2.Response{
+			StatusCode: http.StatusRequestEntityTooLarge,
+			Reason:     apns2.ReasonPayloadTooLarge,
+		}
+		mockClient.On("PushWithContext", mock.Anything, mock.Anything).Return(mockResponse, nil)
+
+		// Act
+		result, err := dispatcher.Dispatch(ctx, []string{"token-1"}, msg)
 
 		// Assert
-		// Note: The current implementation logs transport errors and continues, returning nil error.
-		// This is a design choice (best effort).
 		require.NoError(t, err)
-		assert.Empty(t, invalid)
-		assert.Contains(t, receipt, "total_fail:1")
+		assert.Empty(t, result.Invalid())
+		assert.Equal(t, 1, result.Count(dispatch.OutcomeRejected))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, result.Devices[0].StatusCode)
 	})
+
+	t.Run("Silent Message Is Passive And Soundless", func(t *testing.T) {
+		mockClient := new(MockAPNSClient)
+		dispatcher := &Dispatcher{
+			client: mockClient,
+			topic:  "com.test.app",
+			logger: logger,
+		}
+		silent := msg
+		silent.Content.Sound = "default"
+		silent.Silent = true
+
+		var sent []byte
+		mockClient.On("PushWithContext", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			sent, _ = json.Marshal(args.Get(1).(*apns2.Notification).Payload)
+		}).Return(&apns2.Response{StatusCode: http.StatusOK}, nil)
+
+		_, err := dispatcher.Dispatch(ctx, []string{"token-1"}, silent)
+
+		require.NoError(t, err)
+		assert.Contains(t, string(sent), `"interruption-level":"passive"`)
+		assert.NotContains(t, string(sent), `"sound"`)
+	})
+
+	t.Run("Expiry Sets The APNs Expiration", func(t *testing.T) {
+		mockClient := new(MockAPNSClient)
+		dispatcher := &Dispatcher{
+			client: mockClient,
+			topic:  "com.test.app",
+			logger: logger,
+		}
+		expiring := msg
+		expiring.ExpiresAt = time.Now().Add(5 * time.Minute)
+
+		mockClient.On("PushWithContext", mock.Anything, mock.MatchedBy(func(n *apns2.Notification) bool {
+			return n.Expiration.Equal(expiring.ExpiresAt)
+		})).Return(&apns2.Response{StatusCode: http.StatusOK}, nil)
+
+		_, err := dispatcher.Dispatch(ctx, []string{"token-1"}, expiring)
+
+		require.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("Low Priority Uses apns-priority 5", func(t *testing.T) {
+		mockClient := new(MockAPNSClient)
+		dispatcher := &Dispatcher{
+			client: mockClient,
+			topic:  "com.test.app",
+			logger: logger,
+		}
+		low := msg
+		low.Priority = dispatch.PriorityLow
+
+		mockClient.On("PushWithContext", mock.Anything, mock.MatchedBy(func(n *apns2.Notification) bool {
+			return n.Priority == apns2.PriorityLow
+		})).Return(&apns2.Response{StatusCode: http.StatusOK}, nil).Once()
+		mockClient.On("PushWithContext", mock.Anything, mock.MatchedBy(func(n *apns2.Notification) bool {
+			return n.Priority == apns2.PriorityHigh
+		})).Return(&apns2.Response{StatusCode: http.StatusOK}, nil).Once()
+
+		_, err := dispatcher.Dispatch(ctx, []string{"token-1"}, low)
+		require.NoError(t, err)
+		_, err = dispatcher.Dispatch(ctx, []string{"token-1"}, msg)
+		require.NoError(t, err)
+
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("Collapse Key Sets Collapse And Thread IDs", func(t *testing.T) {
+		mockClient := new(MockAPNSClient)
+		dispatcher := &Dispatcher{
+			client: mockClient,
+			topic:  "com.test.app",
+			logger: logger,
+		}
+		collapsing := msg
+		collapsing.CollapseKey = "chat-42"
+
+		var sent []byte
+		mockClient.On("PushWithContext", mock.Anything, mock.MatchedBy(func(n *apns2.Notification) bool {
+			return n.CollapseID == "chat-42"
+		})).Run(func(args mock.Arguments) {
+			sent, _ = json.Marshal(args.Get(1).(*apns2.Notification).Payload)
+		}).Return(&apns2.Response{StatusCode: http.StatusOK}, nil)
+
+		_, err := dispatcher.Dispatch(ctx, []string{"token-1"}, collapsing)
+
+		require.NoError(t, err)
+		assert.Contains(t, string(sent), `"thread-id":"chat-42"`)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("Data Only Is A Background Push", func(t *testing.T) {
+		mockClient := new(MockAPNSClient)
+		dispatcher := &Dispatcher{
+			client: mockClient,
+			topic:  "com.test.app",
+			logger: logger,
+		}
+		dataOnly := msg
+		dataOnly.DataOnly = true
+		dataOnly.Priority = dispatch.PriorityHigh
+
+		var sent []byte
+		mockClient.On("PushWithContext", mock.Anything, mock.MatchedBy(func(n *apns2.Notification) bool {
+			return n.PushType == apns2.PushTypeBackground && n.Priority == apns2.PriorityLow
+		})).Run(func(args mock.Arguments) {
+			sent, _ = json.Marshal(args.Get(1).(*apns2.Notification).Payload)
+		}).Return(&apns2.Response{StatusCode: http.StatusOK}, nil)
+
+		_, err := dispatcher.Dispatch(ctx, []string{"token-1"}, dataOnly)
+
+		require.NoError(t, err)
+		assert.Contains(t, string(sent), `"content-available":1`)
+		assert.NotContains(t, string(sent), `"alert"`)
+		assert.NotContains(t, string(sent), `"sound"`)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("Rich Content Sets Badge, Category And Mutable Content", func(t *testing.T) {
+		mockClient := new(MockAPNSClient)
+		dispatcher := &Dispatcher{
+			client: mockClient,
+			topic:  "com.test.app",
+			logger: logger,
+		}
+		badge := 0
+		rich := msg
+		rich.Rich = dispatch.RichContent{
+			Subtitle:       "From Alice",
+			ImageURL:       "https://cdn.example.com/cat.jpg",
+			Link:           "https://app.example.com/chat/42",
+			Badge:          &badge,
+			ActionCategory: "CHAT_MESSAGE",
+		}
+
+		var sent []byte
+		mockClient.On("PushWithContext", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			sent, _ = json.Marshal(args.Get(1).(*apns2.Notification).Payload)
+		}).Return(&apns2.Response{StatusCode: http.StatusOK}, nil)
+
+		_, err := dispatcher.Dispatch(ctx, []string{"token-1"}, rich)
+
+		require.NoError(t, err)
+		assert.Contains(t, string(sent), `"subtitle":"From Alice"`)
+		assert.Contains(t, string(sent), `"badge":0`, "a zero badge clears the icon count")
+		assert.Contains(t, string(sent), `"category":"CHAT_MESSAGE"`)
+		assert.Contains(t, string(sent), `"mutable-content":1`)
+		assert.Contains(t, string(sent), `"url":"https://app.example.com/chat/42"`)
+		assert.Contains(t, string(sent), `"image":"https://cdn.example.com/cat.jpg"`)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("Development Tokens Go To The Sandbox", func(t *testing.T) {
+		production, sandbox := new(MockAPNSClient), new(MockAPNSClient)
+		dispatcher := &Dispatcher{
+			client:  production,
+			sandbox: sandbox,
+			topic:   "com.test.app",
+			logger:  logger,
+		}
+		development := msg
+		development.APNsEnvironment = dispatch.APNsDevelopment
+
+		sandbox.On("PushWithContext", mock.Anything, mock.Anything).Return(&apns2.Response{StatusCode: http.StatusOK}, nil)
+
+		result, err := dispatcher.Dispatch(ctx, []string{"dev-token"}, development)
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Count(dispatch.OutcomeDelivered))
+		production.AssertNotCalled(t, "PushWithContext", mock.Anything, mock.Anything)
+		sandbox.AssertExpectations(t)
+	})
+
+	t.Run("Bad Device Token Falls Back To The Other Environment", func(t *testing.T) {
+		production, sandbox := new(MockAPNSClient), new(MockAPNSClient)
+		dispatcher := &Dispatcher{
+			client:  production,
+			sandbox: sandbox,
+			topic:   "com.test.app",
+			logger:  logger,
+		}
+		badToken := &apns2.Response{StatusCode: http.StatusBadRequest, Reason: apns2.ReasonBadDeviceToken}
+
+		// An unregistered development token is sent to production first
+		production.On("PushWithContext", mock.Anything, mock.Anything).Return(badToken, nil)
+		sandbox.On("PushWithContext", mock.Anything, forToken("dev-token")).Return(&apns2.Response{StatusCode: http.StatusOK}, nil)
+		sandbox.On("PushWithContext", mock.Anything, forToken("dead-token")).Return(badToken, nil)
+		sandbox.On("PushWithContext", mock.Anything, forToken("busy-token")).
+			Return(&apns2.Response{StatusCode: http.StatusServiceUnavailable, Reason: apns2.ReasonServiceUnavailable}, nil)
+
+		result, err := dispatcher.Dispatch(ctx, []string{"dev-token", "dead-token", "busy-token"}, msg)
+
+		// Only the token both gateways reject is invalid
+		require.Error(t, err)
+		assert.Equal(t, []string{"dead-token"}, result.Invalid())
+		assert.Equal(t, dispatch.OutcomeDelivered, result.Devices[0].Outcome)
+		assert.Equal(t, dispatch.OutcomeRetryable, result.Devices[2].Outcome)
+	})
+
+	t.Run("Tokens Of An Unreachable Environment Are Rejected, Not Invalid", func(t *testing.T) {
+		// A production-only certificate leaves no sandbox client
+		production := new(MockAPNSClient)
+		dispatcher := &Dispatcher{
+			client: production,
+			topic:  "com.test.app",
+			logger: logger,
+		}
+		development := msg
+		development.APNsEnvironment = dispatch.APNsDevelopment
+
+		result, err := dispatcher.Dispatch(ctx, []string{"dev-token"}, development)
+
+		require.NoError(t, err)
+		assert.Empty(t, result.Invalid())
+		assert.Equal(t, dispatch.OutcomeRejected, result.Devices[0].Outcome)
+		assert.Contains(t, result.Devices[0].Reason, "development")
+		production.AssertNotCalled(t, "PushWithContext", mock.Anything, mock.Anything)
+
+		// And a development-only certificate cannot reach production
+		sandbox := new(MockAPNSClient)
+		dispatcher = &Dispatcher{
+			client:            sandbox,
+			clientEnvironment: dispatch.APNsDevelopment,
+			topic:             "com.test.app",
+			logger:            logger,
+		}
+		prod := msg
+		prod.APNsEnvironment = dispatch.APNsProduction
+
+		result, err = dispatcher.Dispatch(ctx, []string{"prod-token"}, prod)
+
+		require.NoError(t, err)
+		assert.Empty(t, result.Invalid())
+		assert.Equal(t, dispatch.OutcomeRejected, result.Devices[0].Outcome)
+		sandbox.AssertNotCalled(t, "PushWithContext", mock.Anything, mock.Anything)
+	})
+}
+
+// forToken matches the notification pushed to token.
+func forToken(token string) interface{} {
+	return mock.MatchedBy(func(n *apns2.Notification) bool { return n.DeviceToken == token })
 }
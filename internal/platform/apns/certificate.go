@@ -0,0 +1,101 @@
+// --- File: internal/platform/apns/certificate.go ---
+package apns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/sideshow/apns2/certificate"
+)
+
+// certificateExpiryWarning is how far ahead of expiry startup starts warning.
+// Apple push certificates last a year and must be renewed by hand.
+const certificateExpiryWarning = 30 * 24 * time.Hour
+
+// oidUserID is the subject attribute Apple puts the certificate's topic (the
+// bundle ID) in.
+var oidUserID = asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}
+
+// loadCertificate parses a push certificate: PEM (certificate and key in one
+// file), or base64-encoded PKCS#12 (.p12). password decrypts either; use "" for
+// unprotected files.
+func loadCertificate(content, password string) (tls.Certificate, error) {
+	var cert tls.Certificate
+	if strings.Contains(content, "-----BEGIN") {
+		pem, err := certificate.FromPemBytes([]byte(content), password)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to parse APNs PEM certificate: %w", err)
+		}
+		cert = pem
+	} else {
+		p12, err := base64.StdEncoding.DecodeString(strings.TrimSpace(content))
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("APNs certificate is neither PEM nor base64-encoded PKCS#12: %w", err)
+		}
+		if cert, err = certificate.FromP12Bytes(p12, password); err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to parse APNs PKCS#12 certificate: %w", err)
+		}
+	}
+
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to parse APNs certificate: %w", err)
+		}
+		cert.Leaf = leaf
+	}
+	return cert, nil
+}
+
+// checkCertificate fails on a certificate that APNs would refuse at now, and
+// warns about one that expires soon or was issued for another topic.
+func checkCertificate(leaf *x509.Certificate, bundleID string, now time.Time, logger *slog.Logger) error {
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("APNs certificate is not valid until %s", leaf.NotBefore.Format(time.RFC3339))
+	}
+	if !now.Before(leaf.NotAfter) {
+		return fmt.Errorf("APNs certificate expired on %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	if left := leaf.NotAfter.Sub(now); left < certificateExpiryWarning {
+		logger.Warn("APNs certificate expires soon; renew it before native iOS delivery stops",
+			"expires_at", leaf.NotAfter, "days_left", int(left.Hours()/24))
+	}
+	if topic := certificateTopic(leaf); topic != "" && topic != bundleID {
+		logger.Warn("APNs certificate was issued for another topic; pushes will fail with TopicDisallowed",
+			"certificate_topic", topic, "bundle_id", bundleID)
+	}
+	return nil
+}
+
+// certificateTopic returns the bundle ID a push certificate was issued for,
+// or "" when it does not name one.
+func certificateTopic(leaf *x509.Certificate) string {
+	for _, name := range leaf.Subject.Names {
+		if name.Type.Equal(oidUserID) {
+			if topic, ok := name.Value.(string); ok {
+				return topic
+			}
+		}
+	}
+	return ""
+}
+
+// certificateEnvironments reports which gateways accept a push certificate.
+// Apple's legacy per-environment certificates are named for the one they work
+// with; current "Apple Push Services" certificates work with both.
+func certificateEnvironments(leaf *x509.Certificate) (production, development bool) {
+	switch name := leaf.Subject.CommonName; {
+	case strings.HasPrefix(name, "Apple Development IOS Push Services"):
+		return false, true
+	case strings.HasPrefix(name, "Apple Production IOS Push Services"):
+		return true, false
+	default:
+		return true, true
+	}
+}
@@ -0,0 +1,126 @@
+// --- File: internal/platform/apns/certificate_test.go ---
+package apns
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+// newTestCertificate returns a self-signed PEM certificate and key shaped like
+// an Apple push certificate.
+func newTestCertificate(t *testing.T, commonName, topic string, notAfter time.Time) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: commonName,
+			ExtraNames: []pkix.AttributeTypeAndValue{{Type: oidUserID, Value: topic}},
+		},
+		NotBefore: notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:  notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	require.NoError(t, pem.Encode(&out, &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	return out.String()
+}
+
+func TestNewDispatcher_Certificate(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	inAYear := time.Now().Add(365 * 24 * time.Hour)
+
+	t.Run("Universal Certificate Reaches Both Gateways", func(t *testing.T) {
+		pemContent := newTestCertificate(t, "Apple Push Services: com.test.app", "com.test.app", inAYear)
+
+		d, err := NewDispatcher(Config{BundleID: "com.test.app", CertificateContent: pemContent}, logger)
+
+		require.NoError(t, err)
+		assert.NotNil(t, d.client)
+		assert.NotNil(t, d.sandbox)
+	})
+
+	t.Run("Production Certificate Has No Sandbox", func(t *testing.T) {
+		pemContent := newTestCertificate(t, "Apple Production IOS Push Services: com.test.app", "com.test.app", inAYear)
+
+		d, err := NewDispatcher(Config{BundleID: "com.test.app", CertificateContent: pemContent}, logger)
+
+		require.NoError(t, err)
+		assert.NotNil(t, d.client)
+		assert.Nil(t, d.sandbox)
+	})
+
+	t.Run("Development Certificate Only Reaches The Sandbox", func(t *testing.T) {
+		pemContent := newTestCertificate(t, "Apple Development IOS Push Services: com.test.app", "com.test.app", inAYear)
+
+		d, err := NewDispatcher(Config{BundleID: "com.test.app", CertificateContent: pemContent}, logger)
+
+		require.NoError(t, err)
+		assert.NotNil(t, d.client)
+		assert.Equal(t, dispatch.APNsDevelopment, d.clientEnvironment)
+		assert.Nil(t, d.sandbox)
+	})
+
+	t.Run("Rejects Expired Certificate", func(t *testing.T) {
+		pemContent := newTestCertificate(t, "Apple Push Services: com.test.app", "com.test.app", time.Now().Add(-time.Hour))
+
+		_, err := NewDispatcher(Config{BundleID: "com.test.app", CertificateContent: pemContent}, logger)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "expired")
+	})
+
+	t.Run("Rejects Garbage", func(t *testing.T) {
+		_, err := NewDispatcher(Config{BundleID: "com.test.app", CertificateContent: "not a certificate!"}, logger)
+
+		require.Error(t, err)
+	})
+
+	t.Run("Rejects Both Kinds Of Credentials", func(t *testing.T) {
+		pemContent := newTestCertificate(t, "Apple Push Services: com.test.app", "com.test.app", inAYear)
+
+		_, err := NewDispatcher(Config{BundleID: "com.test.app", CertificateContent: pemContent, P8KeyContent: "p8"}, logger)
+
+		require.Error(t, err)
+	})
+
+	t.Run("Rejects Missing Credentials", func(t *testing.T) {
+		_, err := NewDispatcher(Config{BundleID: "com.test.app"}, logger)
+
+		require.Error(t, err)
+	})
+}
+
+func TestCheckCertificate_Warnings(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	now := time.Now()
+
+	cert, err := loadCertificate(newTestCertificate(t, "Apple Push Services: com.other.app", "com.other.app", now.Add(10*24*time.Hour)), "")
+	require.NoError(t, err)
+
+	require.NoError(t, checkCertificate(cert.Leaf, "com.test.app", now, logger))
+	assert.Contains(t, logs.String(), "expires soon")
+	assert.Contains(t, logs.String(), "certificate_topic=com.other.app")
+}
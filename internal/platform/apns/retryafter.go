@@ -0,0 +1,51 @@
+// --- File: internal/platform/apns/retryafter.go ---
+package apns
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sideshow/apns2"
+)
+
+// apns2.Response carries no headers, so the Retry-After APNs sends with 429
+// and 503 responses is caught on the way back through the client's transport
+// and handed to the push through its context.
+
+type retryAfterKey struct{}
+
+// withRetryAfter returns a context whose push records the response's
+// Retry-After header in the returned string.
+func withRetryAfter(ctx context.Context) (context.Context, *string) {
+	header := new(string)
+	return context.WithValue(ctx, retryAfterKey{}, header), header
+}
+
+// retryAfterTransport records the Retry-After header of each response whose
+// request context came from withRetryAfter.
+type retryAfterTransport struct {
+	next http.RoundTripper
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err == nil {
+		if header, ok := req.Context().Value(retryAfterKey{}).(*string); ok {
+			*header = res.Header.Get("Retry-After")
+		}
+	}
+	return res, err
+}
+
+// CloseIdleConnections keeps apns2.Client.CloseIdleConnections working.
+func (t *retryAfterTransport) CloseIdleConnections() {
+	if closer, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// withRetryAfterHeader makes client's pushes report Retry-After.
+func withRetryAfterHeader(client *apns2.Client) *apns2.Client {
+	client.HTTPClient.Transport = &retryAfterTransport{next: client.HTTPClient.Transport}
+	return client
+}
@@ -0,0 +1,76 @@
+// --- File: internal/platform/apns/retryafter_test.go ---
+package apns
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sideshow/apns2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+// newGatewayClient returns a real apns2 client talking to a fake gateway that
+// answers each push with respond(n), n counting from 1.
+func newGatewayClient(t *testing.T, respond func(n int32, w http.ResponseWriter)) (*apns2.Client, *atomic.Int32) {
+	t.Helper()
+	var pushes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond(pushes.Add(1), w)
+	}))
+	t.Cleanup(server.Close)
+
+	client := &apns2.Client{Host: server.URL, HTTPClient: &http.Client{Transport: http.DefaultTransport}}
+	return withRetryAfterHeader(client), &pushes
+}
+
+func tooManyRequests(w http.ResponseWriter, retryAfter string) {
+	w.Header().Set("Retry-After", retryAfter)
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, _ = w.Write([]byte(`{"reason":"TooManyRequests"}`))
+}
+
+func TestDispatch_RetryAfter(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+	policy := dispatch.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Second}
+
+	t.Run("Retry-After Is The Minimum Backoff", func(t *testing.T) {
+		client, pushes := newGatewayClient(t, func(n int32, w http.ResponseWriter) {
+			if n == 1 {
+				tooManyRequests(w, "1")
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+		dispatcher := &Dispatcher{client: client, topic: "com.test.app", retry: policy, logger: logger}
+
+		start := time.Now()
+		result, err := dispatcher.Dispatch(ctx, []string{"token-1"}, dispatch.Message{})
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Count(dispatch.OutcomeDelivered))
+		assert.Equal(t, int32(2), pushes.Load())
+		assert.GreaterOrEqual(t, time.Since(start), time.Second, "the backoff alone would be a millisecond")
+	})
+
+	t.Run("Retry-After Beyond MaxDelay Is Left To Redelivery", func(t *testing.T) {
+		client, pushes := newGatewayClient(t, func(_ int32, w http.ResponseWriter) {
+			tooManyRequests(w, "60")
+		})
+		dispatcher := &Dispatcher{client: client, topic: "com.test.app", retry: policy, logger: logger}
+
+		result, err := dispatcher.Dispatch(ctx, []string{"token-1"}, dispatch.Message{})
+
+		require.Error(t, err)
+		assert.Equal(t, 1, result.Count(dispatch.OutcomeRetryable))
+		assert.Equal(t, int32(1), pushes.Load(), "no retry while APNs asks us to back off")
+	})
+}
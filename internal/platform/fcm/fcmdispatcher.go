@@ -1,13 +1,18 @@
-// --- File: internal/platform/fcm/fcmdispatcher.go ---
 package fcm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"firebase.google.com/go/v4/messaging"
-	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 )
 
 // MessagingClient defines the subset of the Firebase Messaging API we use.
@@ -16,81 +21,326 @@ type MessagingClient interface {
 	SendEachForMulticast(ctx context.Context, msg *messaging.MulticastMessage) (*messaging.BatchResponse, error)
 }
 
+// MaxMulticastTokens is the most tokens FCM accepts in one multicast.
+const MaxMulticastTokens = 500
+
+// defaultConcurrency is how many multicast chunks are in flight at once.
+const defaultConcurrency = 4
+
 type Dispatcher struct {
-	client MessagingClient // Changed from *messaging.Client
-	logger *slog.Logger
+	client      MessagingClient // Changed from *messaging.Client
+	retry       dispatch.RetryPolicy
+	concurrency int
+	logger      *slog.Logger
+}
+
+// Option configures a Dispatcher.
+type Option func(*Dispatcher)
+
+// WithRetryPolicy replaces dispatch.DefaultRetryPolicy for tokens FCM reports as
+// retryable (e.g. UNAVAILABLE, INTERNAL, QUOTA_EXCEEDED).
+func WithRetryPolicy(policy dispatch.RetryPolicy) Option {
+	return func(d *Dispatcher) {
+		d.retry = policy
+	}
+}
+
+// WithConcurrency bounds how many chunks of MaxMulticastTokens are sent at once
+// for a large token list. Values below 1 are ignored.
+func WithConcurrency(n int) Option {
+	return func(d *Dispatcher) {
+		if n > 0 {
+			d.concurrency = n
+		}
+	}
 }
 
 // NewDispatcher accepts the concrete client but stores it as the interface.
 // Note: *messaging.Client automatically satisfies this interface.
-func NewDispatcher(client MessagingClient, logger *slog.Logger) *Dispatcher {
-	return &Dispatcher{
-		client: client,
-		logger: logger.With("component", "FCMDispatcher"),
+func NewDispatcher(client MessagingClient, logger *slog.Logger, opts ...Option) *Dispatcher {
+	d := &Dispatcher{
+		client:      client,
+		retry:       dispatch.DefaultRetryPolicy(),
+		concurrency: defaultConcurrency,
+		logger:      logger.With("component", "FCMDispatcher"),
+	}
+	for _, opt := range opts {
+		opt(d)
 	}
+	return d
 }
 
-// Dispatch remains exactly the same logic-wise...
-func (d *Dispatcher) Dispatch(ctx context.Context, tokens []string, content notification.NotificationContent, data map[string]string) (string, []string, error) {
+// Dispatch multicasts the notification and maps each SendResponse to a DeviceResult.
+// Token lists beyond MaxMulticastTokens are split into chunks sent concurrently;
+// the result lists the devices in the order of tokens either way.
+// Tokens with a retryable failure are sent again in-process under the retry policy;
+// only those still failing are returned as an error (alongside the result) so the
+// message is redelivered. The ledger keeps the delivered tokens from being re-notified.
+func (d *Dispatcher) Dispatch(ctx context.Context, tokens []string, message dispatch.Message) (*dispatch.DispatchResult, error) {
+	result := dispatch.NewDispatchResult(dispatch.PlatformFCM)
 	if len(tokens) == 0 {
-		return "skipped: no tokens", nil, nil
+		return result, nil
 	}
+	start := time.Now()
 
-	msg := &messaging.MulticastMessage{
-		Tokens: tokens,
-		Data:   data,
-		Notification: &messaging.Notification{
-			Title: content.Title,
-			Body:  content.Body,
-		},
-		Webpush: &messaging.WebpushConfig{
-			Notification: &messaging.WebpushNotification{
-				Title: content.Title,
-				Body:  content.Body,
-				Icon:  "/assets/icons/icon-192x192.png",
-			},
-		},
+	chunks := slices.Collect(slices.Chunk(tokens, MaxMulticastTokens))
+	devices := make([][]dispatch.DeviceResult, len(chunks))
+	errs := make([]error, len(chunks))
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, d.concurrency)
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			devices[i], errs[i] = d.send(ctx, chunk, message)
+		}()
 	}
+	wg.Wait()
 
-	// Uses the interface method
-	br, err := d.client.SendEachForMulticast(ctx, msg)
-	if err != nil {
-		// ✅ CHECK: Is this a fatal validation error?
-		// Note: The Firebase Go SDK returns standard error types.
-		// We check if it's NOT a transport error.
-		if messaging.IsInvalidArgument(err) {
-			d.logger.Error("FCM rejected batch as InvalidArgument (dropping)", "err", err)
-			// Return nil error to ACK the message and break the loop
-			return "skipped: invalid_argument", nil, nil
+	for _, chunk := range devices {
+		for _, device := range chunk {
+			result.Add(device)
 		}
+	}
+	result.Latency = time.Since(start)
+	return result, errors.Join(errs...)
+}
 
-		// Real network/auth failure -> Retry
-		return "", nil, fmt.Errorf("fcm transport failed: %w", err)
+// send delivers one chunk of at most MaxMulticastTokens, retrying the retryable
+// tokens under the retry policy. Devices are returned in the order of tokens.
+func (d *Dispatcher) send(ctx context.Context, tokens []string, message dispatch.Message) ([]dispatch.DeviceResult, error) {
+	devices := make([]dispatch.DeviceResult, len(tokens))
+	pending := make([]int, len(tokens)) // Indexes into tokens still to be sent
+	for i := range tokens {
+		pending[i] = i
 	}
 
-	var invalidTokens []string
-	retryableErrors := 0
+	for attempt := 1; ; attempt++ {
+		batch := make([]string, len(pending))
+		for i, idx := range pending {
+			batch[i] = tokens[idx]
+		}
+		msg := buildMulticast(batch, message)
+
+		// Uses the interface method
+		sendStart := time.Now()
+		br, err := d.client.SendEachForMulticast(ctx, msg)
+		latency := time.Since(sendStart)
 
-	if br.FailureCount > 0 {
-		for idx, resp := range br.Responses {
-			if resp.Success {
-				continue
+		if err != nil {
+			// ✅ CHECK: Is this a fatal validation error?
+			// Note: The Firebase Go SDK returns standard error types.
+			// We check if it's NOT a transport error.
+			if messaging.IsInvalidArgument(err) {
+				d.logger.Error("FCM rejected batch as InvalidArgument (dropping)", "err", err)
+				// Return nil error to ACK the message and break the loop
+				for _, idx := range pending {
+					devices[idx] = dispatch.DeviceResult{Address: tokens[idx], Outcome: dispatch.OutcomeRejected, Reason: err.Error(), Latency: latency}
+				}
+				return devices, nil
 			}
 
-			// Check for FATAL errors (The token is garbage)
-			if messaging.IsInvalidArgument(resp.Error) || messaging.IsRegistrationTokenNotRegistered(resp.Error) {
-				invalidTokens = append(invalidTokens, tokens[idx])
-				continue
+			// Real network/auth failure -> Retry. The SDK has already retried the
+			// HTTP calls, so the whole message goes back to Pub/Sub.
+			for _, idx := range pending {
+				devices[idx] = dispatch.DeviceResult{Address: tokens[idx], Outcome: dispatch.OutcomeRetryable, Reason: err.Error(), Latency: latency}
 			}
+			return devices, fmt.Errorf("fcm transport failed: %w", err)
+		}
+
+		var retry []int
+		var retryAfter time.Duration
+		for i, resp := range br.Responses {
+			idx := pending[i]
+			device := dispatch.DeviceResult{
+				Address: tokens[idx],
+				Latency: latency,
+			}
+
+			switch {
+			case resp.Success:
+				device.Outcome = dispatch.OutcomeDelivered
+				device.ProviderMessageID = resp.MessageID
+			case messaging.IsInvalidArgument(resp.Error) || messaging.IsRegistrationTokenNotRegistered(resp.Error):
+				// FATAL: The token is garbage
+				device.Outcome = dispatch.OutcomeInvalid
+				device.Reason = resp.Error.Error()
+			default:
+				device.Outcome = dispatch.OutcomeRetryable
+				if resp.Error != nil {
+					device.Reason = resp.Error.Error()
+					retryAfter = max(retryAfter, retryAfterOf(resp.Error, time.Now()))
+				}
+				retry = append(retry, idx)
+			}
+			devices[idx] = device
+		}
+
+		if len(retry) == 0 {
+			return devices, nil
+		}
+
+		delay, ok := d.retry.Delay(attempt, retryAfter)
+		if ok {
+			ok = dispatch.Wait(ctx, delay)
+		}
+		if !ok {
+			// Out of attempts (or FCM asked us to wait too long): redeliver the rest
+			return devices, fmt.Errorf("batch had %d retryable errors after %d attempts", len(retry), attempt)
+		}
+
+		d.logger.Info("Retrying FCM tokens", "count", len(retry), "attempt", attempt+1, "delay", delay)
+		pending = retry
+	}
+}
+
+// buildMulticast maps our Message onto the FCM multicast payload.
+// A data-only Message becomes an FCM data message: without a Notification
+// nothing is shown and the app handles Data itself.
+func buildMulticast(tokens []string, message dispatch.Message) *messaging.MulticastMessage {
+	content := message.Content
+	msg := &messaging.MulticastMessage{
+		Tokens:  tokens,
+		Data:    message.PayloadData(),
+		Webpush: &messaging.WebpushConfig{},
+	}
+	if !message.DataOnly {
+		msg.Notification = &messaging.Notification{
+			Title:    content.Title,
+			Body:     content.Body,
+			ImageURL: message.Rich.ImageURL,
+		}
+		msg.Webpush.Notification = &messaging.WebpushNotification{
+			Title:  content.Title,
+			Body:   content.Body,
+			Icon:   "/assets/icons/icon-192x192.png",
+			Silent: message.Silent,
+		}
+	}
+
+	if message.Silent && !message.DataOnly {
+		// Android: low priority shows in the tray without sound or heads-up
+		msg.Android = &messaging.AndroidConfig{
+			Notification: &messaging.AndroidNotification{Priority: messaging.PriorityLow},
+		}
+		// iOS via FCM: passive notifications don't light up the screen or play a sound
+		msg.APNS = &messaging.APNSConfig{
+			Payload: &messaging.APNSPayload{
+				Aps: &messaging.Aps{CustomData: map[string]interface{}{"interruption-level": "passive"}},
+			},
+		}
+	}
+
+	if msg.Android == nil {
+		msg.Android = &messaging.AndroidConfig{}
+	}
+	if msg.APNS == nil {
+		msg.APNS = &messaging.APNSConfig{}
+	}
+	msg.APNS.Headers = map[string]string{}
+	msg.Webpush.Headers = map[string]string{}
+
+	if message.DataOnly {
+		// iOS via FCM: content-available wakes the app in the background
+		msg.APNS.Payload = &messaging.APNSPayload{Aps: &messaging.Aps{ContentAvailable: true}}
+		msg.APNS.Headers["apns-push-type"] = "background"
+	}
+
+	// Priority: each transport has its own urgency model
+	switch {
+	case message.Priority.IsHigh():
+		msg.Android.Priority = "high"
+		msg.APNS.Headers["apns-priority"] = "10"
+		msg.Webpush.Headers["Urgency"] = "high"
+	case message.Priority.IsLow():
+		// Android may batch it during Doze; iOS delivers it when power allows
+		msg.Android.Priority = "normal"
+		msg.APNS.Headers["apns-priority"] = "5"
+		msg.Webpush.Headers["Urgency"] = "low"
+	default:
+		// Normal: FCM's own default priority for notification messages
+		msg.Webpush.Headers["Urgency"] = "normal"
+	}
+	if message.DataOnly {
+		// APNs rejects background pushes sent at priority 10
+		msg.APNS.Headers["apns-priority"] = "5"
+	}
 
-			retryableErrors++
+	// Expiry: each transport has its own native field
+	if ttl, ok := message.TTL(time.Now()); ok {
+		msg.Android.TTL = &ttl
+		msg.APNS.Headers["apns-expiration"] = strconv.FormatInt(message.ExpiresAt.Unix(), 10)
+		msg.Webpush.Headers["TTL"] = strconv.Itoa(int(ttl.Seconds()))
+	}
+
+	// Collapse: a newer push with the same key replaces an undelivered one...
+	if key := message.CollapseKey; key != "" {
+		msg.Android.CollapseKey = key
+		msg.APNS.Headers["apns-collapse-id"] = message.APNsCollapseID()
+		msg.Webpush.Headers["Topic"] = message.WebPushTopic()
+	}
+	if message.DataOnly {
+		return msg
+	}
+
+	// ...and a newer notification replaces the shown one
+	if key := message.CollapseKey; key != "" {
+		if msg.Android.Notification == nil {
+			msg.Android.Notification = &messaging.AndroidNotification{}
 		}
+		msg.Android.Notification.Tag = key
+		if msg.APNS.Payload == nil {
+			msg.APNS.Payload = &messaging.APNSPayload{Aps: &messaging.Aps{}}
+		}
+		msg.APNS.Payload.Aps.ThreadID = key
+		msg.Webpush.Notification.Tag = key
+		msg.Webpush.Notification.Renotify = !message.Silent // Alert again for the replacement
 	}
 
-	if retryableErrors > 0 {
-		return "", nil, fmt.Errorf("batch had %d retryable errors", retryableErrors)
+	applyRichContent(msg, message)
+	return msg
+}
+
+// applyRichContent maps the image, link, actions, badge and subtitle onto each
+// transport's native fields. Android has no native action buttons or subtitle;
+// the app finds the links in the data.
+func applyRichContent(msg *messaging.MulticastMessage, message dispatch.Message) {
+	rich := message.Rich
+
+	if rich.Badge != nil {
+		if msg.Android.Notification == nil {
+			msg.Android.Notification = &messaging.AndroidNotification{}
+		}
+		msg.Android.Notification.NotificationCount = rich.Badge
+	}
+
+	if rich.Badge != nil || rich.Subtitle != "" || rich.ImageURL != "" || rich.ActionCategory != "" {
+		if msg.APNS.Payload == nil {
+			msg.APNS.Payload = &messaging.APNSPayload{Aps: &messaging.Aps{}}
+		}
+		aps := msg.APNS.Payload.Aps
+		aps.Badge = rich.Badge
+		aps.Category = rich.ActionCategory
+		if rich.Subtitle != "" {
+			aps.Alert = &messaging.ApsAlert{Title: message.Content.Title, Body: message.Content.Body, SubTitle: rich.Subtitle}
+		}
+		if rich.ImageURL != "" {
+			// The app's notification service extension downloads the image
+			aps.MutableContent = true
+			msg.APNS.FCMOptions = &messaging.APNSFCMOptions{ImageURL: rich.ImageURL}
+		}
 	}
 
-	receipt := fmt.Sprintf("success:%d invalid:%d", br.SuccessCount, len(invalidTokens))
-	return receipt, invalidTokens, nil
+	msg.Webpush.Notification.Image = rich.ImageURL
+	for _, a := range rich.Actions {
+		msg.Webpush.Notification.Actions = append(msg.Webpush.Notification.Actions,
+			&messaging.WebpushNotificationAction{Action: a.ID, Title: a.Title, Icon: a.Icon})
+	}
+	if strings.HasPrefix(rich.Link, "https://") {
+		// FCM rejects the whole message for a non-HTTPS web link
+		msg.Webpush.FCMOptions = &messaging.WebpushFCMOptions{Link: rich.Link}
+	}
 }
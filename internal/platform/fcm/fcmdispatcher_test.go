@@ -6,13 +6,16 @@ import (
 	"errors"
 	"io"
 	"log/slog"
+	"strconv"
 	"testing"
+	"time"
 
 	"firebase.google.com/go/v4/messaging"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/tinywideclouds/go-notification-service/internal/platform/fcm"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
 )
 
@@ -36,8 +39,10 @@ func newTestLogger() *slog.Logger {
 func TestFCMDispatch_Lifecycle(t *testing.T) {
 	logger := newTestLogger()
 	ctx := context.Background()
-	content := notification.NotificationContent{Title: "Test"}
-	data := map[string]string{"id": "1"}
+	msg := dispatch.Message{
+		Content: notification.NotificationContent{Title: "Test"},
+		Data:    map[string]string{"id": "1"},
+	}
 
 	t.Run("Happy Path - All Success", func(t *testing.T) {
 		mockClient := new(MockClient)
@@ -56,12 +61,13 @@ func TestFCMDispatch_Lifecycle(t *testing.T) {
 		mockClient.On("SendEachForMulticast", ctx, mock.Anything).Return(mockResponse, nil)
 
 		// Act
-		receipt, invalid, err := dispatcher.Dispatch(ctx, tokens, content, data)
+		result, err := dispatcher.Dispatch(ctx, tokens, msg)
 
 		// Assert
 		require.NoError(t, err)
-		assert.Empty(t, invalid)
-		assert.Contains(t, receipt, "success:2")
+		assert.Empty(t, result.Invalid())
+		assert.Equal(t, 2, result.Count(dispatch.OutcomeDelivered))
+		assert.Equal(t, "msg-2", result.Devices[1].ProviderMessageID)
 		mockClient.AssertExpectations(t)
 	})
 
@@ -74,11 +80,241 @@ func TestFCMDispatch_Lifecycle(t *testing.T) {
 		mockClient.On("SendEachForMulticast", ctx, mock.Anything).Return(nil, errors.New("network down"))
 
 		// Act
-		_, _, err := dispatcher.Dispatch(ctx, tokens, content, data)
+		result, err := dispatcher.Dispatch(ctx, tokens, msg)
 
 		// Assert
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "transport failed")
+		assert.Equal(t, 1, result.Count(dispatch.OutcomeRetryable))
+	})
+
+	t.Run("Silent Message Has No Alerting Priority", func(t *testing.T) {
+		mockClient := new(MockClient)
+		dispatcher := fcm.NewDispatcher(mockClient, logger)
+		silent := msg
+		silent.Silent = true
+
+		mockClient.On("SendEachForMulticast", ctx, mock.MatchedBy(func(m *messaging.MulticastMessage) bool {
+			return m.Android != nil && m.Android.Notification.Priority == messaging.PriorityLow &&
+				m.APNS.Payload.Aps.CustomData["interruption-level"] == "passive" &&
+				m.Webpush.Notification.Silent
+		})).Return(&messaging.BatchResponse{Responses: []*messaging.SendResponse{{Success: true}}}, nil)
+
+		_, err := dispatcher.Dispatch(ctx, []string{"token-1"}, silent)
+
+		require.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("Expiry Maps To Each Transport TTL", func(t *testing.T) {
+		mockClient := new(MockClient)
+		dispatcher := fcm.NewDispatcher(mockClient, logger)
+		expiring := msg
+		expiring.ExpiresAt = time.Now().Add(10 * time.Minute).Truncate(time.Second)
+
+		mockClient.On("SendEachForMulticast", ctx, mock.MatchedBy(func(m *messaging.MulticastMessage) bool {
+			return m.Android != nil && m.Android.TTL != nil &&
+				*m.Android.TTL > 9*time.Minute && *m.Android.TTL <= 10*time.Minute &&
+				m.Webpush.Headers["TTL"] != "" &&
+				m.APNS.Headers["apns-expiration"] == strconv.FormatInt(expiring.ExpiresAt.Unix(), 10)
+		})).Return(&messaging.BatchResponse{Responses: []*messaging.SendResponse{{Success: true}}}, nil)
+
+		_, err := dispatcher.Dispatch(ctx, []string{"token-1"}, expiring)
+
+		require.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("Priority Maps To Each Transport Urgency", func(t *testing.T) {
+		testCases := []struct {
+			priority dispatch.Priority
+			android  string
+			apns     string
+			urgency  string
+		}{
+			{priority: dispatch.PriorityUrgent, android: "high", apns: "10", urgency: "high"},
+			{priority: dispatch.PriorityHigh, android: "high", apns: "10", urgency: "high"},
+			{priority: "", android: "", apns: "", urgency: "normal"},
+			{priority: dispatch.PriorityLow, android: "normal", apns: "5", urgency: "low"},
+		}
+		for _, tc := range testCases {
+			mockClient := new(MockClient)
+			dispatcher := fcm.NewDispatcher(mockClient, logger)
+			prioritised := msg
+			prioritised.Priority = tc.priority
+
+			mockClient.On("SendEachForMulticast", ctx, mock.MatchedBy(func(m *messaging.MulticastMessage) bool {
+				return m.Android.Priority == tc.android &&
+					m.APNS.Headers["apns-priority"] == tc.apns &&
+					m.Webpush.Headers["Urgency"] == tc.urgency
+			})).Return(&messaging.BatchResponse{Responses: []*messaging.SendResponse{{Success: true}}}, nil)
+
+			_, err := dispatcher.Dispatch(ctx, []string{"token-1"}, prioritised)
+
+			require.NoError(t, err)
+			mockClient.AssertExpectations(t)
+		}
+	})
+
+	t.Run("Collapse Key Replaces On Every Transport", func(t *testing.T) {
+		mockClient := new(MockClient)
+		dispatcher := fcm.NewDispatcher(mockClient, logger)
+		collapsing := msg
+		collapsing.CollapseKey = "chat-42"
+
+		mockClient.On("SendEachForMulticast", ctx, mock.MatchedBy(func(m *messaging.MulticastMessage) bool {
+			return m.Android.CollapseKey == "chat-42" && m.Android.Notification.Tag == "chat-42" &&
+				m.APNS.Headers["apns-collapse-id"] == "chat-42" && m.APNS.Payload.Aps.ThreadID == "chat-42" &&
+				m.Webpush.Headers["Topic"] == "chat-42" && m.Webpush.Notification.Tag == "chat-42" &&
+				m.Webpush.Notification.Renotify
+		})).Return(&messaging.BatchResponse{Responses: []*messaging.SendResponse{{Success: true}}}, nil)
+
+		_, err := dispatcher.Dispatch(ctx, []string{"token-1"}, collapsing)
+
+		require.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("Rich Content Maps To Native Fields", func(t *testing.T) {
+		mockClient := new(MockClient)
+		dispatcher := fcm.NewDispatcher(mockClient, logger)
+		badge := 3
+		rich := msg
+		rich.Rich = dispatch.RichContent{
+			Subtitle:       "From Alice",
+			ImageURL:       "https://cdn.example.com/cat.jpg",
+			Link:           "https://app.example.com/chat/42",
+			Badge:          &badge,
+			Actions:        []dispatch.Action{{ID: "reply", Title: "Reply", Link: "https://app.example.com/chat/42/reply"}},
+			ActionCategory: "CHAT_MESSAGE",
+		}
+
+		mockClient.On("SendEachForMulticast", ctx, mock.MatchedBy(func(m *messaging.MulticastMessage) bool {
+			aps := m.APNS.Payload.Aps
+			return m.Notification.ImageURL == rich.Rich.ImageURL &&
+				m.Data["id"] == "1" && m.Data[dispatch.LinkDataKey] == rich.Rich.Link &&
+				m.Data[dispatch.ActionLinkDataKeyPrefix+"reply"] == "https://app.example.com/chat/42/reply" &&
+				*m.Android.Notification.NotificationCount == 3 &&
+				*aps.Badge == 3 && aps.Category == "CHAT_MESSAGE" && aps.MutableContent &&
+				aps.Alert.SubTitle == "From Alice" && m.APNS.FCMOptions.ImageURL == rich.Rich.ImageURL &&
+				m.Webpush.Notification.Image == rich.Rich.ImageURL &&
+				len(m.Webpush.Notification.Actions) == 1 && m.Webpush.Notification.Actions[0].Action == "reply" &&
+				m.Webpush.FCMOptions.Link == rich.Rich.Link
+		})).Return(&messaging.BatchResponse{Responses: []*messaging.SendResponse{{Success: true}}}, nil)
+
+		_, err := dispatcher.Dispatch(ctx, []string{"token-1"}, rich)
+
+		require.NoError(t, err)
+		mockClient.AssertExpectations(t)
+		assert.Len(t, msg.Data, 1, "the caller's data map is not modified")
+	})
+
+	t.Run("Data Only Omits The Notification", func(t *testing.T) {
+		mockClient := new(MockClient)
+		dispatcher := fcm.NewDispatcher(mockClient, logger)
+		dataOnly := msg
+		dataOnly.DataOnly = true
+		dataOnly.Priority = dispatch.PriorityHigh
+		dataOnly.CollapseKey = "sync"
+
+		mockClient.On("SendEachForMulticast", ctx, mock.MatchedBy(func(m *messaging.MulticastMessage) bool {
+			return m.Notification == nil && m.Webpush.Notification == nil && m.Android.Notification == nil &&
+				m.Data["id"] == "1" &&
+				m.Android.Priority == "high" && m.Android.CollapseKey == "sync" &&
+				m.APNS.Payload.Aps.ContentAvailable && m.APNS.Payload.Aps.Alert == nil &&
+				m.APNS.Headers["apns-push-type"] == "background" && m.APNS.Headers["apns-priority"] == "5"
+		})).Return(&messaging.BatchResponse{Responses: []*messaging.SendResponse{{Success: true}}}, nil)
+
+		_, err := dispatcher.Dispatch(ctx, []string{"token-1"}, dataOnly)
+
+		require.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("Retryable Tokens Are Retried In-Process", func(t *testing.T) {
+		mockClient := new(MockClient)
+		dispatcher := fcm.NewDispatcher(mockClient, logger, fcm.WithRetryPolicy(dispatch.RetryPolicy{
+			MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond,
+		}))
+		withTokens := func(tokens ...string) interface{} {
+			return mock.MatchedBy(func(m *messaging.MulticastMessage) bool { return assert.ObjectsAreEqual(tokens, m.Tokens) })
+		}
+
+		// Attempt 1: token-2 is unavailable; attempt 2: only token-2 is sent again and succeeds
+		mockClient.On("SendEachForMulticast", ctx, withTokens("token-1", "token-2")).Return(&messaging.BatchResponse{
+			Responses: []*messaging.SendResponse{{Success: true, MessageID: "msg-1"}, {Error: errors.New("unavailable")}},
+		}, nil).Once()
+		mockClient.On("SendEachForMulticast", ctx, withTokens("token-2")).Return(&messaging.BatchResponse{
+			Responses: []*messaging.SendResponse{{Success: true, MessageID: "msg-2"}},
+		}, nil).Once()
+
+		result, err := dispatcher.Dispatch(ctx, []string{"token-1", "token-2"}, msg)
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, result.Count(dispatch.OutcomeDelivered))
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("Only The Still-Failing Subset Is Surfaced", func(t *testing.T) {
+		mockClient := new(MockClient)
+		dispatcher := fcm.NewDispatcher(mockClient, logger, fcm.WithRetryPolicy(dispatch.RetryPolicy{
+			MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond,
+		}))
+
+		mockClient.On("SendEachForMulticast", ctx, mock.MatchedBy(func(m *messaging.MulticastMessage) bool { return len(m.Tokens) == 2 })).
+			Return(&messaging.BatchResponse{
+				Responses: []*messaging.SendResponse{{Success: true}, {Error: errors.New("unavailable")}},
+			}, nil).Once()
+		mockClient.On("SendEachForMulticast", ctx, mock.MatchedBy(func(m *messaging.MulticastMessage) bool { return len(m.Tokens) == 1 })).
+			Return(&messaging.BatchResponse{
+				Responses: []*messaging.SendResponse{{Error: errors.New("still unavailable")}},
+			}, nil).Once()
+
+		result, err := dispatcher.Dispatch(ctx, []string{"token-1", "token-2"}, msg)
+
+		require.Error(t, err)
+		assert.Equal(t, 1, result.Count(dispatch.OutcomeDelivered))
+		require.Equal(t, 1, result.Count(dispatch.OutcomeRetryable))
+		assert.Equal(t, "token-2", result.Devices[1].Address)
+		assert.Equal(t, "still unavailable", result.Devices[1].Reason)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("Large Token Lists Are Chunked And Merged In Order", func(t *testing.T) {
+		mockClient := new(MockClient)
+		dispatcher := fcm.NewDispatcher(mockClient, logger, fcm.WithConcurrency(2))
+		tokens := make([]string, 2*fcm.MaxMulticastTokens+1)
+		for i := range tokens {
+			tokens[i] = "token-" + strconv.Itoa(i)
+		}
+		respond := func(m *messaging.MulticastMessage) *messaging.BatchResponse {
+			br := &messaging.BatchResponse{}
+			for _, token := range m.Tokens {
+				br.Responses = append(br.Responses, &messaging.SendResponse{Success: true, MessageID: "id-" + token})
+			}
+			return br
+		}
+		for _, chunk := range [][]string{tokens[:500], tokens[500:1000]} {
+			mockClient.On("SendEachForMulticast", ctx, mock.MatchedBy(func(m *messaging.MulticastMessage) bool {
+				return len(m.Tokens) == len(chunk) && m.Tokens[0] == chunk[0]
+			})).Return(respond(&messaging.MulticastMessage{Tokens: chunk}), nil).Once()
+		}
+		// The last chunk can't reach FCM: only its token is retryable
+		mockClient.On("SendEachForMulticast", ctx, mock.MatchedBy(func(m *messaging.MulticastMessage) bool {
+			return len(m.Tokens) == 1
+		})).Return(nil, errors.New("network down")).Once()
+
+		result, err := dispatcher.Dispatch(ctx, tokens, msg)
+
+		require.Error(t, err)
+		require.Len(t, result.Devices, len(tokens))
+		for i, device := range result.Devices {
+			assert.Equal(t, tokens[i], device.Address)
+		}
+		assert.Equal(t, 2*fcm.MaxMulticastTokens, result.Count(dispatch.OutcomeDelivered))
+		assert.Equal(t, []string{tokens[1000]}, result.Addresses(dispatch.OutcomeRetryable))
+		assert.Equal(t, "id-token-499", result.Devices[499].ProviderMessageID)
+		mockClient.AssertExpectations(t)
 	})
 
 	// Note: We rely on the Integration Test to verify the specific parsing of
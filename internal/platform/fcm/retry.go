@@ -0,0 +1,18 @@
+package fcm
+
+import (
+	"time"
+
+	"firebase.google.com/go/v4/errorutils"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+// retryAfterOf reads the Retry-After header (delay-seconds or an HTTP date)
+// from the HTTP response behind an FCM error. Zero means none was given.
+func retryAfterOf(err error, now time.Time) time.Duration {
+	resp := errorutils.HTTPResponse(err)
+	if resp == nil {
+		return 0
+	}
+	return dispatch.ParseRetryAfter(resp.Header.Get("Retry-After"), now)
+}
@@ -0,0 +1,71 @@
+package web
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
+)
+
+func TestBuildPayload_RichContent(t *testing.T) {
+	badge := 2
+	msg := dispatch.Message{
+		Content: notification.NotificationContent{Title: "Test", Body: "Body"},
+		Data:    map[string]string{"id": "1"},
+		Rich: dispatch.RichContent{
+			Subtitle: "ignored on the web",
+			ImageURL: "https://cdn.example.com/cat.jpg",
+			Link:     "https://app.example.com/chat/42",
+			Badge:    &badge,
+			Actions: []dispatch.Action{
+				{ID: "reply", Title: "Reply", Link: "https://app.example.com/chat/42/reply", Icon: "/reply.png"},
+				{ID: "mute", Title: "Mute"},
+			},
+		},
+	}
+
+	raw, err := buildPayload(msg)
+	require.NoError(t, err)
+
+	var payload struct {
+		Notification map[string]any    `json:"notification"`
+		Data         map[string]string `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(raw, &payload))
+
+	assert.Equal(t, "https://cdn.example.com/cat.jpg", payload.Notification["image"])
+	assert.Equal(t, float64(2), payload.Notification["badgeCount"])
+	assert.Equal(t, []any{
+		map[string]any{"action": "reply", "title": "Reply", "icon": "/reply.png"},
+		map[string]any{"action": "mute", "title": "Mute"},
+	}, payload.Notification["actions"])
+	assert.NotContains(t, payload.Notification, "subtitle")
+
+	assert.Equal(t, map[string]string{
+		"id":        "1",
+		"url":       "https://app.example.com/chat/42",
+		"image":     "https://cdn.example.com/cat.jpg",
+		"url.reply": "https://app.example.com/chat/42/reply",
+	}, payload.Data)
+}
+
+func TestBuildPayload_DataOnly(t *testing.T) {
+	msg := dispatch.Message{
+		Content:  notification.NotificationContent{Title: "Ignored"},
+		Data:     map[string]string{"sync": "inbox"},
+		DataOnly: true,
+	}
+
+	raw, err := buildPayload(msg)
+	require.NoError(t, err)
+
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal(raw, &payload))
+	assert.Equal(t, map[string]any{
+		"dataOnly": true,
+		"data":     map[string]any{"sync": "inbox"},
+	}, payload)
+}
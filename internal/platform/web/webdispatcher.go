@@ -5,100 +5,314 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/SherClockHolmes/webpush-go"
 	"github.com/tinywideclouds/go-notification-service/notificationservice/config"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
 )
 
+// defaultTTL (seconds) applies to messages without an expiry.
+const defaultTTL = 60
+
+const (
+	// defaultConcurrency is how many subscriptions are sent to at once.
+	defaultConcurrency = 8
+	// defaultTimeout bounds a single push service request.
+	defaultTimeout = 10 * time.Second
+	// defaultMaxRetryAfter is the longest Retry-After waited out in-process;
+	// longer ones are left to Pub/Sub redelivery.
+	defaultMaxRetryAfter = 5 * time.Second
+	// maxDrain caps how much of a response body is read before closing it;
+	// larger bodies just cost the connection.
+	maxDrain = 64 << 10
+)
+
 type Dispatcher struct {
-	subscriber string
-	privateKey string
-	publicKey  string
-	logger     *slog.Logger
-	httpClient *http.Client
+	subscriber    string
+	privateKey    string
+	publicKey     string
+	concurrency   int
+	timeout       time.Duration
+	maxRetryAfter time.Duration
+	logger        *slog.Logger
+	httpClient    *http.Client
 }
 
-func NewDispatcher(cfg config.VapidConfig, logger *slog.Logger) *Dispatcher {
-	return &Dispatcher{
-		privateKey: cfg.PrivateKey,
-		publicKey:  cfg.PublicKey,
-		subscriber: cfg.SubscriberEmail,
-		logger:     logger.With("component", "WebPushDispatcher"),
-		httpClient: &http.Client{},
+// Option configures a Dispatcher.
+type Option func(*Dispatcher)
+
+// WithConcurrency bounds how many subscriptions are sent to at once.
+// Values below 1 are ignored.
+func WithConcurrency(n int) Option {
+	return func(d *Dispatcher) {
+		if n > 0 {
+			d.concurrency = n
+		}
+	}
+}
+
+// WithTimeout bounds each push service request. Values below 1ns are ignored.
+func WithTimeout(timeout time.Duration) Option {
+	return func(d *Dispatcher) {
+		if timeout > 0 {
+			d.timeout = timeout
+		}
 	}
 }
 
+// WithMaxRetryAfter sets the longest 429 Retry-After that is waited out before
+// sending once more; longer ones are reported as retryable straight away.
+// Zero disables the in-process retry.
+func WithMaxRetryAfter(limit time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.maxRetryAfter = max(limit, 0)
+	}
+}
+
+func NewDispatcher(cfg config.VapidConfig, logger *slog.Logger, opts ...Option) *Dispatcher {
+	d := &Dispatcher{
+		privateKey:    cfg.PrivateKey,
+		publicKey:     cfg.PublicKey,
+		subscriber:    cfg.SubscriberEmail,
+		concurrency:   defaultConcurrency,
+		timeout:       defaultTimeout,
+		maxRetryAfter: defaultMaxRetryAfter,
+		logger:        logger.With("component", "WebPushDispatcher"),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	// The client timeout is a backstop: each request also carries its own deadline
+	d.httpClient = &http.Client{Timeout: d.timeout}
+	return d
+}
+
 // Dispatch now accepts the strict []notification.WebPushSubscription slice.
-// It returns a list of failed subscriptions that should be removed from the DB.
+// Subscriptions are sent to concurrently by a bounded pool of workers; the result
+// lists them in the order of subs. Device addresses are the subscription Endpoints;
+// Invalid() lists the subscriptions that should be removed from the DB.
+// Any retryable failure (transport error, timeout, 429, 5xx) is returned as an
+// error alongside the result so the message is redelivered.
 func (d *Dispatcher) Dispatch(
 	ctx context.Context,
 	subs []notification.WebPushSubscription,
-	content notification.NotificationContent,
-	data map[string]string,
-) (string, []notification.WebPushSubscription, error) {
+	msg dispatch.Message,
+) (*dispatch.DispatchResult, error) {
+	result := dispatch.NewDispatchResult(dispatch.PlatformWeb)
+	if len(subs) == 0 {
+		return result, nil
+	}
+	start := time.Now()
 
-	var invalidSubs []notification.WebPushSubscription
-	successCount := 0
-	failureCount := 0
+	// 1. Prepare Payload
+	payloadBytes, err := buildPayload(msg)
+	if err != nil {
+		return nil, err
+	}
 
-	// 1. Prepare Payload (Standard JSON structure)
-	payloadBytes, err := json.Marshal(map[string]interface{}{
-		"notification": map[string]string{
-			"title": content.Title,
-			"body":  content.Body,
-			// Add icon/actions here if needed from content
+	// The push service holds the message for at most TTL seconds while the browser is offline
+	ttl := defaultTTL
+	if remaining, ok := msg.TTL(time.Now()); ok {
+		ttl = int(remaining.Seconds())
+	}
+
+	// Urgency lets the browser's push service hold low-value pushes on a low battery
+	urgency := webpush.UrgencyNormal
+	switch {
+	case msg.Priority.IsHigh():
+		urgency = webpush.UrgencyHigh
+	case msg.Priority.IsLow():
+		urgency = webpush.UrgencyLow
+	}
+
+	options := &webpush.Options{
+		Subscriber:      d.subscriber,
+		VAPIDPublicKey:  d.publicKey,
+		VAPIDPrivateKey: d.privateKey,
+		TTL:             ttl,
+		Urgency:         urgency,
+		Topic:           msg.WebPushTopic(), // Replaces an undelivered push with the same topic
+		HTTPClient:      d.httpClient,
+	}
+
+	// 2. Fan out to a bounded pool of workers
+	devices := make([]dispatch.DeviceResult, len(subs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(d.concurrency, len(subs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				devices[i] = d.send(ctx, payloadBytes, subs[i], options)
+			}
+		}()
+	}
+	for i := range subs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, device := range devices {
+		result.Add(device)
+	}
+	result.Latency = time.Since(start)
+
+	if retryable := result.Count(dispatch.OutcomeRetryable); retryable > 0 {
+		return result, fmt.Errorf("web push had %d retryable errors", retryable)
+	}
+	return result, nil
+}
+
+// send pushes to one subscription. A 429 with a short enough Retry-After is
+// waited out and sent once more.
+func (d *Dispatcher) send(ctx context.Context, payload []byte, sub notification.WebPushSubscription, options *webpush.Options) dispatch.DeviceResult {
+	// 3. Build the VAPID Subscription
+	s := &webpush.Subscription{
+		Endpoint: sub.Endpoint,
+		Keys: webpush.Keys{
+			// ✅ Encode []byte -> Base64 String for the library
+			P256dh: base64.RawURLEncoding.EncodeToString(sub.Keys.P256dh),
+			Auth:   base64.RawURLEncoding.EncodeToString(sub.Keys.Auth),
 		},
-		"data": data,
-	})
+	}
+
+	for attempt := 1; ; attempt++ {
+		device, retryAfter := d.push(ctx, payload, s, options)
+		if device.StatusCode != http.StatusTooManyRequests || attempt > 1 ||
+			retryAfter <= 0 || retryAfter > d.maxRetryAfter {
+			return device
+		}
+
+		d.logger.Info("WebPush rate limited; retrying", "endpoint", sub.Endpoint, "retry_after", retryAfter)
+		if !dispatch.Wait(ctx, retryAfter) {
+			return device
+		}
+	}
+}
+
+// push makes one request to the push service and classifies the response. The
+// body is drained and closed so the connection can be reused.
+func (d *Dispatcher) push(ctx context.Context, payload []byte, s *webpush.Subscription, options *webpush.Options) (dispatch.DeviceResult, time.Duration) {
+	reqCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// 4. Send via webpush-go
+	pushStart := time.Now()
+	// webpush-go pads the message in place; clipping makes it copy instead, so
+	// concurrent pushes don't share the payload's spare capacity
+	resp, err := webpush.SendNotificationWithContext(reqCtx, slices.Clip(payload), s, options)
+	device := dispatch.DeviceResult{
+		Address: s.Endpoint,
+		Latency: time.Since(pushStart),
+	}
+
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to marshal payload: %w", err)
-	}
-
-	for _, sub := range subs {
-		// 2. Build the VAPID Subscription
-		s := &webpush.Subscription{
-			Endpoint: sub.Endpoint,
-			Keys: webpush.Keys{
-				// ✅ Encode []byte -> Base64 String for the library
-				P256dh: base64.RawURLEncoding.EncodeToString(sub.Keys.P256dh),
-				Auth:   base64.RawURLEncoding.EncodeToString(sub.Keys.Auth),
-			},
+		// Transport error (DNS, Timeout) - retry later, don't delete
+		d.logger.Error("WebPush transport error", "endpoint", s.Endpoint, "err", err)
+		device.Outcome = dispatch.OutcomeRetryable
+		device.Reason = err.Error()
+		return device, 0
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrain))
+	resp.Body.Close()
+
+	// 5. Handle Response Codes
+	device.StatusCode = resp.StatusCode
+	device.Outcome = classifyStatus(resp.StatusCode)
+	if device.Outcome != dispatch.OutcomeDelivered {
+		device.Reason = http.StatusText(resp.StatusCode)
+	}
+	var retryAfter time.Duration
+	if device.Outcome == dispatch.OutcomeRetryable {
+		retryAfter = dispatch.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if retryAfter > 0 {
+			device.Reason = fmt.Sprintf("%s (retry after %s)", device.Reason, retryAfter)
 		}
+	}
+	if device.Outcome == dispatch.OutcomeRetryable || device.Outcome == dispatch.OutcomeRejected {
+		d.logger.Warn("WebPush rejected", "status", resp.StatusCode, "endpoint", s.Endpoint)
+	}
+	return device, retryAfter
+}
 
-		// 3. Send via webpush-go
-		resp, err := webpush.SendNotification(payloadBytes, s, &webpush.Options{
-			Subscriber:      d.subscriber,
-			VAPIDPublicKey:  d.publicKey,
-			VAPIDPrivateKey: d.privateKey,
-			TTL:             60,
-			HTTPClient:      d.httpClient,
+// buildPayload renders the JSON the service worker receives. "notification" is
+// passed straight to showNotification(); "data" (including data.url and the
+// per-action links) is read by the notificationclick handler. A data-only
+// payload has no "notification" and is flagged "dataOnly" so the push handler
+// syncs without showing anything.
+func buildPayload(msg dispatch.Message) ([]byte, error) {
+	if msg.DataOnly {
+		payloadBytes, err := json.Marshal(map[string]interface{}{
+			"dataOnly": true,
+			"data":     msg.PayloadData(),
 		})
-
 		if err != nil {
-			// Transport error (DNS, Timeout) - Log and skip, don't delete
-			d.logger.Error("WebPush transport error", "endpoint", sub.Endpoint, "err", err)
-			failureCount++
-			continue
+			return nil, fmt.Errorf("failed to marshal payload: %w", err)
 		}
-		defer resp.Body.Close()
-
-		// 4. Handle Response Codes
-		switch resp.StatusCode {
-		case 201:
-			successCount++
-		case 410, 404:
-			// 410 Gone / 404 Not Found -> Token is dead, return for cleanup
-			invalidSubs = append(invalidSubs, sub)
-			failureCount++
-		default:
-			d.logger.Warn("WebPush rejected", "status", resp.StatusCode, "endpoint", sub.Endpoint)
-			failureCount++
+		return payloadBytes, nil
+	}
+
+	notificationPayload := map[string]interface{}{
+		"title": msg.Content.Title,
+		"body":  msg.Content.Body,
+	}
+	if msg.Silent {
+		notificationPayload["silent"] = true
+	}
+	if msg.CollapseKey != "" {
+		// Same tag replaces the shown notification; renotify alerts again for it
+		notificationPayload["tag"] = msg.CollapseKey
+		notificationPayload["renotify"] = !msg.Silent
+	}
+	if msg.Rich.ImageURL != "" {
+		notificationPayload["image"] = msg.Rich.ImageURL
+	}
+	if msg.Rich.Badge != nil {
+		// The Badging API count; the service worker passes it to setAppBadge()
+		notificationPayload["badgeCount"] = *msg.Rich.Badge
+	}
+	if len(msg.Rich.Actions) > 0 {
+		actions := make([]map[string]string, len(msg.Rich.Actions))
+		for i, a := range msg.Rich.Actions {
+			actions[i] = map[string]string{"action": a.ID, "title": a.Title}
+			if a.Icon != "" {
+				actions[i]["icon"] = a.Icon
+			}
 		}
+		notificationPayload["actions"] = actions
+	}
+
+	payloadBytes, err := json.Marshal(map[string]interface{}{
+		"notification": notificationPayload,
+		"data":         msg.PayloadData(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
+	return payloadBytes, nil
+}
 
-	receipt := fmt.Sprintf("success:%d invalid:%d total_fail:%d", successCount, len(invalidSubs), failureCount)
-	return receipt, invalidSubs, nil
+// classifyStatus maps a push service HTTP status onto our outcome model.
+func classifyStatus(status int) dispatch.DeliveryOutcome {
+	switch {
+	case status == http.StatusCreated:
+		return dispatch.OutcomeDelivered
+	case status == http.StatusGone || status == http.StatusNotFound:
+		// 410 Gone / 404 Not Found -> Token is dead, return for cleanup
+		return dispatch.OutcomeInvalid
+	case status == http.StatusTooManyRequests || status >= 500:
+		return dispatch.OutcomeRetryable
+	default:
+		// Other 4xx (400 bad payload, 403 VAPID mismatch, 413 too large): our fault
+		return dispatch.OutcomeRejected
+	}
 }
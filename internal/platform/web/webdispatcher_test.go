@@ -2,40 +2,79 @@ package web_test
 
 import (
 	"context"
+	"crypto/ecdh"
+	"crypto/rand"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/SherClockHolmes/webpush-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tinywideclouds/go-notification-service/internal/platform/web"
 	"github.com/tinywideclouds/go-notification-service/notificationservice/config"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
 )
 
-// Mock VAPID keys (generated for testing)
-const (
-	mockPrivateKey = "4K3a3d... (any string is fine for logic test if library doesn't validate strictly locally)"
-	mockPublicKey  = "BA... (any string)"
-)
+// newSubscriptionKeys returns a browser-shaped key pair: an uncompressed P-256
+// public key and a 16-byte auth secret. webpush-go encrypts against these locally,
+// so they must be real points on the curve.
+func newSubscriptionKeys(t *testing.T) (p256dh, auth []byte) {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	auth = make([]byte, 16)
+	_, err = rand.Read(auth)
+	require.NoError(t, err)
+	return key.PublicKey().Bytes(), auth
+}
 
 func TestDispatch_Lifecycle(t *testing.T) {
+	var mu sync.Mutex // Requests arrive concurrently
+	var ttls, urgencies, topics []string
+	throttled := 0
+
 	// 1. Setup Mock Push Service (Simulates Google/Mozilla Push Server)
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Verify VAPID Headers exist
+		// Verify VAPID + aes128gcm Headers exist
 		assert.NotEmpty(t, r.Header.Get("Authorization"))
-		assert.NotEmpty(t, r.Header.Get("Crypto-Key"))
+		assert.Equal(t, "aes128gcm", r.Header.Get("Content-Encoding"))
+		mu.Lock()
+		defer mu.Unlock()
+		ttls = append(ttls, r.Header.Get("TTL"))
+		urgencies = append(urgencies, r.Header.Get("Urgency"))
+		topics = append(topics, r.Header.Get("Topic"))
 
 		// Routing based on endpoint URL
 		switch r.URL.Path {
+		case "/throttled-once":
+			throttled++
+			if throttled == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case "/throttled-long":
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			w.WriteHeader(http.StatusCreated)
 		case "/success":
 			w.WriteHeader(http.StatusCreated) // 201
 		case "/expired":
 			w.WriteHeader(http.StatusGone) // 410
 		case "/error":
 			w.WriteHeader(http.StatusInternalServerError) // 500
+		case "/forbidden":
+			w.WriteHeader(http.StatusForbidden) // 403 (VAPID mismatch)
 		default:
 			w.WriteHeader(http.StatusNotFound)
 		}
@@ -43,57 +82,97 @@ func TestDispatch_Lifecycle(t *testing.T) {
 	defer mockServer.Close()
 
 	// 2. Setup Dispatcher
-	// We need valid-looking keys or webpush-go might panic on init.
-	// For this test, we might need to bypass key validation or use real dummy keys.
-	// Assuming the library checks keys:
-	// If it fails, use "github.com/SherClockHolmes/webpush-go" GenerateVAPIDKeys()
+	// webpush-go signs the VAPID JWT locally, so the keys must be real.
+	privateKey, publicKey, err := webpush.GenerateVAPIDKeys()
+	require.NoError(t, err)
 
-	// Just use non-empty strings, the mock server doesn't verify signature
 	dispatcher := web.NewDispatcher(config.VapidConfig{
-		PrivateKey:      "test-private",
-		PublicKey:       "test-public",
+		PrivateKey:      privateKey,
+		PublicKey:       publicKey,
 		SubscriberEmail: "mailto:test-runner@tinywideclouds.com",
 	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
 
-	// Override the HTTP client in the dispatcher to ensure it hits our mock?
-	// The library uses the client passed in Options. Our dispatcher creates its own.
-	// *Correction*: We can't easily inject the client into NewDispatcher without changing signature.
-	// *Hack for Test*: Use the functional options pattern or exposed field if possible.
-	// For now, relies on the dispatcher using the URL we pass in the subscription.
-
 	ctx := context.Background()
-	content := notification.NotificationContent{Title: "Test", Body: "Body"}
-	data := map[string]string{"id": "1"}
-
-	// 3. Define Subscriptions pointing to Mock Server
-	validSub := notification.WebPushSubscription{
-		Endpoint: mockServer.URL + "/success",
-		Keys: struct {
-			P256dh []byte `json:"p256dh"`
-			Auth   []byte `json:"auth"`
-		}{P256dh: []byte("validkey"), Auth: []byte("validauth")},
+	msg := dispatch.Message{
+		Content: notification.NotificationContent{Title: "Test", Body: "Body"},
+		Data:    map[string]string{"id": "1"},
 	}
 
-	expiredSub := notification.WebPushSubscription{
-		Endpoint: mockServer.URL + "/expired",
-		Keys: struct {
-			P256dh []byte `json:"p256dh"`
-			Auth   []byte `json:"auth"`
-		}{P256dh: []byte("expiredkey"), Auth: []byte("expiredauth")},
+	// 3. Define Subscriptions pointing to Mock Server
+	newSub := func(path string) notification.WebPushSubscription {
+		p256dh, auth := newSubscriptionKeys(t)
+		sub := notification.WebPushSubscription{Endpoint: mockServer.URL + path}
+		sub.Keys.P256dh = p256dh
+		sub.Keys.Auth = auth
+		return sub
 	}
+	validSub := newSub("/success")
+	expiredSub := newSub("/expired")
+	flakySub := newSub("/error")
+	forbiddenSub := newSub("/forbidden")
 
 	// 4. Run Dispatch
-	subs := []notification.WebPushSubscription{validSub, expiredSub}
-	receipt, invalid, err := dispatcher.Dispatch(ctx, subs, content, data)
+	subs := []notification.WebPushSubscription{validSub, expiredSub, flakySub, forbiddenSub}
+	result, err := dispatcher.Dispatch(ctx, subs, msg)
 
 	// 5. Assertions
-	require.NoError(t, err) // Should not error on 410/500, just report it
+	require.Error(t, err) // The 500 is retryable: the message must be redelivered
+	require.Len(t, result.Devices, 4)
+	for i, sub := range subs {
+		assert.Equal(t, sub.Endpoint, result.Devices[i].Address, "results keep the subscription order")
+	}
 
-	// Check Receipt String
-	assert.Contains(t, receipt, "success:1")
-	assert.Contains(t, receipt, "invalid:1")
+	assert.Equal(t, dispatch.PlatformWeb, result.Platform)
+	assert.Equal(t, 1, result.Count(dispatch.OutcomeDelivered))
+	assert.Equal(t, 1, result.Count(dispatch.OutcomeRetryable))
+	assert.Equal(t, 1, result.Count(dispatch.OutcomeRejected))
+	assert.Equal(t, http.StatusInternalServerError, result.Devices[2].StatusCode)
 
-	// Check Invalid List (Should contain the expired sub)
-	assert.Len(t, invalid, 1)
-	assert.Equal(t, expiredSub.Endpoint, invalid[0].Endpoint)
+	// Check Invalid List (Should contain the expired sub's endpoint)
+	assert.Equal(t, []string{expiredSub.Endpoint}, result.Invalid())
+
+	// Without an expiry the push service gets the default TTL
+	assert.Equal(t, []string{"60", "60", "60", "60"}, ttls)
+
+	assert.Equal(t, []string{"normal", "normal", "normal", "normal"}, urgencies)
+
+	assert.Equal(t, []string{"", "", "", ""}, topics)
+
+	// 6. Expiring, high-priority, collapsing messages carry their remaining lifetime, urgency and topic
+	ttls, urgencies, topics = nil, nil, nil
+	msg.ExpiresAt = time.Now().Add(5 * time.Minute)
+	msg.Priority = dispatch.PriorityHigh
+	msg.CollapseKey = "chat-42"
+	_, err = dispatcher.Dispatch(ctx, []notification.WebPushSubscription{validSub}, msg)
+	require.NoError(t, err)
+	require.Len(t, ttls, 1)
+	ttl, err := strconv.Atoi(ttls[0])
+	require.NoError(t, err)
+	assert.InDelta(t, 300, ttl, 2)
+	assert.Equal(t, []string{"high"}, urgencies)
+	assert.Equal(t, []string{"chat-42"}, topics)
+
+	// 7. A 429 with a short Retry-After is waited out and sent again; a long one is redelivered
+	msg.ExpiresAt, msg.Priority, msg.CollapseKey = time.Time{}, "", ""
+	result, err = dispatcher.Dispatch(ctx, []notification.WebPushSubscription{newSub("/throttled-once")}, msg)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Count(dispatch.OutcomeDelivered))
+	assert.Equal(t, 2, throttled)
+
+	result, err = dispatcher.Dispatch(ctx, []notification.WebPushSubscription{newSub("/throttled-long")}, msg)
+	require.Error(t, err)
+	require.Equal(t, 1, result.Count(dispatch.OutcomeRetryable))
+	assert.Equal(t, http.StatusTooManyRequests, result.Devices[0].StatusCode)
+	assert.Contains(t, result.Devices[0].Reason, "retry after 1h0m0s")
+
+	// 8. A push service that doesn't answer in time is retryable
+	impatient := web.NewDispatcher(config.VapidConfig{
+		PrivateKey:      privateKey,
+		PublicKey:       publicKey,
+		SubscriberEmail: "mailto:test-runner@tinywideclouds.com",
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)), web.WithTimeout(50*time.Millisecond))
+	result, err = impatient.Dispatch(ctx, []notification.WebPushSubscription{newSub("/slow"), validSub}, msg)
+	require.Error(t, err)
+	assert.Equal(t, dispatch.OutcomeRetryable, result.Devices[0].Outcome)
+	assert.Equal(t, dispatch.OutcomeDelivered, result.Devices[1].Outcome)
 }
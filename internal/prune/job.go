@@ -0,0 +1,167 @@
+// --- File: internal/prune/job.go ---
+
+// Package prune removes devices that have not been re-registered for a long time.
+// Clients refresh their registration on app start, so a device whose UpdatedAt is
+// months old is almost certainly uninstalled, even if the provider hasn't said so yet.
+package prune
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+// Mode selects what happens to a stale device.
+type Mode string
+
+const (
+	// ModeDelete removes the device record.
+	ModeDelete Mode = "delete"
+	// ModeQuarantine keeps the record (visible in the device list) but stops delivery.
+	ModeQuarantine Mode = "quarantine"
+)
+
+// ParseMode validates a configured mode. Empty means ModeDelete.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "", ModeDelete:
+		return ModeDelete, nil
+	case ModeQuarantine:
+		return ModeQuarantine, nil
+	}
+	return "", fmt.Errorf("unknown prune mode %q (want %q or %q)", s, ModeDelete, ModeQuarantine)
+}
+
+// CacheInvalidator drops cached device lists (implemented by cache.CachedTokenStore).
+type CacheInvalidator interface {
+	Invalidate(ctx context.Context, user urn.URN) error
+}
+
+// Config controls a pruning run.
+type Config struct {
+	// MaxAge is how long a device may go without re-registering.
+	MaxAge time.Duration
+	Mode   Mode
+	// BatchSize caps the devices handled per run, so one run stays short.
+	BatchSize int
+}
+
+// Report summarises one run.
+type Report struct {
+	Scanned     int
+	Deleted     int
+	Quarantined int
+	Failed      int
+	Users       int
+}
+
+// Job prunes stale devices.
+type Job struct {
+	cfg         Config
+	janitor     dispatch.DeviceJanitor
+	invalidator CacheInvalidator // Optional
+	logger      *slog.Logger
+	now         func() time.Time
+}
+
+// NewJob creates a pruning job. invalidator may be nil when there is no cache.
+func NewJob(cfg Config, janitor dispatch.DeviceJanitor, invalidator CacheInvalidator, logger *slog.Logger) *Job {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = ModeDelete
+	}
+	return &Job{
+		cfg:         cfg,
+		janitor:     janitor,
+		invalidator: invalidator,
+		logger:      logger.With("component", "DevicePruner"),
+		now:         time.Now,
+	}
+}
+
+// RunOnce prunes up to one batch of stale devices. Per-device failures are
+// counted and logged; only a failure to scan is returned as an error.
+func (j *Job) RunOnce(ctx context.Context) (Report, error) {
+	var report Report
+	cutoff := j.now().Add(-j.cfg.MaxAge)
+
+	stale, err := j.janitor.StaleDevices(ctx, cutoff, j.cfg.BatchSize)
+	if err != nil {
+		return report, fmt.Errorf("failed to list stale devices: %w", err)
+	}
+	report.Scanned = len(stale)
+
+	affected := make(map[string]urn.URN)
+	for _, sd := range stale {
+		err := j.prune(ctx, sd)
+		switch {
+		case err == nil:
+			affected[sd.RecipientID.String()] = sd.RecipientID
+		case errors.Is(err, dispatch.ErrDeviceNotFound):
+			// Raced with an unregister: nothing to do
+			continue
+		default:
+			report.Failed++
+			j.logger.Warn("Failed to prune device", "user", sd.RecipientID, "device_id", sd.Device.ID, "err", err)
+			continue
+		}
+		if j.cfg.Mode == ModeQuarantine {
+			report.Quarantined++
+		} else {
+			report.Deleted++
+		}
+	}
+
+	// The store was written directly, so drop each affected user's cached fan-out
+	if j.invalidator != nil {
+		for _, user := range affected {
+			if err := j.invalidator.Invalidate(ctx, user); err != nil {
+				j.logger.Warn("Failed to invalidate device cache", "user", user, "err", err)
+			}
+		}
+	}
+	report.Users = len(affected)
+
+	j.logger.Info("Stale device pruning complete",
+		"mode", j.cfg.Mode,
+		"cutoff", cutoff,
+		"scanned", report.Scanned,
+		"deleted", report.Deleted,
+		"quarantined", report.Quarantined,
+		"failed", report.Failed,
+		"users", report.Users,
+	)
+	return report, nil
+}
+
+// Run prunes once straight away, then once per interval until the context is
+// cancelled. The first run matters: instances restarted more often than the
+// interval (deploys, scale-to-zero) would otherwise never prune.
+func (j *Job) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := j.RunOnce(ctx); err != nil {
+			j.logger.Error("Stale device pruning failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *Job) prune(ctx context.Context, sd dispatch.StaleDevice) error {
+	if j.cfg.Mode == ModeQuarantine {
+		return j.janitor.QuarantineDevice(ctx, sd.RecipientID, sd.Device.ID)
+	}
+	return j.janitor.DeleteDevice(ctx, sd.RecipientID, sd.Device.ID)
+}
@@ -0,0 +1,148 @@
+package prune_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/internal/prune"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+type mockJanitor struct {
+	mock.Mock
+}
+
+func (m *mockJanitor) StaleDevices(ctx context.Context, cutoff time.Time, limit int) ([]dispatch.StaleDevice, error) {
+	args := m.Called(ctx, cutoff, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]dispatch.StaleDevice), args.Error(1)
+}
+func (m *mockJanitor) DeleteDevice(ctx context.Context, user urn.URN, deviceID string) error {
+	return m.Called(ctx, user, deviceID).Error(0)
+}
+func (m *mockJanitor) QuarantineDevice(ctx context.Context, user urn.URN, deviceID string) error {
+	return m.Called(ctx, user, deviceID).Error(0)
+}
+
+type mockInvalidator struct {
+	mock.Mock
+}
+
+func (m *mockInvalidator) Invalidate(ctx context.Context, user urn.URN) error {
+	return m.Called(ctx, user).Error(0)
+}
+
+func newTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestJob_RunOnce(t *testing.T) {
+	ctx := context.Background()
+	alice, _ := urn.Parse("urn:sm:user:alice")
+	bob, _ := urn.Parse("urn:sm:user:bob")
+
+	stale := []dispatch.StaleDevice{
+		{RecipientID: alice, Device: dispatch.Device{ID: "a1"}},
+		{RecipientID: alice, Device: dispatch.Device{ID: "a2"}},
+		{RecipientID: bob, Device: dispatch.Device{ID: "b1"}},
+	}
+
+	t.Run("Delete mode removes devices and invalidates each user once", func(t *testing.T) {
+		janitor := new(mockJanitor)
+		invalidator := new(mockInvalidator)
+
+		// The cutoff must be "now - MaxAge"
+		janitor.On("StaleDevices", ctx, mock.MatchedBy(func(cutoff time.Time) bool {
+			age := time.Since(cutoff)
+			return age > 29*24*time.Hour && age < 31*24*time.Hour
+		}), 100).Return(stale, nil)
+		janitor.On("DeleteDevice", ctx, alice, "a1").Return(nil)
+		janitor.On("DeleteDevice", ctx, alice, "a2").Return(nil)
+		janitor.On("DeleteDevice", ctx, bob, "b1").Return(errors.New("firestore down"))
+		invalidator.On("Invalidate", ctx, alice).Return(nil).Once()
+
+		job := prune.NewJob(prune.Config{MaxAge: 30 * 24 * time.Hour, Mode: prune.ModeDelete, BatchSize: 100},
+			janitor, invalidator, newTestLogger())
+
+		report, err := job.RunOnce(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, prune.Report{Scanned: 3, Deleted: 2, Failed: 1, Users: 1}, report)
+		janitor.AssertExpectations(t)
+		invalidator.AssertExpectations(t) // bob's cache is untouched: nothing changed for him
+	})
+
+	t.Run("Quarantine mode keeps records", func(t *testing.T) {
+		janitor := new(mockJanitor)
+
+		janitor.On("StaleDevices", ctx, mock.Anything, 500).Return(stale[2:], nil)
+		janitor.On("QuarantineDevice", ctx, bob, "b1").Return(nil)
+
+		// No cache configured
+		job := prune.NewJob(prune.Config{MaxAge: time.Hour, Mode: prune.ModeQuarantine}, janitor, nil, newTestLogger())
+
+		report, err := job.RunOnce(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Quarantined)
+		assert.Equal(t, 0, report.Deleted)
+		janitor.AssertNotCalled(t, "DeleteDevice", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Scan failure is returned", func(t *testing.T) {
+		janitor := new(mockJanitor)
+		janitor.On("StaleDevices", ctx, mock.Anything, mock.Anything).Return(nil, errors.New("missing index"))
+
+		job := prune.NewJob(prune.Config{MaxAge: time.Hour}, janitor, nil, newTestLogger())
+
+		_, err := job.RunOnce(ctx)
+		require.Error(t, err)
+	})
+}
+
+func TestJob_Run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	janitor := new(mockJanitor)
+
+	// The first run happens at start, not an interval later
+	janitor.On("StaleDevices", mock.Anything, mock.Anything, mock.Anything).
+		Run(func(mock.Arguments) { cancel() }).
+		Return(nil, nil).Once()
+
+	job := prune.NewJob(prune.Config{MaxAge: time.Hour}, janitor, nil, newTestLogger())
+	done := make(chan struct{})
+	go func() {
+		job.Run(ctx, 24*time.Hour)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not prune on start")
+	}
+	janitor.AssertExpectations(t)
+}
+
+func TestParseMode(t *testing.T) {
+	mode, err := prune.ParseMode("")
+	require.NoError(t, err)
+	assert.Equal(t, prune.ModeDelete, mode)
+
+	mode, err = prune.ParseMode("quarantine")
+	require.NoError(t, err)
+	assert.Equal(t, prune.ModeQuarantine, mode)
+
+	_, err = prune.ParseMode("shred")
+	assert.Error(t, err)
+}
@@ -0,0 +1,114 @@
+// --- File: internal/scheduler/scheduler.go ---
+
+// Package scheduler replays notifications whose delivery was postponed (e.g. until
+// a user's quiet hours end). Deferred requests live in a durable ScheduleStore
+// rather than in held Pub/Sub messages, so they survive restarts and ack deadlines.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+// DeliverFunc hands a due notification back to the processor. An error leaves
+// the notification in the store; it is claimed again once its lease runs out.
+type DeliverFunc func(ctx context.Context, n dispatch.ScheduledNotification) error
+
+// Config controls the polling loop.
+type Config struct {
+	// Lease is how long a claimed notification stays hidden from other instances.
+	// It must comfortably exceed one delivery attempt.
+	Lease time.Duration
+	// BatchSize caps the notifications claimed per poll.
+	BatchSize int
+}
+
+// Report summarises one poll.
+type Report struct {
+	Claimed   int
+	Delivered int
+	Failed    int
+}
+
+// Scheduler polls the store and delivers what is due.
+type Scheduler struct {
+	cfg     Config
+	store   dispatch.ScheduleStore
+	deliver DeliverFunc
+	logger  *slog.Logger
+	now     func() time.Time
+}
+
+// New creates a scheduler.
+func New(cfg Config, store dispatch.ScheduleStore, deliver DeliverFunc, logger *slog.Logger) *Scheduler {
+	if cfg.Lease <= 0 {
+		cfg.Lease = 2 * time.Minute
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	return &Scheduler{
+		cfg:     cfg,
+		store:   store,
+		deliver: deliver,
+		logger:  logger.With("component", "Scheduler"),
+		now:     time.Now,
+	}
+}
+
+// RunOnce claims and delivers one batch of due notifications. Delivery failures
+// are counted and logged; only a failure to claim is returned as an error.
+func (s *Scheduler) RunOnce(ctx context.Context) (Report, error) {
+	var report Report
+	due, err := s.store.Claim(ctx, s.now(), s.cfg.Lease, s.cfg.BatchSize)
+	report.Claimed = len(due)
+	if err != nil && len(due) == 0 {
+		return report, fmt.Errorf("failed to claim due notifications: %w", err)
+	}
+	if err != nil {
+		// Partial claim: deliver what we hold, the rest is picked up next poll
+		s.logger.Warn("Claim stopped early", "claimed", len(due), "err", err)
+	}
+
+	for _, n := range due {
+		if err := s.deliver(ctx, n); err != nil {
+			report.Failed++
+			s.logger.Warn("Scheduled delivery failed; will retry after the lease", "notification_id", n.ID, "err", err)
+			continue
+		}
+		if err := s.store.Complete(ctx, n); err != nil {
+			// Delivered but not removed: the ledger/dedup keep the replay harmless
+			s.logger.Warn("Failed to complete scheduled notification", "notification_id", n.ID, "err", err)
+		}
+		report.Delivered++
+	}
+
+	if report.Claimed > 0 {
+		s.logger.Info("Scheduled notifications processed",
+			"claimed", report.Claimed,
+			"delivered", report.Delivered,
+			"failed", report.Failed,
+		)
+	}
+	return report, nil
+}
+
+// Run polls once per interval until the context is cancelled.
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.RunOnce(ctx); err != nil {
+				s.logger.Error("Scheduler poll failed", "err", err)
+			}
+		}
+	}
+}
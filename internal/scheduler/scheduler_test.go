@@ -0,0 +1,64 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/internal/scheduler"
+	"github.com/tinywideclouds/go-notification-service/internal/storage/memory"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+func newTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestScheduler_RunOnce(t *testing.T) {
+	ctx := context.Background()
+	past := time.Now().Add(-time.Minute)
+
+	t.Run("Due notifications are delivered and completed", func(t *testing.T) {
+		store := memory.NewScheduleStore()
+		require.NoError(t, store.Schedule(ctx, dispatch.ScheduledNotification{ID: "due", MessageID: "msg-1", DeliverAt: past}))
+		require.NoError(t, store.Schedule(ctx, dispatch.ScheduledNotification{ID: "future", DeliverAt: time.Now().Add(time.Hour)}))
+
+		var delivered []dispatch.ScheduledNotification
+		s := scheduler.New(scheduler.Config{}, store, func(_ context.Context, n dispatch.ScheduledNotification) error {
+			delivered = append(delivered, n)
+			return nil
+		}, newTestLogger())
+
+		report, err := s.RunOnce(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, scheduler.Report{Claimed: 1, Delivered: 1}, report)
+		require.Len(t, delivered, 1)
+		assert.Equal(t, "msg-1", delivered[0].MessageID)
+
+		// Completed: even after the lease would have expired, nothing is left to claim
+		left, _ := store.Claim(ctx, time.Now().Add(10*time.Minute), time.Minute, 10)
+		assert.Empty(t, left)
+	})
+
+	t.Run("Failed deliveries stay scheduled for a retry", func(t *testing.T) {
+		store := memory.NewScheduleStore()
+		require.NoError(t, store.Schedule(ctx, dispatch.ScheduledNotification{ID: "flaky", DeliverAt: past}))
+
+		s := scheduler.New(scheduler.Config{Lease: time.Minute}, store, func(context.Context, dispatch.ScheduledNotification) error {
+			return errors.New("token store down")
+		}, newTestLogger())
+
+		report, err := s.RunOnce(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Failed)
+
+		retry, _ := store.Claim(ctx, time.Now().Add(2*time.Minute), time.Minute, 10)
+		require.Len(t, retry, 1, "claimable again once the lease runs out")
+		assert.Equal(t, "flaky", retry[0].ID)
+	})
+}
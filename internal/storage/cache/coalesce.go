@@ -0,0 +1,169 @@
+// --- File: internal/storage/cache/coalesce.go ---
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+// ScriptCache is a CacheClient that can also run Lua scripts, which Redis
+// executes atomically.
+type ScriptCache interface {
+	CacheClient
+	// Eval runs script with the given keys and arguments and returns its reply.
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// CoalesceStore is a dispatch.CoalesceStore backed by the shared cache (Redis),
+// so a burst spread over several instances is still flushed once.
+// Hold reads the group's opener, then joins that burst in a Lua script that
+// checks the opener is unchanged; Take is a single script. Concurrent holds all
+// land in the burst, and a hold that comes after the take opens a new burst
+// instead of joining the taken one.
+//
+// A burst is three keys sharing the group's hash tag: the "open" key naming the
+// opener, a set of message IDs and the latest request. Every key a script
+// touches is passed in KEYS, so the store also works on Redis Cluster.
+type CoalesceStore struct {
+	cache     ScriptCache
+	retention time.Duration
+}
+
+// NewCoalesceStore creates a coalesce store on top of the given cache. Bursts are
+// forgotten after retention, which must outlast the coalescing window plus the flush delay.
+func NewCoalesceStore(cache ScriptCache, retention time.Duration) *CoalesceStore {
+	return &CoalesceStore{
+		cache:     cache,
+		retention: retention,
+	}
+}
+
+// holdAttempts bounds how often Hold chases an opener that changed under it
+// (opened by a concurrent hold, or closed by a take).
+const holdAttempts = 5
+
+// openerScript returns the open burst's opener, or "" when none is open.
+//
+// KEYS[1] open key
+const openerScript = `
+return redis.call('GET', KEYS[1]) or ''
+`
+
+// holdScript joins the burst of the expected opener, opening it when no burst
+// is open and the caller's message is that opener. A redelivered message is not
+// counted twice and does not replace a newer latest request. It returns
+// {1, opener} when held, or {0, current opener} when it changed ("" when none is open).
+//
+// KEYS[1] open key, KEYS[2] ID set, KEYS[3] latest request (of the expected opener's burst)
+// ARGV[1] message ID, ARGV[2] request JSON, ARGV[3] retention (ms), ARGV[4] expected opener
+const holdScript = `
+local opener = redis.call('GET', KEYS[1])
+if not opener then
+	if ARGV[4] ~= ARGV[1] then
+		return {0, ''}
+	end
+	opener = ARGV[1]
+	redis.call('SET', KEYS[1], opener, 'PX', ARGV[3])
+elseif opener ~= ARGV[4] then
+	return {0, opener}
+end
+if redis.call('SADD', KEYS[2], ARGV[1]) == 1 then
+	redis.call('SET', KEYS[3], ARGV[2], 'PX', ARGV[3])
+end
+redis.call('PEXPIRE', KEYS[2], ARGV[3])
+redis.call('PEXPIRE', KEYS[3], ARGV[3])
+return {1, opener}
+`
+
+// takeScript closes the burst if it is still the open one and returns its
+// message IDs and latest request.
+//
+// KEYS[1] open key, KEYS[2] ID set, KEYS[3] latest request
+// ARGV[1] opener
+const takeScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('DEL', KEYS[1])
+end
+return {redis.call('SMEMBERS', KEYS[2]), redis.call('GET', KEYS[3])}
+`
+
+func (s *CoalesceStore) Hold(ctx context.Context, group, messageID string, request dispatch.Request) (string, error) {
+	latest, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request for burst %s: %w", group, err)
+	}
+	reply, err := s.cache.Eval(ctx, openerScript, []string{s.openKey(group)})
+	if err != nil {
+		return "", fmt.Errorf("failed to read burst %s: %w", group, err)
+	}
+	opener, _ := reply.(string)
+
+	for range holdAttempts {
+		if opener == "" {
+			opener = messageID // Nothing open: this message opens the burst
+		}
+		ids, latestKey := s.burstKeys(group, opener)
+		reply, err := s.cache.Eval(ctx, holdScript,
+			[]string{s.openKey(group), ids, latestKey},
+			messageID, string(latest), s.retention.Milliseconds(), opener)
+		if err != nil {
+			return "", fmt.Errorf("failed to hold request in burst %s: %w", group, err)
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			return "", fmt.Errorf("unexpected reply holding request in burst %s: %v", group, reply)
+		}
+		held, _ := parts[0].(int64)
+		current, _ := parts[1].(string)
+		if held == 1 {
+			return current, nil
+		}
+		opener = current
+	}
+	return "", fmt.Errorf("burst %s changed %d times while holding a request", group, holdAttempts)
+}
+
+func (s *CoalesceStore) Take(ctx context.Context, group, opener string) (*dispatch.Burst, error) {
+	ids, latestKey := s.burstKeys(group, opener)
+	reply, err := s.cache.Eval(ctx, takeScript, []string{s.openKey(group), ids, latestKey}, opener)
+	if err != nil {
+		return nil, fmt.Errorf("failed to take burst %s: %w", group, err)
+	}
+	parts, ok := reply.([]interface{})
+	if !ok || len(parts) != 2 {
+		return nil, fmt.Errorf("unexpected reply taking burst %s: %v", group, reply)
+	}
+	members, _ := parts[0].([]interface{})
+	latest, _ := parts[1].(string)
+	if len(members) == 0 || latest == "" {
+		return nil, nil // Unknown or expired
+	}
+
+	burst := &dispatch.Burst{MessageIDs: make([]string, 0, len(members))}
+	for _, member := range members {
+		if id, ok := member.(string); ok {
+			burst.MessageIDs = append(burst.MessageIDs, id)
+		}
+	}
+	slices.Sort(burst.MessageIDs) // Sets are unordered; keep retries stable
+	if err := json.Unmarshal([]byte(latest), &burst.Latest); err != nil {
+		return nil, fmt.Errorf("failed to decode burst %s: %w", group, err)
+	}
+	return burst, nil
+}
+
+// The hash tag keeps a group's keys in one cluster slot, as scripts require.
+func (s *CoalesceStore) openKey(group string) string {
+	return fmt.Sprintf("notify:coalesce:{%s}:open", group)
+}
+
+// burstKeys returns the ID set and latest request keys of one burst.
+func (s *CoalesceStore) burstKeys(group, opener string) (ids, latest string) {
+	prefix := fmt.Sprintf("notify:coalesce:{%s}:burst:%s", group, opener)
+	return prefix + ":ids", prefix + ":latest"
+}
@@ -0,0 +1,82 @@
+// --- File: internal/storage/cache/coalesce_integration_test.go ---
+//go:build integration
+
+package cache_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/illmade-knight/go-test/emulators"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/internal/storage/cache"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+func TestCoalesceStore_Redis(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	t.Cleanup(cancel)
+
+	conn := emulators.SetupRedisContainer(t, ctx, emulators.GetDefaultRedisImageContainer())
+	client, err := cache.NewRedisClient(conn.EmulatorAddress, "", 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+	store := cache.NewCoalesceStore(client, time.Hour)
+
+	t.Run("Concurrent holds all land in one burst", func(t *testing.T) {
+		const holds = 50
+		openers := make([]string, holds)
+		var wg sync.WaitGroup
+		for i := range holds {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				id := "msg-" + strconv.Itoa(i)
+				opener, err := store.Hold(ctx, "group-concurrent", id, dispatch.Request{RequestID: id})
+				assert.NoError(t, err)
+				openers[i] = opener
+			}()
+		}
+		wg.Wait()
+
+		for _, opener := range openers {
+			require.Equal(t, openers[0], opener, "one burst, one opener")
+		}
+		burst, err := store.Take(ctx, "group-concurrent", openers[0])
+		require.NoError(t, err)
+		require.NotNil(t, burst)
+		assert.Equal(t, holds, burst.Count())
+	})
+
+	t.Run("Redelivered holds are counted once", func(t *testing.T) {
+		for range 3 {
+			_, err := store.Hold(ctx, "group-redelivery", "msg-a", dispatch.Request{RequestID: "a"})
+			require.NoError(t, err)
+		}
+		burst, err := store.Take(ctx, "group-redelivery", "msg-a")
+		require.NoError(t, err)
+		require.NotNil(t, burst)
+		assert.Equal(t, 1, burst.Count())
+	})
+
+	t.Run("A hold after the take opens a new burst", func(t *testing.T) {
+		_, err := store.Hold(ctx, "group-late", "msg-a", dispatch.Request{RequestID: "a"})
+		require.NoError(t, err)
+		taken, err := store.Take(ctx, "group-late", "msg-a")
+		require.NoError(t, err)
+		require.NotNil(t, taken)
+
+		opener, err := store.Hold(ctx, "group-late", "msg-b", dispatch.Request{RequestID: "b"})
+		require.NoError(t, err)
+		assert.Equal(t, "msg-b", opener, "the late request is not lost in the taken burst")
+
+		// Retrying the flush returns the same burst, without the late request
+		again, err := store.Take(ctx, "group-late", "msg-a")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"msg-a"}, again.MessageIDs)
+	})
+}
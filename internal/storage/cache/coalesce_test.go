@@ -0,0 +1,88 @@
+// --- File: internal/storage/cache/coalesce_test.go ---
+package cache_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/internal/storage/cache"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+func TestCoalesceStore(t *testing.T) {
+	ctx := context.Background()
+	openKey := "notify:coalesce:{group-1}:open"
+	request := dispatch.Request{RequestID: "req-b"}
+	encoded, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	t.Run("Hold joins the open burst", func(t *testing.T) {
+		mockCache := new(MockCache)
+		store := cache.NewCoalesceStore(mockCache, time.Hour)
+
+		mockCache.On("Eval", ctx, mock.Anything, []string{openKey}, []interface{}(nil)).Return("msg-a", nil)
+		mockCache.On("Eval", ctx, mock.Anything,
+			[]string{openKey, "notify:coalesce:{group-1}:burst:msg-a:ids", "notify:coalesce:{group-1}:burst:msg-a:latest"},
+			[]interface{}{"msg-b", string(encoded), int64(time.Hour / time.Millisecond), "msg-a"}).
+			Return([]interface{}{int64(1), "msg-a"}, nil)
+
+		opener, err := store.Hold(ctx, "group-1", "msg-b", request)
+		require.NoError(t, err)
+		assert.Equal(t, "msg-a", opener)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("Hold follows an opener that changed", func(t *testing.T) {
+		mockCache := new(MockCache)
+		store := cache.NewCoalesceStore(mockCache, time.Hour)
+
+		// Nothing was open, but a concurrent hold opened the burst first
+		mockCache.On("Eval", ctx, mock.Anything, []string{openKey}, []interface{}(nil)).Return("", nil)
+		mockCache.On("Eval", ctx, mock.Anything,
+			[]string{openKey, "notify:coalesce:{group-1}:burst:msg-b:ids", "notify:coalesce:{group-1}:burst:msg-b:latest"},
+			mock.Anything).
+			Return([]interface{}{int64(0), "msg-a"}, nil).Once()
+		mockCache.On("Eval", ctx, mock.Anything,
+			[]string{openKey, "notify:coalesce:{group-1}:burst:msg-a:ids", "notify:coalesce:{group-1}:burst:msg-a:latest"},
+			mock.Anything).
+			Return([]interface{}{int64(1), "msg-a"}, nil).Once()
+
+		opener, err := store.Hold(ctx, "group-1", "msg-b", request)
+		require.NoError(t, err)
+		assert.Equal(t, "msg-a", opener)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("Take returns the burst", func(t *testing.T) {
+		mockCache := new(MockCache)
+		store := cache.NewCoalesceStore(mockCache, time.Hour)
+
+		mockCache.On("Eval", ctx, mock.Anything,
+			[]string{openKey, "notify:coalesce:{group-1}:burst:msg-a:ids", "notify:coalesce:{group-1}:burst:msg-a:latest"},
+			[]interface{}{"msg-a"}).
+			Return([]interface{}{[]interface{}{"msg-b", "msg-a"}, string(encoded)}, nil)
+
+		burst, err := store.Take(ctx, "group-1", "msg-a")
+		require.NoError(t, err)
+		require.NotNil(t, burst)
+		assert.Equal(t, []string{"msg-a", "msg-b"}, burst.MessageIDs)
+		assert.Equal(t, "req-b", burst.Latest.RequestID)
+	})
+
+	t.Run("Take of an unknown burst returns nil", func(t *testing.T) {
+		mockCache := new(MockCache)
+		store := cache.NewCoalesceStore(mockCache, time.Hour)
+
+		mockCache.On("Eval", ctx, mock.Anything, mock.Anything, mock.Anything).
+			Return([]interface{}{[]interface{}{}, nil}, nil)
+
+		burst, err := store.Take(ctx, "group-1", "msg-a")
+		require.NoError(t, err)
+		assert.Nil(t, burst)
+	})
+}
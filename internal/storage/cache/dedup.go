@@ -0,0 +1,44 @@
+// --- File: internal/storage/cache/dedup.go ---
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DedupStore is a dispatch.DedupStore backed by the shared CacheClient (Redis).
+// The claim is a single atomic SETNX, so concurrent duplicates racing on
+// different instances still produce exactly one winner.
+type DedupStore struct {
+	cache CacheClient
+}
+
+// NewDedupStore creates a dedup store on top of the given cache.
+func NewDedupStore(cache CacheClient) *DedupStore {
+	return &DedupStore{cache: cache}
+}
+
+func (s *DedupStore) Claim(ctx context.Context, key, owner string, window time.Duration) (string, error) {
+	cacheKey := s.dedupKey(key)
+
+	claimed, err := s.cache.SetNX(ctx, cacheKey, owner, window)
+	if err != nil {
+		return "", fmt.Errorf("failed to claim idempotency key %s: %w", key, err)
+	}
+	if claimed {
+		return owner, nil
+	}
+
+	// Already held: report by whom, so the caller can tell a redelivery
+	// (same owner) from a true duplicate (different owner).
+	var holder string
+	if err := s.cache.Get(ctx, cacheKey, &holder); err != nil {
+		return "", fmt.Errorf("failed to read idempotency key %s: %w", key, err)
+	}
+	return holder, nil
+}
+
+func (s *DedupStore) dedupKey(key string) string {
+	return fmt.Sprintf("notify:dedup:%s", key)
+}
@@ -0,0 +1,54 @@
+// --- File: internal/storage/cache/dedup_test.go ---
+package cache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/internal/storage/cache"
+)
+
+func TestDedupStore_Claim(t *testing.T) {
+	ctx := context.Background()
+	dedupKey := "notify:dedup:req-1"
+
+	t.Run("Free key is claimed atomically", func(t *testing.T) {
+		mockCache := new(MockCache)
+		store := cache.NewDedupStore(mockCache)
+
+		mockCache.On("SetNX", ctx, dedupKey, "msg-a", time.Minute).Return(true, nil)
+
+		holder, err := store.Claim(ctx, "req-1", "msg-a", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, "msg-a", holder)
+		mockCache.AssertNotCalled(t, "Get", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Held key reports the existing holder", func(t *testing.T) {
+		mockCache := new(MockCache)
+		store := cache.NewDedupStore(mockCache)
+
+		mockCache.On("SetNX", ctx, dedupKey, "msg-b", time.Minute).Return(false, nil)
+		mockCache.On("Get", ctx, dedupKey, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(2).(*string)) = "msg-a"
+		}).Return(nil)
+
+		holder, err := store.Claim(ctx, "req-1", "msg-b", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, "msg-a", holder)
+	})
+
+	t.Run("Cache failure is surfaced", func(t *testing.T) {
+		mockCache := new(MockCache)
+		store := cache.NewDedupStore(mockCache)
+
+		mockCache.On("SetNX", ctx, dedupKey, "msg-a", time.Minute).Return(false, assert.AnError)
+
+		_, err := store.Claim(ctx, "req-1", "msg-a", time.Minute)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,62 @@
+// --- File: internal/storage/cache/ledger.go ---
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+// DeliveryLedger is a dispatch.DeliveryLedger backed by the shared CacheClient (Redis).
+// Each notification gets a single key holding a {deviceKey: outcome} map, which
+// expires after the TTL (it only needs to outlive the Pub/Sub retry window).
+type DeliveryLedger struct {
+	cache CacheClient
+	ttl   time.Duration
+}
+
+// NewDeliveryLedger creates a ledger on top of the given cache.
+func NewDeliveryLedger(cache CacheClient, ttl time.Duration) *DeliveryLedger {
+	return &DeliveryLedger{
+		cache: cache,
+		ttl:   ttl,
+	}
+}
+
+// Outcomes returns the recorded outcomes. A cache miss is not an error: it simply
+// means nothing has been delivered for this notification yet.
+func (l *DeliveryLedger) Outcomes(ctx context.Context, notificationID string) (map[string]dispatch.DeliveryOutcome, error) {
+	outcomes := make(map[string]dispatch.DeliveryOutcome)
+	if err := l.cache.Get(ctx, l.ledgerKey(notificationID), &outcomes); err != nil {
+		if errors.Is(err, redis.Nil) {
+			return make(map[string]dispatch.DeliveryOutcome), nil
+		}
+		return nil, fmt.Errorf("failed to read delivery ledger for %s: %w", notificationID, err)
+	}
+	return outcomes, nil
+}
+
+// Record merges the outcomes into the existing entry (Read-Modify-Write).
+// A message is only ever processed by one worker at a time, so the lack of
+// atomicity here is acceptable.
+func (l *DeliveryLedger) Record(ctx context.Context, notificationID string, outcomes map[string]dispatch.DeliveryOutcome) error {
+	merged, err := l.Outcomes(ctx, notificationID)
+	if err != nil {
+		return err
+	}
+	for k, v := range outcomes {
+		merged[k] = v
+	}
+	if err := l.cache.Set(ctx, l.ledgerKey(notificationID), merged, l.ttl); err != nil {
+		return fmt.Errorf("failed to record delivery outcomes for %s: %w", notificationID, err)
+	}
+	return nil
+}
+
+func (l *DeliveryLedger) ledgerKey(notificationID string) string {
+	return fmt.Sprintf("notify:ledger:%s", notificationID)
+}
@@ -0,0 +1,64 @@
+// --- File: internal/storage/cache/ledger_test.go ---
+package cache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/internal/storage/cache"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+func TestDeliveryLedger(t *testing.T) {
+	ctx := context.Background()
+	ledgerKey := "notify:ledger:msg-1"
+	fcmKey := dispatch.DeviceKey(dispatch.PlatformFCM, "token-1")
+	webKey := dispatch.DeviceKey(dispatch.PlatformWeb, "https://web.push/1")
+
+	t.Run("Cache miss means no history", func(t *testing.T) {
+		mockCache := new(MockCache)
+		ledger := cache.NewDeliveryLedger(mockCache, time.Hour)
+
+		mockCache.On("Get", ctx, ledgerKey, mock.Anything).Return(redis.Nil)
+
+		outcomes, err := ledger.Outcomes(ctx, "msg-1")
+		require.NoError(t, err)
+		assert.Empty(t, outcomes)
+	})
+
+	t.Run("Cache failure is surfaced", func(t *testing.T) {
+		mockCache := new(MockCache)
+		ledger := cache.NewDeliveryLedger(mockCache, time.Hour)
+
+		mockCache.On("Get", ctx, ledgerKey, mock.Anything).Return(assert.AnError)
+
+		_, err := ledger.Outcomes(ctx, "msg-1")
+		require.Error(t, err)
+	})
+
+	t.Run("Record merges with existing entry", func(t *testing.T) {
+		mockCache := new(MockCache)
+		ledger := cache.NewDeliveryLedger(mockCache, time.Hour)
+
+		// Existing entry: FCM already delivered
+		mockCache.On("Get", ctx, ledgerKey, mock.Anything).Run(func(args mock.Arguments) {
+			dest := args.Get(2).(*map[string]dispatch.DeliveryOutcome)
+			(*dest)[fcmKey] = dispatch.OutcomeDelivered
+		}).Return(nil)
+
+		expected := map[string]dispatch.DeliveryOutcome{
+			fcmKey: dispatch.OutcomeDelivered,
+			webKey: dispatch.OutcomeDelivered,
+		}
+		mockCache.On("Set", ctx, ledgerKey, expected, time.Hour).Return(nil)
+
+		err := ledger.Record(ctx, "msg-1", map[string]dispatch.DeliveryOutcome{webKey: dispatch.OutcomeDelivered})
+		require.NoError(t, err)
+		mockCache.AssertExpectations(t)
+	})
+}
@@ -48,10 +48,23 @@ func (c *RedisClient) Set(ctx context.Context, key string, value interface{}, tt
 	return c.rdb.Set(ctx, key, bytes, ttl).Err()
 }
 
+func (c *RedisClient) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	return c.rdb.SetNX(ctx, key, bytes, ttl).Result()
+}
+
 func (c *RedisClient) Del(ctx context.Context, key string) error {
 	return c.rdb.Del(ctx, key).Err()
 }
 
+func (c *RedisClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	// EvalSha first, so the script body is only sent once per connection pool
+	return redis.NewScript(script).Run(ctx, c.rdb, keys, args...).Result()
+}
+
 func (c *RedisClient) Close() error {
 	return c.rdb.Close()
 }
@@ -17,6 +17,9 @@ type CacheClient interface {
 	Get(ctx context.Context, key string, dest interface{}) error
 	// Set stores the value with a TTL.
 	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	// SetNX stores the value with a TTL only if the key does not exist.
+	// It reports whether the value was stored.
+	SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error)
 	// Del removes the key.
 	Del(ctx context.Context, key string) error
 }
@@ -39,12 +42,12 @@ func NewCachedTokenStore(realStore dispatch.TokenStore, cache CacheClient, ttl t
 
 // --- READ PATH (Read-Aside) ---
 
-func (s *CachedTokenStore) Fetch(ctx context.Context, user urn.URN) (*notification.NotificationRequest, error) {
+func (s *CachedTokenStore) Fetch(ctx context.Context, user urn.URN) (*dispatch.RecipientDevices, error) {
 	key := s.cacheKey(user)
-	var cachedReq notification.NotificationRequest
+	var cachedReq dispatch.RecipientDevices
 
 	// 1. Try Cache
-	// We only cache the lightweight Request struct (which contains the buckets), not the whole iterator logic.
+	// We only cache the lightweight buckets struct, not the whole iterator logic.
 	err := s.cache.Get(ctx, key, &cachedReq)
 	if err == nil {
 		// Cache Hit
@@ -67,17 +70,24 @@ func (s *CachedTokenStore) Fetch(ctx context.Context, user urn.URN) (*notificati
 
 // --- WRITE PATHS (Invalidate-on-Write) ---
 
-func (s *CachedTokenStore) RegisterFCM(ctx context.Context, user urn.URN, token string) error {
+func (s *CachedTokenStore) RegisterFCM(ctx context.Context, user urn.URN, token string, meta dispatch.DeviceMetadata) error {
 	// 1. Write to Source of Truth
-	if err := s.realStore.RegisterFCM(ctx, user, token); err != nil {
+	if err := s.realStore.RegisterFCM(ctx, user, token, meta); err != nil {
 		return err
 	}
 	// 2. Invalidate Cache
 	return s.invalidate(ctx, user)
 }
 
-func (s *CachedTokenStore) RegisterWeb(ctx context.Context, user urn.URN, sub notification.WebPushSubscription) error {
-	if err := s.realStore.RegisterWeb(ctx, user, sub); err != nil {
+func (s *CachedTokenStore) RegisterAPNs(ctx context.Context, user urn.URN, token string, meta dispatch.DeviceMetadata) error {
+	if err := s.realStore.RegisterAPNs(ctx, user, token, meta); err != nil {
+		return err
+	}
+	return s.invalidate(ctx, user)
+}
+
+func (s *CachedTokenStore) RegisterWeb(ctx context.Context, user urn.URN, sub notification.WebPushSubscription, meta dispatch.DeviceMetadata) error {
+	if err := s.realStore.RegisterWeb(ctx, user, sub, meta); err != nil {
 		return err
 	}
 	return s.invalidate(ctx, user)
@@ -92,6 +102,13 @@ func (s *CachedTokenStore) UnregisterFCM(ctx context.Context, user urn.URN, toke
 	return s.invalidate(ctx, user)
 }
 
+func (s *CachedTokenStore) UnregisterAPNs(ctx context.Context, user urn.URN, token string) error {
+	if err := s.realStore.UnregisterAPNs(ctx, user, token); err != nil {
+		return err
+	}
+	return s.invalidate(ctx, user)
+}
+
 func (s *CachedTokenStore) UnregisterWeb(ctx context.Context, user urn.URN, endpoint string) error {
 	if err := s.realStore.UnregisterWeb(ctx, user, endpoint); err != nil {
 		return err
@@ -99,6 +116,26 @@ func (s *CachedTokenStore) UnregisterWeb(ctx context.Context, user urn.URN, endp
 	return s.invalidate(ctx, user)
 }
 
+// --- DEVICE MANAGEMENT ---
+
+// ListDevices is not cached: it is a rare, user-initiated read.
+func (s *CachedTokenStore) ListDevices(ctx context.Context, user urn.URN) ([]dispatch.Device, error) {
+	return s.realStore.ListDevices(ctx, user)
+}
+
+func (s *CachedTokenStore) DeleteDevice(ctx context.Context, user urn.URN, deviceID string) error {
+	if err := s.realStore.DeleteDevice(ctx, user, deviceID); err != nil {
+		return err
+	}
+	return s.invalidate(ctx, user)
+}
+
+// Invalidate drops the cached devices of a user. It is used by jobs that write
+// to the real store directly (e.g. pruning).
+func (s *CachedTokenStore) Invalidate(ctx context.Context, user urn.URN) error {
+	return s.invalidate(ctx, user)
+}
+
 // --- Helpers ---
 
 func (s *CachedTokenStore) invalidate(ctx context.Context, user urn.URN) error {
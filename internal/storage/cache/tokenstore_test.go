@@ -10,6 +10,7 @@ import (
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/tinywideclouds/go-notification-service/internal/storage/cache"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
 	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
 )
@@ -26,9 +27,17 @@ func (m *MockCache) Get(ctx context.Context, key string, dest interface{}) error
 func (m *MockCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
 	return m.Called(ctx, key, value, ttl).Error(0)
 }
+func (m *MockCache) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	args := m.Called(ctx, key, value, ttl)
+	return args.Bool(0), args.Error(1)
+}
 func (m *MockCache) Del(ctx context.Context, key string) error {
 	return m.Called(ctx, key).Error(0)
 }
+func (m *MockCache) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	called := m.Called(ctx, script, keys, args)
+	return called.Get(0), called.Error(1)
+}
 
 type MockRealStore struct {
 	mock.Mock
@@ -38,17 +47,32 @@ type MockRealStore struct {
 func (m *MockRealStore) UnregisterWeb(ctx context.Context, user urn.URN, endpoint string) error {
 	return m.Called(ctx, user, endpoint).Error(0)
 }
-func (m *MockRealStore) Fetch(ctx context.Context, user urn.URN) (*notification.NotificationRequest, error) {
+func (m *MockRealStore) ListDevices(ctx context.Context, u urn.URN) ([]dispatch.Device, error) {
+	args := m.Called(ctx, u)
+	return args.Get(0).([]dispatch.Device), args.Error(1)
+}
+func (m *MockRealStore) DeleteDevice(ctx context.Context, u urn.URN, deviceID string) error {
+	return m.Called(ctx, u, deviceID).Error(0)
+}
+func (m *MockRealStore) Fetch(ctx context.Context, user urn.URN) (*dispatch.RecipientDevices, error) {
 	args := m.Called(ctx, user)
-	return args.Get(0).(*notification.NotificationRequest), args.Error(1)
+	return args.Get(0).(*dispatch.RecipientDevices), args.Error(1)
 }
 
 // (Stub other methods as needed)
-func (m *MockRealStore) RegisterFCM(context.Context, urn.URN, string) error { return nil }
-func (m *MockRealStore) RegisterWeb(context.Context, urn.URN, notification.WebPushSubscription) error {
+func (m *MockRealStore) RegisterFCM(context.Context, urn.URN, string, dispatch.DeviceMetadata) error {
+	return nil
+}
+func (m *MockRealStore) RegisterWeb(context.Context, urn.URN, notification.WebPushSubscription, dispatch.DeviceMetadata) error {
 	return nil
 }
 func (m *MockRealStore) UnregisterFCM(context.Context, urn.URN, string) error { return nil }
+func (m *MockRealStore) RegisterAPNs(context.Context, urn.URN, string, dispatch.DeviceMetadata) error {
+	return nil
+}
+func (m *MockRealStore) UnregisterAPNs(ctx context.Context, user urn.URN, token string) error {
+	return m.Called(ctx, user, token).Error(0)
+}
 
 func TestCachedStore_ImmediateInvalidation(t *testing.T) {
 	ctx := context.Background()
@@ -84,7 +108,7 @@ func TestCachedStore_ImmediateInvalidation(t *testing.T) {
 
 		// 2. Expect DB Read (Source of Truth)
 		// Return empty request (user disabled notifications)
-		emptyReq := &notification.NotificationRequest{FCMTokens: []string{}}
+		emptyReq := &dispatch.RecipientDevices{FCMTokens: []string{}}
 		mockDB.On("Fetch", ctx, userURN).Return(emptyReq, nil)
 
 		// 3. Expect Cache SET (Refilling with empty state)
@@ -98,4 +122,38 @@ func TestCachedStore_ImmediateInvalidation(t *testing.T) {
 		require.Empty(t, req.FCMTokens)
 		mockDB.AssertExpectations(t)
 	})
+
+	t.Run("UnregisterAPNs invalidates cache immediately", func(t *testing.T) {
+		token := "apns-device-token"
+
+		mockDB.On("UnregisterAPNs", ctx, userURN, token).Return(nil)
+		mockCache.On("Del", ctx, cacheKey).Return(nil)
+
+		err := store.UnregisterAPNs(ctx, userURN, token)
+
+		require.NoError(t, err)
+		mockDB.AssertExpectations(t)
+		mockCache.AssertExpectations(t)
+	})
+	t.Run("DeleteDevice invalidates cache immediately", func(t *testing.T) {
+		mockDB.On("DeleteDevice", ctx, userURN, "dev-1").Return(nil)
+		mockCache.On("Del", ctx, cacheKey).Return(nil)
+
+		err := store.DeleteDevice(ctx, userURN, "dev-1")
+
+		require.NoError(t, err)
+		mockDB.AssertExpectations(t)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("DeleteDevice not found leaves cache alone", func(t *testing.T) {
+		freshCache := new(MockCache)
+		freshStore := cache.NewCachedTokenStore(mockDB, freshCache, time.Hour)
+		mockDB.On("DeleteDevice", ctx, userURN, "missing").Return(dispatch.ErrDeviceNotFound)
+
+		err := freshStore.DeleteDevice(ctx, userURN, "missing")
+
+		require.ErrorIs(t, err, dispatch.ErrDeviceNotFound)
+		freshCache.AssertNotCalled(t, "Del", mock.Anything, mock.Anything)
+	})
 }
@@ -0,0 +1,121 @@
+package firestore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
+)
+
+// DigestStore implements dispatch.DigestStore using Google Cloud Firestore.
+// One document per period: users/{userID}/digests/{deliverAt unix seconds}
+type DigestStore struct {
+	client *firestore.Client
+}
+
+func NewDigestStore(client *firestore.Client) *DigestStore {
+	return &DigestStore{client: client}
+}
+
+// digestRecord is the internal DB representation.
+type digestRecord struct {
+	DeliverAt time.Time           `firestore:"deliver_at"`
+	Entries   []digestEntryRecord `firestore:"entries"`
+}
+
+type digestEntryRecord struct {
+	MessageID    string            `firestore:"message_id"`
+	Category     string            `firestore:"category,omitempty"`
+	Title        string            `firestore:"title"`
+	Body         string            `firestore:"body"`
+	Sound        string            `firestore:"sound,omitempty"`
+	Template     string            `firestore:"template,omitempty"`
+	TemplateVars map[string]string `firestore:"template_vars,omitempty"`
+	ReceivedAt   time.Time         `firestore:"received_at"`
+}
+
+// Add appends the entry in a transaction, so concurrent workers agree on which
+// message opened the digest.
+func (s *DigestStore) Add(ctx context.Context, recipient urn.URN, deliverAt time.Time, entry dispatch.DigestEntry) (bool, error) {
+	ref := s.ref(recipient, deliverAt)
+	first := false
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		record := digestRecord{DeliverAt: deliverAt}
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := snap.DataTo(&record); err != nil {
+				return err
+			}
+		}
+
+		i := slices.IndexFunc(record.Entries, func(e digestEntryRecord) bool { return e.MessageID == entry.MessageID })
+		first = i == 0 || (i < 0 && len(record.Entries) == 0)
+		if i >= 0 {
+			return nil // Redelivery: already counted
+		}
+		record.Entries = append(record.Entries, digestEntryRecord{
+			MessageID:    entry.MessageID,
+			Category:     entry.Category,
+			Title:        entry.Content.Title,
+			Body:         entry.Content.Body,
+			Sound:        entry.Content.Sound,
+			Template:     entry.Template,
+			TemplateVars: entry.TemplateVars,
+			ReceivedAt:   entry.ReceivedAt,
+		})
+		return tx.Set(ref, record)
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to add digest entry: %w", err)
+	}
+	return first, nil
+}
+
+func (s *DigestStore) Get(ctx context.Context, recipient urn.URN, deliverAt time.Time) (*dispatch.Digest, error) {
+	snap, err := s.ref(recipient, deliverAt).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read digest: %w", err)
+	}
+
+	var record digestRecord
+	if err := snap.DataTo(&record); err != nil {
+		return nil, fmt.Errorf("failed to decode digest: %w", err)
+	}
+	digest := &dispatch.Digest{RecipientID: recipient, DeliverAt: record.DeliverAt}
+	for _, e := range record.Entries {
+		digest.Entries = append(digest.Entries, dispatch.DigestEntry{
+			MessageID:    e.MessageID,
+			Category:     e.Category,
+			Content:      notification.NotificationContent{Title: e.Title, Body: e.Body, Sound: e.Sound},
+			Template:     e.Template,
+			TemplateVars: e.TemplateVars,
+			ReceivedAt:   e.ReceivedAt,
+		})
+	}
+	return digest, nil
+}
+
+func (s *DigestStore) Delete(ctx context.Context, recipient urn.URN, deliverAt time.Time) error {
+	_, err := s.ref(recipient, deliverAt).Delete(ctx)
+	return err
+}
+
+func (s *DigestStore) ref(recipient urn.URN, deliverAt time.Time) *firestore.DocumentRef {
+	return s.client.Collection("users").Doc(recipient.String()).
+		Collection("digests").Doc(strconv.FormatInt(deliverAt.Unix(), 10))
+}
@@ -0,0 +1,66 @@
+// --- File: internal/storage/firestore/digest_test.go ---
+//go:build integration
+
+package firestore_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	fs "github.com/tinywideclouds/go-notification-service/internal/storage/firestore"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
+)
+
+func TestDigestStore_Integration(t *testing.T) {
+	ctx, client, _ := setupSuite(t)
+	store := fs.NewDigestStore(client)
+	userURN, _ := urn.Parse("urn:contacts:user:digest-reader")
+	deliverAt := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	like := dispatch.DigestEntry{
+		MessageID:  "pubsub-1",
+		Category:   "likes",
+		Content:    notification.NotificationContent{Title: "Alice liked your post"},
+		ReceivedAt: deliverAt.Add(-time.Hour),
+	}
+	follow := dispatch.DigestEntry{MessageID: "pubsub-2", Category: "follows"}
+
+	t.Run("Entries Accumulate Once Per Message", func(t *testing.T) {
+		first, err := store.Add(ctx, userURN, deliverAt, like)
+		require.NoError(t, err)
+		assert.True(t, first)
+
+		first, err = store.Add(ctx, userURN, deliverAt, follow)
+		require.NoError(t, err)
+		assert.False(t, first)
+
+		// Redelivery of the opener
+		first, err = store.Add(ctx, userURN, deliverAt, like)
+		require.NoError(t, err)
+		assert.True(t, first)
+
+		digest, err := store.Get(ctx, userURN, deliverAt)
+		require.NoError(t, err)
+		require.NotNil(t, digest)
+		require.Len(t, digest.Entries, 2)
+		assert.Equal(t, "Alice liked your post", digest.Entries[0].Content.Title)
+		assert.True(t, like.ReceivedAt.Equal(digest.Entries[0].ReceivedAt))
+		assert.Equal(t, "follows", digest.Entries[1].Category)
+	})
+
+	t.Run("Delete Removes The Period", func(t *testing.T) {
+		require.NoError(t, store.Delete(ctx, userURN, deliverAt))
+		digest, err := store.Get(ctx, userURN, deliverAt)
+		require.NoError(t, err)
+		assert.Nil(t, digest)
+
+		// Deleting again is fine
+		require.NoError(t, store.Delete(ctx, userURN, deliverAt))
+	})
+}
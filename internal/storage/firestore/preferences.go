@@ -0,0 +1,93 @@
+package firestore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+// PreferencesStore implements dispatch.PreferencesStore using Google Cloud Firestore.
+// Preferences live next to the devices: users/{userID}/settings/notifications
+type PreferencesStore struct {
+	client *firestore.Client
+}
+
+func NewPreferencesStore(client *firestore.Client) *PreferencesStore {
+	return &PreferencesStore{client: client}
+}
+
+// preferencesRecord is the internal DB representation.
+type preferencesRecord struct {
+	Muted              bool              `firestore:"muted"`
+	DisabledCategories []string          `firestore:"disabled_categories"`
+	QuietHours         *quietHoursRecord `firestore:"quiet_hours,omitempty"`
+	Digests            map[string]string `firestore:"digests,omitempty"`
+	UpdatedAt          time.Time         `firestore:"updated_at"`
+}
+
+type quietHoursRecord struct {
+	Start    string `firestore:"start"`
+	End      string `firestore:"end"`
+	Timezone string `firestore:"timezone,omitempty"`
+	Mode     string `firestore:"mode"`
+}
+
+func (s *PreferencesStore) Get(ctx context.Context, user urn.URN) (*dispatch.Preferences, error) {
+	snap, err := s.ref(user).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return &dispatch.Preferences{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read preferences: %w", err)
+	}
+
+	var record preferencesRecord
+	if err := snap.DataTo(&record); err != nil {
+		return nil, fmt.Errorf("failed to decode preferences: %w", err)
+	}
+	prefs := &dispatch.Preferences{
+		Muted:              record.Muted,
+		DisabledCategories: record.DisabledCategories,
+		UpdatedAt:          record.UpdatedAt,
+	}
+	if q := record.QuietHours; q != nil {
+		prefs.QuietHours = &dispatch.QuietHours{Start: q.Start, End: q.End, Timezone: q.Timezone, Mode: dispatch.QuietMode(q.Mode)}
+	}
+	if len(record.Digests) > 0 {
+		prefs.Digests = make(map[string]dispatch.DigestPeriod, len(record.Digests))
+		for category, period := range record.Digests {
+			prefs.Digests[category] = dispatch.DigestPeriod(period)
+		}
+	}
+	return prefs, nil
+}
+
+func (s *PreferencesStore) Put(ctx context.Context, user urn.URN, prefs *dispatch.Preferences) error {
+	record := preferencesRecord{
+		Muted:              prefs.Muted,
+		DisabledCategories: prefs.DisabledCategories,
+		UpdatedAt:          prefs.UpdatedAt,
+	}
+	if q := prefs.QuietHours; q != nil {
+		record.QuietHours = &quietHoursRecord{Start: q.Start, End: q.End, Timezone: q.Timezone, Mode: string(q.Mode)}
+	}
+	if len(prefs.Digests) > 0 {
+		record.Digests = make(map[string]string, len(prefs.Digests))
+		for category, period := range prefs.Digests {
+			record.Digests[category] = string(period)
+		}
+	}
+	_, err := s.ref(user).Set(ctx, record)
+	return err
+}
+
+func (s *PreferencesStore) ref(user urn.URN) *firestore.DocumentRef {
+	return s.client.Collection("users").Doc(user.String()).Collection("settings").Doc("notifications")
+}
@@ -0,0 +1,54 @@
+// --- File: internal/storage/firestore/preferences_test.go ---
+//go:build integration
+
+package firestore_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	fs "github.com/tinywideclouds/go-notification-service/internal/storage/firestore"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+func TestPreferencesStore_Integration(t *testing.T) {
+	ctx, client, _ := setupSuite(t)
+	store := fs.NewPreferencesStore(client)
+	userURN, _ := urn.Parse("urn:contacts:user:prefs-user")
+
+	t.Run("Missing Preferences Are The Zero Value", func(t *testing.T) {
+		prefs, err := store.Get(ctx, userURN)
+		require.NoError(t, err)
+		assert.False(t, prefs.Muted)
+		assert.Empty(t, prefs.DisabledCategories)
+	})
+
+	t.Run("Put Then Get Round-Trips", func(t *testing.T) {
+		saved := &dispatch.Preferences{
+			Muted:              true,
+			DisabledCategories: []string{"marketing"},
+			UpdatedAt:          time.Now().UTC().Truncate(time.Millisecond),
+		}
+		require.NoError(t, store.Put(ctx, userURN, saved))
+
+		prefs, err := store.Get(ctx, userURN)
+		require.NoError(t, err)
+		assert.True(t, prefs.Muted)
+		assert.Equal(t, []string{"marketing"}, prefs.DisabledCategories)
+		assert.True(t, saved.UpdatedAt.Equal(prefs.UpdatedAt))
+	})
+
+	t.Run("Quiet Hours Round-Trip", func(t *testing.T) {
+		quiet := &dispatch.QuietHours{Start: "22:00", End: "07:00", Timezone: "Europe/London", Mode: dispatch.QuietDefer}
+		require.NoError(t, store.Put(ctx, userURN, &dispatch.Preferences{QuietHours: quiet}))
+
+		prefs, err := store.Get(ctx, userURN)
+		require.NoError(t, err)
+		assert.Equal(t, quiet, prefs.QuietHours)
+	})
+}
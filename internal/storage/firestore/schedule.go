@@ -0,0 +1,203 @@
+package firestore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+const scheduleCollection = "scheduled_notifications"
+
+// ScheduleStore implements dispatch.ScheduleStore using Google Cloud Firestore.
+//
+// Claiming works like a queue visibility timeout: due_at starts at the delivery
+// time and is pushed forward by the lease on every claim, so one range query on
+// due_at finds exactly the claimable documents (no composite index needed).
+type ScheduleStore struct {
+	client *firestore.Client
+}
+
+func NewScheduleStore(client *firestore.Client) *ScheduleStore {
+	return &ScheduleStore{client: client}
+}
+
+// scheduleRecord is the internal DB representation. The request is stored as
+// JSON so it round-trips exactly as the producer sent it.
+type scheduleRecord struct {
+	ID        string    `firestore:"id"`
+	MessageID string    `firestore:"message_id"`
+	Request   string    `firestore:"request"`
+	DeliverAt time.Time `firestore:"deliver_at"`
+	DueAt     time.Time `firestore:"due_at"`
+	Reason    string    `firestore:"reason,omitempty"`
+	Recipient string    `firestore:"recipient_id"`
+}
+
+func (s *ScheduleStore) Schedule(ctx context.Context, n dispatch.ScheduledNotification) error {
+	request, err := json.Marshal(n.Request)
+	if err != nil {
+		return fmt.Errorf("failed to encode scheduled request: %w", err)
+	}
+	_, err = s.ref(n.ID).Set(ctx, scheduleRecord{
+		ID:        n.ID,
+		MessageID: n.MessageID,
+		Request:   string(request),
+		DeliverAt: n.DeliverAt,
+		DueAt:     n.DeliverAt,
+		Reason:    n.Reason,
+		Recipient: n.Request.RecipientID.String(),
+	})
+	return err
+}
+
+func (s *ScheduleStore) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]dispatch.ScheduledNotification, error) {
+	iter := s.client.Collection(scheduleCollection).
+		Where("due_at", "<=", now).
+		OrderBy("due_at", firestore.Asc).
+		Limit(limit).
+		Documents(ctx)
+	defer iter.Stop()
+
+	var claimed []dispatch.ScheduledNotification
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return claimed, fmt.Errorf("failed to query due notifications: %w", err)
+		}
+
+		record, ok, err := s.claim(ctx, doc.Ref, now, lease)
+		if err != nil {
+			return claimed, err
+		}
+		if !ok {
+			continue // Another instance got there first
+		}
+
+		n, err := record.notification()
+		if err != nil {
+			// Undecodable: drop it rather than retrying it forever
+			_, _ = doc.Ref.Delete(ctx)
+			return claimed, err
+		}
+		claimed = append(claimed, n)
+	}
+	return claimed, nil
+}
+
+// claim pushes due_at forward in a transaction, so only one instance wins a document.
+func (s *ScheduleStore) claim(ctx context.Context, ref *firestore.DocumentRef, now time.Time, lease time.Duration) (scheduleRecord, bool, error) {
+	var record scheduleRecord
+	won := false
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		won = false
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil // Completed in the meantime
+		}
+		if err != nil {
+			return err
+		}
+		if err := snap.DataTo(&record); err != nil {
+			return err
+		}
+		if record.DueAt.After(now) {
+			return nil // Claimed in the meantime
+		}
+		won = true
+		return tx.Update(ref, []firestore.Update{{Path: "due_at", Value: now.Add(lease)}})
+	})
+	if err != nil {
+		return record, false, fmt.Errorf("failed to claim scheduled notification: %w", err)
+	}
+	return record, won, nil
+}
+
+func (s *ScheduleStore) Complete(ctx context.Context, n dispatch.ScheduledNotification) error {
+	ref := s.ref(n.ID)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil // Cancelled in the meantime
+		}
+		if err != nil {
+			return err
+		}
+		var record scheduleRecord
+		if err := snap.DataTo(&record); err != nil {
+			return err
+		}
+		if !record.DeliverAt.Equal(n.DeliverAt) {
+			return nil // Rescheduled while we delivered it: keep the new one
+		}
+		return tx.Delete(ref)
+	})
+}
+
+func (s *ScheduleStore) Cancel(ctx context.Context, recipient urn.URN, id string) error {
+	ref := s.ref(id)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return dispatch.ErrScheduleNotFound
+		}
+		if err != nil {
+			return err
+		}
+		var record scheduleRecord
+		if err := snap.DataTo(&record); err != nil {
+			return err
+		}
+		// Another recipient's notification is "not found" for this caller
+		if record.Recipient != recipient.String() {
+			return dispatch.ErrScheduleNotFound
+		}
+		return tx.Delete(ref)
+	})
+}
+
+func (s *ScheduleStore) CancelByID(ctx context.Context, id string) error {
+	ref := s.ref(id)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(ref); status.Code(err) == codes.NotFound {
+			return dispatch.ErrScheduleNotFound
+		} else if err != nil {
+			return err
+		}
+		return tx.Delete(ref)
+	})
+}
+
+func (r scheduleRecord) notification() (dispatch.ScheduledNotification, error) {
+	var request dispatch.Request
+	if err := json.Unmarshal([]byte(r.Request), &request); err != nil {
+		return dispatch.ScheduledNotification{}, fmt.Errorf("failed to decode scheduled request %s: %w", r.ID, err)
+	}
+	return dispatch.ScheduledNotification{
+		ID:        r.ID,
+		MessageID: r.MessageID,
+		Request:   request,
+		DeliverAt: r.DeliverAt,
+		Reason:    r.Reason,
+	}, nil
+}
+
+// ref hashes the notification ID: producer-supplied IDs may contain '/'.
+func (s *ScheduleStore) ref(id string) *firestore.DocumentRef {
+	sum := sha256.Sum256([]byte(id))
+	return s.client.Collection(scheduleCollection).Doc(hex.EncodeToString(sum[:]))
+}
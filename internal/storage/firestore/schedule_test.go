@@ -0,0 +1,99 @@
+// --- File: internal/storage/firestore/schedule_test.go ---
+//go:build integration
+
+package firestore_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	fs "github.com/tinywideclouds/go-notification-service/internal/storage/firestore"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
+)
+
+func TestScheduleStore_Integration(t *testing.T) {
+	ctx, client, _ := setupSuite(t)
+	store := fs.NewScheduleStore(client)
+	userURN, _ := urn.Parse("urn:contacts:user:night-owl")
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	request := dispatch.Request{
+		NotificationRequest: notification.NotificationRequest{
+			RecipientID: userURN,
+			Content:     notification.NotificationContent{Title: "Good morning"},
+			DataPayload: map[string]string{"chat_id": "42"},
+		},
+		RequestID: "req/with/slashes",
+		Category:  "chat",
+	}
+
+	require.NoError(t, store.Schedule(ctx, dispatch.ScheduledNotification{
+		ID:        request.RequestID,
+		MessageID: "pubsub-1",
+		Request:   request,
+		DeliverAt: now,
+		Reason:    dispatch.ScheduleReasonQuietHours,
+	}))
+	require.NoError(t, store.Schedule(ctx, dispatch.ScheduledNotification{
+		ID:        "not-yet",
+		Request:   request,
+		DeliverAt: now.Add(time.Hour),
+	}))
+
+	t.Run("Claim Returns Due Notifications Intact", func(t *testing.T) {
+		claimed, err := store.Claim(ctx, now, time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, claimed, 1)
+
+		n := claimed[0]
+		assert.Equal(t, "req/with/slashes", n.ID)
+		assert.Equal(t, "pubsub-1", n.MessageID)
+		assert.Equal(t, userURN, n.Request.RecipientID)
+		assert.Equal(t, "Good morning", n.Request.Content.Title)
+		assert.Equal(t, "42", n.Request.DataPayload["chat_id"])
+		assert.True(t, now.Equal(n.DeliverAt))
+	})
+
+	t.Run("Claimed Notifications Are Leased", func(t *testing.T) {
+		claimed, err := store.Claim(ctx, now.Add(30*time.Second), time.Minute, 10)
+		require.NoError(t, err)
+		assert.Empty(t, claimed)
+	})
+
+	t.Run("Complete Removes The Notification", func(t *testing.T) {
+		require.NoError(t, store.Complete(ctx, dispatch.ScheduledNotification{ID: "req/with/slashes", DeliverAt: now}))
+
+		claimed, err := store.Claim(ctx, now.Add(5*time.Minute), time.Minute, 10)
+		require.NoError(t, err)
+		assert.Empty(t, claimed)
+	})
+
+	t.Run("Cancel Is Scoped To The Recipient", func(t *testing.T) {
+		strangerURN, _ := urn.Parse("urn:contacts:user:stranger")
+
+		require.ErrorIs(t, store.Cancel(ctx, strangerURN, "not-yet"), dispatch.ErrScheduleNotFound)
+		require.NoError(t, store.Cancel(ctx, userURN, "not-yet"))
+		require.ErrorIs(t, store.Cancel(ctx, userURN, "not-yet"), dispatch.ErrScheduleNotFound)
+
+		claimed, err := store.Claim(ctx, now.Add(2*time.Hour), time.Minute, 10)
+		require.NoError(t, err)
+		assert.Empty(t, claimed)
+	})
+
+	t.Run("CancelByID Ignores The Recipient", func(t *testing.T) {
+		require.NoError(t, store.Schedule(ctx, dispatch.ScheduledNotification{
+			ID:        "producer-cancel",
+			Request:   request,
+			DeliverAt: now.Add(time.Hour),
+		}))
+
+		require.NoError(t, store.CancelByID(ctx, "producer-cancel"))
+		require.ErrorIs(t, store.CancelByID(ctx, "producer-cancel"), dispatch.ErrScheduleNotFound)
+	})
+}
@@ -9,7 +9,10 @@ import (
 
 	"cloud.google.com/go/firestore"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
 	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
 )
@@ -27,25 +30,59 @@ func NewFirestoreStore(client *firestore.Client) *FirestoreStore {
 // It can hold EITHER a simple string OR a complex object.
 type deviceRecord struct {
 	Platform        string                            `firestore:"platform"`
-	Token           string                            `firestore:"token,omitempty"`            // Used for Android/iOS
+	Token           string                            `firestore:"token,omitempty"`            // Used for FCM and APNs
 	WebSubscription *notification.WebPushSubscription `firestore:"web_subscription,omitempty"` // Used for Web
 	UpdatedAt       time.Time                         `firestore:"updated_at"`
+	RegisteredAt    time.Time                         `firestore:"registered_at"`
+	// QuarantinedAt is set by the pruning job; a re-registration overwrites the record and clears it.
+	QuarantinedAt *time.Time `firestore:"quarantined_at,omitempty"`
+
+	// Device metadata (all optional)
+	OS         string `firestore:"os,omitempty"`
+	AppVersion string `firestore:"app_version,omitempty"`
+	Locale     string `firestore:"locale,omitempty"`
+	Timezone   string `firestore:"timezone,omitempty"`
+	DeviceName string `firestore:"device_name,omitempty"`
+	// APNsEnvironment is only set for native iOS tokens
+	APNsEnvironment string `firestore:"apns_environment,omitempty"`
+}
+
+func newDeviceRecord(platform string, meta dispatch.DeviceMetadata) deviceRecord {
+	return deviceRecord{
+		Platform:   platform,
+		UpdatedAt:  time.Now(),
+		OS:         meta.OS,
+		AppVersion: meta.AppVersion,
+		Locale:     meta.Locale,
+		Timezone:   meta.Timezone,
+		DeviceName: meta.Name,
+
+		APNsEnvironment: meta.APNsEnvironment,
+	}
+}
+
+func (r deviceRecord) metadata() dispatch.DeviceMetadata {
+	return dispatch.DeviceMetadata{
+		OS:         r.OS,
+		AppVersion: r.AppVersion,
+		Locale:     r.Locale,
+		Timezone:   r.Timezone,
+		Name:       r.DeviceName,
+
+		APNsEnvironment: r.APNsEnvironment,
+	}
 }
 
 // --- DOOR A: FCM (Mobile) ---
 
-func (s *FirestoreStore) RegisterFCM(ctx context.Context, user urn.URN, token string) error {
+func (s *FirestoreStore) RegisterFCM(ctx context.Context, user urn.URN, token string, meta dispatch.DeviceMetadata) error {
 	// Use hash of token as Doc ID to prevent duplicates and hot-spotting
 	docID := hashToken(token)
 
-	record := deviceRecord{
-		Platform:  "fcm", // or "android"/"ios" passed in if you prefer specific tracking
-		Token:     token,
-		UpdatedAt: time.Now(),
-	}
+	record := newDeviceRecord(dispatch.PlatformFCM, meta)
+	record.Token = token
 
-	_, err := s.deviceRef(user, docID).Set(ctx, record)
-	return err
+	return s.upsert(ctx, user, docID, record)
 }
 
 func (s *FirestoreStore) UnregisterFCM(ctx context.Context, user urn.URN, token string) error {
@@ -54,20 +91,35 @@ func (s *FirestoreStore) UnregisterFCM(ctx context.Context, user urn.URN, token
 	return err
 }
 
+// --- DOOR C: APNs (Native iOS) ---
+
+func (s *FirestoreStore) RegisterAPNs(ctx context.Context, user urn.URN, token string, meta dispatch.DeviceMetadata) error {
+	// APNs tokens share the hashed-token Doc ID scheme with FCM; the two token
+	// spaces never overlap, so a collision is not a concern.
+	docID := hashToken(token)
+
+	record := newDeviceRecord(dispatch.PlatformAPNs, meta)
+	record.Token = token
+
+	return s.upsert(ctx, user, docID, record)
+}
+
+func (s *FirestoreStore) UnregisterAPNs(ctx context.Context, user urn.URN, token string) error {
+	docID := hashToken(token)
+	_, err := s.deviceRef(user, docID).Delete(ctx)
+	return err
+}
+
 // --- DOOR B: Web (VAPID) ---
 
-func (s *FirestoreStore) RegisterWeb(ctx context.Context, user urn.URN, sub notification.WebPushSubscription) error {
+func (s *FirestoreStore) RegisterWeb(ctx context.Context, user urn.URN, sub notification.WebPushSubscription, meta dispatch.DeviceMetadata) error {
 	// For Web, the Endpoint URL is the unique identifier
 	docID := hashToken(sub.Endpoint)
 
-	record := deviceRecord{
-		Platform:        "web",
-		WebSubscription: &sub, // Store the full object
-		UpdatedAt:       time.Now(),
-	}
+	record := newDeviceRecord(dispatch.PlatformWeb, meta)
+	record.WebSubscription = &sub // Store the full object
 
-	_, err := s.deviceRef(user, docID).Set(ctx, record)
-	return err
+	return s.upsert(ctx, user, docID, record)
 }
 
 func (s *FirestoreStore) UnregisterWeb(ctx context.Context, user urn.URN, endpoint string) error {
@@ -78,15 +130,16 @@ func (s *FirestoreStore) UnregisterWeb(ctx context.Context, user urn.URN, endpoi
 
 // --- FAN-OUT (The Lookup) ---
 
-func (s *FirestoreStore) Fetch(ctx context.Context, user urn.URN) (*notification.NotificationRequest, error) {
+func (s *FirestoreStore) Fetch(ctx context.Context, user urn.URN) (*dispatch.RecipientDevices, error) {
 	// Query all devices for this user
 	iter := s.devicesCollection(user).Documents(ctx)
 	defer iter.Stop()
 
 	// Initialize the buckets
-	req := &notification.NotificationRequest{
+	req := &dispatch.RecipientDevices{
 		RecipientID:      user,
 		FCMTokens:        make([]string, 0),
+		APNsTokens:       make([]string, 0),
 		WebSubscriptions: make([]notification.WebPushSubscription, 0),
 	}
 
@@ -104,22 +157,169 @@ func (s *FirestoreStore) Fetch(ctx context.Context, user urn.URN) (*notification
 			// Log and continue? Or fail? Usually safe to skip corrupt rows.
 			continue
 		}
+		if record.QuarantinedAt != nil {
+			continue // Pruned as stale: not a delivery target until re-registered
+		}
 
 		// SORTING HAT LOGIC
-		if record.Platform == "web" && record.WebSubscription != nil {
+		if record.Platform == dispatch.PlatformWeb && record.WebSubscription != nil {
 			// Bucket B: Web
 			req.WebSubscriptions = append(req.WebSubscriptions, *record.WebSubscription)
+			req.SetMetadata(dispatch.PlatformWeb, record.WebSubscription.Endpoint, record.metadata())
+		} else if record.Platform == dispatch.PlatformAPNs && record.Token != "" {
+			// Bucket C: Native iOS
+			req.APNsTokens = append(req.APNsTokens, record.Token)
+			req.SetMetadata(dispatch.PlatformAPNs, record.Token, record.metadata())
 		} else if record.Token != "" {
 			// Bucket A: Mobile (Default fallback)
 			req.FCMTokens = append(req.FCMTokens, record.Token)
+			req.SetMetadata(dispatch.PlatformFCM, record.Token, record.metadata())
 		}
 	}
 
 	return req, nil
 }
 
+// --- DEVICE MANAGEMENT ---
+
+func (s *FirestoreStore) ListDevices(ctx context.Context, user urn.URN) ([]dispatch.Device, error) {
+	iter := s.devicesCollection(user).Documents(ctx)
+	defer iter.Stop()
+
+	devices := make([]dispatch.Device, 0)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("firestore iteration failed: %w", err)
+		}
+
+		var record deviceRecord
+		if err := doc.DataTo(&record); err != nil {
+			continue // Same policy as Fetch: skip corrupt rows
+		}
+		devices = append(devices, record.device(doc.Ref.ID))
+	}
+	return devices, nil
+}
+
+func (s *FirestoreStore) DeleteDevice(ctx context.Context, user urn.URN, deviceID string) error {
+	// The Exists precondition turns "not mine / already gone" into NotFound
+	_, err := s.deviceRef(user, deviceID).Delete(ctx, firestore.Exists)
+	if status.Code(err) == codes.NotFound {
+		return dispatch.ErrDeviceNotFound
+	}
+	return err
+}
+
+// --- PRUNING (Across All Users) ---
+
+// StaleDevices runs a collection-group query over every user's devices, oldest
+// first. It needs a collection-group index on devices.updated_at.
+// Quarantined devices are skipped here rather than in the query: records from
+// before quarantining existed have no quarantined_at field, and Firestore's
+// null filter would never match them. The query pages past skipped rows with
+// a cursor until limit devices are found or the stale range runs out.
+func (s *FirestoreStore) StaleDevices(ctx context.Context, cutoff time.Time, limit int) ([]dispatch.StaleDevice, error) {
+	query := s.client.CollectionGroup("devices").
+		Where("updated_at", "<", cutoff).
+		OrderBy("updated_at", firestore.Asc).
+		Limit(limit)
+
+	stale := make([]dispatch.StaleDevice, 0, limit)
+	var last *firestore.DocumentSnapshot
+	for len(stale) < limit {
+		page := query
+		if last != nil {
+			page = query.StartAfter(last)
+		}
+		docs, err := page.Documents(ctx).GetAll()
+		if err != nil {
+			return nil, fmt.Errorf("firestore stale query failed: %w", err)
+		}
+
+		for _, doc := range docs {
+			last = doc
+			var record deviceRecord
+			if err := doc.DataTo(&record); err != nil || record.QuarantinedAt != nil {
+				continue
+			}
+			// Path: users/{urn}/devices/{id}
+			user, err := urn.Parse(doc.Ref.Parent.Parent.ID)
+			if err != nil {
+				continue
+			}
+			stale = append(stale, dispatch.StaleDevice{RecipientID: user, Device: record.device(doc.Ref.ID)})
+			if len(stale) == limit {
+				break
+			}
+		}
+		if len(docs) < limit {
+			break // Nothing stale left
+		}
+	}
+	return stale, nil
+}
+
+func (s *FirestoreStore) QuarantineDevice(ctx context.Context, user urn.URN, deviceID string) error {
+	_, err := s.deviceRef(user, deviceID).Update(ctx, []firestore.Update{
+		{Path: "quarantined_at", Value: time.Now()},
+	})
+	if status.Code(err) == codes.NotFound {
+		return dispatch.ErrDeviceNotFound
+	}
+	return err
+}
+
 // --- Helpers ---
 
+// upsert writes a device record, preserving the original registration time
+// when the device is already known (re-registration refreshes UpdatedAt only).
+func (s *FirestoreStore) upsert(ctx context.Context, user urn.URN, docID string, record deviceRecord) error {
+	ref := s.deviceRef(user, docID)
+	record.RegisteredAt = record.UpdatedAt
+
+	snap, err := ref.Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return fmt.Errorf("failed to read device: %w", err)
+	}
+	if err == nil {
+		var existing deviceRecord
+		if snap.DataTo(&existing) == nil {
+			record.RegisteredAt = existing.registeredAt()
+		}
+	}
+
+	_, err = ref.Set(ctx, record)
+	return err
+}
+
+// registeredAt falls back to UpdatedAt for records written before registered_at existed.
+func (r deviceRecord) registeredAt() time.Time {
+	if r.RegisteredAt.IsZero() {
+		return r.UpdatedAt
+	}
+	return r.RegisteredAt
+}
+
+func (r deviceRecord) device(id string) dispatch.Device {
+	address := r.Token
+	if r.WebSubscription != nil {
+		address = r.WebSubscription.Endpoint
+	}
+	return dispatch.Device{
+		ID:           id,
+		Platform:     r.Platform,
+		Address:      address,
+		Metadata:     r.metadata(),
+		RegisteredAt: r.registeredAt(),
+		UpdatedAt:    r.UpdatedAt,
+		Quarantined:  r.QuarantinedAt != nil,
+	}
+}
+
 // deviceRef: users/{userID}/devices/{deviceHash}
 func (s *FirestoreStore) deviceRef(user urn.URN, docID string) *firestore.DocumentRef {
 	return s.devicesCollection(user).Doc(docID)
@@ -16,6 +16,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	fs "github.com/tinywideclouds/go-notification-service/internal/storage/firestore"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 
 	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
 	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
@@ -42,23 +43,25 @@ func setupSuite(t *testing.T) (context.Context, *firestore.Client, *fs.Firestore
 }
 
 func TestTokenStore_Integration(t *testing.T) {
-	ctx, _, store := setupSuite(t)
+	ctx, client, store := setupSuite(t)
 	userURN, _ := urn.Parse("urn:contacts:user:test-user")
 
 	t.Run("FCM Registration Lifecycle", func(t *testing.T) {
 		// 1. Register FCM
 		token := "token-android-1"
-		err := store.RegisterFCM(ctx, userURN, token)
+		meta := dispatch.DeviceMetadata{OS: "android", AppVersion: "1.0.0", Locale: "en-GB", Timezone: "Europe/London", Name: "Pixel"}
+		err := store.RegisterFCM(ctx, userURN, token, meta)
 		require.NoError(t, err)
 
 		// 2. Fetch and Verify
 		req, err := store.Fetch(ctx, userURN)
 		require.NoError(t, err)
 
-		// Assert it landed in the FCM bucket
+		// Assert it landed in the FCM bucket, metadata intact
 		assert.Len(t, req.FCMTokens, 1)
 		assert.Contains(t, req.FCMTokens, token)
 		assert.Empty(t, req.WebSubscriptions)
+		assert.Equal(t, meta, req.MetadataFor(dispatch.PlatformFCM, token))
 
 		// 3. Unregister
 		err = store.UnregisterFCM(ctx, userURN, token)
@@ -70,6 +73,31 @@ func TestTokenStore_Integration(t *testing.T) {
 		assert.Empty(t, reqAfter.FCMTokens)
 	})
 
+	t.Run("APNs Registration Lifecycle", func(t *testing.T) {
+		// 1. Register APNs
+		token := "token-ios-native-1"
+		err := store.RegisterAPNs(ctx, userURN, token, dispatch.DeviceMetadata{})
+		require.NoError(t, err)
+
+		// 2. Fetch and Verify
+		req, err := store.Fetch(ctx, userURN)
+		require.NoError(t, err)
+
+		// Assert it landed in the APNs bucket, not the FCM one
+		assert.Len(t, req.APNsTokens, 1)
+		assert.Contains(t, req.APNsTokens, token)
+		assert.Empty(t, req.FCMTokens)
+
+		// 3. Unregister
+		err = store.UnregisterAPNs(ctx, userURN, token)
+		require.NoError(t, err)
+
+		// 4. Verify Gone
+		reqAfter, err := store.Fetch(ctx, userURN)
+		require.NoError(t, err)
+		assert.Empty(t, reqAfter.APNsTokens)
+	})
+
 	t.Run("Web Push Registration Lifecycle", func(t *testing.T) {
 		// 1. Register Web
 		sub := notification.WebPushSubscription{
@@ -83,7 +111,7 @@ func TestTokenStore_Integration(t *testing.T) {
 			},
 		}
 
-		err := store.RegisterWeb(ctx, userURN, sub)
+		err := store.RegisterWeb(ctx, userURN, sub, dispatch.DeviceMetadata{OS: "web"})
 		require.NoError(t, err)
 
 		// 2. Fetch and Verify
@@ -119,8 +147,8 @@ func TestTokenStore_Integration(t *testing.T) {
 			},
 		}
 
-		require.NoError(t, store.RegisterFCM(ctx, userURN, fcmToken))
-		require.NoError(t, store.RegisterWeb(ctx, userURN, webSub))
+		require.NoError(t, store.RegisterFCM(ctx, userURN, fcmToken, dispatch.DeviceMetadata{}))
+		require.NoError(t, store.RegisterWeb(ctx, userURN, webSub, dispatch.DeviceMetadata{}))
 
 		// Act: Fetch
 		req, err := store.Fetch(ctx, userURN)
@@ -133,4 +161,107 @@ func TestTokenStore_Integration(t *testing.T) {
 		assert.Len(t, req.WebSubscriptions, 1)
 		assert.Equal(t, webSub.Endpoint, req.WebSubscriptions[0].Endpoint)
 	})
+	t.Run("List And Delete Devices By ID", func(t *testing.T) {
+		owner, _ := urn.Parse("urn:contacts:user:device-owner")
+		stranger, _ := urn.Parse("urn:contacts:user:device-stranger")
+		token := "token-managed-1"
+		meta := dispatch.DeviceMetadata{OS: "ios", Name: "Work iPhone"}
+
+		require.NoError(t, store.RegisterFCM(ctx, owner, token, meta))
+		first, err := store.ListDevices(ctx, owner)
+		require.NoError(t, err)
+		require.Len(t, first, 1)
+		assert.Equal(t, token, first[0].Address)
+		assert.Equal(t, meta, first[0].Metadata)
+		assert.False(t, first[0].RegisteredAt.IsZero())
+
+		// Re-registration refreshes "last seen" but keeps the registration time
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, store.RegisterFCM(ctx, owner, token, meta))
+		second, err := store.ListDevices(ctx, owner)
+		require.NoError(t, err)
+		require.Len(t, second, 1)
+		assert.True(t, second[0].RegisteredAt.Equal(first[0].RegisteredAt))
+		assert.True(t, second[0].UpdatedAt.After(first[0].UpdatedAt))
+
+		// Another user cannot delete it
+		err = store.DeleteDevice(ctx, stranger, first[0].ID)
+		assert.ErrorIs(t, err, dispatch.ErrDeviceNotFound)
+
+		require.NoError(t, store.DeleteDevice(ctx, owner, first[0].ID))
+		req, err := store.Fetch(ctx, owner)
+		require.NoError(t, err)
+		assert.Empty(t, req.FCMTokens)
+
+		err = store.DeleteDevice(ctx, owner, first[0].ID)
+		assert.ErrorIs(t, err, dispatch.ErrDeviceNotFound)
+	})
+	t.Run("Stale Devices Are Found And Quarantined", func(t *testing.T) {
+		owner, _ := urn.Parse("urn:contacts:user:stale-owner")
+		token := "token-stale-1"
+		require.NoError(t, store.RegisterFCM(ctx, owner, token, dispatch.DeviceMetadata{}))
+
+		// Everything registered so far is "stale" relative to a future cutoff
+		stale, err := store.StaleDevices(ctx, time.Now().Add(time.Minute), 1000)
+		require.NoError(t, err)
+		var found *dispatch.StaleDevice
+		for i := range stale {
+			if stale[i].RecipientID == owner {
+				found = &stale[i]
+			}
+		}
+		require.NotNil(t, found, "collection-group scan must find the device")
+		assert.Equal(t, token, found.Device.Address)
+
+		// Quarantine: kept in the list, gone from the fan-out
+		require.NoError(t, store.QuarantineDevice(ctx, owner, found.Device.ID))
+		req, err := store.Fetch(ctx, owner)
+		require.NoError(t, err)
+		assert.Empty(t, req.FCMTokens)
+
+		listed, err := store.ListDevices(ctx, owner)
+		require.NoError(t, err)
+		require.Len(t, listed, 1)
+		assert.True(t, listed[0].Quarantined)
+
+		// Already-quarantined devices are not rescanned
+		stale, err = store.StaleDevices(ctx, time.Now().Add(time.Minute), 1000)
+		require.NoError(t, err)
+		for _, sd := range stale {
+			assert.NotEqual(t, owner, sd.RecipientID)
+		}
+
+		// The batch size is applied by the query
+		limited, err := store.StaleDevices(ctx, time.Now().Add(time.Minute), 1)
+		require.NoError(t, err)
+		assert.Len(t, limited, 1)
+
+		// Records from before quarantining existed have no quarantined_at field.
+		// Behind the oldest (quarantined) device, a batch of one pages past it.
+		legacyOwner, _ := urn.Parse("urn:contacts:user:legacy-owner")
+		legacyDevices := client.Collection("users").Doc(legacyOwner.String()).Collection("devices")
+		_, err = legacyDevices.Doc("quarantined-device").Set(ctx, map[string]interface{}{
+			"platform":       "fcm",
+			"token":          "token-quarantined",
+			"updated_at":     time.Now().Add(-48 * time.Hour),
+			"quarantined_at": time.Now(),
+		})
+		require.NoError(t, err)
+		_, err = legacyDevices.Doc("legacy-device").Set(ctx, map[string]interface{}{
+			"platform":   "fcm",
+			"token":      "token-legacy",
+			"updated_at": time.Now().Add(-24 * time.Hour),
+		})
+		require.NoError(t, err)
+		stale, err = store.StaleDevices(ctx, time.Now().Add(time.Minute), 1)
+		require.NoError(t, err)
+		require.Len(t, stale, 1)
+		assert.Equal(t, "token-legacy", stale[0].Device.Address)
+
+		// Re-registering lifts the quarantine
+		require.NoError(t, store.RegisterFCM(ctx, owner, token, dispatch.DeviceMetadata{}))
+		req, err = store.Fetch(ctx, owner)
+		require.NoError(t, err)
+		assert.Equal(t, []string{token}, req.FCMTokens)
+	})
 }
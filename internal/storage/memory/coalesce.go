@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+type heldBurst struct {
+	burst     dispatch.Burst
+	expiresAt time.Time
+}
+
+// coalesceSweepInterval bounds how often expired bursts are purged.
+const coalesceSweepInterval = time.Minute
+
+// CoalesceStore is an in-memory dispatch.CoalesceStore. Bursts are per instance,
+// so it only coalesces reliably when a single instance consumes the subscription.
+type CoalesceStore struct {
+	mu        sync.Mutex
+	retention time.Duration
+	open      map[string]string     // group -> opener of the open burst
+	bursts    map[string]*heldBurst // group|opener -> burst (open or taken)
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewCoalesceStore creates an empty store. Bursts are forgotten after retention,
+// which must outlast the coalescing window plus the flush delay.
+func NewCoalesceStore(retention time.Duration) *CoalesceStore {
+	return &CoalesceStore{
+		retention: retention,
+		open:      make(map[string]string),
+		bursts:    make(map[string]*heldBurst),
+		now:       time.Now,
+	}
+}
+
+func (s *CoalesceStore) Hold(_ context.Context, group, messageID string, request dispatch.Request) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	opener, ok := s.open[group]
+	if held, found := s.bursts[burstKey(group, opener)]; !found || !now.Before(held.expiresAt) {
+		ok = false // Expired without a flush: start over
+	}
+	if !ok {
+		opener = messageID
+		s.open[group] = opener
+		s.bursts[burstKey(group, opener)] = &heldBurst{expiresAt: now.Add(s.retention)}
+	}
+
+	held := s.bursts[burstKey(group, opener)]
+	if !slices.Contains(held.burst.MessageIDs, messageID) {
+		// A redelivered message is not counted twice, nor does it replace newer content
+		held.burst.MessageIDs = append(held.burst.MessageIDs, messageID)
+		held.burst.Latest = request
+	}
+	return opener, nil
+}
+
+func (s *CoalesceStore) Take(_ context.Context, group, opener string) (*dispatch.Burst, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.open[group] == opener {
+		delete(s.open, group)
+	}
+	held, ok := s.bursts[burstKey(group, opener)]
+	if !ok || !s.now().Before(held.expiresAt) {
+		return nil, nil
+	}
+	burst := held.burst
+	burst.MessageIDs = slices.Clone(burst.MessageIDs)
+	return &burst, nil
+}
+
+// sweep drops expired bursts (and closes them if still open). Caller holds the lock.
+func (s *CoalesceStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < coalesceSweepInterval {
+		return
+	}
+	for group, opener := range s.open {
+		if held, ok := s.bursts[burstKey(group, opener)]; !ok || !now.Before(held.expiresAt) {
+			delete(s.open, group)
+		}
+	}
+	for key, held := range s.bursts {
+		if !now.Before(held.expiresAt) {
+			delete(s.bursts, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+func burstKey(group, opener string) string {
+	return group + "|" + opener
+}
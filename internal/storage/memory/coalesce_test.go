@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
+)
+
+func TestCoalesceStore(t *testing.T) {
+	ctx := context.Background()
+	request := func(body string) dispatch.Request {
+		return dispatch.Request{NotificationRequest: notification.NotificationRequest{
+			Content: notification.NotificationContent{Title: "Alice", Body: body},
+		}}
+	}
+
+	t.Run("First message opens the burst, later ones join it", func(t *testing.T) {
+		store := NewCoalesceStore(time.Hour)
+
+		opener, err := store.Hold(ctx, "group-1", "msg-a", request("one"))
+		require.NoError(t, err)
+		assert.Equal(t, "msg-a", opener)
+
+		opener, err = store.Hold(ctx, "group-1", "msg-b", request("two"))
+		require.NoError(t, err)
+		assert.Equal(t, "msg-a", opener)
+
+		// A redelivery is not counted twice
+		_, err = store.Hold(ctx, "group-1", "msg-b", request("two"))
+		require.NoError(t, err)
+
+		burst, err := store.Take(ctx, "group-1", "msg-a")
+		require.NoError(t, err)
+		require.NotNil(t, burst)
+		assert.Equal(t, 2, burst.Count())
+		assert.Equal(t, "two", burst.Latest.Content.Body)
+	})
+
+	t.Run("A redelivered older message does not replace the latest", func(t *testing.T) {
+		store := NewCoalesceStore(time.Hour)
+		_, err := store.Hold(ctx, "group-1", "msg-a", request("one"))
+		require.NoError(t, err)
+		_, err = store.Hold(ctx, "group-1", "msg-b", request("two"))
+		require.NoError(t, err)
+
+		_, err = store.Hold(ctx, "group-1", "msg-a", request("one"))
+		require.NoError(t, err)
+
+		burst, err := store.Take(ctx, "group-1", "msg-a")
+		require.NoError(t, err)
+		require.NotNil(t, burst)
+		assert.Equal(t, 2, burst.Count())
+		assert.Equal(t, "two", burst.Latest.Content.Body)
+	})
+
+	t.Run("Take closes the burst but can be repeated", func(t *testing.T) {
+		store := NewCoalesceStore(time.Hour)
+		_, err := store.Hold(ctx, "group-1", "msg-a", request("one"))
+		require.NoError(t, err)
+
+		first, err := store.Take(ctx, "group-1", "msg-a")
+		require.NoError(t, err)
+
+		// A request after the flush opens a new burst
+		opener, err := store.Hold(ctx, "group-1", "msg-c", request("three"))
+		require.NoError(t, err)
+		assert.Equal(t, "msg-c", opener)
+
+		// Retrying the first flush sees the same burst, not the new one
+		again, err := store.Take(ctx, "group-1", "msg-a")
+		require.NoError(t, err)
+		assert.Equal(t, first, again)
+	})
+
+	t.Run("Bursts expire after the retention", func(t *testing.T) {
+		store := NewCoalesceStore(time.Minute)
+		clock := time.Now()
+		store.now = func() time.Time { return clock }
+
+		_, err := store.Hold(ctx, "group-1", "msg-a", request("one"))
+		require.NoError(t, err)
+		clock = clock.Add(2 * time.Minute)
+
+		burst, err := store.Take(ctx, "group-1", "msg-a")
+		require.NoError(t, err)
+		assert.Nil(t, burst)
+
+		opener, err := store.Hold(ctx, "group-1", "msg-b", request("two"))
+		require.NoError(t, err)
+		assert.Equal(t, "msg-b", opener)
+	})
+}
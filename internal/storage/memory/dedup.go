@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type dedupClaim struct {
+	owner     string
+	expiresAt time.Time
+}
+
+// dedupSweepInterval bounds how often expired claims are purged.
+const dedupSweepInterval = time.Minute
+
+// DedupStore is an in-memory dispatch.DedupStore.
+type DedupStore struct {
+	mu        sync.Mutex
+	claims    map[string]dedupClaim
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewDedupStore creates an empty dedup store.
+func NewDedupStore() *DedupStore {
+	return &DedupStore{
+		claims: make(map[string]dedupClaim),
+		now:    time.Now,
+	}
+}
+
+func (s *DedupStore) Claim(_ context.Context, key, owner string, window time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if claim, ok := s.claims[key]; ok && now.Before(claim.expiresAt) {
+		return claim.owner, nil
+	}
+
+	s.sweep(now)
+	s.claims[key] = dedupClaim{owner: owner, expiresAt: now.Add(window)}
+	return owner, nil
+}
+
+// sweep drops expired claims so the map stays bounded by the window. Caller holds the lock.
+func (s *DedupStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < dedupSweepInterval {
+		return
+	}
+	for k, claim := range s.claims {
+		if !now.Before(claim.expiresAt) {
+			delete(s.claims, k)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("First claim wins, later claimants see the holder", func(t *testing.T) {
+		store := NewDedupStore()
+
+		holder, err := store.Claim(ctx, "req-1", "msg-a", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, "msg-a", holder)
+
+		holder, err = store.Claim(ctx, "req-1", "msg-b", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, "msg-a", holder)
+
+		// Re-claim by the same owner (redelivery) still reports the owner
+		holder, err = store.Claim(ctx, "req-1", "msg-a", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, "msg-a", holder)
+	})
+
+	t.Run("Claims expire after the window", func(t *testing.T) {
+		store := NewDedupStore()
+		clock := time.Now()
+		store.now = func() time.Time { return clock }
+
+		_, err := store.Claim(ctx, "req-2", "msg-a", time.Minute)
+		require.NoError(t, err)
+
+		clock = clock.Add(2 * time.Minute)
+		holder, err := store.Claim(ctx, "req-2", "msg-b", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, "msg-b", holder)
+	})
+}
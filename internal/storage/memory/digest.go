@@ -0,0 +1,60 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+// DigestStore is an in-memory dispatch.DigestStore.
+type DigestStore struct {
+	mu      sync.Mutex
+	digests map[string]*dispatch.Digest
+}
+
+// NewDigestStore creates an empty store.
+func NewDigestStore() *DigestStore {
+	return &DigestStore{digests: make(map[string]*dispatch.Digest)}
+}
+
+func (s *DigestStore) Add(_ context.Context, recipient urn.URN, deliverAt time.Time, entry dispatch.DigestEntry) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := dispatch.DigestID(recipient, deliverAt)
+	digest, ok := s.digests[key]
+	if !ok {
+		digest = &dispatch.Digest{RecipientID: recipient, DeliverAt: deliverAt}
+		s.digests[key] = digest
+	}
+	i := slices.IndexFunc(digest.Entries, func(e dispatch.DigestEntry) bool { return e.MessageID == entry.MessageID })
+	if i < 0 {
+		digest.Entries = append(digest.Entries, entry)
+		i = len(digest.Entries) - 1
+	}
+	return i == 0, nil
+}
+
+func (s *DigestStore) Get(_ context.Context, recipient urn.URN, deliverAt time.Time) (*dispatch.Digest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	digest, ok := s.digests[dispatch.DigestID(recipient, deliverAt)]
+	if !ok {
+		return nil, nil
+	}
+	clone := *digest
+	clone.Entries = slices.Clone(digest.Entries)
+	return &clone, nil
+}
+
+func (s *DigestStore) Delete(_ context.Context, recipient urn.URN, deliverAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.digests, dispatch.DigestID(recipient, deliverAt))
+	return nil
+}
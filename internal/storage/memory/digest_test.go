@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+func TestDigestStore(t *testing.T) {
+	ctx := context.Background()
+	user, _ := urn.Parse("urn:contacts:user:digest-user")
+	deliverAt := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+	entry := func(id string) dispatch.DigestEntry {
+		return dispatch.DigestEntry{MessageID: id, Category: "likes"}
+	}
+
+	t.Run("First entry opens the digest, redeliveries are not counted twice", func(t *testing.T) {
+		store := NewDigestStore()
+
+		first, err := store.Add(ctx, user, deliverAt, entry("msg-a"))
+		require.NoError(t, err)
+		assert.True(t, first)
+
+		first, err = store.Add(ctx, user, deliverAt, entry("msg-b"))
+		require.NoError(t, err)
+		assert.False(t, first)
+
+		// The opener's redelivery is still the first entry (it reschedules)
+		first, err = store.Add(ctx, user, deliverAt, entry("msg-a"))
+		require.NoError(t, err)
+		assert.True(t, first)
+
+		digest, err := store.Get(ctx, user, deliverAt)
+		require.NoError(t, err)
+		require.NotNil(t, digest)
+		assert.Len(t, digest.Entries, 2)
+	})
+
+	t.Run("Periods are kept apart and deleted independently", func(t *testing.T) {
+		store := NewDigestStore()
+		next := deliverAt.Add(24 * time.Hour)
+		_, err := store.Add(ctx, user, deliverAt, entry("msg-a"))
+		require.NoError(t, err)
+		first, err := store.Add(ctx, user, next, entry("msg-b"))
+		require.NoError(t, err)
+		assert.True(t, first)
+
+		require.NoError(t, store.Delete(ctx, user, deliverAt))
+		gone, err := store.Get(ctx, user, deliverAt)
+		require.NoError(t, err)
+		assert.Nil(t, gone)
+
+		kept, err := store.Get(ctx, user, next)
+		require.NoError(t, err)
+		require.NotNil(t, kept)
+		assert.Equal(t, "msg-b", kept.Entries[0].MessageID)
+	})
+}
@@ -0,0 +1,83 @@
+// Package memory provides in-process implementations of the storage contracts.
+// They are used in tests and as a best-effort fallback when no shared store
+// (Redis/Firestore) is configured; state is lost on restart and is not shared
+// between instances.
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+type ledgerEntry struct {
+	outcomes  map[string]dispatch.DeliveryOutcome
+	expiresAt time.Time
+}
+
+// DeliveryLedger is an in-memory dispatch.DeliveryLedger with per-entry expiry.
+type DeliveryLedger struct {
+	mu        sync.Mutex
+	entries   map[string]*ledgerEntry
+	ttl       time.Duration
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewDeliveryLedger creates an empty ledger whose entries expire after ttl.
+func NewDeliveryLedger(ttl time.Duration) *DeliveryLedger {
+	return &DeliveryLedger{
+		entries: make(map[string]*ledgerEntry),
+		ttl:     ttl,
+		now:     time.Now,
+	}
+}
+
+func (l *DeliveryLedger) Outcomes(_ context.Context, notificationID string) (map[string]dispatch.DeliveryOutcome, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := make(map[string]dispatch.DeliveryOutcome)
+	entry, ok := l.entries[notificationID]
+	if !ok || l.now().After(entry.expiresAt) {
+		return result, nil
+	}
+	for k, v := range entry.outcomes {
+		result[k] = v
+	}
+	return result, nil
+}
+
+func (l *DeliveryLedger) Record(_ context.Context, notificationID string, outcomes map[string]dispatch.DeliveryOutcome) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	entry, ok := l.entries[notificationID]
+	if !ok || now.After(entry.expiresAt) {
+		entry = &ledgerEntry{outcomes: make(map[string]dispatch.DeliveryOutcome)}
+		l.entries[notificationID] = entry
+	}
+	for k, v := range outcomes {
+		entry.outcomes[k] = v
+	}
+	entry.expiresAt = now.Add(l.ttl)
+	return nil
+}
+
+// sweep drops expired entries, at most once per TTL period. Caller holds the lock.
+func (l *DeliveryLedger) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.ttl {
+		return
+	}
+	for id, entry := range l.entries {
+		if now.After(entry.expiresAt) {
+			delete(l.entries, id)
+		}
+	}
+	l.lastSweep = now
+}
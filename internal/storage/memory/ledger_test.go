@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+func TestDeliveryLedger(t *testing.T) {
+	ctx := context.Background()
+	fcmKey := dispatch.DeviceKey(dispatch.PlatformFCM, "token-1")
+	webKey := dispatch.DeviceKey(dispatch.PlatformWeb, "https://web.push/1")
+
+	t.Run("Unknown notification has no history", func(t *testing.T) {
+		ledger := NewDeliveryLedger(time.Hour)
+
+		outcomes, err := ledger.Outcomes(ctx, "never-seen")
+		require.NoError(t, err)
+		assert.Empty(t, outcomes)
+	})
+
+	t.Run("Record merges outcomes", func(t *testing.T) {
+		ledger := NewDeliveryLedger(time.Hour)
+
+		require.NoError(t, ledger.Record(ctx, "msg-1", map[string]dispatch.DeliveryOutcome{fcmKey: dispatch.OutcomeDelivered}))
+		require.NoError(t, ledger.Record(ctx, "msg-1", map[string]dispatch.DeliveryOutcome{webKey: dispatch.OutcomeInvalid}))
+
+		outcomes, err := ledger.Outcomes(ctx, "msg-1")
+		require.NoError(t, err)
+		assert.Equal(t, dispatch.OutcomeDelivered, outcomes[fcmKey])
+		assert.Equal(t, dispatch.OutcomeInvalid, outcomes[webKey])
+	})
+
+	t.Run("Entries expire after the TTL", func(t *testing.T) {
+		ledger := NewDeliveryLedger(time.Minute)
+		clock := time.Now()
+		ledger.now = func() time.Time { return clock }
+
+		require.NoError(t, ledger.Record(ctx, "msg-2", map[string]dispatch.DeliveryOutcome{fcmKey: dispatch.OutcomeDelivered}))
+
+		clock = clock.Add(2 * time.Minute)
+		outcomes, err := ledger.Outcomes(ctx, "msg-2")
+		require.NoError(t, err)
+		assert.Empty(t, outcomes)
+
+		// The next write sweeps the expired entry away
+		require.NoError(t, ledger.Record(ctx, "msg-3", map[string]dispatch.DeliveryOutcome{fcmKey: dispatch.OutcomeDelivered}))
+		assert.NotContains(t, ledger.entries, "msg-2")
+	})
+}
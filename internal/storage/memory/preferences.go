@@ -0,0 +1,46 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"sync"
+
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+// PreferencesStore is an in-memory dispatch.PreferencesStore.
+type PreferencesStore struct {
+	mu    sync.RWMutex
+	prefs map[string]dispatch.Preferences
+}
+
+// NewPreferencesStore creates an empty store.
+func NewPreferencesStore() *PreferencesStore {
+	return &PreferencesStore{prefs: make(map[string]dispatch.Preferences)}
+}
+
+func (s *PreferencesStore) Get(_ context.Context, user urn.URN) (*dispatch.Preferences, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p := clonePreferences(s.prefs[user.String()]) // Zero value when unknown
+	return &p, nil
+}
+
+func (s *PreferencesStore) Put(_ context.Context, user urn.URN, prefs *dispatch.Preferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prefs[user.String()] = clonePreferences(*prefs)
+	return nil
+}
+
+// clonePreferences copies the slice, map and pointer fields so callers can't alias stored state.
+func clonePreferences(p dispatch.Preferences) dispatch.Preferences {
+	p.DisabledCategories = append([]string(nil), p.DisabledCategories...)
+	if p.QuietHours != nil {
+		quiet := *p.QuietHours
+		p.QuietHours = &quiet
+	}
+	p.Digests = maps.Clone(p.Digests)
+	return p
+}
@@ -0,0 +1,40 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+func TestPreferencesStore(t *testing.T) {
+	ctx := context.Background()
+	user, _ := urn.Parse("urn:sm:user:prefs")
+
+	t.Run("Unknown user gets the zero value", func(t *testing.T) {
+		store := NewPreferencesStore()
+
+		prefs, err := store.Get(ctx, user)
+		require.NoError(t, err)
+		assert.False(t, prefs.Muted)
+		assert.True(t, prefs.Allows("anything"))
+	})
+
+	t.Run("Put replaces and callers can't mutate the stored copy", func(t *testing.T) {
+		store := NewPreferencesStore()
+		saved := &dispatch.Preferences{DisabledCategories: []string{"marketing"}}
+		require.NoError(t, store.Put(ctx, user, saved))
+		saved.DisabledCategories[0] = "chat"
+
+		prefs, err := store.Get(ctx, user)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"marketing"}, prefs.DisabledCategories)
+
+		prefs.DisabledCategories[0] = "chat"
+		again, _ := store.Get(ctx, user)
+		assert.Equal(t, []string{"marketing"}, again.DisabledCategories)
+	})
+}
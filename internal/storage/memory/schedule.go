@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+type scheduleEntry struct {
+	notification dispatch.ScheduledNotification
+	visibleAt    time.Time // DeliverAt, pushed forward while claimed
+}
+
+// ScheduleStore is an in-memory dispatch.ScheduleStore.
+type ScheduleStore struct {
+	mu      sync.Mutex
+	entries map[string]*scheduleEntry
+}
+
+// NewScheduleStore creates an empty store.
+func NewScheduleStore() *ScheduleStore {
+	return &ScheduleStore{entries: make(map[string]*scheduleEntry)}
+}
+
+func (s *ScheduleStore) Schedule(_ context.Context, n dispatch.ScheduledNotification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[n.ID] = &scheduleEntry{notification: n, visibleAt: n.DeliverAt}
+	return nil
+}
+
+func (s *ScheduleStore) Claim(_ context.Context, now time.Time, lease time.Duration, limit int) ([]dispatch.ScheduledNotification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*scheduleEntry
+	for _, e := range s.entries {
+		if !e.visibleAt.After(now) {
+			due = append(due, e)
+		}
+	}
+	slices.SortFunc(due, func(a, b *scheduleEntry) int {
+		return a.visibleAt.Compare(b.visibleAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]dispatch.ScheduledNotification, 0, len(due))
+	for _, e := range due {
+		e.visibleAt = now.Add(lease)
+		claimed = append(claimed, e.notification)
+	}
+	return claimed, nil
+}
+
+func (s *ScheduleStore) Complete(_ context.Context, n dispatch.ScheduledNotification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[n.ID]; ok && e.notification.DeliverAt.Equal(n.DeliverAt) {
+		delete(s.entries, n.ID)
+	}
+	return nil
+}
+
+func (s *ScheduleStore) Cancel(_ context.Context, recipient urn.URN, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok || e.notification.Request.RecipientID.String() != recipient.String() {
+		return dispatch.ErrScheduleNotFound
+	}
+	delete(s.entries, id)
+	return nil
+}
+
+func (s *ScheduleStore) CancelByID(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[id]; !ok {
+		return dispatch.ErrScheduleNotFound
+	}
+	delete(s.entries, id)
+	return nil
+}
@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+func TestScheduleStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 7, 0, 0, 0, time.UTC)
+
+	t.Run("Only due notifications are claimed, earliest first", func(t *testing.T) {
+		store := NewScheduleStore()
+		require.NoError(t, store.Schedule(ctx, dispatch.ScheduledNotification{ID: "later", DeliverAt: now.Add(time.Hour)}))
+		require.NoError(t, store.Schedule(ctx, dispatch.ScheduledNotification{ID: "due-2", DeliverAt: now}))
+		require.NoError(t, store.Schedule(ctx, dispatch.ScheduledNotification{ID: "due-1", DeliverAt: now.Add(-time.Minute)}))
+
+		claimed, err := store.Claim(ctx, now, time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, claimed, 2)
+		assert.Equal(t, "due-1", claimed[0].ID)
+		assert.Equal(t, "due-2", claimed[1].ID)
+	})
+
+	t.Run("A claim is hidden until its lease runs out", func(t *testing.T) {
+		store := NewScheduleStore()
+		require.NoError(t, store.Schedule(ctx, dispatch.ScheduledNotification{ID: "n1", DeliverAt: now}))
+
+		claimed, _ := store.Claim(ctx, now, time.Minute, 10)
+		require.Len(t, claimed, 1)
+
+		again, _ := store.Claim(ctx, now.Add(30*time.Second), time.Minute, 10)
+		assert.Empty(t, again, "still leased")
+
+		expired, _ := store.Claim(ctx, now.Add(2*time.Minute), time.Minute, 10)
+		assert.Len(t, expired, 1, "lease expired: claimable again")
+	})
+
+	t.Run("Completed notifications are gone", func(t *testing.T) {
+		store := NewScheduleStore()
+		n := dispatch.ScheduledNotification{ID: "n1", DeliverAt: now}
+		require.NoError(t, store.Schedule(ctx, n))
+		require.NoError(t, store.Complete(ctx, n))
+
+		claimed, _ := store.Claim(ctx, now.Add(time.Hour), time.Minute, 10)
+		assert.Empty(t, claimed)
+	})
+
+	t.Run("Complete keeps a notification rescheduled during delivery", func(t *testing.T) {
+		store := NewScheduleStore()
+		n := dispatch.ScheduledNotification{ID: "n1", DeliverAt: now}
+		require.NoError(t, store.Schedule(ctx, n))
+		claimed, _ := store.Claim(ctx, now, time.Minute, 10)
+		require.Len(t, claimed, 1)
+
+		// The processor deferred it again (quiet hours) before the scheduler completed it
+		require.NoError(t, store.Schedule(ctx, dispatch.ScheduledNotification{ID: "n1", DeliverAt: now.Add(8 * time.Hour)}))
+		require.NoError(t, store.Complete(ctx, claimed[0]))
+
+		later, _ := store.Claim(ctx, now.Add(8*time.Hour), time.Minute, 10)
+		assert.Len(t, later, 1)
+	})
+
+	t.Run("Cancel is scoped to the recipient", func(t *testing.T) {
+		store := NewScheduleStore()
+		alice, _ := urn.Parse("urn:sm:user:alice")
+		bob, _ := urn.Parse("urn:sm:user:bob")
+		n := dispatch.ScheduledNotification{ID: "n1", DeliverAt: now}
+		n.Request.RecipientID = alice
+		require.NoError(t, store.Schedule(ctx, n))
+
+		assert.ErrorIs(t, store.Cancel(ctx, bob, "n1"), dispatch.ErrScheduleNotFound)
+		require.NoError(t, store.Cancel(ctx, alice, "n1"))
+		assert.ErrorIs(t, store.Cancel(ctx, alice, "n1"), dispatch.ErrScheduleNotFound)
+
+		claimed, _ := store.Claim(ctx, now, time.Minute, 10)
+		assert.Empty(t, claimed)
+	})
+
+	t.Run("CancelByID ignores the recipient", func(t *testing.T) {
+		store := NewScheduleStore()
+		n := dispatch.ScheduledNotification{ID: "n1", DeliverAt: now}
+		n.Request.RecipientID, _ = urn.Parse("urn:sm:user:alice")
+		require.NoError(t, store.Schedule(ctx, n))
+
+		require.NoError(t, store.CancelByID(ctx, "n1"))
+		assert.ErrorIs(t, store.CancelByID(ctx, "n1"), dispatch.ErrScheduleNotFound)
+	})
+
+	t.Run("Batch size is respected", func(t *testing.T) {
+		store := NewScheduleStore()
+		for _, id := range []string{"a", "b", "c"} {
+			require.NoError(t, store.Schedule(ctx, dispatch.ScheduledNotification{ID: id, DeliverAt: now}))
+		}
+		claimed, _ := store.Claim(ctx, now, time.Minute, 2)
+		assert.Len(t, claimed, 2)
+	})
+}
@@ -0,0 +1,175 @@
+// --- File: internal/templates/registry.go ---
+
+// Package templates holds the notification templates producers refer to by ID.
+// Each template has one variant per locale; the processor renders the variant
+// that best matches each device's registered locale.
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
+	"gopkg.in/yaml.v3"
+)
+
+// Variant is one locale's wording. Title and Body are text/template strings
+// over the request's TemplateVars, e.g. "New message from {{.sender}}".
+type Variant struct {
+	Title string `yaml:"title"`
+	Body  string `yaml:"body"`
+	Sound string `yaml:"sound"`
+}
+
+// File is the YAML layout of a template file: template ID -> locale -> variant.
+//
+//	new_message:
+//	  en: {title: "New message from {{.sender}}", body: "{{.preview}}"}
+//	  fr: {title: "Nouveau message de {{.sender}}", body: "{{.preview}}"}
+type File map[string]map[string]Variant
+
+type parsedVariant struct {
+	title *template.Template
+	body  *template.Template
+	sound string
+}
+
+// Registry implements dispatch.TemplateRenderer over templates parsed at startup.
+type Registry struct {
+	defaultLocale string
+	templates     map[string]map[string]parsedVariant // id -> normalized locale -> variant
+}
+
+// NewRegistry parses the templates. Every template needs a variant for
+// defaultLocale, which ends every fallback chain.
+func NewRegistry(defaultLocale string, files ...File) (*Registry, error) {
+	r := &Registry{
+		defaultLocale: normalizeLocale(defaultLocale),
+		templates:     make(map[string]map[string]parsedVariant),
+	}
+	for _, file := range files {
+		for id, variants := range file {
+			if _, exists := r.templates[id]; exists {
+				return nil, fmt.Errorf("template %q is defined twice", id)
+			}
+			parsed := make(map[string]parsedVariant, len(variants))
+			for locale, v := range variants {
+				pv, err := parseVariant(id, locale, v)
+				if err != nil {
+					return nil, err
+				}
+				parsed[normalizeLocale(locale)] = pv
+			}
+			if _, ok := parsed[r.defaultLocale]; !ok {
+				return nil, fmt.Errorf("template %q has no %q variant", id, r.defaultLocale)
+			}
+			r.templates[id] = parsed
+		}
+	}
+	return r, nil
+}
+
+// Load reads templates from a YAML file, or from every .yaml/.yml file in a directory.
+func Load(path, defaultLocale string) (*Registry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read templates: %w", err)
+	}
+	paths := []string{path}
+	if info.IsDir() {
+		paths = nil
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			matches, _ := filepath.Glob(filepath.Join(path, pattern))
+			paths = append(paths, matches...)
+		}
+	}
+
+	files := make([]File, 0, len(paths))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read templates: %w", err)
+		}
+		var file File
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", p, err)
+		}
+		files = append(files, file)
+	}
+	return NewRegistry(defaultLocale, files...)
+}
+
+// Len returns the number of templates.
+func (r *Registry) Len() int {
+	return len(r.templates)
+}
+
+// Render fills in the variant for the first locale of the fallback chain the
+// template has: the exact tag ("fr-ca"), its language ("fr"), then the default.
+func (r *Registry) Render(templateID, locale string, vars map[string]string) (notification.NotificationContent, error) {
+	variants, ok := r.templates[templateID]
+	if !ok {
+		return notification.NotificationContent{}, fmt.Errorf("%w: %q", dispatch.ErrTemplateNotFound, templateID)
+	}
+	var variant parsedVariant
+	for _, candidate := range r.fallbacks(locale) {
+		if v, ok := variants[candidate]; ok {
+			variant = v
+			break
+		}
+	}
+
+	title, err := execute(variant.title, vars)
+	if err != nil {
+		return notification.NotificationContent{}, err
+	}
+	body, err := execute(variant.body, vars)
+	if err != nil {
+		return notification.NotificationContent{}, err
+	}
+	return notification.NotificationContent{Title: title, Body: body, Sound: variant.sound}, nil
+}
+
+// fallbacks lists the locales to try for a device, most specific first.
+func (r *Registry) fallbacks(locale string) []string {
+	var chain []string
+	for locale = normalizeLocale(locale); locale != ""; {
+		chain = append(chain, locale)
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+	return append(chain, r.defaultLocale)
+}
+
+func parseVariant(id, locale string, v Variant) (parsedVariant, error) {
+	title, err := template.New(id + ".title").Option("missingkey=error").Parse(v.Title)
+	if err != nil {
+		return parsedVariant{}, fmt.Errorf("template %q (%s) title: %w", id, locale, err)
+	}
+	body, err := template.New(id + ".body").Option("missingkey=error").Parse(v.Body)
+	if err != nil {
+		return parsedVariant{}, fmt.Errorf("template %q (%s) body: %w", id, locale, err)
+	}
+	return parsedVariant{title: title, body: body, sound: v.Sound}, nil
+}
+
+func execute(t *template.Template, vars map[string]string) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// normalizeLocale makes "fr_CA" and "fr-CA" the same key ("fr-ca").
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
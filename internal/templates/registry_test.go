@@ -0,0 +1,93 @@
+package templates_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tinywideclouds/go-notification-service/internal/templates"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+func TestRegistry_Render(t *testing.T) {
+	registry, err := templates.NewRegistry("en", templates.File{
+		"new_message": {
+			"en":    {Title: "New message from {{.sender}}", Body: "{{.preview}}"},
+			"fr":    {Title: "Nouveau message de {{.sender}}", Body: "{{.preview}}"},
+			"fr_CA": {Title: "Nouveau courriel de {{.sender}}", Body: "{{.preview}}", Sound: "quebec.caf"},
+		},
+	})
+	require.NoError(t, err)
+	vars := map[string]string{"sender": "Alice", "preview": "Salut !"}
+
+	testCases := []struct {
+		name      string
+		locale    string
+		wantTitle string
+	}{
+		{"Exact locale", "fr-CA", "Nouveau courriel de Alice"},
+		{"Language fallback", "fr-FR", "Nouveau message de Alice"},
+		{"Default fallback", "de-DE", "New message from Alice"},
+		{"No locale", "", "New message from Alice"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			content, err := registry.Render("new_message", tc.locale, vars)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantTitle, content.Title)
+			assert.Equal(t, "Salut !", content.Body)
+		})
+	}
+
+	t.Run("Unknown template or missing variable fails", func(t *testing.T) {
+		_, err := registry.Render("nope", "en", vars)
+		assert.ErrorIs(t, err, dispatch.ErrTemplateNotFound)
+		_, err = registry.Render("new_message", "en", map[string]string{"sender": "Alice"})
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, dispatch.ErrTemplateNotFound, "the template exists; the request is wrong")
+	})
+}
+
+func TestRegistry_Validation(t *testing.T) {
+	_, err := templates.NewRegistry("en", templates.File{"greeting": {"fr": {Title: "Bonjour"}}})
+	assert.Error(t, err, "every template needs the default locale")
+
+	_, err = templates.NewRegistry("en", templates.File{"greeting": {"en": {Title: "{{.name"}}})
+	assert.Error(t, err, "templates are parsed at startup")
+
+	_, err = templates.NewRegistry("en",
+		templates.File{"greeting": {"en": {Title: "Hi"}}},
+		templates.File{"greeting": {"en": {Title: "Hello"}}})
+	assert.Error(t, err, "IDs are unique across files")
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "chat.yaml"), []byte(`
+new_message:
+  en: {title: "New message from {{.sender}}", body: "{{.preview}}"}
+  fr: {title: "Nouveau message de {{.sender}}", body: "{{.preview}}"}
+`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "social.yml"), []byte(`
+new_follower:
+  en: {title: "{{.name}} followed you"}
+`), 0o600))
+
+	registry, err := templates.Load(dir, "en")
+	require.NoError(t, err)
+	assert.Equal(t, 2, registry.Len())
+
+	content, err := registry.Render("new_follower", "fr", map[string]string{"name": "Bob"})
+	require.NoError(t, err)
+	assert.Equal(t, "Bob followed you", content.Title)
+
+	single, err := templates.Load(filepath.Join(dir, "chat.yaml"), "en")
+	require.NoError(t, err)
+	assert.Equal(t, 1, single.Len())
+
+	_, err = templates.Load(filepath.Join(dir, "missing.yaml"), "en")
+	assert.Error(t, err)
+}
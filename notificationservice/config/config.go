@@ -7,6 +7,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/illmade-knight/go-dataflow/pkg/messagepipeline"
 	"github.com/tinywideclouds/go-microservice-base/pkg/middleware"
@@ -25,6 +26,126 @@ type VapidConfig struct {
 	SubscriberEmail string
 }
 
+// WebPushConfig tunes delivery to browser push services.
+type WebPushConfig struct {
+	// Concurrency is how many subscriptions are sent to at once.
+	Concurrency int
+	// Timeout bounds each push service request.
+	Timeout time.Duration
+	// MaxRetryAfter is the longest 429 Retry-After waited out before sending once
+	// more; longer ones are left to Pub/Sub redelivery.
+	MaxRetryAfter time.Duration
+}
+
+// FCMConfig tunes delivery through Firebase Cloud Messaging.
+type FCMConfig struct {
+	// RetryMaxAttempts is the total sends per token, including the first, before
+	// a retryable failure is left to Pub/Sub redelivery. 1 disables in-process retries.
+	RetryMaxAttempts int
+	// RetryBaseDelay is the backoff before the first retry; it doubles each attempt.
+	RetryBaseDelay time.Duration
+	// RetryMaxDelay caps a single wait, including a Retry-After from FCM.
+	RetryMaxDelay time.Duration
+	// Concurrency is how many 500-token multicast chunks are sent at once.
+	Concurrency int
+}
+
+// APNsConfig holds the credentials for native iOS delivery: a P8 signing key
+// (token auth) or a push certificate. APNs is optional: when the chosen
+// credentials are missing, the APNs path is disabled.
+type APNsConfig struct {
+	// Auth is "token" or "certificate". Empty picks certificate when only a
+	// certificate is configured, and token otherwise.
+	Auth     string
+	KeyID    string
+	TeamID   string
+	BundleID string
+	// P8Key is the raw content of the .p8 signing key (not a file path).
+	P8Key string
+	// Certificate is the content of a PEM push certificate (certificate and
+	// key), or a base64-encoded .p12 file (not a file path).
+	Certificate string
+	// CertificatePassword decrypts the certificate's private key, if protected.
+	CertificatePassword string
+	// Concurrency is how many pushes are in flight at once on the HTTP/2 connection.
+	Concurrency int
+	// RetryMaxAttempts is the total pushes per token, including the first, before
+	// a retryable failure is left to Pub/Sub redelivery. 1 disables in-process retries.
+	RetryMaxAttempts int
+	// RetryBaseDelay is the backoff before the first retry; it doubles each attempt.
+	RetryBaseDelay time.Duration
+	// RetryMaxDelay caps a single wait, including a Retry-After from APNs.
+	RetryMaxDelay time.Duration
+}
+
+// Enabled reports whether enough credentials are present to build a dispatcher.
+func (c APNsConfig) Enabled() bool {
+	if c.Auth == "certificate" {
+		return c.Certificate != "" && c.BundleID != ""
+	}
+	return c.P8Key != "" && c.KeyID != "" && c.TeamID != "" && c.BundleID != ""
+}
+
+// PruneConfig controls the stale-device pruning job.
+type PruneConfig struct {
+	Enabled bool
+	// MaxAge is how long a device may go without re-registering before it is pruned.
+	MaxAge time.Duration
+	// Interval is how often the job runs inside the service.
+	Interval time.Duration
+	// Mode is "delete" or "quarantine".
+	Mode      string
+	BatchSize int
+}
+
+// SchedulerConfig controls the deferred-delivery poller (e.g. quiet hours).
+type SchedulerConfig struct {
+	// PollInterval is how often due notifications are looked up. It bounds how
+	// late a deferred notification can be.
+	PollInterval time.Duration
+	// Lease hides a claimed notification from other instances while it is delivered.
+	Lease     time.Duration
+	BatchSize int
+}
+
+// CoalesceConfig controls burst coalescing. A zero Window disables it.
+type CoalesceConfig struct {
+	// Window is how long requests with the same recipient and collapse key are
+	// gathered after the first before being delivered as one notification.
+	Window time.Duration
+}
+
+// DigestConfig lists the categories that are rolled up into periodic digests
+// instead of being pushed one by one. Users may override it per category.
+type DigestConfig struct {
+	// Categories maps a category to "hourly" or "daily".
+	Categories map[string]string
+	// DailyAt is the local time ("HH:MM") daily digests are delivered at.
+	DailyAt string
+}
+
+// TemplatesConfig points at the notification templates loaded at startup.
+type TemplatesConfig struct {
+	// Path is a YAML file, or a directory of them. Empty disables templates.
+	Path string
+	// DefaultLocale ends every locale fallback chain; each template needs it.
+	DefaultLocale string
+}
+
+// PriorityLaneConfig is an optional second ingestion lane: its own topic,
+// subscription and workers, so high-priority traffic isn't stuck behind a
+// burst of bulk sends on the main subscription.
+type PriorityLaneConfig struct {
+	TopicID        string
+	SubscriptionID string
+	NumWorkers     int
+}
+
+// Enabled reports whether the priority lane should be consumed.
+func (c PriorityLaneConfig) Enabled() bool {
+	return c.SubscriptionID != ""
+}
+
 // Config defines the *single*, authoritative configuration.
 type Config struct {
 	ProjectID              string
@@ -33,11 +154,28 @@ type Config struct {
 	SubscriptionDLQTopicID string
 	NumPipelineWorkers     int
 
+	// DedupWindow is how long a RequestID is remembered for idempotent ingestion.
+	DedupWindow time.Duration
+
 	CorsConfig middleware.CorsConfig
 	Redis      RedisConfig
 	Vapid      VapidConfig // ✅ Added
+	WebPush    WebPushConfig
+	FCM        FCMConfig
+	APNs       APNsConfig
+	Prune      PruneConfig
+	Scheduler  SchedulerConfig
+	Coalesce   CoalesceConfig
+	Digest     DigestConfig
+	Templates  TemplatesConfig
 
-	TopicID              string
+	// PriorityLane is consumed alongside the main subscription when enabled.
+	PriorityLane PriorityLaneConfig
+
+	TopicID string
+	// FeedbackTopicID is the Pub/Sub topic for delivery-feedback events.
+	// Empty disables feedback publishing.
+	FeedbackTopicID      string
 	PubsubConsumerConfig *messagepipeline.GooglePubsubConsumerConfig
 }
 
@@ -63,6 +201,10 @@ func UpdateConfigWithEnvOverrides(cfg *Config, logger *slog.Logger) (*Config, er
 		logger.Debug("Overriding config value", "key", "SUBSCRIPTION_DLQ_TOPIC_ID", "source", "env")
 		cfg.SubscriptionDLQTopicID = val
 	}
+	if val := os.Getenv("FEEDBACK_TOPIC_ID"); val != "" {
+		logger.Debug("Overriding config value", "key", "FEEDBACK_TOPIC_ID", "source", "env")
+		cfg.FeedbackTopicID = val
+	}
 	if val := os.Getenv("NUM_PIPELINE_WORKERS"); val != "" {
 		if workers, err := strconv.Atoi(val); err == nil && workers > 0 {
 			logger.Debug("Overriding config value", "key", "NUM_PIPELINE_WORKERS", "source", "env")
@@ -70,6 +212,13 @@ func UpdateConfigWithEnvOverrides(cfg *Config, logger *slog.Logger) (*Config, er
 		}
 	}
 
+	if val := os.Getenv("DEDUP_WINDOW"); val != "" {
+		if window, err := time.ParseDuration(val); err == nil && window > 0 {
+			logger.Debug("Overriding config value", "key", "DEDUP_WINDOW", "source", "env")
+			cfg.DedupWindow = window
+		}
+	}
+
 	// Redis Overrides
 	if val := os.Getenv("REDIS_ADDR"); val != "" {
 		cfg.Redis.Addr = val
@@ -102,6 +251,182 @@ func UpdateConfigWithEnvOverrides(cfg *Config, logger *slog.Logger) (*Config, er
 		cfg.Vapid.SubscriberEmail = val
 	}
 
+	// Web Push Overrides
+	if val := os.Getenv("WEB_PUSH_CONCURRENCY"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			logger.Debug("Overriding config value", "key", "WEB_PUSH_CONCURRENCY", "source", "env")
+			cfg.WebPush.Concurrency = n
+		}
+	}
+	if val := os.Getenv("WEB_PUSH_TIMEOUT"); val != "" {
+		if timeout, err := time.ParseDuration(val); err == nil && timeout > 0 {
+			logger.Debug("Overriding config value", "key", "WEB_PUSH_TIMEOUT", "source", "env")
+			cfg.WebPush.Timeout = timeout
+		}
+	}
+	if val := os.Getenv("WEB_PUSH_MAX_RETRY_AFTER"); val != "" {
+		if limit, err := time.ParseDuration(val); err == nil && limit > 0 {
+			logger.Debug("Overriding config value", "key", "WEB_PUSH_MAX_RETRY_AFTER", "source", "env")
+			cfg.WebPush.MaxRetryAfter = limit
+		}
+	}
+
+	// FCM Overrides
+	if val := os.Getenv("FCM_RETRY_MAX_ATTEMPTS"); val != "" {
+		if attempts, err := strconv.Atoi(val); err == nil && attempts > 0 {
+			logger.Debug("Overriding config value", "key", "FCM_RETRY_MAX_ATTEMPTS", "source", "env")
+			cfg.FCM.RetryMaxAttempts = attempts
+		}
+	}
+	if val := os.Getenv("FCM_RETRY_BASE_DELAY"); val != "" {
+		if delay, err := time.ParseDuration(val); err == nil && delay > 0 {
+			logger.Debug("Overriding config value", "key", "FCM_RETRY_BASE_DELAY", "source", "env")
+			cfg.FCM.RetryBaseDelay = delay
+		}
+	}
+	if val := os.Getenv("FCM_RETRY_MAX_DELAY"); val != "" {
+		if delay, err := time.ParseDuration(val); err == nil && delay > 0 {
+			logger.Debug("Overriding config value", "key", "FCM_RETRY_MAX_DELAY", "source", "env")
+			cfg.FCM.RetryMaxDelay = delay
+		}
+	}
+
+	if val := os.Getenv("FCM_CONCURRENCY"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			logger.Debug("Overriding config value", "key", "FCM_CONCURRENCY", "source", "env")
+			cfg.FCM.Concurrency = n
+		}
+	}
+
+	// APNs Overrides (the P8 key or certificate is usually injected from Secret Manager)
+	if val := os.Getenv("APNS_AUTH"); val != "" {
+		logger.Debug("Overriding config value", "key", "APNS_AUTH", "source", "env")
+		cfg.APNs.Auth = val
+	}
+	if val := os.Getenv("APNS_KEY_ID"); val != "" {
+		logger.Debug("Overriding config value", "key", "APNS_KEY_ID", "source", "env")
+		cfg.APNs.KeyID = val
+	}
+	if val := os.Getenv("APNS_TEAM_ID"); val != "" {
+		logger.Debug("Overriding config value", "key", "APNS_TEAM_ID", "source", "env")
+		cfg.APNs.TeamID = val
+	}
+	if val := os.Getenv("APNS_BUNDLE_ID"); val != "" {
+		logger.Debug("Overriding config value", "key", "APNS_BUNDLE_ID", "source", "env")
+		cfg.APNs.BundleID = val
+	}
+	if val := os.Getenv("APNS_P8_KEY"); val != "" {
+		logger.Debug("Overriding config value", "key", "APNS_P8_KEY", "source", "env")
+		cfg.APNs.P8Key = val
+	}
+	if val := os.Getenv("APNS_CERTIFICATE"); val != "" {
+		logger.Debug("Overriding config value", "key", "APNS_CERTIFICATE", "source", "env")
+		cfg.APNs.Certificate = val
+	}
+	if val := os.Getenv("APNS_CERTIFICATE_PASSWORD"); val != "" {
+		logger.Debug("Overriding config value", "key", "APNS_CERTIFICATE_PASSWORD", "source", "env")
+		cfg.APNs.CertificatePassword = val
+	}
+	if val := os.Getenv("APNS_CONCURRENCY"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			logger.Debug("Overriding config value", "key", "APNS_CONCURRENCY", "source", "env")
+			cfg.APNs.Concurrency = n
+		}
+	}
+	if val := os.Getenv("APNS_RETRY_MAX_ATTEMPTS"); val != "" {
+		if attempts, err := strconv.Atoi(val); err == nil && attempts > 0 {
+			logger.Debug("Overriding config value", "key", "APNS_RETRY_MAX_ATTEMPTS", "source", "env")
+			cfg.APNs.RetryMaxAttempts = attempts
+		}
+	}
+	if val := os.Getenv("APNS_RETRY_BASE_DELAY"); val != "" {
+		if delay, err := time.ParseDuration(val); err == nil && delay > 0 {
+			logger.Debug("Overriding config value", "key", "APNS_RETRY_BASE_DELAY", "source", "env")
+			cfg.APNs.RetryBaseDelay = delay
+		}
+	}
+	if val := os.Getenv("APNS_RETRY_MAX_DELAY"); val != "" {
+		if delay, err := time.ParseDuration(val); err == nil && delay > 0 {
+			logger.Debug("Overriding config value", "key", "APNS_RETRY_MAX_DELAY", "source", "env")
+			cfg.APNs.RetryMaxDelay = delay
+		}
+	}
+
+	// Pruning Overrides
+	if val := os.Getenv("PRUNE_ENABLED"); val != "" {
+		enabled, _ := strconv.ParseBool(val)
+		cfg.Prune.Enabled = enabled
+	}
+	if val := os.Getenv("PRUNE_MAX_AGE"); val != "" {
+		if age, err := time.ParseDuration(val); err == nil && age > 0 {
+			logger.Debug("Overriding config value", "key", "PRUNE_MAX_AGE", "source", "env")
+			cfg.Prune.MaxAge = age
+		}
+	}
+	if val := os.Getenv("PRUNE_MODE"); val != "" {
+		logger.Debug("Overriding config value", "key", "PRUNE_MODE", "source", "env")
+		cfg.Prune.Mode = val
+	}
+
+	// Scheduler Overrides
+	if val := os.Getenv("SCHEDULER_POLL_INTERVAL"); val != "" {
+		if interval, err := time.ParseDuration(val); err == nil && interval > 0 {
+			logger.Debug("Overriding config value", "key", "SCHEDULER_POLL_INTERVAL", "source", "env")
+			cfg.Scheduler.PollInterval = interval
+		}
+	}
+
+	// Coalescing Overrides
+	if val := os.Getenv("COALESCE_WINDOW"); val != "" {
+		if window, err := time.ParseDuration(val); err == nil && window >= 0 {
+			logger.Debug("Overriding config value", "key", "COALESCE_WINDOW", "source", "env")
+			cfg.Coalesce.Window = window
+		}
+	}
+
+	// Digest Overrides (DIGEST_CATEGORIES="likes=hourly,follows=daily")
+	if val := os.Getenv("DIGEST_CATEGORIES"); val != "" {
+		logger.Debug("Overriding config value", "key", "DIGEST_CATEGORIES", "source", "env")
+		categories := make(map[string]string)
+		for _, pair := range strings.Split(val, ",") {
+			category, period, _ := strings.Cut(pair, "=")
+			if category = strings.TrimSpace(category); category != "" {
+				categories[category] = strings.TrimSpace(period)
+			}
+		}
+		cfg.Digest.Categories = categories
+	}
+	if val := os.Getenv("DIGEST_DAILY_AT"); val != "" {
+		logger.Debug("Overriding config value", "key", "DIGEST_DAILY_AT", "source", "env")
+		cfg.Digest.DailyAt = val
+	}
+
+	// Template Overrides
+	if val := os.Getenv("TEMPLATES_PATH"); val != "" {
+		logger.Debug("Overriding config value", "key", "TEMPLATES_PATH", "source", "env")
+		cfg.Templates.Path = val
+	}
+	if val := os.Getenv("TEMPLATES_DEFAULT_LOCALE"); val != "" {
+		logger.Debug("Overriding config value", "key", "TEMPLATES_DEFAULT_LOCALE", "source", "env")
+		cfg.Templates.DefaultLocale = val
+	}
+
+	// Priority Lane Overrides
+	if val := os.Getenv("PRIORITY_TOPIC_ID"); val != "" {
+		logger.Debug("Overriding config value", "key", "PRIORITY_TOPIC_ID", "source", "env")
+		cfg.PriorityLane.TopicID = val
+	}
+	if val := os.Getenv("PRIORITY_SUBSCRIPTION_ID"); val != "" {
+		logger.Debug("Overriding config value", "key", "PRIORITY_SUBSCRIPTION_ID", "source", "env")
+		cfg.PriorityLane.SubscriptionID = val
+	}
+	if val := os.Getenv("PRIORITY_NUM_WORKERS"); val != "" {
+		if workers, err := strconv.Atoi(val); err == nil && workers > 0 {
+			logger.Debug("Overriding config value", "key", "PRIORITY_NUM_WORKERS", "source", "env")
+			cfg.PriorityLane.NumWorkers = workers
+		}
+	}
+
 	// CORS Overrides
 	if corsOrigins := os.Getenv("CORS_ALLOWED_ORIGINS"); corsOrigins != "" {
 		logger.Debug("Overriding config value", "key", "CORS_ALLOWED_ORIGINS", "source", "env")
@@ -128,6 +453,109 @@ func UpdateConfigWithEnvOverrides(cfg *Config, logger *slog.Logger) (*Config, er
 	if cfg.NumPipelineWorkers <= 0 {
 		cfg.NumPipelineWorkers = 1
 	}
+	if cfg.DedupWindow <= 0 {
+		cfg.DedupWindow = 10 * time.Minute
+	}
+
+	if cfg.WebPush.Concurrency <= 0 {
+		cfg.WebPush.Concurrency = 8
+	}
+	if cfg.WebPush.Timeout <= 0 {
+		cfg.WebPush.Timeout = 10 * time.Second
+	}
+	if cfg.WebPush.MaxRetryAfter <= 0 {
+		cfg.WebPush.MaxRetryAfter = 5 * time.Second
+	}
+
+	if cfg.FCM.RetryMaxAttempts <= 0 {
+		cfg.FCM.RetryMaxAttempts = 3
+	}
+	if cfg.FCM.RetryBaseDelay <= 0 {
+		cfg.FCM.RetryBaseDelay = 500 * time.Millisecond
+	}
+	if cfg.FCM.RetryMaxDelay <= 0 {
+		cfg.FCM.RetryMaxDelay = 10 * time.Second
+	}
+	if cfg.FCM.Concurrency <= 0 {
+		cfg.FCM.Concurrency = 4
+	}
+
+	if cfg.APNs.Concurrency <= 0 {
+		cfg.APNs.Concurrency = 16
+	}
+	if cfg.APNs.RetryMaxAttempts <= 0 {
+		cfg.APNs.RetryMaxAttempts = 3
+	}
+	if cfg.APNs.RetryBaseDelay <= 0 {
+		cfg.APNs.RetryBaseDelay = 500 * time.Millisecond
+	}
+	if cfg.APNs.RetryMaxDelay <= 0 {
+		cfg.APNs.RetryMaxDelay = 10 * time.Second
+	}
+	if cfg.APNs.Auth == "" {
+		cfg.APNs.Auth = "token"
+		if cfg.APNs.Certificate != "" && cfg.APNs.P8Key == "" {
+			cfg.APNs.Auth = "certificate"
+		}
+	}
+	if cfg.APNs.Auth != "token" && cfg.APNs.Auth != "certificate" {
+		return nil, fmt.Errorf("apns auth must be 'token' or 'certificate', got %q", cfg.APNs.Auth)
+	}
+
+	if cfg.Prune.MaxAge <= 0 {
+		cfg.Prune.MaxAge = 60 * 24 * time.Hour
+	}
+	if cfg.Prune.Interval <= 0 {
+		cfg.Prune.Interval = 24 * time.Hour
+	}
+	if cfg.Prune.BatchSize <= 0 {
+		cfg.Prune.BatchSize = 500
+	}
+	if cfg.Prune.Mode == "" {
+		cfg.Prune.Mode = "delete"
+	}
+	if cfg.Prune.Mode != "delete" && cfg.Prune.Mode != "quarantine" {
+		return nil, fmt.Errorf("prune mode must be 'delete' or 'quarantine', got %q", cfg.Prune.Mode)
+	}
+
+	if cfg.Scheduler.PollInterval <= 0 {
+		cfg.Scheduler.PollInterval = 30 * time.Second
+	}
+	if cfg.Scheduler.Lease <= 0 {
+		cfg.Scheduler.Lease = 2 * time.Minute
+	}
+	if cfg.Scheduler.BatchSize <= 0 {
+		cfg.Scheduler.BatchSize = 100
+	}
+
+	if cfg.Digest.DailyAt == "" {
+		cfg.Digest.DailyAt = "18:00"
+	}
+	if _, err := time.Parse("15:04", cfg.Digest.DailyAt); err != nil {
+		return nil, fmt.Errorf("digest daily_at must be HH:MM, got %q", cfg.Digest.DailyAt)
+	}
+	digests := make(map[string]string, len(cfg.Digest.Categories))
+	for category, period := range cfg.Digest.Categories {
+		period = strings.ToLower(period)
+		if period != "hourly" && period != "daily" {
+			return nil, fmt.Errorf("digest period for %q must be 'hourly' or 'daily', got %q", category, period)
+		}
+		digests[strings.ToLower(category)] = period
+	}
+	cfg.Digest.Categories = digests
+
+	if cfg.Templates.DefaultLocale == "" {
+		cfg.Templates.DefaultLocale = "en"
+	}
+
+	if cfg.PriorityLane.Enabled() {
+		if cfg.PriorityLane.TopicID == "" {
+			return nil, fmt.Errorf("priority_lane.topic_id is required when priority_lane.subscription_id is set")
+		}
+		if cfg.PriorityLane.NumWorkers <= 0 {
+			cfg.PriorityLane.NumWorkers = cfg.NumPipelineWorkers
+		}
+	}
 
 	if cfg.PubsubConsumerConfig == nil && cfg.SubscriptionID != "" {
 		cfg.PubsubConsumerConfig = messagepipeline.NewGooglePubsubConsumerDefaults(cfg.SubscriptionID)
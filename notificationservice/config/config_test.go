@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -38,22 +39,95 @@ func TestUpdateConfigWithEnvOverrides(t *testing.T) {
 		t.Setenv("PROJECT_ID", "env-project")
 		t.Setenv("PORT", "9090")
 		t.Setenv("SUBSCRIPTION_ID", "env-sub")
+		t.Setenv("DEDUP_WINDOW", "90s")
+		t.Setenv("FEEDBACK_TOPIC_ID", "env-feedback")
+		t.Setenv("PRUNE_ENABLED", "true")
+		t.Setenv("PRUNE_MAX_AGE", "720h")
+		t.Setenv("PRUNE_MODE", "quarantine")
+		t.Setenv("SCHEDULER_POLL_INTERVAL", "5s")
+		t.Setenv("COALESCE_WINDOW", "20s")
+		t.Setenv("DIGEST_CATEGORIES", "Likes=hourly, follows=daily")
+		t.Setenv("DIGEST_DAILY_AT", "09:30")
+		t.Setenv("TEMPLATES_PATH", "/etc/templates")
+		t.Setenv("TEMPLATES_DEFAULT_LOCALE", "fr")
+		t.Setenv("PRIORITY_TOPIC_ID", "env-priority-topic")
+		t.Setenv("PRIORITY_SUBSCRIPTION_ID", "env-priority-sub")
 
 		// ✅ Test VAPID Overrides
 		t.Setenv("VAPID_PUBLIC_KEY", "env-pub")
 		t.Setenv("VAPID_PRIVATE_KEY", "env-priv")
 		t.Setenv("VAPID_SUB_EMAIL", "env@test.com")
 
+		t.Setenv("WEB_PUSH_CONCURRENCY", "16")
+		t.Setenv("WEB_PUSH_TIMEOUT", "3s")
+		t.Setenv("WEB_PUSH_MAX_RETRY_AFTER", "2s")
+		t.Setenv("FCM_RETRY_MAX_ATTEMPTS", "5")
+		t.Setenv("FCM_RETRY_BASE_DELAY", "250ms")
+		t.Setenv("FCM_RETRY_MAX_DELAY", "30s")
+		t.Setenv("FCM_CONCURRENCY", "8")
+
+		t.Setenv("APNS_KEY_ID", "env-key-id")
+		t.Setenv("APNS_TEAM_ID", "env-team-id")
+		t.Setenv("APNS_BUNDLE_ID", "com.env.app")
+		t.Setenv("APNS_P8_KEY", "env-p8-content")
+		t.Setenv("APNS_CONCURRENCY", "32")
+		t.Setenv("APNS_RETRY_MAX_ATTEMPTS", "4")
+		t.Setenv("APNS_RETRY_BASE_DELAY", "100ms")
+		t.Setenv("APNS_RETRY_MAX_DELAY", "20s")
+
 		finalCfg, err := config.UpdateConfigWithEnvOverrides(cfg, logger)
 		require.NoError(t, err)
 
 		assert.Equal(t, "env-project", finalCfg.ProjectID)
 		assert.Equal(t, ":9090", finalCfg.ListenAddr)
 		assert.Equal(t, "env-sub", finalCfg.SubscriptionID)
+		assert.Equal(t, 90*time.Second, finalCfg.DedupWindow)
+		assert.Equal(t, "env-feedback", finalCfg.FeedbackTopicID)
+		assert.True(t, finalCfg.Prune.Enabled)
+		assert.Equal(t, 720*time.Hour, finalCfg.Prune.MaxAge)
+		assert.Equal(t, "quarantine", finalCfg.Prune.Mode)
+		assert.Equal(t, 5*time.Second, finalCfg.Scheduler.PollInterval)
+		assert.Equal(t, 20*time.Second, finalCfg.Coalesce.Window)
+		assert.Equal(t, map[string]string{"likes": "hourly", "follows": "daily"}, finalCfg.Digest.Categories)
+		assert.Equal(t, "09:30", finalCfg.Digest.DailyAt)
+		assert.Equal(t, config.TemplatesConfig{Path: "/etc/templates", DefaultLocale: "fr"}, finalCfg.Templates)
+		assert.True(t, finalCfg.PriorityLane.Enabled())
+		assert.Equal(t, "env-priority-topic", finalCfg.PriorityLane.TopicID)
+		assert.Equal(t, "env-priority-sub", finalCfg.PriorityLane.SubscriptionID)
+		assert.Equal(t, 2, finalCfg.PriorityLane.NumWorkers) // Defaults to the main lane's
 
 		assert.Equal(t, "env-pub", finalCfg.Vapid.PublicKey)
 		assert.Equal(t, "env-priv", finalCfg.Vapid.PrivateKey)
 		assert.Equal(t, "env@test.com", finalCfg.Vapid.SubscriberEmail)
+
+		assert.Equal(t, config.WebPushConfig{Concurrency: 16, Timeout: 3 * time.Second, MaxRetryAfter: 2 * time.Second}, finalCfg.WebPush)
+		assert.Equal(t, config.FCMConfig{RetryMaxAttempts: 5, RetryBaseDelay: 250 * time.Millisecond, RetryMaxDelay: 30 * time.Second, Concurrency: 8}, finalCfg.FCM)
+
+		assert.Equal(t, "env-key-id", finalCfg.APNs.KeyID)
+		assert.Equal(t, "env-team-id", finalCfg.APNs.TeamID)
+		assert.Equal(t, "com.env.app", finalCfg.APNs.BundleID)
+		assert.Equal(t, "env-p8-content", finalCfg.APNs.P8Key)
+		assert.Equal(t, 32, finalCfg.APNs.Concurrency)
+		assert.Equal(t, 4, finalCfg.APNs.RetryMaxAttempts)
+		assert.Equal(t, 100*time.Millisecond, finalCfg.APNs.RetryBaseDelay)
+		assert.Equal(t, 20*time.Second, finalCfg.APNs.RetryMaxDelay)
+		assert.Equal(t, "token", finalCfg.APNs.Auth)
+	})
+
+	t.Run("Success - APNs Certificate Auth", func(t *testing.T) {
+		cfg := baseConfig()
+		t.Setenv("APNS_BUNDLE_ID", "com.env.app")
+		t.Setenv("APNS_CERTIFICATE", "env-certificate")
+		t.Setenv("APNS_CERTIFICATE_PASSWORD", "env-password")
+
+		finalCfg, err := config.UpdateConfigWithEnvOverrides(cfg, logger)
+		require.NoError(t, err)
+
+		// Only a certificate is configured, so certificate auth is picked
+		assert.Equal(t, "certificate", finalCfg.APNs.Auth)
+		assert.Equal(t, "env-certificate", finalCfg.APNs.Certificate)
+		assert.Equal(t, "env-password", finalCfg.APNs.CertificatePassword)
+		assert.True(t, finalCfg.APNs.Enabled())
 	})
 
 	t.Run("Success - Defaults preserved", func(t *testing.T) {
@@ -63,6 +137,55 @@ func TestUpdateConfigWithEnvOverrides(t *testing.T) {
 
 		assert.Equal(t, "base-project", finalCfg.ProjectID)
 		assert.Equal(t, "base-pub", finalCfg.Vapid.PublicKey)
+		assert.Equal(t, 10*time.Minute, finalCfg.DedupWindow)
+		assert.Equal(t, 60*24*time.Hour, finalCfg.Prune.MaxAge)
+		assert.Equal(t, "delete", finalCfg.Prune.Mode)
+		assert.Equal(t, 30*time.Second, finalCfg.Scheduler.PollInterval)
+		assert.Equal(t, 2*time.Minute, finalCfg.Scheduler.Lease)
+		assert.False(t, finalCfg.PriorityLane.Enabled())
+		assert.Zero(t, finalCfg.Coalesce.Window)
+		assert.Empty(t, finalCfg.Digest.Categories)
+		assert.Equal(t, "18:00", finalCfg.Digest.DailyAt)
+		assert.Equal(t, config.TemplatesConfig{DefaultLocale: "en"}, finalCfg.Templates)
+		assert.Equal(t, 16, finalCfg.APNs.Concurrency)
+		assert.Equal(t, 3, finalCfg.APNs.RetryMaxAttempts)
+		assert.Equal(t, 500*time.Millisecond, finalCfg.APNs.RetryBaseDelay)
+		assert.Equal(t, 10*time.Second, finalCfg.APNs.RetryMaxDelay)
+		assert.Equal(t, config.WebPushConfig{Concurrency: 8, Timeout: 10 * time.Second, MaxRetryAfter: 5 * time.Second}, finalCfg.WebPush)
+		assert.Equal(t, config.FCMConfig{RetryMaxAttempts: 3, RetryBaseDelay: 500 * time.Millisecond, RetryMaxDelay: 10 * time.Second, Concurrency: 4}, finalCfg.FCM)
+	})
+
+	t.Run("Validation Failure - Unknown Digest Period", func(t *testing.T) {
+		cfg := baseConfig()
+		cfg.Digest.Categories = map[string]string{"likes": "weekly"}
+		_, err := config.UpdateConfigWithEnvOverrides(cfg, logger)
+		assert.Error(t, err)
+
+		cfg = baseConfig()
+		cfg.Digest.DailyAt = "6pm"
+		_, err = config.UpdateConfigWithEnvOverrides(cfg, logger)
+		assert.Error(t, err)
+	})
+
+	t.Run("Validation Failure - Priority Lane Without Topic", func(t *testing.T) {
+		cfg := baseConfig()
+		cfg.PriorityLane.SubscriptionID = "priority-sub"
+		_, err := config.UpdateConfigWithEnvOverrides(cfg, logger)
+		assert.Error(t, err)
+	})
+
+	t.Run("Validation Failure - Unknown Prune Mode", func(t *testing.T) {
+		cfg := baseConfig()
+		cfg.Prune.Mode = "shred"
+		_, err := config.UpdateConfigWithEnvOverrides(cfg, logger)
+		assert.Error(t, err)
+	})
+
+	t.Run("Validation Failure - Unknown APNs Auth", func(t *testing.T) {
+		cfg := baseConfig()
+		cfg.APNs.Auth = "password"
+		_, err := config.UpdateConfigWithEnvOverrides(cfg, logger)
+		assert.Error(t, err)
 	})
 
 	t.Run("Validation Failure - Missing ProjectID", func(t *testing.T) {
@@ -3,6 +3,7 @@ package config
 
 import (
 	"log/slog"
+	"time"
 
 	"github.com/illmade-knight/go-dataflow/pkg/messagepipeline"
 	"github.com/tinywideclouds/go-microservice-base/pkg/middleware"
@@ -26,18 +27,90 @@ type YamlVapidConfig struct {
 	SubscriberEmail string `yaml:"subscriber_email"`
 }
 
+type YamlWebPushConfig struct {
+	Concurrency   int           `yaml:"concurrency"`
+	Timeout       time.Duration `yaml:"timeout"`
+	MaxRetryAfter time.Duration `yaml:"max_retry_after"`
+}
+
+type YamlFCMConfig struct {
+	RetryMaxAttempts int           `yaml:"retry_max_attempts"`
+	RetryBaseDelay   time.Duration `yaml:"retry_base_delay"`
+	RetryMaxDelay    time.Duration `yaml:"retry_max_delay"`
+	Concurrency      int           `yaml:"concurrency"`
+}
+
+type YamlAPNsConfig struct {
+	Auth                string        `yaml:"auth"`
+	KeyID               string        `yaml:"key_id"`
+	TeamID              string        `yaml:"team_id"`
+	BundleID            string        `yaml:"bundle_id"`
+	P8Key               string        `yaml:"p8_key"`
+	Certificate         string        `yaml:"certificate"`
+	CertificatePassword string        `yaml:"certificate_password"`
+	Concurrency         int           `yaml:"concurrency"`
+	RetryMaxAttempts    int           `yaml:"retry_max_attempts"`
+	RetryBaseDelay      time.Duration `yaml:"retry_base_delay"`
+	RetryMaxDelay       time.Duration `yaml:"retry_max_delay"`
+}
+
+type YamlPruneConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	MaxAge    time.Duration `yaml:"max_age"`
+	Interval  time.Duration `yaml:"interval"`
+	Mode      string        `yaml:"mode"`
+	BatchSize int           `yaml:"batch_size"`
+}
+
+type YamlSchedulerConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval"`
+	Lease        time.Duration `yaml:"lease"`
+	BatchSize    int           `yaml:"batch_size"`
+}
+
+type YamlCoalesceConfig struct {
+	Window time.Duration `yaml:"window"`
+}
+
+type YamlDigestConfig struct {
+	Categories map[string]string `yaml:"categories"`
+	DailyAt    string            `yaml:"daily_at"`
+}
+
+type YamlTemplatesConfig struct {
+	Path          string `yaml:"path"`
+	DefaultLocale string `yaml:"default_locale"`
+}
+
+type YamlPriorityLaneConfig struct {
+	TopicID        string `yaml:"topic_id"`
+	SubscriptionID string `yaml:"subscription_id"`
+	NumWorkers     int    `yaml:"num_workers"`
+}
+
 // YamlConfig is the structure that mirrors the raw config.yaml file.
 type YamlConfig struct {
-	ProjectID              string          `yaml:"project_id"`
-	ListenAddr             string          `yaml:"listen_addr"`
-	SubscriberEmail        string          `yaml:"subscriber_email"`
-	TopicID                string          `yaml:"topic_id"`
-	SubscriptionID         string          `yaml:"subscription_id"`
-	SubscriptionDLQTopicID string          `yaml:"subscription_dlq_topic_id"`
-	CorsConfig             YamlCorsConfig  `yaml:"cors"`
-	RedisConfig            YamlRedisConfig `yaml:"redis"`
-	VapidConfig            YamlVapidConfig `yaml:"vapid"` // ✅ Added
-	NumPipelineWorkers     int             `yaml:"num_pipeline_workers"`
+	ProjectID              string                 `yaml:"project_id"`
+	ListenAddr             string                 `yaml:"listen_addr"`
+	SubscriberEmail        string                 `yaml:"subscriber_email"`
+	TopicID                string                 `yaml:"topic_id"`
+	SubscriptionID         string                 `yaml:"subscription_id"`
+	SubscriptionDLQTopicID string                 `yaml:"subscription_dlq_topic_id"`
+	FeedbackTopicID        string                 `yaml:"feedback_topic_id"`
+	CorsConfig             YamlCorsConfig         `yaml:"cors"`
+	RedisConfig            YamlRedisConfig        `yaml:"redis"`
+	VapidConfig            YamlVapidConfig        `yaml:"vapid"` // ✅ Added
+	WebPushConfig          YamlWebPushConfig      `yaml:"web_push"`
+	FCMConfig              YamlFCMConfig          `yaml:"fcm"`
+	APNsConfig             YamlAPNsConfig         `yaml:"apns"`
+	PruneConfig            YamlPruneConfig        `yaml:"prune"`
+	SchedulerConfig        YamlSchedulerConfig    `yaml:"scheduler"`
+	CoalesceConfig         YamlCoalesceConfig     `yaml:"coalesce"`
+	DigestConfig           YamlDigestConfig       `yaml:"digest"`
+	TemplatesConfig        YamlTemplatesConfig    `yaml:"templates"`
+	PriorityLaneConfig     YamlPriorityLaneConfig `yaml:"priority_lane"`
+	NumPipelineWorkers     int                    `yaml:"num_pipeline_workers"`
+	DedupWindow            time.Duration          `yaml:"dedup_window"`
 }
 
 // NewConfigFromYaml converts the YamlConfig into a clean, base Config struct.
@@ -64,8 +137,62 @@ func NewConfigFromYaml(baseCfg *YamlConfig, logger *slog.Logger) (*Config, error
 			PrivateKey:      baseCfg.VapidConfig.PrivateKey,
 			SubscriberEmail: baseCfg.VapidConfig.SubscriberEmail,
 		},
+		WebPush: WebPushConfig{
+			Concurrency:   baseCfg.WebPushConfig.Concurrency,
+			Timeout:       baseCfg.WebPushConfig.Timeout,
+			MaxRetryAfter: baseCfg.WebPushConfig.MaxRetryAfter,
+		},
+		FCM: FCMConfig{
+			RetryMaxAttempts: baseCfg.FCMConfig.RetryMaxAttempts,
+			RetryBaseDelay:   baseCfg.FCMConfig.RetryBaseDelay,
+			RetryMaxDelay:    baseCfg.FCMConfig.RetryMaxDelay,
+			Concurrency:      baseCfg.FCMConfig.Concurrency,
+		},
+		APNs: APNsConfig{
+			Auth:                baseCfg.APNsConfig.Auth,
+			KeyID:               baseCfg.APNsConfig.KeyID,
+			TeamID:              baseCfg.APNsConfig.TeamID,
+			BundleID:            baseCfg.APNsConfig.BundleID,
+			P8Key:               baseCfg.APNsConfig.P8Key,
+			Certificate:         baseCfg.APNsConfig.Certificate,
+			CertificatePassword: baseCfg.APNsConfig.CertificatePassword,
+			Concurrency:         baseCfg.APNsConfig.Concurrency,
+			RetryMaxAttempts:    baseCfg.APNsConfig.RetryMaxAttempts,
+			RetryBaseDelay:      baseCfg.APNsConfig.RetryBaseDelay,
+			RetryMaxDelay:       baseCfg.APNsConfig.RetryMaxDelay,
+		},
+		Prune: PruneConfig{
+			Enabled:   baseCfg.PruneConfig.Enabled,
+			MaxAge:    baseCfg.PruneConfig.MaxAge,
+			Interval:  baseCfg.PruneConfig.Interval,
+			Mode:      baseCfg.PruneConfig.Mode,
+			BatchSize: baseCfg.PruneConfig.BatchSize,
+		},
+		Scheduler: SchedulerConfig{
+			PollInterval: baseCfg.SchedulerConfig.PollInterval,
+			Lease:        baseCfg.SchedulerConfig.Lease,
+			BatchSize:    baseCfg.SchedulerConfig.BatchSize,
+		},
+		Coalesce: CoalesceConfig{
+			Window: baseCfg.CoalesceConfig.Window,
+		},
+		Digest: DigestConfig{
+			Categories: baseCfg.DigestConfig.Categories,
+			DailyAt:    baseCfg.DigestConfig.DailyAt,
+		},
+		Templates: TemplatesConfig{
+			Path:          baseCfg.TemplatesConfig.Path,
+			DefaultLocale: baseCfg.TemplatesConfig.DefaultLocale,
+		},
+		PriorityLane: PriorityLaneConfig{
+			TopicID:        baseCfg.PriorityLaneConfig.TopicID,
+			SubscriptionID: baseCfg.PriorityLaneConfig.SubscriptionID,
+			NumWorkers:     baseCfg.PriorityLaneConfig.NumWorkers,
+		},
 		SubscriptionDLQTopicID: baseCfg.SubscriptionDLQTopicID,
+		FeedbackTopicID:        baseCfg.FeedbackTopicID,
 		NumPipelineWorkers:     baseCfg.NumPipelineWorkers,
+		DedupWindow:            baseCfg.DedupWindow,
 	}
 
 	if cfg.SubscriptionID != "" {
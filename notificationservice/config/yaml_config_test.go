@@ -3,11 +3,13 @@ package config_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tinywideclouds/go-microservice-base/pkg/middleware"
 	"github.com/tinywideclouds/go-notification-service/notificationservice/config"
+	"gopkg.in/yaml.v3"
 )
 
 func TestNewConfigFromYaml(t *testing.T) {
@@ -20,7 +22,9 @@ func TestNewConfigFromYaml(t *testing.T) {
 			TopicID:                "yaml-topic",
 			SubscriptionID:         "yaml-subscription",
 			SubscriptionDLQTopicID: "yaml-dlq",
+			FeedbackTopicID:        "yaml-feedback",
 			NumPipelineWorkers:     5,
+			DedupWindow:            15 * time.Minute,
 			CorsConfig: config.YamlCorsConfig{
 				AllowedOrigins: []string{"http://yaml.com"},
 				Role:           "editor",
@@ -31,6 +35,30 @@ func TestNewConfigFromYaml(t *testing.T) {
 				PrivateKey:      "yaml-private-key",
 				SubscriberEmail: "yaml@test.com",
 			},
+			WebPushConfig: config.YamlWebPushConfig{Concurrency: 4, Timeout: 5 * time.Second, MaxRetryAfter: 2 * time.Second},
+			FCMConfig:     config.YamlFCMConfig{RetryMaxAttempts: 4, RetryBaseDelay: time.Second, RetryMaxDelay: 20 * time.Second, Concurrency: 2},
+			APNsConfig: config.YamlAPNsConfig{
+				Auth:             "token",
+				KeyID:            "yaml-key-id",
+				TeamID:           "yaml-team-id",
+				BundleID:         "com.yaml.app",
+				P8Key:            "yaml-p8-content",
+				Concurrency:      24,
+				RetryMaxAttempts: 2,
+				RetryBaseDelay:   time.Second,
+				RetryMaxDelay:    5 * time.Second,
+			},
+			CoalesceConfig: config.YamlCoalesceConfig{Window: 30 * time.Second},
+			DigestConfig: config.YamlDigestConfig{
+				Categories: map[string]string{"likes": "hourly"},
+				DailyAt:    "08:00",
+			},
+			TemplatesConfig: config.YamlTemplatesConfig{Path: "templates/", DefaultLocale: "en"},
+			PriorityLaneConfig: config.YamlPriorityLaneConfig{
+				TopicID:        "yaml-priority-topic",
+				SubscriptionID: "yaml-priority-sub",
+				NumWorkers:     3,
+			},
 		}
 
 		cfg, err := config.NewConfigFromYaml(yamlCfg, logger)
@@ -44,7 +72,9 @@ func TestNewConfigFromYaml(t *testing.T) {
 		assert.Equal(t, "yaml-topic", cfg.TopicID)
 		assert.Equal(t, "yaml-subscription", cfg.SubscriptionID)
 		assert.Equal(t, "yaml-dlq", cfg.SubscriptionDLQTopicID)
+		assert.Equal(t, "yaml-feedback", cfg.FeedbackTopicID)
 		assert.Equal(t, 5, cfg.NumPipelineWorkers)
+		assert.Equal(t, 15*time.Minute, cfg.DedupWindow)
 
 		// 2. Complex Logic: CORS
 		assert.Equal(t, []string{"http://yaml.com"}, cfg.CorsConfig.AllowedOrigins)
@@ -55,6 +85,32 @@ func TestNewConfigFromYaml(t *testing.T) {
 		assert.Equal(t, "yaml-private-key", cfg.Vapid.PrivateKey)
 		assert.Equal(t, "yaml@test.com", cfg.Vapid.SubscriberEmail)
 
+		assert.Equal(t, config.WebPushConfig{Concurrency: 4, Timeout: 5 * time.Second, MaxRetryAfter: 2 * time.Second}, cfg.WebPush)
+		assert.Equal(t, config.FCMConfig{RetryMaxAttempts: 4, RetryBaseDelay: time.Second, RetryMaxDelay: 20 * time.Second, Concurrency: 2}, cfg.FCM)
+
+		// 4. Verify APNs
+		assert.Equal(t, "yaml-key-id", cfg.APNs.KeyID)
+		assert.Equal(t, "yaml-team-id", cfg.APNs.TeamID)
+		assert.Equal(t, "com.yaml.app", cfg.APNs.BundleID)
+		assert.Equal(t, "yaml-p8-content", cfg.APNs.P8Key)
+		assert.Equal(t, 24, cfg.APNs.Concurrency)
+		assert.Equal(t, 2, cfg.APNs.RetryMaxAttempts)
+		assert.Equal(t, time.Second, cfg.APNs.RetryBaseDelay)
+		assert.Equal(t, 5*time.Second, cfg.APNs.RetryMaxDelay)
+		assert.Equal(t, "token", cfg.APNs.Auth)
+		assert.True(t, cfg.APNs.Enabled())
+
+		assert.Equal(t, 30*time.Second, cfg.Coalesce.Window)
+		assert.Equal(t, config.TemplatesConfig{Path: "templates/", DefaultLocale: "en"}, cfg.Templates)
+		assert.Equal(t, config.DigestConfig{Categories: map[string]string{"likes": "hourly"}, DailyAt: "08:00"}, cfg.Digest)
+
+		// 5. Verify Priority Lane
+		assert.Equal(t, config.PriorityLaneConfig{
+			TopicID:        "yaml-priority-topic",
+			SubscriptionID: "yaml-priority-sub",
+			NumWorkers:     3,
+		}, cfg.PriorityLane)
+
 		assert.NotNil(t, cfg.PubsubConsumerConfig)
 	})
 
@@ -71,5 +127,19 @@ func TestNewConfigFromYaml(t *testing.T) {
 		assert.Equal(t, 0, cfg.NumPipelineWorkers)
 		assert.Empty(t, cfg.ListenAddr)
 		assert.Empty(t, cfg.Vapid.PublicKey) // Verify zero value
+		assert.False(t, cfg.APNs.Enabled())
+	})
+
+	t.Run("Success - Parses duration strings from YAML", func(t *testing.T) {
+		var yamlCfg config.YamlConfig
+		err := yaml.Unmarshal([]byte("project_id: p\ndedup_window: 2m\nprune:\n  enabled: true\n  max_age: 720h\n  mode: quarantine\n"), &yamlCfg)
+		require.NoError(t, err)
+
+		cfg, err := config.NewConfigFromYaml(&yamlCfg, logger)
+		require.NoError(t, err)
+		assert.Equal(t, 2*time.Minute, cfg.DedupWindow)
+		assert.True(t, cfg.Prune.Enabled)
+		assert.Equal(t, 720*time.Hour, cfg.Prune.MaxAge)
+		assert.Equal(t, "quarantine", cfg.Prune.Mode)
 	})
 }
@@ -6,21 +6,142 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/illmade-knight/go-dataflow/pkg/messagepipeline"
 	"github.com/tinywideclouds/go-microservice-base/pkg/microservice"
 	"github.com/tinywideclouds/go-microservice-base/pkg/middleware"
 	"github.com/tinywideclouds/go-notification-service/internal/api"
 	"github.com/tinywideclouds/go-notification-service/internal/pipeline"
+	"github.com/tinywideclouds/go-notification-service/internal/prune"
+	"github.com/tinywideclouds/go-notification-service/internal/scheduler"
 	"github.com/tinywideclouds/go-notification-service/notificationservice/config"
 	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
-	notification "github.com/tinywideclouds/go-platform/pkg/notification/v1"
 )
 
+// Option configures optional service components.
+type Option func(*options)
+
+type options struct {
+	processorOpts []pipeline.ProcessorOption
+	preferences   dispatch.PreferencesStore
+	pruner        *prune.Job
+	pruneInterval time.Duration
+
+	schedule         dispatch.ScheduleStore
+	schedulerConfig  scheduler.Config
+	scheduleInterval time.Duration
+	digests          dispatch.DigestStore
+
+	priorityConsumer messagepipeline.MessageConsumer
+	priorityWorkers  int
+}
+
+// WithDeliveryLedger enables per-device delivery tracking so that Pub/Sub
+// redeliveries do not re-notify devices that were already served.
+func WithDeliveryLedger(ledger dispatch.DeliveryLedger) Option {
+	return func(o *options) {
+		o.processorOpts = append(o.processorOpts, pipeline.WithDeliveryLedger(ledger))
+	}
+}
+
+// WithDeduplication drops repeated requests (same RequestID, different Pub/Sub
+// message) that arrive within the window.
+func WithDeduplication(store dispatch.DedupStore, window time.Duration) Option {
+	return func(o *options) {
+		o.processorOpts = append(o.processorOpts, pipeline.WithDeduplication(store, window))
+	}
+}
+
+// WithFeedback publishes delivery-feedback events (TokenInvalidated,
+// NotificationDelivered, NotificationFailed) for every dispatch.
+func WithFeedback(publisher dispatch.FeedbackPublisher) Option {
+	return func(o *options) {
+		o.processorOpts = append(o.processorOpts, pipeline.WithFeedback(publisher))
+	}
+}
+
+// WithPreferences enables user notification preferences: the processor honours
+// mute and per-category opt-outs, and GET/PUT /api/v1/preferences are served.
+func WithPreferences(store dispatch.PreferencesStore) Option {
+	return func(o *options) {
+		o.preferences = store
+		o.processorOpts = append(o.processorOpts, pipeline.WithPreferences(store))
+	}
+}
+
+// WithDeferredDelivery enables the durable delayed-delivery queue. The processor
+// parks scheduled (deliverAt) and deferred (quiet hours) notifications in store,
+// and the service polls it every interval, replaying due notifications through
+// the processor. Producers cancel a pending notification with a cancel command on
+// the topic; users with DELETE /api/v1/scheduled/{id}.
+func WithDeferredDelivery(store dispatch.ScheduleStore, cfg scheduler.Config, interval time.Duration) Option {
+	return func(o *options) {
+		o.schedule = store
+		o.schedulerConfig = cfg
+		o.scheduleInterval = interval
+		o.processorOpts = append(o.processorOpts, pipeline.WithScheduleStore(store))
+	}
+}
+
+// WithCoalescing delivers bursts of requests with the same recipient and collapse
+// key as one summary notification, window after the first. It needs WithDeferredDelivery,
+// whose poller performs the flush.
+func WithCoalescing(store dispatch.CoalesceStore, window time.Duration) Option {
+	return func(o *options) {
+		o.processorOpts = append(o.processorOpts, pipeline.WithCoalescing(store, window))
+	}
+}
+
+// WithDigests rolls requests in digest categories (defaults in categories, overridable
+// per user) up into hourly or daily summaries. It needs WithDeferredDelivery, whose
+// poller delivers each digest and then removes it from store.
+func WithDigests(store dispatch.DigestStore, categories map[string]dispatch.DigestPeriod, dailyAt string) Option {
+	return func(o *options) {
+		o.digests = store
+		o.processorOpts = append(o.processorOpts, pipeline.WithDigests(store, categories, dailyAt))
+	}
+}
+
+// WithTemplates renders templated requests per device locale.
+func WithTemplates(renderer dispatch.TemplateRenderer) Option {
+	return func(o *options) {
+		o.processorOpts = append(o.processorOpts, pipeline.WithTemplates(renderer))
+	}
+}
+
+// WithPriorityLane consumes a second subscription with its own worker pool, so
+// high-priority traffic isn't stuck behind a burst of bulk sends on the main one.
+// Requests on the lane default to high priority.
+func WithPriorityLane(consumer messagepipeline.MessageConsumer, numWorkers int) Option {
+	return func(o *options) {
+		o.priorityConsumer = consumer
+		o.priorityWorkers = numWorkers
+	}
+}
+
+// WithPruner runs the stale-device pruning job every interval while the service is up.
+func WithPruner(job *prune.Job, interval time.Duration) Option {
+	return func(o *options) {
+		o.pruner = job
+		o.pruneInterval = interval
+	}
+}
+
 type Wrapper struct {
 	*microservice.BaseServer
-	pipelineService *messagepipeline.StreamingService[notification.NotificationRequest]
+	pipelineService *messagepipeline.StreamingService[dispatch.Request]
+	priorityService *messagepipeline.StreamingService[dispatch.Request] // Optional lane
 	logger          *slog.Logger
+
+	// Background jobs (optional)
+	pruner           *prune.Job
+	pruneInterval    time.Duration
+	scheduler        *scheduler.Scheduler
+	scheduleInterval time.Duration
+	stopJobs         context.CancelFunc
+	jobs             sync.WaitGroup
 }
 
 // New assembles the service.
@@ -28,17 +149,46 @@ func New(
 	cfg *config.Config,
 	consumer messagepipeline.MessageConsumer,
 	fcmDispatcher dispatch.Dispatcher,
+	apnsDispatcher dispatch.Dispatcher, // Optional: nil disables the native iOS path
 	webDispatcher dispatch.WebDispatcher,
 	tokenStore dispatch.TokenStore,
 	authMiddleware func(http.Handler) http.Handler,
 	logger *slog.Logger,
+	opts ...Option,
 ) (*Wrapper, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
 
 	// 1. Base Server
 	baseServer := microservice.NewBaseServer(logger, cfg.ListenAddr)
 
 	// 2. Processor
-	processor := pipeline.NewProcessor(fcmDispatcher, webDispatcher, tokenStore, logger)
+	processor := pipeline.NewProcessor(fcmDispatcher, apnsDispatcher, webDispatcher, tokenStore, logger, o.processorOpts...)
+
+	// Scheduler (optional): replays deferred notifications through the same processor
+	var deferred *scheduler.Scheduler
+	if o.schedule != nil {
+		deferred = scheduler.New(o.schedulerConfig, o.schedule, func(ctx context.Context, n dispatch.ScheduledNotification) error {
+			// Reusing the original Pub/Sub message ID keeps the dedup claim valid
+			original := messagepipeline.Message{
+				MessageData: messagepipeline.MessageData{ID: n.MessageID},
+				Attributes:  map[string]string{dispatch.ReplayReasonAttribute: n.Reason},
+			}
+			request := n.Request
+			if err := processor(ctx, original, &request); err != nil {
+				return err
+			}
+			if n.Reason == dispatch.ScheduleReasonDigest && o.digests != nil {
+				// Delivered (or deliberately dropped): the next period starts afresh
+				if err := o.digests.Delete(ctx, n.Request.RecipientID, n.Request.DeliverAt); err != nil {
+					logger.Warn("Failed to delete delivered digest", "id", n.ID, "err", err)
+				}
+			}
+			return nil
+		}, logger)
+	}
 
 	// 3. Pipeline
 	streamingService, err := messagepipeline.NewStreamingService(
@@ -52,6 +202,21 @@ func New(
 		return nil, fmt.Errorf("failed to create streaming service: %w", err)
 	}
 
+	// Priority lane (optional): same processor, separate subscription and workers
+	var priorityService *messagepipeline.StreamingService[dispatch.Request]
+	if o.priorityConsumer != nil {
+		priorityService, err = messagepipeline.NewStreamingService(
+			messagepipeline.StreamingServiceConfig{NumWorkers: o.priorityWorkers},
+			o.priorityConsumer,
+			pipeline.PriorityLaneTransformer(dispatch.PriorityHigh),
+			processor,
+			logger.With("lane", "priority"),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create priority streaming service: %w", err)
+		}
+	}
+
 	// 4. API (Token Registration)
 	tokenAPI := api.NewTokenAPI(tokenStore, logger)
 
@@ -68,13 +233,32 @@ func New(
 
 	// 1. Registration Paths (Segregated)
 	handle("POST /api/v1/register/fcm", tokenAPI.RegisterFCM)
+	handle("POST /api/v1/register/apns", tokenAPI.RegisterAPNs)
 	handle("POST /api/v1/register/web", tokenAPI.RegisterWeb)
 
 	// 2. Unregistration Paths (Segregated)
 	handle("POST /api/v1/unregister/fcm", tokenAPI.UnregisterFCM)
+	handle("POST /api/v1/unregister/apns", tokenAPI.UnregisterAPNs)
 	handle("POST /api/v1/unregister/web", tokenAPI.UnregisterWeb)
 
-	// 3. Global OPTIONS for the API namespace (CORS preflight)
+	// 3. Device Management (list / revoke my devices)
+	handle("GET /api/v1/devices", tokenAPI.ListDevices)
+	handle("DELETE /api/v1/devices/{id}", tokenAPI.DeleteDevice)
+
+	// 4. Preferences (mute / per-category opt-out)
+	if o.preferences != nil {
+		preferencesAPI := api.NewPreferencesAPI(o.preferences, logger)
+		handle("GET /api/v1/preferences", preferencesAPI.GetPreferences)
+		handle("PUT /api/v1/preferences", preferencesAPI.PutPreferences)
+	}
+
+	// 5. Scheduled notifications (cancel before they are due)
+	if o.schedule != nil {
+		scheduleAPI := api.NewScheduleAPI(o.schedule, logger)
+		handle("DELETE /api/v1/scheduled/{id...}", scheduleAPI.CancelScheduled)
+	}
+
+	// 6. Global OPTIONS for the API namespace (CORS preflight)
 	mux.Handle("OPTIONS /api/v1/", corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Just returns 200 OK with CORS headers handled by middleware
 	})))
@@ -82,9 +266,14 @@ func New(
 	// --- REFACTORED ROUTES END ---
 
 	return &Wrapper{
-		BaseServer:      baseServer,
-		pipelineService: streamingService,
-		logger:          logger,
+		BaseServer:       baseServer,
+		pipelineService:  streamingService,
+		priorityService:  priorityService,
+		logger:           logger,
+		pruner:           o.pruner,
+		pruneInterval:    o.pruneInterval,
+		scheduler:        deferred,
+		scheduleInterval: o.scheduleInterval,
 	}, nil
 }
 
@@ -94,6 +283,13 @@ func (w *Wrapper) Start(ctx context.Context) error {
 	if err := w.pipelineService.Start(ctx); err != nil {
 		return fmt.Errorf("failed to start processing service: %w", err)
 	}
+	if w.priorityService != nil {
+		if err := w.priorityService.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start priority lane: %w", err)
+		}
+		w.logger.Info("Priority lane started.")
+	}
+	w.startJobs(ctx)
 	w.SetReady(true)
 	w.logger.Info("Service is now ready.")
 	return w.BaseServer.Start()
@@ -102,10 +298,17 @@ func (w *Wrapper) Start(ctx context.Context) error {
 func (w *Wrapper) Shutdown(ctx context.Context) error {
 	w.logger.Info("Shutting down service components...")
 	var finalErr error
+	w.stopBackgroundJobs()
 	if err := w.pipelineService.Stop(ctx); err != nil {
 		w.logger.Error("Processing pipeline shutdown failed.", "err", err)
 		finalErr = err
 	}
+	if w.priorityService != nil {
+		if err := w.priorityService.Stop(ctx); err != nil {
+			w.logger.Error("Priority lane shutdown failed.", "err", err)
+			finalErr = err
+		}
+	}
 	if err := w.BaseServer.Shutdown(ctx); err != nil {
 		w.logger.Error("HTTP server shutdown failed.", "err", err)
 		finalErr = err
@@ -113,3 +316,34 @@ func (w *Wrapper) Shutdown(ctx context.Context) error {
 	w.logger.Info("Service shutdown complete.")
 	return finalErr
 }
+
+// startJobs launches the optional background jobs. They stop on Shutdown.
+func (w *Wrapper) startJobs(ctx context.Context) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	w.stopJobs = cancel
+
+	if w.pruner != nil {
+		w.logger.Info("Stale device pruning scheduled", "interval", w.pruneInterval)
+		w.jobs.Add(1)
+		go func() {
+			defer w.jobs.Done()
+			w.pruner.Run(jobCtx, w.pruneInterval)
+		}()
+	}
+
+	if w.scheduler != nil {
+		w.logger.Info("Deferred delivery polling started", "interval", w.scheduleInterval)
+		w.jobs.Add(1)
+		go func() {
+			defer w.jobs.Done()
+			w.scheduler.Run(jobCtx, w.scheduleInterval)
+		}()
+	}
+}
+
+func (w *Wrapper) stopBackgroundJobs() {
+	if w.stopJobs != nil {
+		w.stopJobs()
+	}
+	w.jobs.Wait()
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/tinywideclouds/go-notification-service/notificationservice"
 	"github.com/tinywideclouds/go-notification-service/notificationservice/config"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 	"github.com/tinywideclouds/go-platform/pkg/net/v1"
 	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
 	"google.golang.org/protobuf/types/known/durationpb"
@@ -35,11 +36,17 @@ type mockTokenStore struct {
 	mock.Mock
 }
 
-func (m *mockTokenStore) RegisterFCM(ctx context.Context, userURN urn.URN, token string) error {
-	return m.Called(ctx, userURN, token).Error(0)
+func (m *mockTokenStore) RegisterFCM(ctx context.Context, userURN urn.URN, token string, meta dispatch.DeviceMetadata) error {
+	return m.Called(ctx, userURN, token, meta).Error(0)
+}
+func (m *mockTokenStore) RegisterWeb(ctx context.Context, userURN urn.URN, sub notification.WebPushSubscription, meta dispatch.DeviceMetadata) error {
+	return m.Called(ctx, userURN, sub, meta).Error(0)
+}
+func (m *mockTokenStore) RegisterAPNs(ctx context.Context, userURN urn.URN, token string, meta dispatch.DeviceMetadata) error {
+	return m.Called(ctx, userURN, token, meta).Error(0)
 }
-func (m *mockTokenStore) RegisterWeb(ctx context.Context, userURN urn.URN, sub notification.WebPushSubscription) error {
-	return m.Called(ctx, userURN, sub).Error(0)
+func (m *mockTokenStore) UnregisterAPNs(ctx context.Context, userURN urn.URN, token string) error {
+	return m.Called(ctx, userURN, token).Error(0)
 }
 func (m *mockTokenStore) UnregisterFCM(ctx context.Context, userURN urn.URN, token string) error {
 	return m.Called(ctx, userURN, token).Error(0)
@@ -47,20 +54,27 @@ func (m *mockTokenStore) UnregisterFCM(ctx context.Context, userURN urn.URN, tok
 func (m *mockTokenStore) UnregisterWeb(ctx context.Context, userURN urn.URN, endpoint string) error {
 	return m.Called(ctx, userURN, endpoint).Error(0)
 }
-func (m *mockTokenStore) Fetch(ctx context.Context, userURN urn.URN) (*notification.NotificationRequest, error) {
+func (m *mockTokenStore) ListDevices(ctx context.Context, u urn.URN) ([]dispatch.Device, error) {
+	args := m.Called(ctx, u)
+	return args.Get(0).([]dispatch.Device), args.Error(1)
+}
+func (m *mockTokenStore) DeleteDevice(ctx context.Context, u urn.URN, deviceID string) error {
+	return m.Called(ctx, u, deviceID).Error(0)
+}
+func (m *mockTokenStore) Fetch(ctx context.Context, userURN urn.URN) (*dispatch.RecipientDevices, error) {
 	args := m.Called(ctx, userURN)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).(*notification.NotificationRequest), args.Error(1)
+	return args.Get(0).(*dispatch.RecipientDevices), args.Error(1)
 }
 
 // Reuse mockWebDispatcher from service_integration_test.go if in same package,
 // otherwise redefine here. Redefining for safety.
 type mockPoisonWebDispatcher struct{}
 
-func (m *mockPoisonWebDispatcher) Dispatch(ctx context.Context, subs []notification.WebPushSubscription, c notification.NotificationContent, d map[string]string) (string, []notification.WebPushSubscription, error) {
-	return "", nil, nil
+func (m *mockPoisonWebDispatcher) Dispatch(ctx context.Context, subs []notification.WebPushSubscription, msg dispatch.Message) (*dispatch.DispatchResult, error) {
+	return dispatch.NewDispatchResult(dispatch.PlatformWeb), nil
 }
 
 // --- Test ---
@@ -131,6 +145,7 @@ func TestNotificationService_PoisonPill(t *testing.T) {
 		cfg,
 		consumer,
 		fcmDispatcher,
+		nil, // APNs not under test
 		webDispatcher,
 		tokenStore,
 		noopAuth,
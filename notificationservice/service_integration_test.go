@@ -24,6 +24,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tinywideclouds/go-notification-service/notificationservice"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
 	"github.com/tinywideclouds/go-platform/pkg/net/v1"
 	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
 	"google.golang.org/protobuf/types/known/durationpb"
@@ -45,15 +46,22 @@ type mockDispatcher struct {
 func newMockDispatcher(failOnCount int) *mockDispatcher {
 	return &mockDispatcher{failOnCount: failOnCount}
 }
-func (m *mockDispatcher) Dispatch(ctx context.Context, tokens []string, content notification.NotificationContent, data map[string]string) (string, []string, error) {
+func (m *mockDispatcher) Dispatch(ctx context.Context, tokens []string, msg dispatch.Message) (*dispatch.DispatchResult, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.callCount++
 	m.lastTokens = tokens
+	result := dispatch.NewDispatchResult(dispatch.PlatformFCM)
 	if m.failOnCount > 0 && m.callCount == m.failOnCount {
-		return "", nil, errors.New("fail")
+		for _, t := range tokens {
+			result.Add(dispatch.DeviceResult{Address: t, Outcome: dispatch.OutcomeRetryable})
+		}
+		return result, errors.New("fail")
+	}
+	for _, t := range tokens {
+		result.Add(dispatch.DeviceResult{Address: t, Outcome: dispatch.OutcomeDelivered, ProviderMessageID: "123-343-success"})
 	}
-	return "123-343-success", nil, nil
+	return result, nil
 }
 func (m *mockDispatcher) GetCallCount() int {
 	m.mu.Lock()
@@ -71,11 +79,11 @@ type mockWebDispatcher struct {
 	mu sync.Mutex
 }
 
-func (m *mockWebDispatcher) Dispatch(ctx context.Context, subs []notification.WebPushSubscription, content notification.NotificationContent, data map[string]string) (string, []notification.WebPushSubscription, error) {
+func (m *mockWebDispatcher) Dispatch(ctx context.Context, subs []notification.WebPushSubscription, msg dispatch.Message) (*dispatch.DispatchResult, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	// No-op for this test, but must exist
-	return "web-success", nil, nil
+	return dispatch.NewDispatchResult(dispatch.PlatformWeb), nil
 }
 
 // --- TEST ---
@@ -118,6 +126,7 @@ func TestNotificationService_Integration(t *testing.T) {
 			&config.Config{ListenAddr: ":0", NumPipelineWorkers: 2}, // Mock Config
 			consumer,
 			fcmDispatcher, // Explicit FCM
+			nil,           // APNs not under test
 			webDispatcher, // Explicit Web
 			tokenStore,
 			func(h http.Handler) http.Handler { return h }, // No-op Auth
@@ -133,7 +142,7 @@ func TestNotificationService_Integration(t *testing.T) {
 
 		// Step A: Register Token (Using new RegisterFCM method)
 		userURN, _ := urn.Parse("urn:sm:user:integ-user")
-		err = tokenStore.RegisterFCM(ctx, userURN, "android-token-999")
+		err = tokenStore.RegisterFCM(ctx, userURN, "android-token-999", dispatch.DeviceMetadata{OS: "android"})
 		require.NoError(t, err)
 
 		// Step B: Publish Message (WITHOUT TOKENS)
@@ -143,8 +152,10 @@ func TestNotificationService_Integration(t *testing.T) {
 			Content:     notification.NotificationContent{Title: "Hello"},
 		}
 		payload, _ := json.Marshal(req)
+		// Producers publish the request inside a MessageData envelope
+		envelope, _ := json.Marshal(messagepipeline.MessageData{ID: uuid.NewString(), Payload: payload})
 
-		psClient.Publisher(topicID).Publish(ctx, &pubsub.Message{Data: payload}).Get(ctx)
+		psClient.Publisher(topicID).Publish(ctx, &pubsub.Message{Data: envelope}).Get(ctx)
 
 		// Assert: FCM Dispatcher called with the token we registered in Step A
 		require.Eventually(t, func() bool {
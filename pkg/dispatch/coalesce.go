@@ -0,0 +1,74 @@
+// --- File: pkg/dispatch/coalesce.go ---
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+// CoalescedCountDataKey is the DataPayload key carrying how many requests a
+// coalesced notification stands for.
+const CoalescedCountDataKey = "coalescedCount"
+
+// Burst is a run of requests for one recipient and collapse key that is being
+// held back so it can be delivered as a single notification.
+type Burst struct {
+	// MessageIDs are the distinct Pub/Sub messages folded into the burst.
+	// Holding a redelivered message again does not count it twice.
+	MessageIDs []string `json:"messageIds"`
+	// Latest is the most recently held request; its content leads the summary.
+	Latest Request `json:"latest"`
+}
+
+// Count is the number of requests in the burst.
+func (b *Burst) Count() int {
+	return len(b.MessageIDs)
+}
+
+// Summary builds the one notification that replaces the burst: the latest
+// request, with the count in its data and, for several requests, in the body.
+// Templated requests get the count as a template variable instead.
+func (b *Burst) Summary() Request {
+	summary := b.Latest
+	count := b.Count()
+	data := make(map[string]string, len(summary.DataPayload)+1)
+	for k, v := range summary.DataPayload {
+		data[k] = v
+	}
+	data[CoalescedCountDataKey] = strconv.Itoa(count)
+	summary.DataPayload = data
+	if summary.Template != "" {
+		vars := make(map[string]string, len(summary.TemplateVars)+1)
+		for k, v := range summary.TemplateVars {
+			vars[k] = v
+		}
+		vars[CoalescedCountDataKey] = strconv.Itoa(count)
+		summary.TemplateVars = vars
+	}
+	if count > 1 {
+		summary.Content.Body = fmt.Sprintf("%s (+%d more)", summary.Content.Body, count-1)
+	}
+	return summary
+}
+
+// CoalesceGroup is the key bursts are grouped on.
+func CoalesceGroup(recipient urn.URN, collapseKey string) string {
+	return recipient.String() + "|" + collapseKey
+}
+
+// CoalesceStore holds bursts until they are flushed.
+type CoalesceStore interface {
+	// Hold adds the request carried by messageID to the group's open burst,
+	// opening one when there is none. It returns the message that opened the
+	// burst: messageID itself means the caller (or an earlier delivery of the
+	// same message) opened it and is responsible for arranging the flush.
+	Hold(ctx context.Context, group, messageID string, request Request) (opener string, err error)
+	// Take closes the burst opened by opener and returns it; requests held
+	// afterwards start a new burst. Taking the same burst again returns it
+	// again, so a failed flush can be retried. It returns nil when the burst
+	// is unknown (e.g. it expired).
+	Take(ctx context.Context, group, opener string) (*Burst, error)
+}
@@ -0,0 +1,15 @@
+// --- File: pkg/dispatch/dedup.go ---
+package dispatch
+
+import (
+	"context"
+	"time"
+)
+
+// DedupStore remembers which message first claimed an idempotency key.
+type DedupStore interface {
+	// Claim records owner as the holder of key for the given window, unless the
+	// key is already held. It returns the current holder: owner itself when the
+	// claim succeeded or when the same owner claims again (e.g. a Pub/Sub redelivery).
+	Claim(ctx context.Context, key, owner string, window time.Duration) (string, error)
+}
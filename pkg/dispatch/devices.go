@@ -0,0 +1,132 @@
+// --- File: pkg/dispatch/devices.go ---
+package dispatch
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
+)
+
+// DeviceMetadata is what the client tells us about a device at registration.
+// Every field is optional; older clients send none of them.
+type DeviceMetadata struct {
+	// OS is the client operating system ("android", "ios", "web", ...). It tells
+	// Android apart from iOS-via-FCM, which share the FCM path.
+	OS         string `json:"os,omitempty"`
+	AppVersion string `json:"appVersion,omitempty"`
+	// Locale is a BCP 47 tag (e.g. "en-GB").
+	Locale string `json:"locale,omitempty"`
+	// Timezone is an IANA zone name (e.g. "Europe/London").
+	Timezone string `json:"timezone,omitempty"`
+	// Name is a human-readable label (e.g. "Pixel 8 (work)").
+	Name string `json:"name,omitempty"`
+	// APNsEnvironment is the APNs gateway a native iOS token was issued for
+	// (APNsProduction or APNsDevelopment). Empty means production.
+	APNsEnvironment string `json:"apnsEnvironment,omitempty"`
+}
+
+// APNs environments. Apps built for development (e.g. run from Xcode) get
+// tokens from the sandbox gateway, which production does not recognise.
+const (
+	APNsProduction  = "production"
+	APNsDevelopment = "development"
+)
+
+// Validate checks the fields that policies depend on.
+func (m DeviceMetadata) Validate() error {
+	switch m.APNsEnvironment {
+	case "", APNsProduction, APNsDevelopment:
+	default:
+		return fmt.Errorf("invalid APNs environment %q (want %s or %s)", m.APNsEnvironment, APNsProduction, APNsDevelopment)
+	}
+	if m.Timezone != "" {
+		if _, err := time.LoadLocation(m.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q: %w", m.Timezone, err)
+		}
+	}
+	return nil
+}
+
+// Location returns the device's time zone, or nil when it is unknown.
+func (m DeviceMetadata) Location() *time.Location {
+	if m.Timezone == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(m.Timezone)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
+
+// RecipientDevices is the result of a TokenStore fan-out lookup.
+// Each delivery path gets its own bucket so the processor can hand it
+// straight to the matching dispatcher.
+type RecipientDevices struct {
+	RecipientID      urn.URN                            `json:"recipientId"`
+	FCMTokens        []string                           `json:"fcmTokens"`
+	APNsTokens       []string                           `json:"apnsTokens"`
+	WebSubscriptions []notification.WebPushSubscription `json:"webSubscriptions"`
+	// Metadata holds the registration metadata, keyed by DeviceKey(platform, address).
+	// Devices registered without metadata have no entry.
+	Metadata map[string]DeviceMetadata `json:"metadata,omitempty"`
+}
+
+// IsEmpty reports whether the recipient has no registered devices on any path.
+func (d *RecipientDevices) IsEmpty() bool {
+	return len(d.FCMTokens) == 0 && len(d.APNsTokens) == 0 && len(d.WebSubscriptions) == 0
+}
+
+// MetadataFor returns the metadata of a device, or the zero value when none was registered.
+func (d *RecipientDevices) MetadataFor(platform, address string) DeviceMetadata {
+	return d.Metadata[DeviceKey(platform, address)]
+}
+
+// SetMetadata attaches metadata to a device. Empty metadata is not stored.
+func (d *RecipientDevices) SetMetadata(platform, address string, meta DeviceMetadata) {
+	if meta == (DeviceMetadata{}) {
+		return
+	}
+	if d.Metadata == nil {
+		d.Metadata = make(map[string]DeviceMetadata)
+	}
+	d.Metadata[DeviceKey(platform, address)] = meta
+}
+
+// Location returns the time zone of the recipient's devices, or nil when none
+// registered one. Devices are checked in a stable order so the answer doesn't flap.
+func (d *RecipientDevices) Location() *time.Location {
+	keys := make([]string, 0, len(d.Metadata))
+	for k := range d.Metadata {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		if loc := d.Metadata[k].Location(); loc != nil {
+			return loc
+		}
+	}
+	return nil
+}
+
+// ErrDeviceNotFound is returned when a device ID does not belong to the user.
+var ErrDeviceNotFound = errors.New("device not found")
+
+// Device is one registered device, as listed for device management.
+type Device struct {
+	// ID is the store's stable identifier for the device (not the token).
+	ID       string `json:"id"`
+	Platform string `json:"platform"`
+	// Address is the raw FCM/APNs token or Web Push endpoint. Never expose it unmasked.
+	Address      string         `json:"address"`
+	Metadata     DeviceMetadata `json:"metadata"`
+	RegisteredAt time.Time      `json:"registeredAt"`
+	// UpdatedAt is refreshed on every (re-)registration, i.e. "last seen".
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Quarantined devices were pruned as stale; they receive nothing until re-registered.
+	Quarantined bool `json:"quarantined,omitempty"`
+}
@@ -0,0 +1,149 @@
+// --- File: pkg/dispatch/digest.go ---
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
+)
+
+// DigestPeriod is how often a category's notifications are rolled up.
+type DigestPeriod string
+
+const (
+	DigestHourly DigestPeriod = "hourly"
+	DigestDaily  DigestPeriod = "daily"
+	// DigestImmediate lets a user opt a category back out of a configured digest.
+	DigestImmediate DigestPeriod = "immediate"
+)
+
+// Valid reports whether p is a known period.
+func (p DigestPeriod) Valid() bool {
+	return p == DigestHourly || p == DigestDaily || p == DigestImmediate
+}
+
+// End returns when the period containing t closes: the top of the next hour,
+// or the next dailyAt ("HH:MM") wall-clock time in loc.
+func (p DigestPeriod) End(t time.Time, dailyAt string, loc *time.Location) (time.Time, error) {
+	local := t.In(loc)
+	switch p {
+	case DigestHourly:
+		return time.Date(local.Year(), local.Month(), local.Day(), local.Hour()+1, 0, 0, 0, loc), nil
+	case DigestDaily:
+		at, err := parseClock(dailyAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid daily digest time: %w", err)
+		}
+		end := time.Date(local.Year(), local.Month(), local.Day(), at/60, at%60, 0, 0, loc)
+		if !end.After(local) {
+			end = end.AddDate(0, 0, 1)
+		}
+		return end, nil
+	default:
+		return time.Time{}, fmt.Errorf("period %q has no end", p)
+	}
+}
+
+// DigestCountDataKey is the DataPayload key carrying how many notifications a
+// digest stands for.
+const DigestCountDataKey = "digestCount"
+
+// DigestCollapseKey makes each digest replace the previous one on the device.
+const DigestCollapseKey = "digest"
+
+// DigestTemplate is the template a digest of several entries is rendered with
+// in each device's locale. Its variables are DigestCountDataKey (the total) and
+// DigestCategoriesVar. Without it, the English summary is sent.
+const DigestTemplate = "digest"
+
+// DigestCategoriesVar is the DigestTemplate variable holding the count per
+// category, e.g. "likes (2), follows (1)".
+const DigestCategoriesVar = "digestCategories"
+
+// DigestEntry is one notification accumulated into a digest.
+type DigestEntry struct {
+	// MessageID is the Pub/Sub message that carried the request. Adding a
+	// redelivered message again does not count it twice.
+	MessageID string                           `json:"messageId"`
+	Category  string                           `json:"category"`
+	Content   notification.NotificationContent `json:"content"`
+	// Template and TemplateVars are kept so a lone entry is still rendered per locale.
+	Template     string            `json:"template,omitempty"`
+	TemplateVars map[string]string `json:"templateVars,omitempty"`
+	ReceivedAt   time.Time         `json:"receivedAt"`
+}
+
+// Digest is a recipient's accumulated notifications for one period.
+type Digest struct {
+	RecipientID urn.URN       `json:"recipientId"`
+	DeliverAt   time.Time     `json:"deliverAt"`
+	Entries     []DigestEntry `json:"entries"`
+}
+
+// Summary renders the one notification that replaces the digest. A single entry
+// is delivered as it was; several become a total and a count per category,
+// rendered through DigestTemplate with the English content as the fallback
+// (title "3 new notifications", body "likes (2), follows (1)").
+func (d *Digest) Summary() Request {
+	summary := Request{
+		Priority:    PriorityLow,
+		CollapseKey: DigestCollapseKey,
+	}
+	summary.RecipientID = d.RecipientID
+	summary.DataPayload = map[string]string{DigestCountDataKey: strconv.Itoa(len(d.Entries))}
+
+	if len(d.Entries) == 1 {
+		summary.Category = d.Entries[0].Category
+		summary.Content = d.Entries[0].Content
+		summary.Template = d.Entries[0].Template
+		summary.TemplateVars = d.Entries[0].TemplateVars
+		return summary
+	}
+
+	var order []string
+	counts := make(map[string]int)
+	for _, e := range d.Entries {
+		category := e.Category
+		if category == "" {
+			category = "other"
+		}
+		if counts[category] == 0 {
+			order = append(order, category)
+		}
+		counts[category]++
+	}
+	parts := make([]string, len(order))
+	for i, category := range order {
+		parts[i] = fmt.Sprintf("%s (%d)", category, counts[category])
+	}
+	summary.Content.Title = fmt.Sprintf("%d new notifications", len(d.Entries))
+	summary.Content.Body = strings.Join(parts, ", ")
+	summary.Template = DigestTemplate
+	summary.TemplateVars = map[string]string{
+		DigestCountDataKey:  strconv.Itoa(len(d.Entries)),
+		DigestCategoriesVar: summary.Content.Body,
+	}
+	return summary
+}
+
+// DigestID is the schedule ID of a recipient's digest for one period.
+func DigestID(recipient urn.URN, deliverAt time.Time) string {
+	return "digest:" + recipient.String() + ":" + strconv.FormatInt(deliverAt.Unix(), 10)
+}
+
+// DigestStore accumulates digest entries until their period ends.
+type DigestStore interface {
+	// Add appends entry to the recipient's digest for the period ending at
+	// deliverAt. It reports whether entry is the digest's first one (or an
+	// earlier delivery of that same message).
+	Add(ctx context.Context, recipient urn.URN, deliverAt time.Time, entry DigestEntry) (first bool, err error)
+	// Get returns the digest, or nil when there is none.
+	Get(ctx context.Context, recipient urn.URN, deliverAt time.Time) (*Digest, error)
+	// Delete removes a delivered digest. Deleting a missing digest is not an error.
+	Delete(ctx context.Context, recipient urn.URN, deliverAt time.Time) error
+}
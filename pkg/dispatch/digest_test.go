@@ -0,0 +1,92 @@
+package dispatch_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
+)
+
+func TestDigestPeriod_End(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	require.NoError(t, err)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2025, time.March, day, hour, minute, 0, 0, london)
+	}
+
+	testCases := []struct {
+		name   string
+		period dispatch.DigestPeriod
+		now    time.Time
+		want   time.Time
+	}{
+		{"Hourly closes at the next full hour", dispatch.DigestHourly, at(10, 14, 25), at(10, 15, 0)},
+		{"Hourly on the hour waits a full hour", dispatch.DigestHourly, at(10, 14, 0), at(10, 15, 0)},
+		{"Daily before the time closes today", dispatch.DigestDaily, at(10, 9, 0), at(10, 18, 0)},
+		{"Daily at or after the time closes tomorrow", dispatch.DigestDaily, at(10, 18, 0), at(11, 18, 0)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			end, err := tc.period.End(tc.now, "18:00", london)
+			require.NoError(t, err)
+			assert.True(t, tc.want.Equal(end), "got %v, want %v", end, tc.want)
+		})
+	}
+
+	_, err = dispatch.DigestDaily.End(at(10, 9, 0), "6pm", london)
+	assert.Error(t, err)
+	_, err = dispatch.DigestImmediate.End(at(10, 9, 0), "18:00", london)
+	assert.Error(t, err)
+}
+
+func TestDigest_Summary(t *testing.T) {
+	entry := func(category, title string) dispatch.DigestEntry {
+		return dispatch.DigestEntry{Category: category, Content: notification.NotificationContent{Title: title}}
+	}
+
+	t.Run("A single entry is delivered as it was", func(t *testing.T) {
+		digest := dispatch.Digest{Entries: []dispatch.DigestEntry{entry("likes", "Alice liked your post")}}
+		summary := digest.Summary()
+		assert.Equal(t, "Alice liked your post", summary.Content.Title)
+		assert.Equal(t, "1", summary.DataPayload[dispatch.DigestCountDataKey])
+		assert.Equal(t, dispatch.PriorityLow, summary.Priority)
+
+		digest.Entries[0].Template = "new_like"
+		assert.Equal(t, "new_like", digest.Summary().Template, "still rendered per locale")
+	})
+
+	t.Run("Several entries are counted per category", func(t *testing.T) {
+		digest := dispatch.Digest{Entries: []dispatch.DigestEntry{
+			entry("likes", "a"), entry("follows", "b"), entry("likes", "c"),
+		}}
+		summary := digest.Summary()
+		assert.Equal(t, "3 new notifications", summary.Content.Title)
+		assert.Equal(t, "likes (2), follows (1)", summary.Content.Body)
+		assert.Equal(t, "3", summary.DataPayload[dispatch.DigestCountDataKey])
+		assert.Equal(t, dispatch.DigestCollapseKey, summary.CollapseKey)
+		// Localized through the digest template, with the English as the fallback
+		assert.Equal(t, dispatch.DigestTemplate, summary.Template)
+		assert.Equal(t, map[string]string{"digestCount": "3", "digestCategories": "likes (2), follows (1)"}, summary.TemplateVars)
+	})
+}
+
+func TestPreferences_DigestPeriod(t *testing.T) {
+	defaults := map[string]dispatch.DigestPeriod{"likes": dispatch.DigestHourly, "follows": dispatch.DigestDaily}
+	prefs := &dispatch.Preferences{Digests: map[string]dispatch.DigestPeriod{
+		"likes":    dispatch.DigestDaily,
+		"follows":  dispatch.DigestImmediate,
+		"mentions": dispatch.DigestHourly,
+	}}
+
+	assert.Equal(t, dispatch.DigestDaily, prefs.DigestPeriod("likes", defaults), "user overrides the default")
+	assert.Empty(t, prefs.DigestPeriod("follows", defaults), "user opts out of the default")
+	assert.Equal(t, dispatch.DigestHourly, prefs.DigestPeriod("mentions", defaults), "user opts in")
+	assert.Empty(t, prefs.DigestPeriod("chat", defaults))
+	assert.Empty(t, prefs.DigestPeriod("", defaults))
+
+	var none *dispatch.Preferences
+	assert.Equal(t, dispatch.DigestHourly, none.DigestPeriod("likes", defaults))
+}
@@ -0,0 +1,77 @@
+// --- File: pkg/dispatch/feedback.go ---
+package dispatch
+
+import (
+	"context"
+	"time"
+
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+// FeedbackEventType names the events published on the push-feedback topic.
+type FeedbackEventType string
+
+const (
+	// EventTokenInvalidated is emitted when a provider reports a device as dead
+	// (FCM NotRegistered, APNs Unregistered/BadDeviceToken, Web 404/410) and it is unregistered.
+	EventTokenInvalidated FeedbackEventType = "TokenInvalidated"
+	// EventNotificationDelivered is emitted for every device the provider accepted.
+	EventNotificationDelivered FeedbackEventType = "NotificationDelivered"
+	// EventNotificationFailed is emitted for every device that was not served.
+	// Outcome tells consumers whether the failure will be retried (retryable) or not (rejected).
+	EventNotificationFailed FeedbackEventType = "NotificationFailed"
+)
+
+// FeedbackEvent is one per-device delivery fact, suitable for analytics and
+// for other services that keep their own copy of device registrations.
+type FeedbackEvent struct {
+	Type           FeedbackEventType `json:"type"`
+	NotificationID string            `json:"notificationId"`
+	RecipientID    urn.URN           `json:"recipientId"`
+	Platform       string            `json:"platform"`
+	// Address is the FCM/APNs token or the Web Push endpoint.
+	Address           string          `json:"address"`
+	Outcome           DeliveryOutcome `json:"outcome"`
+	ProviderMessageID string          `json:"providerMessageId,omitempty"`
+	StatusCode        int             `json:"statusCode,omitempty"`
+	Reason            string          `json:"reason,omitempty"`
+	OccurredAt        time.Time       `json:"occurredAt"`
+}
+
+// FeedbackPublisher emits feedback events. Publishing is best effort: a failure
+// must never fail or retry the notification it describes.
+type FeedbackPublisher interface {
+	Publish(ctx context.Context, event FeedbackEvent) error
+}
+
+// FeedbackEvents converts a dispatch result into one event per device.
+// Invalid devices produce TokenInvalidated, delivered devices NotificationDelivered,
+// and everything else NotificationFailed.
+func FeedbackEvents(notificationID string, recipient urn.URN, result *DispatchResult, now time.Time) []FeedbackEvent {
+	if result == nil {
+		return nil
+	}
+	events := make([]FeedbackEvent, 0, len(result.Devices))
+	for _, d := range result.Devices {
+		eventType := EventNotificationFailed
+		switch d.Outcome {
+		case OutcomeDelivered:
+			eventType = EventNotificationDelivered
+		case OutcomeInvalid:
+			eventType = EventTokenInvalidated
+		}
+		events = append(events, FeedbackEvent{
+			Type:              eventType,
+			NotificationID:    notificationID,
+			RecipientID:       recipient,
+			Platform:          result.Platform,
+			Address:           d.Address,
+			Outcome:           d.Outcome,
+			ProviderMessageID: d.ProviderMessageID,
+			StatusCode:        d.StatusCode,
+			Reason:            d.Reason,
+			OccurredAt:        now,
+		})
+	}
+	return events
+}
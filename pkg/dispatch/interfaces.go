@@ -3,46 +3,69 @@ package dispatch
 
 import (
 	"context"
+	"errors"
 
 	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
 	notification "github.com/tinywideclouds/go-platform/pkg/notification/v1"
 )
 
 // Dispatcher defines the contract for platforms that use simple string tokens
-// (e.g. Firebase Cloud Messaging for Android/iOS).
+// (e.g. Firebase Cloud Messaging for Android, native APNs for iOS).
 type Dispatcher interface {
-	// Dispatch sends the notification to a list of string tokens.
+	// Dispatch sends the message to a list of string tokens.
 	// Returns:
-	// 1. Receipt string (log summary)
-	// 2. []string: A list of FATALLY invalid tokens to be deleted.
-	// 3. error: Only for RETRYABLE system failures.
-	Dispatch(ctx context.Context, tokens []string, content notification.NotificationContent, data map[string]string) (string, []string, error)
+	// 1. *DispatchResult: the per-device outcomes (Invalid() lists tokens to be deleted).
+	//    It is returned even alongside an error when some devices were reached.
+	// 2. error: Only for RETRYABLE system failures.
+	Dispatch(ctx context.Context, tokens []string, msg Message) (*DispatchResult, error)
 }
 
 // WebDispatcher defines the contract for platforms that use complex subscription objects
 // (specifically W3C Web Push / VAPID).
 type WebDispatcher interface {
-	// Dispatch sends the notification to a list of WebPushSubscription objects.
+	// Dispatch sends the message to a list of WebPushSubscription objects.
+	// Device addresses in the result are the subscription Endpoints.
 	// Returns:
-	// 1. Receipt string
-	// 2. []WebPushSubscription: A list of FATALLY invalid subscriptions to be deleted (410 Gone).
-	// 3. error: Retryable system failures.
-	Dispatch(ctx context.Context, subs []notification.WebPushSubscription, content notification.NotificationContent, data map[string]string) (string, []notification.WebPushSubscription, error)
+	// 1. *DispatchResult: the per-device outcomes (Invalid() lists endpoints to be deleted, 410 Gone).
+	// 2. error: Retryable system failures.
+	Dispatch(ctx context.Context, subs []notification.WebPushSubscription, msg Message) (*DispatchResult, error)
+}
+
+// ErrTemplateNotFound is returned by TemplateRenderer.Render for unknown templates.
+var ErrTemplateNotFound = errors.New("template not found")
+
+// TemplateRenderer renders a notification template for one device locale.
+type TemplateRenderer interface {
+	// Render picks the best variant for locale (a BCP 47 tag; empty means the
+	// default) and fills it in with vars. It fails with ErrTemplateNotFound for
+	// unknown template IDs, and with a different error when the template needs
+	// a variable that vars lacks.
+	Render(templateID, locale string, vars map[string]string) (notification.NotificationContent, error)
 }
 
 // TokenStore defines the storage contract for managing device registrations.
-// It explicitly separates the "Mobile/String" path from the "Web/Object" path.
+// It explicitly separates the "Mobile/String" paths (FCM, APNs) from the "Web/Object" path.
 type TokenStore interface {
 	// --- Registration (Write) ---
-	RegisterFCM(ctx context.Context, user urn.URN, token string) error
-	RegisterWeb(ctx context.Context, user urn.URN, sub notification.WebPushSubscription) error
+	// Re-registering an existing device replaces its metadata and refreshes its UpdatedAt.
+	RegisterFCM(ctx context.Context, user urn.URN, token string, meta DeviceMetadata) error
+	RegisterAPNs(ctx context.Context, user urn.URN, token string, meta DeviceMetadata) error
+	RegisterWeb(ctx context.Context, user urn.URN, sub notification.WebPushSubscription, meta DeviceMetadata) error
 
 	// --- Unregistration (Delete) ---
 	UnregisterFCM(ctx context.Context, user urn.URN, token string) error
+	UnregisterAPNs(ctx context.Context, user urn.URN, token string) error
 	UnregisterWeb(ctx context.Context, user urn.URN, endpoint string) error
 
 	// --- Fan-Out (Read) ---
-	// Fetch retrieves all devices for a user and populates the NotificationRequest
-	// with the separated lists (FCMTokens and WebSubscriptions).
-	Fetch(ctx context.Context, user urn.URN) (*notification.NotificationRequest, error)
+	// Fetch retrieves all devices for a user, sorted into one bucket per
+	// delivery path (FCMTokens, APNsTokens and WebSubscriptions), plus each device's metadata.
+	Fetch(ctx context.Context, user urn.URN) (*RecipientDevices, error)
+
+	// --- Device Management ---
+	// ListDevices returns every device registered to the user, with its metadata.
+	ListDevices(ctx context.Context, user urn.URN) ([]Device, error)
+	// DeleteDevice removes one device by ID. It returns ErrDeviceNotFound
+	// when the user has no such device.
+	DeleteDevice(ctx context.Context, user urn.URN, deviceID string) error
 }
@@ -0,0 +1,72 @@
+// --- File: pkg/dispatch/ledger.go ---
+package dispatch
+
+import (
+	"context"
+)
+
+// Platform identifiers shared by storage, ledger keys and dispatch results.
+const (
+	PlatformFCM  = "fcm"
+	PlatformAPNs = "apns"
+	PlatformWeb  = "web"
+)
+
+// DeliveryOutcome is the per-device result of a delivery attempt.
+type DeliveryOutcome string
+
+const (
+	// OutcomeDelivered means the provider accepted the notification for this device.
+	OutcomeDelivered DeliveryOutcome = "delivered"
+	// OutcomeInvalid means the provider rejected the device as dead (it has been unregistered).
+	OutcomeInvalid DeliveryOutcome = "invalid"
+	// OutcomeRetryable means a transient failure (transport, throttling, provider 5xx).
+	OutcomeRetryable DeliveryOutcome = "retryable"
+	// OutcomeRejected means the provider refused the push because of our own
+	// configuration or payload (e.g. wrong topic, payload too large). Retrying won't help.
+	OutcomeRejected DeliveryOutcome = "rejected"
+)
+
+// IsFinal reports whether a device with this outcome must NOT be re-targeted
+// when the same notification is retried.
+func (o DeliveryOutcome) IsFinal() bool {
+	return o == OutcomeDelivered || o == OutcomeInvalid || o == OutcomeRejected
+}
+
+// DeliveryLedger records per-device outcomes for a single notification.
+// It allows a redelivered Pub/Sub message to re-target only the devices that
+// have not yet been served, instead of notifying every device again.
+type DeliveryLedger interface {
+	// Outcomes returns the recorded outcomes for a notification, keyed by DeviceKey.
+	// An unknown notification returns an empty map and no error.
+	Outcomes(ctx context.Context, notificationID string) (map[string]DeliveryOutcome, error)
+	// Record merges the given outcomes into the ledger entry for a notification.
+	Record(ctx context.Context, notificationID string, outcomes map[string]DeliveryOutcome) error
+}
+
+// DeviceKey builds the ledger key for a device address (token or web endpoint).
+func DeviceKey(platform, address string) string {
+	return platform + ":" + address
+}
+
+// Pending returns a copy of the devices, minus every device whose recorded
+// outcome is final. The original is left untouched (it may be cached).
+func (d *RecipientDevices) Pending(outcomes map[string]DeliveryOutcome) *RecipientDevices {
+	pending := &RecipientDevices{RecipientID: d.RecipientID, Metadata: d.Metadata}
+	for _, t := range d.FCMTokens {
+		if !outcomes[DeviceKey(PlatformFCM, t)].IsFinal() {
+			pending.FCMTokens = append(pending.FCMTokens, t)
+		}
+	}
+	for _, t := range d.APNsTokens {
+		if !outcomes[DeviceKey(PlatformAPNs, t)].IsFinal() {
+			pending.APNsTokens = append(pending.APNsTokens, t)
+		}
+	}
+	for _, sub := range d.WebSubscriptions {
+		if !outcomes[DeviceKey(PlatformWeb, sub.Endpoint)].IsFinal() {
+			pending.WebSubscriptions = append(pending.WebSubscriptions, sub)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,101 @@
+// --- File: pkg/dispatch/message.go ---
+package dispatch
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"regexp"
+	"time"
+
+	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
+)
+
+// Message is what a dispatcher delivers to a batch of devices: the request's
+// content and data, plus the delivery options the processor resolved for it.
+type Message struct {
+	Content notification.NotificationContent
+	Data    map[string]string
+	// Rich is the optional content beyond title and body.
+	Rich RichContent
+
+	// Silent delivers without sound or a heads-up alert (e.g. during quiet hours).
+	// The notification still lands in the tray / notification center.
+	Silent bool
+
+	// DataOnly omits the visible alert: the push only hands Data to the app
+	// (FCM data message, APNs background push, Web Push data for the service worker).
+	DataOnly bool
+
+	// CollapseKey makes a newer notification replace an older one with the same
+	// key (FCM collapse_key/tag, apns-collapse-id/thread-id, Web Push Topic/tag).
+	CollapseKey string
+
+	// Priority is mapped onto each provider's urgency model (FCM priority,
+	// apns-priority, Web Push Urgency). Empty means normal.
+	Priority Priority
+
+	// APNsEnvironment routes an APNs batch to the production (default) or
+	// development gateway. The processor splits batches by each device's environment.
+	APNsEnvironment string
+
+	// ExpiresAt is when providers should stop trying to deliver. Zero means
+	// the provider's default.
+	ExpiresAt time.Time
+}
+
+// Message builds the default Message for the request.
+func (r *Request) Message() Message {
+	return Message{
+		Content:     r.Content,
+		Data:        r.DataPayload,
+		Rich:        r.Rich.trimmed(),
+		DataOnly:    r.DataOnly,
+		CollapseKey: r.CollapseKey,
+		Priority:    r.Priority,
+		ExpiresAt:   r.ExpiresAt,
+	}
+}
+
+// TTL returns how long the message may still be delivered, for providers that
+// take a relative TTL. ok is false when the message has no expiry.
+func (m Message) TTL(now time.Time) (ttl time.Duration, ok bool) {
+	if m.ExpiresAt.IsZero() {
+		return 0, false
+	}
+	return max(m.ExpiresAt.Sub(now), 0), true
+}
+
+// Provider limits on the collapse identifiers derived from CollapseKey.
+const (
+	maxAPNsCollapseID = 64 // bytes
+	maxWebPushTopic   = 32 // characters of the URL-safe base64 alphabet
+)
+
+var webPushTopicPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// APNsCollapseID returns the apns-collapse-id for the message's CollapseKey.
+func (m Message) APNsCollapseID() string {
+	if len(m.CollapseKey) <= maxAPNsCollapseID {
+		return m.CollapseKey
+	}
+	return hashKey(m.CollapseKey, maxAPNsCollapseID)
+}
+
+// WebPushTopic returns the Web Push Topic header for the message's CollapseKey.
+// Keys the header can't carry as-is are hashed, which still collapses consistently.
+func (m Message) WebPushTopic() string {
+	if m.CollapseKey == "" {
+		return ""
+	}
+	if len(m.CollapseKey) <= maxWebPushTopic && webPushTopicPattern.MatchString(m.CollapseKey) {
+		return m.CollapseKey
+	}
+	return hashKey(m.CollapseKey, maxWebPushTopic)
+}
+
+// hashKey derives a stable, URL-safe identifier of at most size characters.
+func hashKey(key string, size int) string {
+	sum := sha256.Sum256([]byte(key))
+	encoded := base64.RawURLEncoding.EncodeToString(sum[:])
+	return encoded[:min(size, len(encoded))]
+}
@@ -0,0 +1,73 @@
+package dispatch_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+func TestMessage_CollapseIdentifiers(t *testing.T) {
+	urlSafe := regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+	t.Run("Short keys are used as-is", func(t *testing.T) {
+		msg := dispatch.Message{CollapseKey: "chat-42"}
+		assert.Equal(t, "chat-42", msg.APNsCollapseID())
+		assert.Equal(t, "chat-42", msg.WebPushTopic())
+	})
+
+	t.Run("Keys the Topic header can't carry are hashed consistently", func(t *testing.T) {
+		msg := dispatch.Message{CollapseKey: "urn:contacts:conversation:42"}
+		assert.Equal(t, "urn:contacts:conversation:42", msg.APNsCollapseID())
+
+		topic := msg.WebPushTopic()
+		assert.LessOrEqual(t, len(topic), 32)
+		assert.Regexp(t, urlSafe, topic)
+		assert.Equal(t, topic, dispatch.Message{CollapseKey: "urn:contacts:conversation:42"}.WebPushTopic())
+		assert.NotEqual(t, topic, dispatch.Message{CollapseKey: "urn:contacts:conversation:43"}.WebPushTopic())
+	})
+
+	t.Run("Long keys fit the APNs limit", func(t *testing.T) {
+		msg := dispatch.Message{CollapseKey: strings.Repeat("x", 100)}
+		assert.LessOrEqual(t, len(msg.APNsCollapseID()), 64)
+		assert.LessOrEqual(t, len(msg.WebPushTopic()), 32)
+	})
+
+	t.Run("No key, no identifiers", func(t *testing.T) {
+		assert.Empty(t, dispatch.Message{}.APNsCollapseID())
+		assert.Empty(t, dispatch.Message{}.WebPushTopic())
+	})
+}
+
+func TestRequest_MessageRichContent(t *testing.T) {
+	t.Run("Actions beyond the limit are dropped", func(t *testing.T) {
+		req := dispatch.Request{Rich: dispatch.RichContent{Actions: []dispatch.Action{
+			{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"},
+		}}}
+		assert.Len(t, req.Message().Rich.Actions, dispatch.MaxActions)
+	})
+
+	t.Run("Links and image are added to a copy of the data", func(t *testing.T) {
+		data := map[string]string{"id": "1"}
+		msg := dispatch.Message{Data: data, Rich: dispatch.RichContent{
+			Link:     "app://chat/42",
+			ImageURL: "https://cdn.example.com/cat.jpg",
+			Actions:  []dispatch.Action{{ID: "reply", Link: "app://chat/42/reply"}, {ID: "mute"}},
+		}}
+
+		assert.Equal(t, map[string]string{
+			"id":        "1",
+			"url":       "app://chat/42",
+			"image":     "https://cdn.example.com/cat.jpg",
+			"url.reply": "app://chat/42/reply",
+		}, msg.PayloadData())
+		assert.Len(t, data, 1)
+	})
+
+	t.Run("Plain messages keep their data", func(t *testing.T) {
+		data := map[string]string{"id": "1"}
+		assert.Equal(t, data, dispatch.Message{Data: data}.PayloadData())
+	})
+}
@@ -0,0 +1,64 @@
+// --- File: pkg/dispatch/preferences.go ---
+package dispatch
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+// Preferences are a user's own delivery settings.
+// The zero value (nothing muted) is what a user without stored preferences gets.
+type Preferences struct {
+	// Muted silences every notification for the user.
+	Muted bool `json:"muted"`
+	// DisabledCategories lists the categories the user opted out of.
+	DisabledCategories []string `json:"disabledCategories"`
+	// QuietHours is the optional daily do-not-disturb window.
+	QuietHours *QuietHours `json:"quietHours,omitempty"`
+	// Digests overrides the configured digest period per category.
+	Digests   map[string]DigestPeriod `json:"digests,omitempty"`
+	UpdatedAt time.Time               `json:"updatedAt"`
+}
+
+// Allows reports whether a notification in the given category may be delivered.
+// Uncategorised notifications are only stopped by the global mute.
+func (p *Preferences) Allows(category string) bool {
+	if p == nil {
+		return true
+	}
+	if p.Muted {
+		return false
+	}
+	return category == "" || !slices.Contains(p.DisabledCategories, category)
+}
+
+// DigestPeriod returns the period notifications in category are rolled up over,
+// or "" when they are pushed individually. The user's choice wins over defaults.
+func (p *Preferences) DigestPeriod(category string, defaults map[string]DigestPeriod) DigestPeriod {
+	if category == "" {
+		return ""
+	}
+	period, ok := DigestPeriod(""), false
+	if p != nil {
+		period, ok = p.Digests[category]
+	}
+	if !ok {
+		period = defaults[category]
+	}
+	if period == DigestImmediate {
+		return ""
+	}
+	return period
+}
+
+// PreferencesStore persists user preferences.
+type PreferencesStore interface {
+	// Get returns the user's preferences, or the zero value (not an error)
+	// when the user never saved any.
+	Get(ctx context.Context, user urn.URN) (*Preferences, error)
+	// Put replaces the user's preferences.
+	Put(ctx context.Context, user urn.URN, prefs *Preferences) error
+}
@@ -0,0 +1,27 @@
+// --- File: pkg/dispatch/prune.go ---
+package dispatch
+
+import (
+	"context"
+	"time"
+
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+// StaleDevice is a device that has not been (re-)registered since a cutoff.
+type StaleDevice struct {
+	RecipientID urn.URN
+	Device      Device
+}
+
+// DeviceJanitor is the storage contract for pruning devices across all users.
+type DeviceJanitor interface {
+	// StaleDevices returns up to limit devices whose UpdatedAt is before cutoff.
+	// Devices that are already quarantined are not returned.
+	StaleDevices(ctx context.Context, cutoff time.Time, limit int) ([]StaleDevice, error)
+	// DeleteDevice removes one device (see TokenStore.DeleteDevice).
+	DeleteDevice(ctx context.Context, user urn.URN, deviceID string) error
+	// QuarantineDevice keeps the record but stops Fetch from returning it.
+	// Re-registering the device lifts the quarantine.
+	QuarantineDevice(ctx context.Context, user urn.URN, deviceID string) error
+}
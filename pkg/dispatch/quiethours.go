@@ -0,0 +1,102 @@
+// --- File: pkg/dispatch/quiethours.go ---
+package dispatch
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// QuietMode is what happens to a non-urgent notification during quiet hours.
+type QuietMode string
+
+const (
+	// QuietDrop discards the notification.
+	QuietDrop QuietMode = "drop"
+	// QuietSilent delivers it without sound or alert.
+	QuietSilent QuietMode = "silent"
+	// QuietDefer holds it back and delivers it when the quiet hours end.
+	QuietDefer QuietMode = "defer"
+)
+
+// QuietHours is a daily do-not-disturb window in the user's local time.
+// Windows may cross midnight (e.g. 22:00 to 07:00).
+type QuietHours struct {
+	Start string `json:"start"` // "HH:MM", inclusive
+	End   string `json:"end"`   // "HH:MM", exclusive
+	// Timezone is an IANA zone name. When empty, the timezone of the user's
+	// devices is used, falling back to UTC.
+	Timezone string    `json:"timezone,omitempty"`
+	Mode     QuietMode `json:"mode"`
+}
+
+// Validate checks the window, the timezone and the mode.
+func (q *QuietHours) Validate() error {
+	start, err := parseClock(q.Start)
+	if err != nil {
+		return fmt.Errorf("invalid start: %w", err)
+	}
+	end, err := parseClock(q.End)
+	if err != nil {
+		return fmt.Errorf("invalid end: %w", err)
+	}
+	if start == end {
+		return errors.New("start and end must differ")
+	}
+	if q.Timezone != "" {
+		if _, err := time.LoadLocation(q.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q", q.Timezone)
+		}
+	}
+	switch q.Mode {
+	case QuietDrop, QuietSilent, QuietDefer:
+		return nil
+	default:
+		return fmt.Errorf("invalid mode %q (want drop, silent or defer)", q.Mode)
+	}
+}
+
+// Window reports whether t falls inside the quiet hours in loc and, if so,
+// when they end. An invalid window is never active.
+func (q *QuietHours) Window(t time.Time, loc *time.Location) (bool, time.Time) {
+	if q == nil {
+		return false, time.Time{}
+	}
+	start, err := parseClock(q.Start)
+	if err != nil {
+		return false, time.Time{}
+	}
+	end, err := parseClock(q.End)
+	if err != nil || start == end {
+		return false, time.Time{}
+	}
+
+	local := t.In(loc)
+	now := local.Hour()*60 + local.Minute()
+	endToday := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, loc)
+
+	if start < end {
+		// Same-day window, e.g. 13:00 to 15:00
+		if now >= start && now < end {
+			return true, endToday
+		}
+		return false, time.Time{}
+	}
+	// Window crosses midnight, e.g. 22:00 to 07:00
+	if now >= start {
+		return true, endToday.AddDate(0, 0, 1)
+	}
+	if now < end {
+		return true, endToday
+	}
+	return false, time.Time{}
+}
+
+// parseClock turns "HH:MM" into minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
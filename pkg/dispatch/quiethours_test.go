@@ -0,0 +1,74 @@
+package dispatch_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+func TestQuietHours_Window(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	require.NoError(t, err)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2025, time.March, day, hour, minute, 0, 0, london)
+	}
+	overnight := &dispatch.QuietHours{Start: "22:00", End: "07:00", Mode: dispatch.QuietDefer}
+	afternoon := &dispatch.QuietHours{Start: "13:00", End: "15:30", Mode: dispatch.QuietDrop}
+
+	testCases := []struct {
+		name       string
+		quiet      *dispatch.QuietHours
+		now        time.Time
+		wantActive bool
+		wantEnd    time.Time
+	}{
+		{"Overnight, before midnight ends tomorrow", overnight, at(10, 23, 15), true, at(11, 7, 0)},
+		{"Overnight, after midnight ends today", overnight, at(11, 6, 59), true, at(11, 7, 0)},
+		{"Overnight, start is inclusive", overnight, at(10, 22, 0), true, at(11, 7, 0)},
+		{"Overnight, end is exclusive", overnight, at(11, 7, 0), false, time.Time{}},
+		{"Overnight, daytime", overnight, at(10, 12, 0), false, time.Time{}},
+		{"Same-day window", afternoon, at(10, 14, 0), true, at(10, 15, 30)},
+		{"Same-day window, outside", afternoon, at(10, 16, 0), false, time.Time{}},
+		{"Nil quiet hours", nil, at(10, 23, 0), false, time.Time{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			active, end := tc.quiet.Window(tc.now, london)
+			assert.Equal(t, tc.wantActive, active)
+			assert.True(t, tc.wantEnd.Equal(end), "end: want %v, got %v", tc.wantEnd, end)
+		})
+	}
+
+	t.Run("Evaluated in the given zone", func(t *testing.T) {
+		// 23:00 in London is 08:00 next day in Tokyo: quiet in one, not the other
+		tokyo, err := time.LoadLocation("Asia/Tokyo")
+		require.NoError(t, err)
+		active, _ := overnight.Window(at(10, 23, 0), tokyo)
+		assert.False(t, active)
+	})
+}
+
+func TestQuietHours_Validate(t *testing.T) {
+	valid := dispatch.QuietHours{Start: "22:00", End: "07:00", Timezone: "Europe/London", Mode: dispatch.QuietSilent}
+	require.NoError(t, valid.Validate())
+
+	invalid := map[string]func(q *dispatch.QuietHours){
+		"bad start":     func(q *dispatch.QuietHours) { q.Start = "25:00" },
+		"bad end":       func(q *dispatch.QuietHours) { q.End = "7pm" },
+		"empty window":  func(q *dispatch.QuietHours) { q.End = q.Start },
+		"bad timezone":  func(q *dispatch.QuietHours) { q.Timezone = "Mars/Olympus" },
+		"unknown mode":  func(q *dispatch.QuietHours) { q.Mode = "snooze" },
+		"mode required": func(q *dispatch.QuietHours) { q.Mode = "" },
+	}
+	for name, mutate := range invalid {
+		t.Run(name, func(t *testing.T) {
+			q := valid
+			mutate(&q)
+			assert.Error(t, q.Validate())
+		})
+	}
+}
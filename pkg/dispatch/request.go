@@ -0,0 +1,125 @@
+// --- File: pkg/dispatch/request.go ---
+package dispatch
+
+import (
+	"strings"
+	"time"
+
+	"github.com/tinywideclouds/go-platform/pkg/notification/v1"
+)
+
+// Request is the service's inbound "Notify User" command.
+// It embeds the platform NotificationRequest, so its wire format is a superset:
+// producers that only know the platform type keep working unchanged.
+type Request struct {
+	notification.NotificationRequest
+
+	// RequestID is the producer-supplied idempotency key. When empty, the
+	// transformer falls back to the Pub/Sub message ID.
+	RequestID string `json:"requestId,omitempty"`
+
+	// Cancel turns the request into a command for producers: the scheduled
+	// notification with this RequestID is cancelled and nothing is delivered.
+	// RecipientID may be left out.
+	Cancel bool `json:"cancel,omitempty"`
+
+	// Category classifies the notification (e.g. "chat", "marketing") so users can
+	// opt out per category. Producers that can't set it may use DataPayload["category"].
+	Category string `json:"category,omitempty"`
+
+	// Priority is the sender's urgency. Urgent notifications bypass quiet hours.
+	Priority Priority `json:"priority,omitempty"`
+
+	// DeliverAt schedules the notification. A time in the future parks the request
+	// in the schedule store until then; zero (or a past time) delivers immediately.
+	DeliverAt time.Time `json:"deliverAt,omitzero"`
+
+	// CollapseKey groups notifications that replace each other (e.g. one per chat
+	// conversation): a newer one takes the older one's place instead of stacking.
+	CollapseKey string `json:"collapseKey,omitempty"`
+
+	// DataOnly sends a background push that wakes the app to sync without
+	// showing anything. Content is ignored; DataPayload is delivered as-is.
+	DataOnly bool `json:"dataOnly,omitempty"`
+
+	// Rich adds an image, click-through link, action buttons, badge and subtitle.
+	Rich RichContent `json:"rich,omitzero"`
+
+	// Template names a registered template to render per device locale instead of
+	// sending Content as-is. Content stays the fallback when rendering fails.
+	Template string `json:"template,omitempty"`
+	// TemplateVars are the values the template refers to (e.g. {{.sender}}).
+	TemplateVars map[string]string `json:"templateVars,omitempty"`
+
+	// TTLSeconds is how long the notification stays worth delivering, counted from
+	// publish (or from DeliverAt when scheduled). The transformer turns it into ExpiresAt.
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
+
+	// ExpiresAt is the absolute deadline. Expired notifications are ACKed and dropped,
+	// and providers are told not to deliver (or keep retrying) past it.
+	ExpiresAt time.Time `json:"expiresAt,omitzero"`
+}
+
+// Priority is the urgency a producer assigns to a notification.
+type Priority string
+
+const (
+	PriorityLow    Priority = "low"
+	PriorityNormal Priority = "normal" // Default when unset
+	PriorityHigh   Priority = "high"
+	PriorityUrgent Priority = "urgent"
+)
+
+// IsHigh reports whether providers should deliver immediately, waking the device.
+// Urgent implies high.
+func (p Priority) IsHigh() bool {
+	return p == PriorityHigh || p == PriorityUrgent
+}
+
+// IsLow reports whether providers may delay delivery to save power.
+func (p Priority) IsLow() bool {
+	return p == PriorityLow
+}
+
+// IsUrgent reports whether the notification must be delivered immediately,
+// whatever the user's quiet hours say.
+func (r *Request) IsUrgent() bool {
+	return r.Priority == PriorityUrgent
+}
+
+// CategoryDataKey is the DataPayload key used when Category is not set.
+const CategoryDataKey = "category"
+
+// ResolvedCategory returns the notification category, preferring the explicit field.
+// Categories are case-insensitive and returned lower-cased.
+func (r *Request) ResolvedCategory() string {
+	category := r.Category
+	if category == "" {
+		category = r.DataPayload[CategoryDataKey]
+	}
+	return strings.ToLower(strings.TrimSpace(category))
+}
+
+// IsScheduledAfter reports whether the request asks to be delivered later than now.
+func (r *Request) IsScheduledAfter(now time.Time) bool {
+	return r.DeliverAt.After(now)
+}
+
+// ResolveExpiry fills ExpiresAt from TTLSeconds when the producer didn't set it.
+// The TTL runs from the publish time, so Pub/Sub redeliveries don't extend it,
+// or from DeliverAt when the notification is scheduled later than that.
+func (r *Request) ResolveExpiry(published time.Time) {
+	if !r.ExpiresAt.IsZero() || r.TTLSeconds <= 0 {
+		return
+	}
+	from := published
+	if r.DeliverAt.After(from) {
+		from = r.DeliverAt
+	}
+	r.ExpiresAt = from.Add(time.Duration(r.TTLSeconds) * time.Second)
+}
+
+// IsExpired reports whether the notification's deadline has passed.
+func (r *Request) IsExpired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
@@ -0,0 +1,73 @@
+// --- File: pkg/dispatch/result.go ---
+package dispatch
+
+import (
+	"fmt"
+	"time"
+)
+
+// DeviceResult is the outcome of delivering one notification to one device.
+type DeviceResult struct {
+	// Address is the FCM/APNs token or the Web Push endpoint.
+	Address string          `json:"address"`
+	Outcome DeliveryOutcome `json:"outcome"`
+	// ProviderMessageID is the provider's handle for the push
+	// (FCM message ID, APNs apns-id). Empty when not delivered.
+	ProviderMessageID string `json:"providerMessageId,omitempty"`
+	// StatusCode is the provider's HTTP status, when one was received.
+	StatusCode int `json:"statusCode,omitempty"`
+	// Reason is the provider's error reason (e.g. "Unregistered") or transport error text.
+	Reason  string        `json:"reason,omitempty"`
+	Latency time.Duration `json:"latency"`
+}
+
+// DispatchResult is the structured outcome of a single Dispatch call.
+type DispatchResult struct {
+	Platform string         `json:"platform"`
+	Devices  []DeviceResult `json:"devices"`
+	// Latency is the wall-clock time of the whole Dispatch call.
+	Latency time.Duration `json:"latency"`
+}
+
+// NewDispatchResult creates an empty result for the given platform.
+func NewDispatchResult(platform string) *DispatchResult {
+	return &DispatchResult{Platform: platform}
+}
+
+// Add appends a device outcome.
+func (r *DispatchResult) Add(d DeviceResult) {
+	r.Devices = append(r.Devices, d)
+}
+
+// Count returns the number of devices with the given outcome.
+func (r *DispatchResult) Count(outcome DeliveryOutcome) int {
+	n := 0
+	for _, d := range r.Devices {
+		if d.Outcome == outcome {
+			n++
+		}
+	}
+	return n
+}
+
+// Addresses returns the addresses of the devices with the given outcome.
+func (r *DispatchResult) Addresses(outcome DeliveryOutcome) []string {
+	var out []string
+	for _, d := range r.Devices {
+		if d.Outcome == outcome {
+			out = append(out, d.Address)
+		}
+	}
+	return out
+}
+
+// Invalid returns the addresses of FATALLY invalid devices, to be deleted.
+func (r *DispatchResult) Invalid() []string {
+	return r.Addresses(OutcomeInvalid)
+}
+
+// String is a compact log summary.
+func (r *DispatchResult) String() string {
+	return fmt.Sprintf("delivered:%d invalid:%d retryable:%d rejected:%d latency:%s",
+		r.Count(OutcomeDelivered), r.Count(OutcomeInvalid), r.Count(OutcomeRetryable), r.Count(OutcomeRejected), r.Latency)
+}
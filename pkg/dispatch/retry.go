@@ -0,0 +1,77 @@
+// --- File: pkg/dispatch/retry.go ---
+package dispatch
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy bounds a dispatcher's in-process retries of devices the provider
+// reported as retryable. What is still failing afterwards is returned as an
+// error, so Pub/Sub redelivers the message.
+type RetryPolicy struct {
+	// MaxAttempts is the total sends per device, including the first. 1 (or 0) disables retries.
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry; it doubles with each attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps a single wait. A Retry-After longer than this is left to
+	// Pub/Sub redelivery rather than holding the worker.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy retries twice, after roughly 500ms and 1s.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 10 * time.Second}
+}
+
+// Delay returns how long to wait after the given (1-based) attempt, and false
+// when no further attempt should be made. The exponential backoff is jittered
+// so devices failing together don't retry in lockstep; the provider's
+// Retry-After is a lower bound.
+func (p RetryPolicy) Delay(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	if retryAfter > p.MaxDelay {
+		return 0, false
+	}
+	backoff := min(p.BaseDelay<<(attempt-1), p.MaxDelay)
+	if backoff > 0 {
+		// Equal jitter: half fixed, half random
+		backoff = backoff/2 + rand.N(backoff/2+1)
+	}
+	return max(backoff, retryAfter), true
+}
+
+// Wait sleeps for d, reporting false if ctx ends first.
+func Wait(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ParseRetryAfter reads a provider's Retry-After header, given either as
+// delay-seconds or as an HTTP date. Zero means none (or one already past).
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
@@ -0,0 +1,51 @@
+package dispatch_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tinywideclouds/go-notification-service/pkg/dispatch"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	assert.Equal(t, 30*time.Second, dispatch.ParseRetryAfter("30", now))
+	assert.Equal(t, 90*time.Second, dispatch.ParseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now))
+	assert.Zero(t, dispatch.ParseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+	assert.Zero(t, dispatch.ParseRetryAfter("", now))
+	assert.Zero(t, dispatch.ParseRetryAfter("soon", now))
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := dispatch.RetryPolicy{MaxAttempts: 4, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	t.Run("Backoff doubles with jitter", func(t *testing.T) {
+		for attempt, base := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond} {
+			delay, ok := policy.Delay(attempt, 0)
+			assert.True(t, ok)
+			assert.GreaterOrEqual(t, delay, base/2)
+			assert.LessOrEqual(t, delay, base)
+		}
+	})
+
+	t.Run("Stops after the last attempt", func(t *testing.T) {
+		_, ok := policy.Delay(4, 0)
+		assert.False(t, ok)
+		_, ok = dispatch.RetryPolicy{}.Delay(1, 0)
+		assert.False(t, ok, "the zero policy never retries")
+	})
+
+	t.Run("Retry-After is a lower bound", func(t *testing.T) {
+		delay, ok := policy.Delay(1, 700*time.Millisecond)
+		assert.True(t, ok)
+		assert.Equal(t, 700*time.Millisecond, delay)
+	})
+
+	t.Run("A Retry-After beyond MaxDelay is left to redelivery", func(t *testing.T) {
+		_, ok := policy.Delay(1, time.Minute)
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,80 @@
+// --- File: pkg/dispatch/rich.go ---
+package dispatch
+
+// MaxActions is the most action buttons a notification carries (Android's limit;
+// browsers may show fewer). Extra actions are dropped.
+const MaxActions = 3
+
+// Data keys that carry rich content to app code that has no native field for it
+// (e.g. the service worker's notificationclick handler or an iOS extension).
+const (
+	// LinkDataKey holds the click-through link.
+	LinkDataKey = "url"
+	// ActionLinkDataKeyPrefix + action ID holds the link an action button opens.
+	ActionLinkDataKeyPrefix = "url."
+	// ImageDataKey holds the image URL, for an iOS notification service extension.
+	ImageDataKey = "image"
+)
+
+// RichContent is the optional content beyond title and body. Each dispatcher
+// maps what its platform supports onto native fields and ignores the rest.
+type RichContent struct {
+	// Subtitle is a second heading line (iOS).
+	Subtitle string `json:"subtitle,omitempty"`
+	// ImageURL is a picture shown in the expanded notification. It must be HTTPS.
+	ImageURL string `json:"imageUrl,omitempty"`
+	// Link is the deep link or URL opened when the notification is tapped.
+	Link string `json:"link,omitempty"`
+	// Badge sets the app icon badge count. nil leaves it unchanged; 0 clears it.
+	Badge *int `json:"badge,omitempty"`
+	// Actions are buttons on the notification, at most MaxActions.
+	Actions []Action `json:"actions,omitempty"`
+	// ActionCategory is the iOS notification category registered by the app.
+	// iOS only shows action buttons through a category.
+	ActionCategory string `json:"actionCategory,omitempty"`
+}
+
+// Action is a button on a notification.
+type Action struct {
+	// ID is reported back to the app when the button is pressed.
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// Link is opened when the button is pressed, instead of the notification's Link.
+	Link string `json:"link,omitempty"`
+	// Icon is an image URL for the button (web only).
+	Icon string `json:"icon,omitempty"`
+}
+
+// trimmed returns the content with at most MaxActions actions.
+func (r RichContent) trimmed() RichContent {
+	if len(r.Actions) > MaxActions {
+		r.Actions = r.Actions[:MaxActions]
+	}
+	return r
+}
+
+// PayloadData returns the message's data plus the link, action links and image
+// under their data keys, for platforms that hand data to app code. The message's
+// own map is not modified.
+func (m Message) PayloadData() map[string]string {
+	rich := m.Rich
+	if rich.Link == "" && rich.ImageURL == "" && len(rich.Actions) == 0 {
+		return m.Data
+	}
+	data := make(map[string]string, len(m.Data)+len(rich.Actions)+2)
+	for k, v := range m.Data {
+		data[k] = v
+	}
+	if rich.Link != "" {
+		data[LinkDataKey] = rich.Link
+	}
+	if rich.ImageURL != "" {
+		data[ImageDataKey] = rich.ImageURL
+	}
+	for _, a := range rich.Actions {
+		if a.Link != "" {
+			data[ActionLinkDataKeyPrefix+a.ID] = a.Link
+		}
+	}
+	return data
+}
@@ -0,0 +1,63 @@
+// --- File: pkg/dispatch/schedule.go ---
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	urn "github.com/tinywideclouds/go-platform/pkg/net/v1"
+)
+
+// ScheduledNotification is a request held back for later delivery.
+type ScheduledNotification struct {
+	// ID is the notification ID (RequestID). Scheduling the same ID again replaces it.
+	ID string `json:"id"`
+	// MessageID is the Pub/Sub message that carried the request. The replay reuses
+	// it so the deduplication claim recognises the request as its own.
+	MessageID string    `json:"messageId"`
+	Request   Request   `json:"request"`
+	DeliverAt time.Time `json:"deliverAt"`
+	// Reason says why delivery was postponed (e.g. "quiet_hours").
+	Reason string `json:"reason,omitempty"`
+}
+
+const (
+	// ScheduleReasonDeliverAt marks notifications the producer scheduled (Request.DeliverAt).
+	ScheduleReasonDeliverAt = "deliver_at"
+	// ScheduleReasonQuietHours marks notifications deferred until quiet hours end.
+	ScheduleReasonQuietHours = "quiet_hours"
+	// ScheduleReasonCoalesce marks the flush of a coalesced burst.
+	ScheduleReasonCoalesce = "coalesce"
+	// ScheduleReasonDigest marks the delivery of a periodic digest.
+	ScheduleReasonDigest = "digest"
+)
+
+// ReplayReasonAttribute is the message attribute a scheduler replay carries: the
+// Reason of the ScheduledNotification being delivered. Fresh Pub/Sub deliveries
+// don't have it.
+const ReplayReasonAttribute = "replayReason"
+
+// ErrScheduleNotFound is returned when a scheduled notification does not exist
+// (or is not addressed to the given recipient).
+var ErrScheduleNotFound = errors.New("scheduled notification not found")
+
+// ScheduleStore is the durable delayed-delivery queue.
+type ScheduleStore interface {
+	// Schedule stores a notification for delivery at n.DeliverAt.
+	Schedule(ctx context.Context, n ScheduledNotification) error
+	// Claim leases up to limit notifications that are due at now. A claimed
+	// notification is hidden from other claimers until the lease runs out, so
+	// a crashed worker's claims are picked up again.
+	Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]ScheduledNotification, error)
+	// Complete removes a claimed notification once it has been handed to the processor.
+	// It is a no-op when the notification was rescheduled in the meantime (e.g. a
+	// released request deferred again by quiet hours), which shows as a new DeliverAt.
+	Complete(ctx context.Context, n ScheduledNotification) error
+	// Cancel removes a pending notification addressed to recipient. It returns
+	// ErrScheduleNotFound when there is no such notification.
+	Cancel(ctx context.Context, recipient urn.URN, id string) error
+	// CancelByID removes a pending notification whoever it is addressed to. It is
+	// for trusted producers; it returns ErrScheduleNotFound when there is none.
+	CancelByID(ctx context.Context, id string) error
+}